@@ -1,10 +1,15 @@
 package engine;
 
+import java.util.ArrayList;
+import java.util.List;
 import java.util.concurrent.ConcurrentMap;
 import org.mapdb.*;
 
 public class DbSchemas {
     private static ConcurrentMap<String, String> schemasMap;
+    private static ConcurrentMap<String, String> viewsMap; // name => {"query", "columns", "from"}
+    private static ConcurrentMap<String, String> materializedMap; // name => the plan sent to the engine
+    private static ConcurrentMap<String, String> databasesMap; // name => ""
     private static DB db;
     private static final String BASE_PATH = "/Users/alexsandergomes/Documents/A2GDB/planner/src/main/java/resources/";
     private static final Object LOCK = new Object();
@@ -23,6 +28,15 @@ public class DbSchemas {
                     schemasMap = db
                             .hashMap("schemas_map", Serializer.STRING, Serializer.STRING)
                             .createOrOpen();
+                    viewsMap = db
+                            .hashMap("views_map", Serializer.STRING, Serializer.STRING)
+                            .createOrOpen();
+                    materializedMap = db
+                            .hashMap("materialized_map", Serializer.STRING, Serializer.STRING)
+                            .createOrOpen();
+                    databasesMap = db
+                            .hashMap("databases_map", Serializer.STRING, Serializer.STRING)
+                            .createOrOpen();
                 }
             }
         }
@@ -44,9 +58,93 @@ public class DbSchemas {
         }
     }
 
+    public static void remove(String key) {
+        synchronized (LOCK) {
+            schemasMap.remove(key);
+            db.commit();
+        }
+    }
+
     public static String get(String key) {
         synchronized (LOCK) {
             return schemasMap.get(key);
         }
     }
+
+    public static void putView(String key, String val) {
+        synchronized (LOCK) {
+            viewsMap.put(key, val);
+            db.commit();
+        }
+    }
+
+    public static void removeView(String key) {
+        synchronized (LOCK) {
+            viewsMap.remove(key);
+            db.commit();
+        }
+    }
+
+    public static String getView(String key) {
+        synchronized (LOCK) {
+            return viewsMap.get(key);
+        }
+    }
+
+    public static List<String> viewNames() {
+        synchronized (LOCK) {
+            return new ArrayList<>(viewsMap.keySet());
+        }
+    }
+
+    public static void putMaterialized(String key, String val) {
+        synchronized (LOCK) {
+            materializedMap.put(key, val);
+            db.commit();
+        }
+    }
+
+    public static void removeMaterialized(String key) {
+        synchronized (LOCK) {
+            materializedMap.remove(key);
+            db.commit();
+        }
+    }
+
+    public static String getMaterialized(String key) {
+        synchronized (LOCK) {
+            return materializedMap.get(key);
+        }
+    }
+
+    public static List<String> materializedNames() {
+        synchronized (LOCK) {
+            return new ArrayList<>(materializedMap.keySet());
+        }
+    }
+
+    public static void putDatabase(String name) {
+        synchronized (LOCK) {
+            databasesMap.put(name, "");
+            db.commit();
+        }
+    }
+
+    public static boolean hasDatabase(String name) {
+        synchronized (LOCK) {
+            return databasesMap.containsKey(name);
+        }
+    }
+
+    // the tables and views of a database are kept as name.table
+    public static void removeDatabase(String name) {
+        String prefix = name + ".";
+        synchronized (LOCK) {
+            databasesMap.remove(name);
+            schemasMap.keySet().removeIf(key -> key.startsWith(prefix));
+            viewsMap.keySet().removeIf(key -> key.startsWith(prefix));
+            materializedMap.keySet().removeIf(key -> key.startsWith(prefix));
+            db.commit();
+        }
+    }
 }
@@ -7,31 +7,48 @@ import org.apache.calcite.rel.core.AggregateCall;
 import org.apache.calcite.rel.type.RelDataType;
 import org.apache.calcite.rel.type.RelDataTypeFactory;
 import org.apache.calcite.rel.type.RelDataTypeSystem;
+import org.apache.calcite.schema.ColumnStrategy;
 import org.apache.calcite.schema.SchemaPlus;
+import org.apache.calcite.schema.impl.AbstractSchema;
 import org.apache.calcite.schema.impl.AbstractTable;
 import org.apache.calcite.sql.SqlBasicCall;
+import org.apache.calcite.sql.SqlBasicTypeNameSpec;
 import org.apache.calcite.sql.SqlCall;
+import org.apache.calcite.sql.SqlDataTypeSpec;
 import org.apache.calcite.sql.SqlDelete;
 import org.apache.calcite.sql.SqlIdentifier;
 import org.apache.calcite.sql.SqlInsert;
+import org.apache.calcite.sql.SqlIntervalLiteral;
+import org.apache.calcite.sql.SqlIntervalQualifier;
 import org.apache.calcite.sql.SqlJoin;
+import org.apache.calcite.sql.SqlKind;
+import org.apache.calcite.sql.SqlLiteral;
 import org.apache.calcite.sql.SqlNode;
 import org.apache.calcite.sql.SqlNodeList;
 import org.apache.calcite.sql.SqlOrderBy;
 import org.apache.calcite.sql.SqlSelect;
 import org.apache.calcite.sql.SqlUpdate;
+import org.apache.calcite.sql.fun.SqlLibrary;
+import org.apache.calcite.sql.fun.SqlStdOperatorTable;
+import org.apache.calcite.sql.fun.SqlLibraryOperatorTableFactory;
+import org.apache.calcite.sql.ddl.SqlCheckConstraint;
 import org.apache.calcite.sql.ddl.SqlColumnDeclaration;
 import org.apache.calcite.sql.ddl.SqlCreateTable;
+import org.apache.calcite.sql.ddl.SqlDropTable;
 import org.apache.calcite.sql.ddl.SqlKeyConstraint;
+import org.apache.calcite.sql.ddl.SqlTruncateTable;
 import org.apache.calcite.sql.parser.SqlParseException;
 import org.apache.calcite.sql.parser.SqlParser;
 import org.apache.calcite.sql.parser.SqlParser.Config;
+import org.apache.calcite.sql.parser.SqlParserPos;
 import org.apache.calcite.sql.parser.ddl.SqlDdlParserImpl;
 import org.apache.calcite.sql.type.SqlTypeName;
+import org.apache.calcite.sql.util.SqlShuttle;
 import org.apache.calcite.sql.validate.SqlConformanceEnum;
 import org.apache.calcite.tools.*;
 import org.apache.calcite.util.JsonBuilder;
 import org.apache.calcite.util.Pair;
+import org.apache.calcite.util.Util;
 import org.json.JSONArray;
 import org.json.JSONObject;
 import org.apache.calcite.rel.externalize.RelJsonWriter;
@@ -45,36 +62,108 @@ import com.fasterxml.jackson.core.JsonProcessingException;
 
 import java.sql.SQLException;
 import java.util.ArrayList;
+import java.util.EnumSet;
 import java.util.List;
+import java.util.Map;
 import java.util.Set;
+import java.util.TreeMap;
 import java.util.concurrent.ExecutorService;
 import java.util.concurrent.Executors;
 import java.util.logging.Logger;
+import java.util.regex.Matcher;
+import java.util.regex.Pattern;
 import java.io.*;
 import java.net.*;
 import java.util.HashMap;
+import java.util.HashSet;
 
 public class QueryPlanner {
   private static Logger logger = Logger.getLogger(QueryPlanner.class.getName());
-  private final Planner planner;
+  private static final Pattern JSON_ARROW_CHAIN = Pattern.compile(
+      "(`?[A-Za-z_]\\w*`?(?:\\.`?[A-Za-z_]\\w*`?)?)((?:\\s*->>?\\s*(?:'[^']*'|\\d+))+)");
+  private static final Pattern JSON_ARROW = Pattern.compile("\\s*(->>?)\\s*(?:'([^']*)'|(\\d+))");
+  private static final Pattern ON_CONFLICT = Pattern.compile(
+      "(?is)^\\s*(INSERT\\b.*?)\\s+ON\\s+CONFLICT\\s*\\(\\s*`?(\\w+)`?\\s*\\)\\s*DO\\s+(NOTHING|UPDATE\\s+SET\\s+(.+?))\\s*;?\\s*$");
+  private static final Pattern RETURNING = Pattern.compile(
+      "(?is)^\\s*((?:INSERT|UPDATE|DELETE)\\b.*)\\s+RETURNING\\s+(\\*|`?\\w+`?(?:\\s*,\\s*`?\\w+`?)*)\\s*;?\\s*$");
+  private static final Pattern CREATE_SEQUENCE = Pattern.compile(
+      "(?is)^\\s*CREATE\\s+SEQUENCE\\s+(IF\\s+NOT\\s+EXISTS\\s+)?`?(\\w+)`?(.*?)\\s*;?\\s*$");
+  private static final Pattern SEQUENCE_START = Pattern.compile("(?i)\\bSTART\\s+(?:WITH\\s+)?(-?\\d+)");
+  private static final Pattern SEQUENCE_INCREMENT = Pattern.compile("(?i)\\bINCREMENT\\s+(?:BY\\s+)?(-?\\d+)");
+  private static final Pattern ALTER_TABLE = Pattern.compile(
+      "(?is)^\\s*ALTER\\s+TABLE\\s+(`?\\w+`?(?:\\.`?\\w+`?)?)\\s+(.+?)\\s*;?\\s*$");
+  private static final Pattern ALTER_ADD = Pattern.compile(
+      "(?is)^ADD\\s+(?:COLUMN\\s+)?`?(\\w+)`?\\s+(\\w+(?:\\s*\\([^)]*\\))?)(?:\\s+DEFAULT\\s+('(?:[^']|'')*'|\\S+))?$");
+  private static final Pattern ALTER_DROP = Pattern.compile(
+      "(?is)^DROP\\s+(?:COLUMN\\s+)?(IF\\s+EXISTS\\s+)?`?(\\w+)`?$");
+  private static final Pattern ALTER_BLOOM = Pattern.compile(
+      "(?is)^(ADD|DROP)\\s+BLOOM\\s+FILTER\\s*\\(([^)]*)\\)$");
+  private static final Pattern ALTER_RENAME_TABLE = Pattern.compile("(?is)^RENAME\\s+TO\\s+`?(\\w+)`?$");
+  private static final Pattern ALTER_RENAME_COLUMN = Pattern.compile(
+      "(?is)^RENAME\\s+(?:COLUMN\\s+)?`?(\\w+)`?\\s+TO\\s+`?(\\w+)`?$");
+  private static final Pattern FOREIGN_KEY = Pattern.compile(
+      "(?is),\\s*(?:CONSTRAINT\\s+`?(\\w+)`?\\s+)?FOREIGN\\s+KEY\\s*\\(([^)]*)\\)\\s*REFERENCES\\s+`?(\\w+(?:`?\\.`?\\w+)?)`?"
+          + "\\s*(?:\\(([^)]*)\\))?((?:\\s+ON\\s+(?:DELETE|UPDATE)\\s+(?:RESTRICT|CASCADE|SET\\s+NULL|NO\\s+ACTION))*)");
+  private static final Pattern REFERENCE_ACTION = Pattern.compile(
+      "(?i)ON\\s+(DELETE|UPDATE)\\s+(RESTRICT|CASCADE|SET\\s+NULL|NO\\s+ACTION)");
+  private static final Pattern CREATE_VIEW = Pattern.compile(
+      "(?is)^\\s*CREATE\\s+(OR\\s+REPLACE\\s+)?VIEW\\s+`?(\\w+)`?\\s*(?:\\(([^)]*)\\))?\\s*AS\\s+(.+?)\\s*;?\\s*$");
+  private static final Pattern DROP_VIEW = Pattern.compile(
+      "(?is)^\\s*DROP\\s+VIEW\\s+(IF\\s+EXISTS\\s+)?`?(\\w+)`?\\s*;?\\s*$");
+  private static final int MAX_VIEW_DEPTH = 16;
+  private static final Pattern CREATE_INDEX = Pattern.compile(
+      "(?is)^\\s*CREATE\\s+(UNIQUE\\s+)?INDEX\\s+(IF\\s+NOT\\s+EXISTS\\s+)?`?(\\w+)`?\\s+ON\\s+`?(\\w+(?:`?\\.`?\\w+)?)`?\\s*\\(([^)]*)\\)\\s*;?\\s*$");
+  private static final Pattern DROP_INDEX = Pattern.compile(
+      "(?is)^\\s*DROP\\s+INDEX\\s+(IF\\s+EXISTS\\s+)?`?(\\w+(?:`?\\.`?\\w+)?)`?(?:\\s+ON\\s+`?(\\w+(?:`?\\.`?\\w+)?)`?)?\\s*;?\\s*$");
+  private static final Pattern CREATE_MATERIALIZED_VIEW = Pattern.compile(
+      "(?is)^\\s*CREATE\\s+MATERIALIZED\\s+VIEW\\s+`?(\\w+)`?\\s+AS\\s+(.+?)\\s*;?\\s*$");
+  private static final Pattern REFRESH_MATERIALIZED_VIEW = Pattern.compile(
+      "(?is)^\\s*REFRESH\\s+MATERIALIZED\\s+VIEW\\s+`?(\\w+)`?\\s*;?\\s*$");
+  private static final Pattern DROP_MATERIALIZED_VIEW = Pattern.compile(
+      "(?is)^\\s*DROP\\s+MATERIALIZED\\s+VIEW\\s+(IF\\s+EXISTS\\s+)?`?(\\w+)`?\\s*;?\\s*$");
+  // USE db alone or in front of a query: USE db; SELECT ...
+  private static final Pattern USE_DATABASE = Pattern.compile(
+      "(?is)^\\s*USE\\s+`?(\\w+)`?(?:\\s*;\\s*(.+?))?\\s*;?\\s*$");
+  private static final Pattern CREATE_DATABASE = Pattern.compile(
+      "(?is)^\\s*CREATE\\s+(?:DATABASE|SCHEMA)\\s+(IF\\s+NOT\\s+EXISTS\\s+)?`?(\\w+)`?\\s*;?\\s*$");
+  private static final Pattern DROP_DATABASE = Pattern.compile(
+      "(?is)^\\s*DROP\\s+(?:DATABASE|SCHEMA)\\s+(IF\\s+EXISTS\\s+)?`?(\\w+)`?\\s*;?\\s*$");
+  private static final Pattern SHOW_TABLES = Pattern.compile(
+      "(?is)^\\s*SHOW\\s+TABLES(?:\\s+(?:FROM|IN)\\s+`?(\\w+)`?)?\\s*;?\\s*$");
+  // aggregates the engine keeps current in materialized views
+  private static final Set<SqlKind> VIEW_AGGREGATES = EnumSet.of(SqlKind.COUNT, SqlKind.SUM, SqlKind.AVG, SqlKind.MIN, SqlKind.MAX);
+  private static final Pattern AUTO_INCREMENT = Pattern.compile(
+      "(?i)\\b(?:TINYINT|SMALLINT|MEDIUMINT|INTEGER|INT|BIGINT)(?:\\s*\\(\\s*\\d+\\s*\\))?((?:\\s+NOT\\s+NULL)?)\\s+AUTO_INCREMENT\\b");
+  private Planner planner;
   private final SchemaPlus rootSchema;
+  private final Config parserConfig;
+  private String database; // chosen by USE, bare names are looked up there first
 
   private QueryPlanner() {
-    Config parserConfig = SqlParser.config()
+    this.parserConfig = SqlParser.config()
         .withLex(Lex.MYSQL)
         .withParserFactory(SqlDdlParserImpl.FACTORY)
         .withConformance(SqlConformanceEnum.LENIENT);
 
     this.rootSchema = Frameworks.createRootSchema(true);
+    this.planner = newPlanner(rootSchema);
+  }
+
+  // the default schema resolves the bare table names, calcite falls back to
+  // the root schema for the tables outside of any database
+  private Planner newPlanner(SchemaPlus defaultSchema) {
     FrameworkConfig calciteFrameworkConfig = Frameworks.newConfigBuilder()
         .parserConfig(parserConfig)
-        .defaultSchema(rootSchema)
+        .defaultSchema(defaultSchema)
         .context(Contexts.EMPTY_CONTEXT)
         .costFactory(null)
         .typeSystem(RelDataTypeSystem.DEFAULT)
+        // NOW(), DATE_TRUNC, DATE_PART... live outside of the standard operator table
+        .operatorTable(SqlLibraryOperatorTableFactory.INSTANCE.getOperatorTable(
+            SqlLibrary.STANDARD, SqlLibrary.POSTGRESQL, SqlLibrary.BIG_QUERY, SqlLibrary.MYSQL))
         .build();
 
-    this.planner = Frameworks.getPlanner(calciteFrameworkConfig);
+    return Frameworks.getPlanner(calciteFrameworkConfig);
   }
 
   public static QueryPlanner create() {
@@ -85,23 +174,152 @@ public class QueryPlanner {
     String jsonPlan = "";
 
     try {
-      SqlNode sqlNode = planner.parse(query);
+      // the engine sends the database of the session in front of the query
+      Matcher use = USE_DATABASE.matcher(query);
+      while (use.matches()) {
+        if (use.group(2) == null) {
+          planner.close();
+          return handleUse(use.group(1));
+        }
+        useDatabase(use.group(1));
+        query = use.group(2);
+        use = USE_DATABASE.matcher(query);
+      }
+
+      Matcher createDatabase = CREATE_DATABASE.matcher(query);
+      if (createDatabase.matches()) {
+        planner.close();
+        return handleCreateDatabase(createDatabase);
+      }
+
+      Matcher dropDatabase = DROP_DATABASE.matcher(query);
+      if (dropDatabase.matches()) {
+        planner.close();
+        return handleDropDatabase(dropDatabase);
+      }
+
+      Matcher showTables = SHOW_TABLES.matcher(query);
+      if (showTables.matches()) {
+        planner.close();
+        return handleShowTables(showTables);
+      }
+
+      // neither RETURNING nor ON CONFLICT are calcite syntax, RETURNING
+      // closes the statement so it's cut off first
+      JSONArray returning = null;
+      Matcher returningMatch = RETURNING.matcher(query);
+      if (returningMatch.matches()) {
+        query = returningMatch.group(1);
+        returning = encodeReturning(returningMatch.group(2));
+      }
+
+      // then ON CONFLICT, the clause is cut off and encoded on its own
+      JSONObject onConflict = null;
+      Matcher conflict = ON_CONFLICT.matcher(query);
+      if (conflict.matches()) {
+        query = conflict.group(1);
+        onConflict = encodeOnConflict(conflict);
+      }
+
+      // calcite's ddl parser has no sequences
+      Matcher sequence = CREATE_SEQUENCE.matcher(query);
+      if (sequence.matches()) {
+        planner.close();
+        return handleCreateSequence(sequence);
+      }
+
+      // nor ALTER TABLE
+      Matcher alter = ALTER_TABLE.matcher(query);
+      if (alter.matches()) {
+        planner.close();
+        return handleAlterTable(resolveName(alter.group(1).replace("`", "")), alter.group(2));
+      }
+
+      // views are kept as the text of their SELECT, calcite's nodes don't
+      // print back as mysql
+      Matcher createView = CREATE_VIEW.matcher(query);
+      if (createView.matches()) {
+        jsonPlan = handleCreateView(createView);
+        planner.close();
+        return jsonPlan;
+      }
+
+      Matcher dropView = DROP_VIEW.matcher(query);
+      if (dropView.matches()) {
+        planner.close();
+        return handleDropView(dropView);
+      }
+
+      Matcher createIndex = CREATE_INDEX.matcher(query);
+      if (createIndex.matches()) {
+        planner.close();
+        return handleCreateIndex(createIndex);
+      }
+
+      Matcher dropIndex = DROP_INDEX.matcher(query);
+      if (dropIndex.matches()) {
+        planner.close();
+        return handleDropIndex(dropIndex);
+      }
+
+      Matcher createMaterialized = CREATE_MATERIALIZED_VIEW.matcher(query);
+      if (createMaterialized.matches()) {
+        jsonPlan = handleCreateMaterializedView(createMaterialized);
+        planner.close();
+        return jsonPlan;
+      }
+
+      Matcher refreshMaterialized = REFRESH_MATERIALIZED_VIEW.matcher(query);
+      if (refreshMaterialized.matches()) {
+        planner.close();
+        return handleRefreshMaterializedView(refreshMaterialized);
+      }
+
+      Matcher dropMaterialized = DROP_MATERIALIZED_VIEW.matcher(query);
+      if (dropMaterialized.matches()) {
+        planner.close();
+        return handleDropMaterializedView(dropMaterialized);
+      }
+
+      // INT AUTO_INCREMENT is the mysql spelling of SERIAL
+      query = AUTO_INCREMENT.matcher(query).replaceAll("SERIAL$1");
+
+      // calcite's ddl parser has no FOREIGN KEY, the clauses are cut off the
+      // CREATE TABLE and added to its constraints
+      JSONArray foreignKeys = new JSONArray();
+      query = cutForeignKeys(query, foreignKeys);
+
+      SqlNode sqlNode = planner.parse(rewriteJsonSyntax(query));
       if (sqlNode instanceof SqlCreateTable) {
-        jsonPlan = handleCreate(sqlNode);
+        jsonPlan = handleCreate(sqlNode, foreignKeys);
       } else if (sqlNode instanceof SqlSelect) {
         jsonPlan = handleSelect(sqlNode);
       } else if (sqlNode instanceof SqlInsert) {
         jsonPlan = handleInsert(sqlNode);
+        if (onConflict != null) {
+          jsonPlan = new JSONObject(jsonPlan).put("onConflict", onConflict).toString();
+        }
       } else if (sqlNode instanceof SqlOrderBy) {
         jsonPlan = handleOrderBy(sqlNode);
       } else if (sqlNode instanceof SqlDelete) {
         jsonPlan = handleDelete(sqlNode);
       } else if (sqlNode instanceof SqlUpdate) {
         jsonPlan = handleUpdate(sqlNode);
+      } else if (sqlNode instanceof SqlTruncateTable) {
+        jsonPlan = handleTruncate(sqlNode);
+      } else if (sqlNode instanceof SqlDropTable) {
+        jsonPlan = handleDropTable(sqlNode);
       } else {
         throw new Exception("sqlNode type unhandled");
       }
 
+      if (returning != null) {
+        if (!(sqlNode instanceof SqlInsert || sqlNode instanceof SqlUpdate || sqlNode instanceof SqlDelete)) {
+          throw new Exception("RETURNING is only supported by INSERT, UPDATE and DELETE");
+        }
+        jsonPlan = new JSONObject(jsonPlan).put("returning", returning).toString();
+      }
+
     } catch (Exception e) {
       planner.close();
       jsonPlan = handleExepction(e);
@@ -111,6 +329,111 @@ public class QueryPlanner {
     return jsonPlan;
   }
 
+  // calcite doesn't parse the -> / ->> operators, they are rewritten to the sql/json
+  // functions: data->'a'->>'b' => JSON_VALUE(data, 'lax $.a.b')
+  static String rewriteJsonSyntax(String query) {
+    Matcher chain = JSON_ARROW_CHAIN.matcher(query);
+    StringBuffer rewritten = new StringBuffer();
+
+    while (chain.find()) {
+      String path = "$";
+      String function = "JSON_QUERY";
+
+      Matcher arrow = JSON_ARROW.matcher(chain.group(2));
+      while (arrow.find()) {
+        function = arrow.group(1).equals("->>") ? "JSON_VALUE" : "JSON_QUERY";
+
+        String key = arrow.group(2);
+        if (key == null) {
+          path += "[" + arrow.group(3) + "]";
+        } else if (key.startsWith("$")) {
+          path += key.substring(1);
+        } else if (key.matches("\\w+")) {
+          path += "." + key;
+        } else {
+          path += ".\"" + key + "\"";
+        }
+      }
+
+      String replacement = function + "(" + chain.group(1) + ", 'lax " + path + "')";
+      chain.appendReplacement(rewritten, Matcher.quoteReplacement(replacement));
+    }
+    chain.appendTail(rewritten);
+
+    return rewritten.toString()
+        .replaceAll("(?i)\\bjson_array_length\\s*\\(", "JSON_LENGTH(")
+        .replaceAll("(?i)\\bjson_typeof\\s*\\(", "JSON_TYPE(");
+  }
+
+  // encodes an expression with the same shape the RelJsonWriter uses for rex nodes,
+  // except that columns are referenced by name: {"column": "Age"}
+  private JSONObject encodeExpression(SqlNode node) {
+    JSONObject json = new JSONObject();
+
+    if (node instanceof SqlIdentifier) {
+      SqlIdentifier identifier = (SqlIdentifier) node;
+      if (identifier.names.size() > 1) {
+        json.put("qualifier", identifier.names.get(0));
+      }
+      json.put("column", Util.last(identifier.names));
+      return json;
+    }
+
+    if (node instanceof SqlIntervalLiteral) {
+      SqlIntervalLiteral.IntervalValue interval = (SqlIntervalLiteral.IntervalValue) ((SqlIntervalLiteral) node).getValue();
+      String sign = interval.getSign() < 0 ? "-" : "";
+      json.put("literal", sign + interval.getIntervalLiteral() + " " + interval.getIntervalQualifier().timeUnitRange.startUnit.name());
+      json.put("type", new JSONObject().put("type", "INTERVAL"));
+      return json;
+    }
+
+    if (node instanceof SqlLiteral) {
+      SqlLiteral literal = (SqlLiteral) node;
+      String value = literal.toValue();
+      json.put("literal", value == null ? JSONObject.NULL : value);
+      json.put("type", new JSONObject().put("type", literal.getTypeName().getName()));
+      return json;
+    }
+
+    if (node instanceof SqlIntervalQualifier) { // EXTRACT(YEAR FROM ...)
+      json.put("literal", ((SqlIntervalQualifier) node).timeUnitRange.name());
+      json.put("type", new JSONObject().put("type", "SYMBOL"));
+      return json;
+    }
+
+    SqlCall call = (SqlCall) node;
+    JSONArray operands = new JSONArray();
+
+    for (SqlNode operand : call.getOperandList()) {
+      if (operand == null) {
+        continue;
+      }
+
+      if (operand instanceof SqlDataTypeSpec) { // CAST(x AS type)
+        json.put("type", new JSONObject().put("type", ((SqlDataTypeSpec) operand).getTypeName().getSimple()));
+        continue;
+      }
+
+      if (operand instanceof SqlNodeList) { // x IN (1, 2, 3)
+        for (SqlNode item : (SqlNodeList) operand) {
+          operands.put(encodeExpression(item));
+        }
+        continue;
+      }
+
+      operands.put(encodeExpression(operand));
+    }
+
+    JSONObject op = new JSONObject();
+    op.put("kind", call.getKind().name());
+    op.put("name", call.getOperator().getName());
+
+    json.put("op", op);
+    json.put("operands", operands);
+
+    return json;
+  }
+
   private String handleExepction(Exception e) {
     JSONObject errorResponse = new JSONObject();
 
@@ -123,59 +446,212 @@ public class QueryPlanner {
 
   private String handleUpdate(SqlNode node) {
     SqlUpdate updateNode = (SqlUpdate) node;
+    String tableName = tableName(updateNode.getTargetTable());
+    checkNotView(tableName);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "UPDATE");
+    jsonObj.put("table", tableName);
+    jsonObj.put("assignments", encodeAssignments(updateNode));
 
     SqlNode updateCondition = updateNode.getCondition();
-    SqlBasicCall condition = (SqlBasicCall) updateCondition;
+    if (updateCondition != null) {
+      jsonObj.put("condition", encodeExpression(updateCondition));
+    }
 
-    String leftOperand = condition.getOperandList().get(0).toString();
-    String rightOperand = condition.getOperandList().get(1).toString();
+    return jsonObj.toString();
+  }
+
+  // SET a = expr, b = expr ... every expression sees the old row
+  private JSONArray encodeAssignments(SqlUpdate updateNode) {
+    JSONArray assignments = new JSONArray();
+    SqlNodeList targetColumns = updateNode.getTargetColumnList();
+    SqlNodeList sourceExpressions = updateNode.getSourceExpressionList();
+
+    for (int i = 0; i < targetColumns.size(); i++) {
+      JSONObject assignment = new JSONObject();
+      assignment.put("column", Util.last(((SqlIdentifier) targetColumns.get(i)).names));
+      assignment.put("expr", encodeExpression(sourceExpressions.get(i)));
+      assignments.put(assignment);
+    }
 
-    String tableName = updateNode.getTargetTable().toString();
+    return assignments;
+  }
 
-    String targetColumn = updateNode.getTargetColumnList().get(0).toString();
-    String sourceExpression = updateNode.getSourceExpressionList().get(0).toString();
+  // ON CONFLICT (col) DO NOTHING | DO UPDATE SET ..., the SET list is parsed
+  // as an UPDATE so EXCLUDED.col comes out as a qualified column
+  private JSONObject encodeOnConflict(Matcher conflict) throws SqlParseException {
+    JSONObject json = new JSONObject();
+    json.put("column", conflict.group(2));
 
+    if (conflict.group(4) == null) {
+      json.put("action", "NOTHING");
+      return json;
+    }
+
+    String setList = rewriteJsonSyntax(conflict.group(4));
+    SqlUpdate updateNode = (SqlUpdate) SqlParser.create("UPDATE t SET " + setList, parserConfig).parseStmt();
+
+    json.put("action", "UPDATE");
+    json.put("assignments", encodeAssignments(updateNode));
+
+    return json;
+  }
+
+  // CREATE SEQUENCE [IF NOT EXISTS] name [START [WITH] n] [INCREMENT [BY] n], the
+  // bounds are sent as strings so the engine gets every int64 intact
+  private String handleCreateSequence(Matcher sequence) {
     JSONObject jsonObj = new JSONObject();
-    jsonObj.put("STATEMENT", "UPDATE");
-    jsonObj.put("table", tableName);
+    jsonObj.put("STATEMENT", "CREATE_SEQUENCE");
+    jsonObj.put("name", sequence.group(2));
+    jsonObj.put("ifNotExists", sequence.group(1) != null);
 
-    jsonObj.put("modify_column", targetColumn);
-    jsonObj.put("modify_value", sourceExpression);
+    Matcher start = SEQUENCE_START.matcher(sequence.group(3));
+    if (start.find()) {
+      jsonObj.put("start", start.group(1));
+    }
 
-    jsonObj.put("filter_column", leftOperand);
-    jsonObj.put("filter_value", rightOperand);
+    Matcher increment = SEQUENCE_INCREMENT.matcher(sequence.group(3));
+    if (increment.find()) {
+      jsonObj.put("increment", increment.group(1));
+    }
 
     return jsonObj.toString();
   }
 
-  private String handleDelete(SqlNode node) {
+  // ALTER TABLE name ADD [COLUMN] col type [DEFAULT v] | DROP [COLUMN] [IF EXISTS] col |
+  // RENAME [COLUMN] col TO new | RENAME TO new | REWRITE |
+  // ADD BLOOM FILTER (cols) | DROP BLOOM FILTER (cols), the schema kept here
+  // follows the change like it does for CREATE and DROP
+  private String handleAlterTable(String tableName, String action) throws Exception {
     JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "ALTER_TABLE");
+    jsonObj.put("table", tableName);
+
+    List<Pair<String, String>> columns = getSchema(tableName);
+
+    Matcher bloom = ALTER_BLOOM.matcher(action);
+    Matcher add = ALTER_ADD.matcher(action);
+    Matcher drop = ALTER_DROP.matcher(action);
+    Matcher renameTable = ALTER_RENAME_TABLE.matcher(action);
+    Matcher renameColumn = ALTER_RENAME_COLUMN.matcher(action);
+
+    if (bloom.matches()) { // before ADD, it would take BLOOM for a column
+      jsonObj.put("action", bloom.group(1).toUpperCase() + "_BLOOM_FILTER");
+      jsonObj.put("columns", encodeColumnList(bloom.group(2)));
+    } else if (add.matches()) {
+      String colType = add.group(2).replaceAll("\\s+", "").toUpperCase(); // DECIMAL(10,2) is enforced by the engine
+      jsonObj.put("action", "ADD_COLUMN");
+      jsonObj.put("column", add.group(1));
+      jsonObj.put("type", colType);
+
+      String defaultVal = add.group(3);
+      if (defaultVal != null && !defaultVal.equalsIgnoreCase("NULL")) {
+        if (defaultVal.startsWith("'")) {
+          defaultVal = defaultVal.substring(1, defaultVal.length() - 1).replace("''", "'");
+        }
+        jsonObj.put("default", defaultVal);
+      }
+
+      columns.add(Pair.of(add.group(1), colType));
+    } else if (drop.matches()) {
+      jsonObj.put("action", "DROP_COLUMN");
+      jsonObj.put("column", drop.group(2));
+      jsonObj.put("ifExists", drop.group(1) != null);
+
+      columns.removeIf(column -> column.left.equals(drop.group(2)));
+    } else if (renameTable.matches()) {
+      // the table stays in its database
+      String newName = tableName.contains(".")
+          ? tableName.substring(0, tableName.indexOf('.') + 1) + renameTable.group(1)
+          : renameTable.group(1);
+      jsonObj.put("action", "RENAME_TABLE");
+      jsonObj.put("newName", newName);
+
+      checkNoViews(tableName); // they hold the name in their text
+
+      DbSchemas.remove(tableName);
+      tableName = newName;
+    } else if (renameColumn.matches()) {
+      jsonObj.put("action", "RENAME_COLUMN");
+      jsonObj.put("column", renameColumn.group(1));
+      jsonObj.put("newName", renameColumn.group(2));
+
+      columns.replaceAll(column -> column.left.equals(renameColumn.group(1))
+          ? Pair.of(renameColumn.group(2), column.right)
+          : column);
+    } else if (action.equalsIgnoreCase("REWRITE")) {
+      jsonObj.put("action", "REWRITE");
+    } else {
+      throw new Exception("unsupported ALTER TABLE action: " + action);
+    }
+
+    JSONArray columnsArray = new JSONArray();
+    for (Pair<String, String> column : columns) {
+      columnsArray.put(new JSONObject().put(column.left, column.right));
+    }
+    DbSchemas.put(tableName, columnsArray.toString());
+
+    return jsonObj.toString();
+  }
+
+  // RETURNING * | col, ... => ["*"] | ["col", ...]
+  private static JSONArray encodeReturning(String list) {
+    JSONArray columns = new JSONArray();
+    for (String column : list.split(",")) {
+      columns.put(column.trim().replace("`", ""));
+    }
+
+    return columns;
+  }
 
+  private String handleDelete(SqlNode node) {
     SqlDelete deleteNode = (SqlDelete) node;
-    String tableName = deleteNode.getTargetTable().toString();
+    String tableName = tableName(deleteNode.getTargetTable());
+    checkNotView(tableName);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "DELETE");
+    jsonObj.put("table", tableName);
 
     SqlNode whereCondition = deleteNode.getCondition();
     if (whereCondition != null) {
-      if (whereCondition instanceof SqlCall) {
-        SqlCall whereCall = (SqlCall) whereCondition;
-        if (whereCall.getOperator().getName().equals("=")) {
-          SqlNode leftOperand = whereCall.operand(0);
-          SqlNode rightOperand = whereCall.operand(1);
-
-          String columnName = leftOperand.toString();
-          String value = rightOperand.toString();
-
-          jsonObj.put("STATEMENT", "DELETE");
-          jsonObj.put("table", tableName);
-          jsonObj.put("column", columnName);
-          jsonObj.put("value", value);
-        }
-      }
+      jsonObj.put("condition", encodeExpression(whereCondition));
     }
 
     return jsonObj.toString();
   }
 
+  private String handleTruncate(SqlNode node) {
+    SqlTruncateTable truncateNode = (SqlTruncateTable) node;
+    String tableName = tableName(truncateNode.name);
+    checkNotView(tableName);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "TRUNCATE");
+    jsonObj.put("table", tableName);
+
+    return jsonObj.toString();
+  }
+
+  // the schema is forgotten here like it's registered by handleCreate,
+  // the engine drops the files
+  private String handleDropTable(SqlNode node) {
+    SqlDropTable dropNode = (SqlDropTable) node;
+    String tableName = tableName(dropNode.name);
+
+    checkNotView(tableName);
+    checkNoViews(tableName);
+    DbSchemas.remove(tableName);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "DROP_TABLE");
+    jsonObj.put("table", tableName);
+    jsonObj.put("ifExists", dropNode.ifExists);
+
+    return jsonObj.toString();
+  }
+
   private String handleOrderBy(SqlNode node)
       throws ValidationException, RelConversionException, JsonProcessingException {
     String jsonPlan = "";
@@ -191,19 +667,24 @@ public class QueryPlanner {
     jsonPlan = handleSelect(query);
 
     SqlNodeList orderList = orderByNode.orderList;
+    JSONObject sortExpression = null;
 
     for (SqlNode order : orderList) {
-      if (order instanceof SqlBasicCall) {
+      if (order.getKind() == SqlKind.DESCENDING) {
         SqlBasicCall sqlBasicCall = (SqlBasicCall) order;
 
         List<SqlNode> operands = sqlBasicCall.getOperandList();
-        column = operands.get(0).toString();
+        order = operands.get(0);
         isDesc = true;
       }
+
+      column = order.toString().replace("`", "");
+      if (!(order instanceof SqlIdentifier)) {
+        sortExpression = encodeExpression(order);
+      }
     }
 
     sortDirection = isDesc ? "DESC" : "ASC";
-    column = !isDesc ? orderList.toString().replace("`", "") : column;
 
     ObjectMapper objectMapper = new ObjectMapper();
     JsonNode rootNode = objectMapper.readTree(jsonPlan);
@@ -214,6 +695,9 @@ public class QueryPlanner {
     newRelObject.put("sortDirection", sortDirection);
     newRelObject.put("column", column);
     newRelObject.put("limit", fetchVal);
+    if (sortExpression != null) {
+      newRelObject.set("expr", objectMapper.readTree(sortExpression.toString()));
+    }
 
     relsArray.add(newRelObject);
 
@@ -222,9 +706,24 @@ public class QueryPlanner {
     return jsonPlan;
   }
 
-  private String handleInsert(SqlNode node) {
+  // values are sent as their sql text, nextval('seq') is resolved by the engine
+  private static Object encodeInsertValue(SqlNode value) {
+    if (value instanceof SqlBasicCall) {
+      SqlBasicCall call = (SqlBasicCall) value;
+      if (call.getOperator().getName().equalsIgnoreCase("NEXTVAL") && call.operandCount() == 1
+          && call.operand(0) instanceof SqlLiteral) {
+        return new JSONObject().put("nextval", ((SqlLiteral) call.operand(0)).getValueAs(String.class));
+      }
+    }
+
+    return value.toString();
+  }
+
+  private String handleInsert(SqlNode node)
+      throws ValidationException, RelConversionException, JsonProcessingException {
     SqlInsert insertNode = (SqlInsert) node;
-    String tableName = insertNode.getTargetTable().toString();
+    String tableName = tableName(insertNode.getTargetTable());
+    checkNotView(tableName);
 
     List<String> columnNames = new ArrayList<>();
     if (insertNode.getTargetColumnList() != null) {
@@ -233,15 +732,29 @@ public class QueryPlanner {
       }
     }
 
-    List<List<String>> rows = new ArrayList<>();
+    // INSERT INTO t (cols) SELECT ... carries the plan of the select
+    SqlNode source = insertNode.getSource();
+    if (source instanceof SqlSelect || source instanceof SqlOrderBy) {
+      String sourcePlan = source instanceof SqlSelect ? handleSelect(source) : handleOrderBy(source);
+
+      JSONObject jsonBuilder = new JSONObject();
+      jsonBuilder.put("STATEMENT", "INSERT");
+      jsonBuilder.put("table", tableName);
+      jsonBuilder.put("selectedCols", new JSONArray(columnNames));
+      jsonBuilder.put("source", new JSONObject(sourcePlan));
+
+      return jsonBuilder.toString();
+    }
+
+    List<List<Object>> rows = new ArrayList<>();
     SqlBasicCall allRowsNode = (SqlBasicCall) insertNode.getSource();
 
     for (SqlNode operand : allRowsNode.getOperandList()) {
       SqlBasicCall singleRowNode = (SqlBasicCall) operand;
 
-      List<String> row = new ArrayList<>();
+      List<Object> row = new ArrayList<>();
       for (SqlNode rowValue : singleRowNode.getOperandList()) {
-        row.add(rowValue.toString());
+        row.add(encodeInsertValue(rowValue));
       }
       rows.add(row);
     }
@@ -260,6 +773,7 @@ public class QueryPlanner {
 
   private String handleSelect(SqlNode node)
       throws ValidationException, RelConversionException, JsonProcessingException {
+    node = expandViews(node, 0);
     List<String> tableNames = GetTableName(node);
     HashMap<String, String> refEntries = setSchemas(tableNames);
 
@@ -319,11 +833,675 @@ public class QueryPlanner {
     return jsonResponse;
   }
 
-  private String handleCreate(SqlNode node) {
+  // CREATE [OR REPLACE] VIEW name [(columns)] AS SELECT ..., a view filters and
+  // projects a single table or view. The definition is validated like a query
+  // and kept here for expandViews, the engine stores it in its catalog
+  private String handleCreateView(Matcher view) throws Exception {
+    String viewName = qualifyName(view.group(2));
+    String sql = view.group(4);
+
+    if (DbSchemas.get(viewName) != null) {
+      throw new Exception("table: " + viewName + " already exists");
+    }
+    if (view.group(1) == null && DbSchemas.getView(viewName) != null) {
+      throw new Exception("view: " + viewName + " already exists");
+    }
+
+    SqlNode query = parseQuery(sql);
+    if (!(query instanceof SqlSelect)) {
+      throw new Exception("view: " + viewName + " must be a SELECT without ORDER BY or LIMIT");
+    }
+
+    SqlSelect select = (SqlSelect) query;
+    if (select.isDistinct() || select.getGroup() != null || select.getHaving() != null) {
+      throw new Exception("view: " + viewName + " can only filter and project, DISTINCT and GROUP BY aren't supported");
+    }
+
+    String from = viewSource(select);
+    if (readsView(from, viewName)) {
+      throw new Exception("view: " + viewName + " can't read itself");
+    }
+
+    // planned like a query to validate it, aggregates come out as their own rel
+    JSONArray rels = new JSONObject(handleSelect(planner.parse(rewriteJsonSyntax(sql)))).getJSONArray("rels");
+    for (int i = 0; i < rels.length(); i++) {
+      if (rels.getJSONObject(i).getString("relOp").equals("LogicalAggregate")) {
+        throw new Exception("view: " + viewName + " can only filter and project, aggregates aren't supported");
+      }
+    }
+
+    // col => col, expr AS name => name, * => the columns of the source
+    List<String> columns = new ArrayList<>();
+    for (SqlNode item : select.getSelectList()) {
+      if (item instanceof SqlIdentifier && ((SqlIdentifier) item).isStar()) {
+        columns.addAll(sourceColumns(from));
+      } else if (item.getKind() == SqlKind.AS) {
+        columns.add(((SqlIdentifier) ((SqlBasicCall) item).operand(1)).getSimple());
+      } else if (item instanceof SqlIdentifier) {
+        columns.add(Util.last(((SqlIdentifier) item).names));
+      } else {
+        columns.add(null);
+      }
+    }
+
+    if (view.group(3) != null) {
+      JSONArray declared = encodeColumnList(view.group(3));
+      if (declared.length() != columns.size()) {
+        throw new Exception("view: " + viewName + " names " + declared.length() + " columns, its query has " + columns.size());
+      }
+      for (int i = 0; i < declared.length(); i++) {
+        columns.set(i, declared.getString(i));
+      }
+    }
+
+    Set<String> seen = new HashSet<>();
+    for (String column : columns) {
+      if (column == null) {
+        throw new Exception("view: " + viewName + " needs a name for every expression, use AS");
+      }
+      if (!seen.add(column.toLowerCase())) {
+        throw new Exception("view: " + viewName + " has the column: " + column + " twice");
+      }
+    }
+
+    JSONObject definition = new JSONObject();
+    definition.put("query", sql);
+    definition.put("columns", new JSONArray(columns));
+    definition.put("from", from);
+    DbSchemas.putView(viewName, definition.toString());
+
+    definition.put("STATEMENT", "CREATE_VIEW");
+    definition.put("name", viewName);
+    definition.put("orReplace", view.group(1) != null);
+
+    return definition.toString();
+  }
+
+  private String handleDropView(Matcher view) {
+    String viewName = resolveName(view.group(2));
+
+    checkNoViews(viewName);
+    DbSchemas.removeView(viewName);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "DROP_VIEW");
+    jsonObj.put("name", viewName);
+    jsonObj.put("ifExists", view.group(1) != null);
+
+    return jsonObj.toString();
+  }
+
+  // CREATE [UNIQUE] INDEX [IF NOT EXISTS] name ON table (a, b), the index is
+  // named in the database of its table, the engine builds it from the rows
+  private String handleCreateIndex(Matcher index) {
+    String tableName = resolveName(index.group(4).replace("`", ""));
+    if (DbSchemas.get(tableName) == null) {
+      throw new IllegalArgumentException("table: " + tableName + " doesn't exist");
+    }
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "CREATE_INDEX");
+    jsonObj.put("name", indexName(index.group(3), tableName));
+    jsonObj.put("table", tableName);
+    jsonObj.put("columns", encodeColumnList(index.group(5)));
+    jsonObj.put("unique", index.group(1) != null);
+    jsonObj.put("ifNotExists", index.group(2) != null);
+
+    return jsonObj.toString();
+  }
+
+  // DROP INDEX [IF EXISTS] name [ON table], mysql names the table
+  private String handleDropIndex(Matcher index) {
+    String name = index.group(2).replace("`", "");
+    if (!name.contains(".")) {
+      String tableName = index.group(3) != null ? resolveName(index.group(3).replace("`", "")) : qualifyName(name);
+      name = indexName(name, tableName);
+    }
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "DROP_INDEX");
+    jsonObj.put("name", name);
+    jsonObj.put("ifExists", index.group(1) != null);
+
+    return jsonObj.toString();
+  }
+
+  private static String indexName(String name, String tableName) {
+    int dot = tableName.indexOf('.');
+    return dot < 0 ? name : tableName.substring(0, dot + 1) + name;
+  }
+
+  // CREATE MATERIALIZED VIEW name AS SELECT ..., the result is kept in a table
+  // of the same name. The engine runs the definition itself: it gets the WHERE,
+  // the GROUP BY columns and per column an expression or an aggregate of one
+  private String handleCreateMaterializedView(Matcher view) throws Exception {
+    String viewName = qualifyName(view.group(1));
+    String sql = view.group(2);
+
+    if (DbSchemas.get(viewName) != null || DbSchemas.getView(viewName) != null) {
+      throw new Exception("table or view: " + viewName + " already exists");
+    }
+
+    SqlNode query = expandViews(parseQuery(sql), 0);
+    if (!(query instanceof SqlSelect)) {
+      throw new Exception("materialized view: " + viewName + " must be a SELECT without ORDER BY or LIMIT");
+    }
+
+    SqlSelect select = (SqlSelect) query;
+    if (select.isDistinct() || select.getHaving() != null) {
+      throw new Exception("materialized view: " + viewName + " doesn't support DISTINCT or HAVING");
+    }
+
+    String from = viewSource(select);
+    if (DbSchemas.getMaterialized(from) != null) {
+      throw new Exception("materialized view: " + viewName + " can't read another materialized view");
+    }
+
+    // planned like a query to validate it
+    handleSelect(planner.parse(rewriteJsonSyntax(sql)));
+
+    Map<String, String> sourceTypes = new TreeMap<>(String.CASE_INSENSITIVE_ORDER);
+    for (Pair<String, String> column : getSchema(from)) {
+      if (column.right.equals("PRIMARY")) {
+        sourceTypes.putIfAbsent(column.left, "BIGINT");
+      } else {
+        sourceTypes.put(column.left, column.right.matches("(?i)SERIAL|AUTO_INCREMENT") ? "BIGINT" : column.right);
+      }
+    }
+
+    JSONArray groupBy = new JSONArray();
+    if (select.getGroup() != null) {
+      for (SqlNode item : select.getGroup()) {
+        if (!(item instanceof SqlIdentifier)) {
+          throw new Exception("materialized view: " + viewName + " can only GROUP BY columns");
+        }
+        groupBy.put(Util.last(((SqlIdentifier) item).names));
+      }
+    }
+
+    JSONArray columns = new JSONArray();
+    JSONArray schema = new JSONArray(); // of the view table
+    for (SqlNode item : select.getSelectList()) {
+      if (item instanceof SqlIdentifier && ((SqlIdentifier) item).isStar()) {
+        for (String column : sourceColumns(from)) {
+          columns.put(new JSONObject().put("name", column).put("expr", new JSONObject().put("column", column)));
+          schema.put(new JSONObject().put(column, sourceTypes.get(column)));
+        }
+        continue;
+      }
+
+      String name = null;
+      SqlNode expression = item;
+      if (item.getKind() == SqlKind.AS) {
+        name = ((SqlIdentifier) ((SqlBasicCall) item).operand(1)).getSimple();
+        expression = ((SqlBasicCall) item).operand(0);
+      } else if (item instanceof SqlIdentifier) {
+        name = Util.last(((SqlIdentifier) item).names);
+      }
+      if (name == null) {
+        throw new Exception("materialized view: " + viewName + " needs a name for every expression, use AS");
+      }
+
+      JSONObject column = new JSONObject().put("name", name);
+      String type = "VARCHAR";
+
+      if (VIEW_AGGREGATES.contains(expression.getKind())) {
+        SqlCall call = (SqlCall) expression;
+        SqlNode argument = call.operandCount() == 0 ? null : call.operand(0);
+        if (call.getFunctionQuantifier() != null || call.operandCount() > 1 || hasAggregate(argument)) {
+          throw new Exception("materialized view: " + viewName + " supports " + VIEW_AGGREGATES + " of a single expression");
+        }
+
+        if (argument instanceof SqlIdentifier && !((SqlIdentifier) argument).isStar()) {
+          type = sourceTypes.getOrDefault(Util.last(((SqlIdentifier) argument).names), type);
+        }
+        if (argument != null && !(argument instanceof SqlIdentifier && ((SqlIdentifier) argument).isStar())) {
+          column.put("expr", encodeExpression(argument));
+        }
+
+        column.put("function", expression.getKind().name());
+        type = aggregateType(expression.getKind(), type);
+      } else {
+        if (hasAggregate(expression)) {
+          throw new Exception("materialized view: " + viewName + " supports " + VIEW_AGGREGATES + " of a single expression");
+        }
+
+        if (expression instanceof SqlIdentifier) {
+          type = sourceTypes.getOrDefault(Util.last(((SqlIdentifier) expression).names), type);
+        }
+        column.put("expr", encodeExpression(expression));
+      }
+
+      columns.put(column);
+      schema.put(new JSONObject().put(name, type));
+    }
+
+    JSONObject definition = new JSONObject();
+    definition.put("query", sql);
+    definition.put("from", from);
+    definition.put("groupBy", groupBy);
+    definition.put("columns", columns);
+    if (select.getWhere() != null) {
+      definition.put("condition", encodeExpression(select.getWhere()));
+    }
+
+    DbSchemas.putMaterialized(viewName, definition.toString());
+    DbSchemas.put(viewName, schema.toString());
+
+    definition.put("STATEMENT", "CREATE_MATERIALIZED_VIEW");
+    definition.put("name", viewName);
+
+    return definition.toString();
+  }
+
+  private String handleRefreshMaterializedView(Matcher view) {
+    String viewName = resolveName(view.group(1));
+    if (DbSchemas.getMaterialized(viewName) == null) {
+      throw new IllegalArgumentException("materialized view: " + viewName + " doesn't exist");
+    }
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "REFRESH_MATERIALIZED_VIEW");
+    jsonObj.put("name", viewName);
+
+    return jsonObj.toString();
+  }
+
+  private String handleDropMaterializedView(Matcher view) {
+    String viewName = resolveName(view.group(2));
+
+    checkNoViews(viewName);
+    if (DbSchemas.getMaterialized(viewName) != null) {
+      DbSchemas.removeMaterialized(viewName);
+      DbSchemas.remove(viewName);
+    }
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "DROP_MATERIALIZED_VIEW");
+    jsonObj.put("name", viewName);
+    jsonObj.put("ifExists", view.group(1) != null);
+
+    return jsonObj.toString();
+  }
+
+  // type of the view column holding the aggregate of a column of type
+  private static String aggregateType(SqlKind kind, String type) {
+    switch (kind) {
+      case COUNT:
+        return "BIGINT";
+      case SUM:
+        return type.matches("(?i)(TINY|SMALL|BIG)?INT(EGER)?") ? "BIGINT" : "DECIMAL";
+      case AVG:
+        return "DECIMAL";
+      default:
+        return type;
+    }
+  }
+
+  private static boolean hasAggregate(SqlNode node) {
+    if (!(node instanceof SqlCall)) {
+      return false;
+    }
+
+    SqlCall call = (SqlCall) node;
+    if (call.getOperator().isAggregator()) {
+      return true;
+    }
+    for (SqlNode operand : call.getOperandList()) {
+      if (hasAggregate(operand)) {
+        return true;
+      }
+    }
+    return false;
+  }
+
+  // a view in FROM is replaced by what it reads: the columns of the query
+  // become the expressions of the view and its WHERE is AND-ed to the one of
+  // the query. Views over views are expanded from the inside out
+  private SqlNode expandViews(SqlNode node, int depth) {
+    if (!(node instanceof SqlSelect)) {
+      return node;
+    }
+
+    SqlSelect select = (SqlSelect) node;
+    SqlNode from = select.getFrom();
+    if (from != null && from.getKind() == SqlKind.AS) {
+      from = ((SqlBasicCall) from).operand(0);
+    }
+    if (!(from instanceof SqlIdentifier)) {
+      return select;
+    }
+
+    String viewName = tableName(from);
+    String definition = DbSchemas.getView(viewName);
+    if (definition == null) {
+      return select;
+    }
+    if (depth == MAX_VIEW_DEPTH) {
+      throw new IllegalArgumentException("views nested deeper than " + MAX_VIEW_DEPTH + " at: " + viewName);
+    }
+
+    JSONObject viewObj = new JSONObject(definition);
+    SqlSelect view = (SqlSelect) expandViews(parseQuery(viewObj.getString("query")), depth + 1);
+
+    List<SqlNode> expressions = new ArrayList<>();
+    for (SqlNode item : view.getSelectList()) {
+      if (item instanceof SqlIdentifier && ((SqlIdentifier) item).isStar()) {
+        for (String column : sourceColumns(viewSource(view))) {
+          expressions.add(new SqlIdentifier(column, SqlParserPos.ZERO));
+        }
+      } else if (item.getKind() == SqlKind.AS) {
+        expressions.add(((SqlBasicCall) item).operand(0));
+      } else {
+        expressions.add(item);
+      }
+    }
+
+    JSONArray names = viewObj.getJSONArray("columns");
+    if (names.length() != expressions.size()) {
+      throw new IllegalArgumentException("view: " + viewName + " no longer matches the columns of: " + viewObj.getString("from"));
+    }
+
+    Map<String, SqlNode> columns = new TreeMap<>(String.CASE_INSENSITIVE_ORDER);
+    for (int i = 0; i < names.length(); i++) {
+      columns.put(names.getString(i), expressions.get(i));
+    }
+
+    SqlShuttle substitute = new SqlShuttle() {
+      @Override
+      public SqlNode visit(SqlIdentifier id) {
+        SqlNode expression = id.isStar() ? null : columns.get(Util.last(id.names));
+        return expression == null ? id : expression.clone(SqlParserPos.ZERO);
+      }
+    };
+
+    SqlNodeList selectList = new SqlNodeList(SqlParserPos.ZERO);
+    for (SqlNode item : select.getSelectList()) {
+      if (item instanceof SqlIdentifier && ((SqlIdentifier) item).isStar()) {
+        for (int i = 0; i < names.length(); i++) {
+          selectList.add(alias(expressions.get(i).clone(SqlParserPos.ZERO), names.getString(i)));
+        }
+      } else if (item.getKind() == SqlKind.AS) {
+        SqlBasicCall as = (SqlBasicCall) item;
+        selectList.add(alias(as.operand(0).accept(substitute), ((SqlIdentifier) as.operand(1)).getSimple()));
+      } else if (item instanceof SqlIdentifier) {
+        selectList.add(alias(item.accept(substitute), Util.last(((SqlIdentifier) item).names)));
+      } else {
+        selectList.add(item.accept(substitute));
+      }
+    }
+
+    SqlNode where = select.getWhere() == null ? null : select.getWhere().accept(substitute);
+    if (view.getWhere() != null) {
+      where = where == null
+          ? view.getWhere()
+          : SqlStdOperatorTable.AND.createCall(SqlParserPos.ZERO, view.getWhere(), where);
+    }
+
+    select.setSelectList(selectList);
+    select.setFrom(view.getFrom());
+    select.setWhere(where);
+    if (select.getGroup() != null) {
+      select.setGroupBy((SqlNodeList) select.getGroup().accept(substitute));
+    }
+    if (select.getHaving() != null) {
+      select.setHaving(select.getHaving().accept(substitute));
+    }
+
+    return select;
+  }
+
+  // expr AS name, left alone when expr already is the column name
+  private static SqlNode alias(SqlNode expression, String name) {
+    if (expression instanceof SqlIdentifier && Util.last(((SqlIdentifier) expression).names).equalsIgnoreCase(name)) {
+      return expression;
+    }
+    return SqlStdOperatorTable.AS.createCall(SqlParserPos.ZERO, expression, new SqlIdentifier(name, SqlParserPos.ZERO));
+  }
+
+  private SqlNode parseQuery(String sql) {
+    try {
+      return SqlParser.create(rewriteJsonSyntax(sql), parserConfig).parseQuery();
+    } catch (SqlParseException e) {
+      throw new IllegalArgumentException("parsing view failed: " + e.getMessage(), e);
+    }
+  }
+
+  // the table or view a view reads: FROM name [AS alias]
+  private String viewSource(SqlSelect select) {
+    SqlNode from = select.getFrom();
+    if (from != null && from.getKind() == SqlKind.AS) {
+      from = ((SqlBasicCall) from).operand(0);
+    }
+    if (!(from instanceof SqlIdentifier)) {
+      throw new IllegalArgumentException("a view reads a single table or view");
+    }
+    return tableName(from);
+  }
+
+  private List<String> sourceColumns(String source) {
+    List<String> columns = new ArrayList<>();
+
+    String view = DbSchemas.getView(source);
+    if (view != null) {
+      JSONArray names = new JSONObject(view).getJSONArray("columns");
+      for (int i = 0; i < names.length(); i++) {
+        columns.add(names.getString(i));
+      }
+      return columns;
+    }
+
+    for (Pair<String, String> column : getSchema(source)) {
+      if (!columns.contains(column.left)) { // PRIMARY repeats the key column
+        columns.add(column.left);
+      }
+    }
+    return columns;
+  }
+
+  // follows what the views read from source, true when viewName is on the way
+  private static boolean readsView(String source, String viewName) {
+    for (int depth = 0; source != null; depth++) {
+      if (source.equals(viewName) || depth > MAX_VIEW_DEPTH) {
+        return true;
+      }
+
+      String view = DbSchemas.getView(source);
+      source = view == null ? null : new JSONObject(view).getString("from");
+    }
+    return false;
+  }
+
+  // db.table names an object of another database, a bare name is looked up in
+  // the database chosen by USE first, then among the ones outside of any database
+  private String tableName(SqlNode identifier) {
+    return resolveName(String.join(".", ((SqlIdentifier) identifier).names));
+  }
+
+  private String resolveName(String name) {
+    if (database == null || name.contains(".")) {
+      return name;
+    }
+
+    String qualified = database + "." + name;
+    if (DbSchemas.get(qualified) != null || DbSchemas.getView(qualified) != null) {
+      return qualified;
+    }
+    return name;
+  }
+
+  // tables and views created after USE db go in db
+  private String qualifyName(String name) {
+    if (name.contains(".")) {
+      String db = name.substring(0, name.indexOf('.'));
+      if (!DbSchemas.hasDatabase(db)) {
+        throw new IllegalArgumentException("database: " + db + " doesn't exist");
+      }
+      return name;
+    }
+    return database == null ? name : database + "." + name;
+  }
+
+  private void useDatabase(String name) {
+    if (!DbSchemas.hasDatabase(name)) {
+      throw new IllegalArgumentException("database: " + name + " doesn't exist");
+    }
+
+    database = name;
+    planner.close();
+    planner = newPlanner(databaseSchema(name));
+  }
+
+  // USE db, the engine checks the database and keeps it for the session
+  private static String handleUse(String name) {
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "USE");
+    jsonObj.put("database", name);
+
+    return jsonObj.toString();
+  }
+
+  // CREATE DATABASE [IF NOT EXISTS] name, the engine gives it a catalog and a
+  // directory of its own
+  private static String handleCreateDatabase(Matcher createDatabase) {
+    String name = createDatabase.group(2);
+    if (createDatabase.group(1) == null && DbSchemas.hasDatabase(name)) {
+      throw new IllegalArgumentException("database: " + name + " already exists");
+    }
+    DbSchemas.putDatabase(name);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "CREATE_DATABASE");
+    jsonObj.put("database", name);
+    jsonObj.put("ifNotExists", createDatabase.group(1) != null);
+
+    return jsonObj.toString();
+  }
+
+  // DROP DATABASE [IF EXISTS] name forgets the schemas and views kept here for
+  // its tables, the engine removes its directory
+  private static String handleDropDatabase(Matcher dropDatabase) {
+    String name = dropDatabase.group(2);
+    for (String viewName : DbSchemas.viewNames()) {
+      String view = DbSchemas.getView(viewName);
+      String from = view == null ? "" : new JSONObject(view).getString("from");
+      if (!viewName.startsWith(name + ".") && from.startsWith(name + ".")) {
+        throw new IllegalArgumentException("view: " + viewName + " depends on: " + from);
+      }
+    }
+    DbSchemas.removeDatabase(name);
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "DROP_DATABASE");
+    jsonObj.put("database", name);
+    jsonObj.put("ifExists", dropDatabase.group(1) != null);
+
+    return jsonObj.toString();
+  }
+
+  // SHOW TABLES [FROM db], the tables of the database chosen by USE otherwise
+  private String handleShowTables(Matcher showTables) {
+    String name = showTables.group(1) != null ? showTables.group(1) : database;
+
+    JSONObject jsonObj = new JSONObject();
+    jsonObj.put("STATEMENT", "SHOW_TABLES");
+    jsonObj.put("database", name == null ? "" : name);
+
+    return jsonObj.toString();
+  }
+
+  private static void checkNotView(String name) {
+    if (DbSchemas.getView(name) != null) {
+      throw new IllegalArgumentException("view: " + name + " can only be read, it isn't a table");
+    }
+    if (DbSchemas.getMaterialized(name) != null) {
+      throw new IllegalArgumentException("materialized view: " + name + " is only changed by REFRESH MATERIALIZED VIEW");
+    }
+  }
+
+  // a table or view can't go, or be renamed, while views read it
+  private static void checkNoViews(String name) {
+    for (String viewName : DbSchemas.viewNames()) {
+      String view = DbSchemas.getView(viewName);
+      if (view != null && new JSONObject(view).getString("from").equals(name)) {
+        throw new IllegalArgumentException("view: " + viewName + " depends on: " + name);
+      }
+    }
+
+    for (String viewName : DbSchemas.materializedNames()) {
+      String view = DbSchemas.getMaterialized(viewName);
+      if (view != null && new JSONObject(view).getString("from").equals(name)) {
+        throw new IllegalArgumentException("materialized view: " + viewName + " depends on: " + name);
+      }
+    }
+  }
+
+  // FOREIGN KEY (a) REFERENCES t (b) ON DELETE CASCADE =>
+  // {"kind": "FOREIGN_KEY", "columns": ["a"], "refTable": "t", "refColumns": ["b"], "onDelete": "CASCADE"}
+  static String cutForeignKeys(String query, JSONArray foreignKeys) {
+    Matcher foreignKey = FOREIGN_KEY.matcher(query);
+    StringBuffer rest = new StringBuffer();
+
+    while (foreignKey.find()) {
+      JSONObject fk = new JSONObject().put("kind", "FOREIGN_KEY")
+          .put("columns", encodeColumnList(foreignKey.group(2)))
+          .put("refTable", foreignKey.group(3).replace("`", ""));
+      if (foreignKey.group(1) != null) {
+        fk.put("name", foreignKey.group(1));
+      }
+      if (foreignKey.group(4) != null) {
+        fk.put("refColumns", encodeColumnList(foreignKey.group(4)));
+      }
+
+      Matcher action = REFERENCE_ACTION.matcher(foreignKey.group(5));
+      while (action.find()) {
+        String field = action.group(1).equalsIgnoreCase("DELETE") ? "onDelete" : "onUpdate";
+        fk.put(field, action.group(2).toUpperCase().replaceAll("\\s+", "_"));
+      }
+
+      foreignKeys.put(fk);
+      foreignKey.appendReplacement(rest, "");
+    }
+    foreignKey.appendTail(rest);
+
+    return rest.toString();
+  }
+
+  private static JSONArray encodeColumnList(String list) {
+    JSONArray columns = new JSONArray();
+    for (String column : list.split(",")) {
+      columns.put(column.trim().replace("`", ""));
+    }
+    return columns;
+  }
+
+  // the declared type with its limits, VARCHAR(20) and DECIMAL(10,2), the
+  // engine checks the values written against them
+  private static String columnType(SqlDataTypeSpec dataType) {
+    String type = dataType.getTypeName().toString();
+    if (!(dataType.getTypeNameSpec() instanceof SqlBasicTypeNameSpec)) {
+      return type;
+    }
+
+    SqlBasicTypeNameSpec spec = (SqlBasicTypeNameSpec) dataType.getTypeNameSpec();
+    if (spec.getPrecision() < 0 || type.startsWith("TIMESTAMP")) {
+      return type;
+    }
+    if (spec.getScale() < 0) {
+      return type + "(" + spec.getPrecision() + ")";
+    }
+    return type + "(" + spec.getPrecision() + "," + spec.getScale() + ")";
+  }
+
+  private String handleCreate(SqlNode node, JSONArray foreignKeys) {
     List<Pair<String, String>> columnsInfo = new ArrayList<Pair<String, String>>();
 
     SqlCreateTable createTableNode = (SqlCreateTable) node;
-    SqlIdentifier tableName = createTableNode.name;
+    String tableName = qualifyName(String.join(".", createTableNode.name.names));
+    checkNotView(tableName);
+
+    // NOT NULL and DEFAULT come with the columns, UNIQUE, CHECK and FOREIGN KEY are table constraints
+    JSONArray constraints = new JSONArray();
 
     List<SqlNode> columnNodeList = createTableNode.columnList.getList();
     for (SqlNode columnNode : columnNodeList) {
@@ -331,10 +1509,45 @@ public class QueryPlanner {
         SqlColumnDeclaration columnInfo = (SqlColumnDeclaration) columnNode;
 
         String colName = columnInfo.name.getSimple();
-        String colType = columnInfo.dataType.getTypeName().toString();
+        String colType = columnType(columnInfo.dataType);
         Pair<String, String> columnPair = Pair.of(colName, colType);
 
         columnsInfo.add(columnPair);
+
+        if (Boolean.FALSE.equals(columnInfo.dataType.getNullable())) {
+          constraints.put(new JSONObject().put("kind", "NOT_NULL").put("column", colName));
+        }
+
+        if (columnInfo.strategy == ColumnStrategy.DEFAULT && columnInfo.expression != null) {
+          JSONObject defaultJson = encodeDefault(columnInfo.expression);
+          if (defaultJson != null) {
+            constraints.put(defaultJson.put("kind", "DEFAULT").put("column", colName));
+          }
+        }
+      } else if (columnNode instanceof SqlKeyConstraint && columnNode.getKind() == SqlKind.UNIQUE) {
+        SqlKeyConstraint uniqueNode = (SqlKeyConstraint) columnNode;
+        SqlIdentifier name = (SqlIdentifier) uniqueNode.getOperandList().get(0);
+
+        JSONArray columns = new JSONArray();
+        for (SqlNode column : (SqlNodeList) uniqueNode.getOperandList().get(1)) {
+          columns.put(Util.last(((SqlIdentifier) column).names));
+        }
+
+        JSONObject unique = new JSONObject().put("kind", "UNIQUE").put("columns", columns);
+        if (name != null) {
+          unique.put("name", name.getSimple());
+        }
+        constraints.put(unique);
+      } else if (columnNode instanceof SqlCheckConstraint) {
+        SqlCheckConstraint checkNode = (SqlCheckConstraint) columnNode;
+        SqlIdentifier name = (SqlIdentifier) checkNode.getOperandList().get(0);
+
+        JSONObject check = new JSONObject().put("kind", "CHECK")
+            .put("expr", encodeExpression(checkNode.getOperandList().get(1)));
+        if (name != null) {
+          check.put("name", name.getSimple());
+        }
+        constraints.put(check);
       } else if (columnNode instanceof SqlKeyConstraint) {
         SqlKeyConstraint primaryKeyNode = (SqlKeyConstraint) columnNode;
         List<SqlNode> primaryKeyList = primaryKeyNode.getOperandList();
@@ -349,8 +1562,25 @@ public class QueryPlanner {
       }
     }
 
-    addSchemaInMemory(tableName.getSimple(), columnsInfo);
-    return encodeCreateTableSchema(tableName.getSimple(), columnsInfo);
+    for (int i = 0; i < foreignKeys.length(); i++) {
+      JSONObject fk = foreignKeys.getJSONObject(i);
+      constraints.put(fk.put("refTable", resolveName(fk.getString("refTable"))));
+    }
+
+    addSchemaInMemory(tableName, columnsInfo);
+    return new JSONObject(encodeCreateTableSchema(tableName, columnsInfo))
+        .put("constraints", constraints)
+        .toString();
+  }
+
+  // DEFAULT 'x' | 5 => {"value": "x"}, DEFAULT NOW() => {"expr": {...}}, DEFAULT NULL => null
+  private JSONObject encodeDefault(SqlNode expression) {
+    if (expression instanceof SqlLiteral && !(expression instanceof SqlIntervalLiteral)) {
+      String value = ((SqlLiteral) expression).toValue();
+      return value == null ? null : new JSONObject().put("value", value);
+    }
+
+    return new JSONObject().put("expr", encodeExpression(expression));
   }
 
   private HashMap<String, String> setSchemas(List<String> tableNames) {
@@ -358,9 +1588,8 @@ public class QueryPlanner {
     int availableIndex = 0;
 
     for (String tableName : tableNames) {
-      Set<String> set = rootSchema.getTableNames();
       List<Pair<String, String>> columns = getSchema(tableName);
-      if (!set.contains(tableName)) {
+      if (!isRegistered(tableName)) {
         addSchemaInMemory(tableName, columns);
       }
       availableIndex = ResolveReference(columns, refList, availableIndex);
@@ -369,7 +1598,12 @@ public class QueryPlanner {
   }
 
   private int ResolveReference(List<Pair<String, String>> columns, HashMap<String, String> refList, int avlIndex) {
+    Set<String> added = new HashSet<>();
     for (Pair<String, String> col : columns) {
+      if (!added.add(col.left)) {
+        continue;
+      }
+
       refList.put(String.valueOf("$" + avlIndex), col.left);
       avlIndex++;
     }
@@ -377,17 +1611,43 @@ public class QueryPlanner {
     return avlIndex;
   }
 
+  // db.table is registered as table in the schema of db so calcite resolves
+  // both db.table and, after USE db, the bare name
+  private boolean isRegistered(String tableName) {
+    int dot = tableName.indexOf('.');
+    if (dot < 0) {
+      return rootSchema.getTableNames().contains(tableName);
+    }
+
+    SchemaPlus schema = rootSchema.getSubSchema(tableName.substring(0, dot));
+    return schema != null && schema.getTableNames().contains(tableName.substring(dot + 1));
+  }
+
+  private SchemaPlus databaseSchema(String name) {
+    SchemaPlus schema = rootSchema.getSubSchema(name);
+    return schema != null ? schema : rootSchema.add(name, new AbstractSchema());
+  }
+
   private void addSchemaInMemory(String tableName, List<Pair<String, String>> columnsInfo) {
-    rootSchema.add(tableName, new AbstractTable() {
+    int dot = tableName.indexOf('.');
+    SchemaPlus schema = dot < 0 ? rootSchema : databaseSchema(tableName.substring(0, dot));
+
+    schema.add(tableName.substring(dot + 1), new AbstractTable() {
       @Override
       public RelDataType getRowType(RelDataTypeFactory typeFactory) {
         RelDataTypeFactory.Builder builder = typeFactory.builder();
 
+        // a declared column named again by PRIMARY KEY(col) is a single field
+        Set<String> added = new HashSet<>();
         for (Pair<String, String> pair : columnsInfo) {
+          if (!added.add(pair.left)) {
+            continue;
+          }
+
           builder.add(pair.left,
               // #### changing the type from VARCHAR to ANY causes a different query plan
               // which breaks your backend
-              typeFactory.createTypeWithNullability(typeFactory.createSqlType(SqlTypeName.VARCHAR), true));
+              typeFactory.createTypeWithNullability(typeFactory.createSqlType(toSqlType(pair.right)), true));
         }
 
         return builder.build();
@@ -395,6 +1655,20 @@ public class QueryPlanner {
     });
   }
 
+  // temporal columns keep their type so that date arithmetic and functions validate,
+  // everything else is still handed to the backend as VARCHAR
+  private SqlTypeName toSqlType(String colType) {
+    String type = colType == null ? "" : colType.toUpperCase();
+
+    if (type.startsWith("TIMESTAMP")) {
+      return SqlTypeName.TIMESTAMP;
+    } else if (type.equals("DATE")) {
+      return SqlTypeName.DATE;
+    }
+
+    return SqlTypeName.VARCHAR;
+  }
+
   private List<String> GetTableName(SqlNode sqlNode) {
     SqlIdentifier table;
     List<String> tables = new ArrayList<String>();
@@ -404,7 +1678,7 @@ public class QueryPlanner {
 
     if (fromNode instanceof SqlIdentifier) {
       table = (SqlIdentifier) fromNode;
-      tables.add(table.getSimple());
+      tables.add(tableName(table));
     } else if (fromNode instanceof SqlJoin) {
       SqlJoin join = (SqlJoin) fromNode;
       SqlNode leftTable = join.getLeft();
@@ -412,12 +1686,12 @@ public class QueryPlanner {
 
       if (leftTable instanceof SqlIdentifier) {
         table = (SqlIdentifier) leftTable;
-        tables.add(table.getSimple());
+        tables.add(tableName(table));
       }
 
       if (rightTable instanceof SqlIdentifier) {
         table = (SqlIdentifier) rightTable;
-        tables.add(table.getSimple());
+        tables.add(tableName(table));
       }
     }
 
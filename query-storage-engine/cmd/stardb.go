@@ -25,8 +25,10 @@ func InitDatabase(k int, dirName string, config engines.QueryEngineConfig) (*eng
 		QueryChan:         make(chan *engines.QueryInfo, 1000),
 		ResultManager:     &engines.ResultManager{SubscribedQueries: map[uint64]chan *engines.Result{}, GlobalChannel: globalChannel, SchedulerNotification: schedulerNotification},
 		CtxManager:        engines.NewContextManager(),
+		Pool:              engines.NewWorkerPool(config.Workers),
 	}
 
+	bufferPool.Wal.OnCommit = queryEngine.MaintainViews
 	queryEngine.Scheduler = engines.NewQueryScheduler(schedulerNotification, globalChannel, queryEngine)
 	queryEngine.SystemStats, _ = queryEngine.GetSystemPressureStats()
 
@@ -76,6 +76,17 @@ func (r *LRUKReplacer) Evict() (FrameID, error) {
 	return evictedFrameID, nil
 }
 
+// Remove forgets the history of a frame that was freed without eviction.
+func (r *LRUKReplacer) Remove(frameID FrameID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frameToElem[frameID]; ok {
+		r.accessHistory.Remove(elem)
+		delete(r.frameToElem, frameID)
+	}
+}
+
 func (r *LRUKReplacer) computeBackwardKDistance(frameID FrameID) (int, error) {
 	elem, ok := r.frameToElem[frameID]
 	if !ok {
@@ -0,0 +1,812 @@
+package engines
+
+import (
+	"a2gdb/logger"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ALTER TABLE
+//
+// Rows are never rewritten by an ALTER, the catalog tells how to read them:
+//   - RENAME COLUMN keeps the old name inside the rows (ColumnType.Stored)
+//   - DROP COLUMN remembers the stored name (TableInfo.Dropped), the values
+//     are filtered on read and go away when the row is written again
+//   - ADD COLUMN ... DEFAULT gives the older rows the default on read
+//     (ColumnType.Missing), newer rows hold an explicit NULL instead of
+//     leaving the column out
+//   - RENAME TABLE keeps the files where they are (TableInfo.Dir)
+//
+// A rewrite copies every row into new files with the plain layout and swaps
+// them in with a single catalog replacement, it runs on demand or in the
+// background after each column change (QueryEngineConfig.RewriteAfterAlter),
+// holding the table of the ALTER once its result is sent (Result.background).
+// The background copy goes on next to the reads and the statements on other
+// tables, only the switch waits for them.
+
+const ATTR_SEPARATOR = "\x00"
+
+// nullMarker tells a NULL apart from a column the row was written without.
+const nullMarker = "\x00\xffNULL\x00"
+
+// rowLayout maps the stored rows to the schema.
+type rowLayout struct {
+	identity bool
+	names    map[string]string // stored name => schema name
+	stored   map[string]string // schema name => stored name, renamed columns only
+	missing  map[string]string
+}
+
+// rowLayout is shared by the scan workers, it is only replaced while the
+// table is changed (CRUD statements don't overlap with reads).
+func (ti *TableInfo) rowLayout() *rowLayout {
+	if ti.layout != nil {
+		return ti.layout
+	}
+	return ti.buildLayout()
+}
+
+func (ti *TableInfo) refreshLayout() {
+	ti.layout = ti.buildLayout()
+}
+
+func (ti *TableInfo) buildLayout() *rowLayout {
+	layout := &rowLayout{
+		identity: len(ti.Dropped) == 0,
+		names:    make(map[string]string, len(ti.Schema)),
+		stored:   make(map[string]string),
+		missing:  make(map[string]string),
+	}
+
+	for column, columnType := range ti.Schema {
+		name := columnType.storedName(column)
+		layout.names[name] = column
+
+		if name != column {
+			layout.stored[column] = name
+			layout.identity = false
+		}
+
+		if columnType.Missing != "" {
+			layout.missing[column] = columnType.Missing
+			layout.identity = false
+		}
+	}
+
+	return layout
+}
+
+// toSchema renames the stored keys and leaves the dropped ones out.
+func (layout *rowLayout) toSchema(values map[string]string) map[string]string {
+	renamed := make(map[string]string, len(values))
+	for name, val := range values {
+		if column, ok := layout.names[name]; ok {
+			renamed[column] = val
+		}
+	}
+
+	return renamed
+}
+
+func (layout *rowLayout) toStored(values map[string]string) map[string]string {
+	renamed := make(map[string]string, len(values))
+	for column, val := range values {
+		if name, ok := layout.stored[column]; ok {
+			column = name
+		}
+		renamed[column] = val
+	}
+
+	for column := range layout.missing {
+		name := column
+		if stored, ok := layout.stored[column]; ok {
+			name = stored
+		}
+
+		if _, ok := renamed[name]; !ok {
+			renamed[name] = nullMarker
+		}
+	}
+
+	return renamed
+}
+
+// fillMissing gives the rows written before ADD COLUMN ... DEFAULT the default
+// and turns the explicit NULLs of the newer rows back into absent columns.
+func (layout *rowLayout) fillMissing(values map[string]string) {
+	for column, val := range layout.missing {
+		stored, ok := values[column]
+		switch {
+		case !ok:
+			values[column] = val
+		case stored == nullMarker:
+			delete(values, column)
+		}
+	}
+}
+
+func (ct ColumnType) storedName(column string) string {
+	if ct.Stored != "" {
+		return ct.Stored
+	}
+	return column
+}
+
+func (ct ColumnType) attrs() map[string]string {
+	attrs := make(map[string]string)
+	for key, val := range map[string]string{"stored": ct.Stored, "missing": ct.Missing, "default": ct.Default, "defaultExpr": ct.DefaultExpr} {
+		if val != "" {
+			attrs[key] = val
+		}
+	}
+	if ct.NotNull {
+		attrs["notNull"] = "true"
+	}
+	if ct.Bloom {
+		attrs["bloom"] = "true"
+	}
+	return attrs
+}
+
+func (ct *ColumnType) setAttrs(attrs map[string]string) {
+	ct.Stored, ct.Missing, ct.Default = attrs["stored"], attrs["missing"], attrs["default"]
+	ct.DefaultExpr, ct.NotNull = attrs["defaultExpr"], attrs["notNull"] == "true"
+	ct.Bloom = attrs["bloom"] == "true"
+}
+
+func (ti *TableInfo) attrs() map[string]string {
+	attrs := make(map[string]string)
+	if ti.Dir != "" {
+		attrs["dir"] = ti.Dir
+	}
+	if len(ti.Dropped) > 0 {
+		attrs["dropped"] = strings.Join(ti.Dropped, ATTR_SEPARATOR)
+	}
+	if len(ti.Constraints) > 0 {
+		encoded, _ := json.Marshal(ti.Constraints) // strings only, can't fail
+		attrs["constraints"] = string(encoded)
+	}
+	if len(ti.Indexes) > 0 {
+		encoded, _ := json.Marshal(ti.Indexes)
+		attrs["indexes"] = string(encoded)
+	}
+	return attrs
+}
+
+func (ti *TableInfo) setAttrs(attrs map[string]string) error {
+	ti.Dir = attrs["dir"]
+	if dropped, ok := attrs["dropped"]; ok {
+		ti.Dropped = strings.Split(dropped, ATTR_SEPARATOR)
+	}
+	if constraints, ok := attrs["constraints"]; ok {
+		if err := json.Unmarshal([]byte(constraints), &ti.Constraints); err != nil {
+			return fmt.Errorf("decoding constraints failed: %w", err)
+		}
+	}
+	if indexes, ok := attrs["indexes"]; ok {
+		if err := json.Unmarshal([]byte(indexes), &ti.Indexes); err != nil {
+			return fmt.Errorf("decoding indexes failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// alterSchema applies change to a copy of the schema and swaps it in once the
+// catalog is on disk, a failure leaves the table as it was.
+func (dm *DiskManagerV2) alterSchema(tableName string, change func(schema map[string]ColumnType, tableInfo *TableInfo) error) error {
+	tableInfo, ok := dm.PageCatalog.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+
+	oldSchema, oldDropped, oldConstraints, oldIndexes := tableInfo.Schema, tableInfo.Dropped, tableInfo.Constraints, tableInfo.Indexes
+	restore := func() {
+		tableInfo.Schema, tableInfo.Dropped, tableInfo.Constraints, tableInfo.Indexes = oldSchema, oldDropped, oldConstraints, oldIndexes
+		tableInfo.refreshLayout()
+	}
+
+	schema := maps.Clone(oldSchema)
+	tableInfo.Dropped, tableInfo.Constraints = slices.Clone(oldDropped), slices.Clone(oldConstraints)
+	tableInfo.Indexes = slices.Clone(oldIndexes)
+	if err := change(schema, tableInfo); err != nil {
+		restore()
+		return err
+	}
+
+	tableInfo.Schema = schema
+	tableInfo.refreshLayout()
+	if err := tableInfo.compileConstraints(); err != nil {
+		restore()
+		return err
+	}
+
+	if err := dm.UpdateCatalog(); err != nil {
+		restore()
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	return nil
+}
+
+// AddColumn adds a nullable column, with a default the existing rows read it
+// without being rewritten. A name still held by old rows gets a fresh
+// stored name so their values don't come back.
+func (dm *DiskManagerV2) AddColumn(tableName, column, colType, defaultVal string) error {
+	if isSerialType(colType) {
+		return errors.New("SERIAL columns can't be added to an existing table")
+	}
+
+	return dm.alterSchema(tableName, func(schema map[string]ColumnType, tableInfo *TableInfo) error {
+		if _, ok := schema[column]; ok {
+			return fmt.Errorf("column: %s already exists", column)
+		}
+
+		taken := slices.Clone(tableInfo.Dropped)
+		for name, columnType := range schema {
+			taken = append(taken, columnType.storedName(name))
+		}
+
+		stored := column
+		for n := 1; slices.Contains(taken, stored); n++ {
+			stored = fmt.Sprintf("%s#%d", column, n)
+		}
+
+		if defaultVal != "" {
+			coerced, err := CoerceValue(defaultVal, colType)
+			if err == nil {
+				_, err = EncodeStoredValue(coerced, colType)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid default for column: %s: %w", column, err)
+			}
+			defaultVal = coerced
+		}
+
+		added := ColumnType{Type: colType, Missing: defaultVal, Default: defaultVal}
+		if stored != column {
+			added.Stored = stored
+		}
+
+		schema[column] = added
+		return nil
+	})
+}
+
+// DropColumn removes the column from the schema, the values stay in the rows
+// until they are written again or the table is rewritten.
+func (dm *DiskManagerV2) DropColumn(tableName, column string) error {
+	var serial bool
+	var indexes []string
+	var blooms []Column
+
+	err := dm.alterSchema(tableName, func(schema map[string]ColumnType, tableInfo *TableInfo) error {
+		columnType, ok := schema[column]
+		if !ok {
+			return fmt.Errorf("column: %s doesn't exist", column)
+		}
+
+		if columnType.IsIndex {
+			return fmt.Errorf("primary column: %s can't be dropped", column)
+		}
+
+		if err := dm.PageCatalog.checkNotReferenced(tableName, column); err != nil {
+			return fmt.Errorf("column: %s can't be dropped: %w", column, err)
+		}
+
+		serial = isSerialType(columnType.Type)
+		if columnType.Bloom {
+			blooms = append(blooms, Column(columnType.storedName(column)))
+		}
+		tableInfo.Dropped = append(tableInfo.Dropped, columnType.storedName(column))
+		delete(schema, column)
+
+		// like postgres, the constraints on the column go with it
+		tableInfo.Constraints = slices.DeleteFunc(tableInfo.Constraints, func(constraint Constraint) bool {
+			return slices.Contains(constraint.Columns, column)
+		})
+
+		// and so do the indexes
+		tableInfo.Indexes = slices.DeleteFunc(tableInfo.Indexes, func(info IndexInfo) bool {
+			if slices.Contains(info.Columns, column) {
+				indexes = append(indexes, info.Name)
+				return true
+			}
+			return false
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := dm.removeIndexes(tableName, indexes...); err != nil {
+		return err
+	}
+
+	dm.Mu.RLock()
+	tableObj, ok := dm.TableObjs[tableName]
+	dm.Mu.RUnlock()
+	if ok {
+		if err := tableObj.dropBlooms(blooms...); err != nil {
+			return err
+		}
+	}
+
+	if serial {
+		// the next catalog write forgets it, a leftover sequence is harmless
+		dm.PageCatalog.seqMu.Lock()
+		delete(dm.PageCatalog.Sequences, ownedSequence(tableName, column))
+		dm.PageCatalog.seqMu.Unlock()
+	}
+
+	return nil
+}
+
+// RenameColumn only touches the catalog, the rows keep the stored name.
+func (dm *DiskManagerV2) RenameColumn(tableName, column, newName string) error {
+	var serial bool
+
+	restoreReferences := dm.PageCatalog.retargetReferences(tableName, func(fk *Constraint) {
+		if i := slices.Index(fk.RefColumns, column); i >= 0 {
+			fk.RefColumns[i] = newName
+		}
+	})
+
+	err := dm.alterSchema(tableName, func(schema map[string]ColumnType, tableInfo *TableInfo) error {
+		columnType, ok := schema[column]
+		if !ok {
+			return fmt.Errorf("column: %s doesn't exist", column)
+		}
+
+		if _, ok := schema[newName]; ok {
+			return fmt.Errorf("column: %s already exists", newName)
+		}
+
+		serial = isSerialType(columnType.Type)
+		if serial {
+			dm.renameSequence(ownedSequence(tableName, column), ownedSequence(tableName, newName), tableName, newName)
+		}
+
+		constraints, err := renameConstraintColumn(tableInfo.Constraints, column, newName)
+		if err != nil {
+			return err
+		}
+		tableInfo.Constraints = constraints
+		tableInfo.Indexes = renameIndexInfos(tableInfo.Indexes, column, newName)
+
+		columnType.Stored = columnType.storedName(column)
+		if columnType.Stored == newName {
+			columnType.Stored = "" // renamed back
+		}
+
+		delete(schema, column)
+		schema[newName] = columnType
+		return nil
+	})
+
+	if err != nil {
+		restoreReferences()
+	} else {
+		dm.renameIndexColumn(tableName, column, newName)
+	}
+
+	if err != nil && serial {
+		dm.renameSequence(ownedSequence(tableName, newName), ownedSequence(tableName, column), tableName, column)
+	}
+
+	return err
+}
+
+// RenameTable moves the catalog entry, the files stay in their directory.
+func (dm *DiskManagerV2) RenameTable(tableName, newName string) error {
+	catalog := dm.PageCatalog
+
+	tableInfo, ok := catalog.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+
+	if _, ok := catalog.Tables[newName]; ok {
+		return fmt.Errorf("table: %s already exists", newName)
+	}
+
+	// the files stay in the directory of the database
+	from, _ := SplitTableName(tableName)
+	if to, _ := SplitTableName(newName); from != to {
+		return fmt.Errorf("table: %s can't move to another database", tableName)
+	}
+
+	if _, ok := catalog.Views[newName]; ok {
+		return fmt.Errorf("view: %s already exists", newName)
+	}
+
+	// the views hold the name in their text
+	if err := catalog.checkNoViews(tableName); err != nil {
+		return err
+	}
+
+	oldDir := tableInfo.Dir
+	tableInfo.Dir = dm.tableDir(tableName)
+	renameSequences := func(from, to string) {
+		for column, columnType := range tableInfo.Schema {
+			if isSerialType(columnType.Type) {
+				dm.renameSequence(ownedSequence(from, column), ownedSequence(to, column), to, column)
+			}
+		}
+	}
+
+	restoreReferences := catalog.retargetReferences(tableName, func(fk *Constraint) {
+		fk.RefTable = newName
+	})
+
+	delete(catalog.Tables, tableName)
+	catalog.Tables[newName] = tableInfo
+	renameSequences(tableName, newName)
+
+	if err := dm.UpdateCatalog(); err != nil {
+		restoreReferences()
+		renameSequences(newName, tableName)
+		delete(catalog.Tables, newName)
+		catalog.Tables[tableName] = tableInfo
+		tableInfo.Dir = oldDir
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	dm.Mu.Lock()
+	if tableObj, ok := dm.TableObjs[tableName]; ok {
+		delete(dm.TableObjs, tableName)
+		tableObj.TableName = newName
+		dm.TableObjs[newName] = tableObj
+	}
+	dm.Mu.Unlock()
+
+	return nil
+}
+
+func (dm *DiskManagerV2) renameSequence(name, newName, table, column string) {
+	catalog := dm.PageCatalog
+
+	catalog.seqMu.Lock()
+	defer catalog.seqMu.Unlock()
+
+	if seq, ok := catalog.Sequences[name]; ok {
+		delete(catalog.Sequences, name)
+		seq.Table, seq.Column = table, column
+		catalog.Sequences[newName] = seq
+	}
+}
+
+// rewriteTable copies the rows into new files laid out like the schema, a
+// page at a time. In the background only the switch waits for the other
+// statements, like a CRUD one (asCrud), the copy just holds the table.
+func (qe *QueryEngine) rewriteTable(tableName string, background bool) error {
+	manager := qe.BufferPoolManager.DiskManager
+
+	tableInfo, ok := manager.PageCatalog.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+
+	tableObj, err := GetTableObj(tableName, manager)
+	if err != nil {
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+
+	files, err := qe.buildTable(tableName, func(add func(row *RowV2) error) error {
+		return qe.scanRows(tableObj, tableInfo, add)
+	})
+	if err != nil {
+		return err
+	}
+
+	swap := func() error { return qe.swapTable(tableName, files) }
+	if background {
+		err = qe.asCrud(swap)
+	} else {
+		err = swap()
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Log.WithField("table", tableName).Info("table rewritten")
+	return nil
+}
+
+// replaceTable swaps in new files of the table holding the rows.
+func (qe *QueryEngine) replaceTable(tableName string, rows []RowV2) error {
+	files, err := qe.buildTable(tableName, func(add func(row *RowV2) error) error {
+		for i := range rows {
+			if err := add(&rows[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return qe.swapTable(tableName, files)
+}
+
+// tableFiles are new files of a table built aside by buildTable.
+type tableFiles struct {
+	dir     string
+	dirPath string
+	schema  map[string]ColumnType
+	obj     *TableObj
+	info    *TableInfo
+}
+
+// buildTable writes the rows fill adds into new files of the table, nothing
+// points to them until swapTable.
+func (qe *QueryEngine) buildTable(tableName string, fill func(add func(row *RowV2) error) error) (*tableFiles, error) {
+	manager := qe.BufferPoolManager.DiskManager
+
+	tableInfo, ok := manager.PageCatalog.Tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+
+	schema := make(map[string]ColumnType, len(tableInfo.Schema))
+	for column, columnType := range tableInfo.Schema {
+		columnType.Stored, columnType.Missing = "", ""
+		schema[column] = columnType
+	}
+
+	dir, err := manager.createTableDir(tableName)
+	if err != nil {
+		return nil, fmt.Errorf("createTableDir failed: %w", err)
+	}
+	dirPath := filepath.Join(manager.tablesPath(tableName), dir)
+
+	newObj, newInfo, err := manager.writeRows(tableName, dir, schema, fill)
+	if err != nil {
+		os.RemoveAll(dirPath)
+		return nil, fmt.Errorf("writeRows failed: %w", err)
+	}
+
+	return &tableFiles{dir: dir, dirPath: dirPath, schema: schema, obj: newObj, info: newInfo}, nil
+}
+
+// swapTable switches the table to the files of buildTable, the catalog
+// replacement is the switch point. A crash before it leaves the new
+// directory as an orphan, after it the old one, both removed on start.
+func (qe *QueryEngine) swapTable(tableName string, files *tableFiles) error {
+	manager := qe.BufferPoolManager.DiskManager
+
+	tableInfo, ok := manager.PageCatalog.Tables[tableName]
+	if !ok {
+		files.obj.Close()
+		os.RemoveAll(files.dirPath)
+		return fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+
+	tableObj, err := GetTableObj(tableName, manager)
+	if err != nil {
+		files.obj.Close()
+		os.RemoveAll(files.dirPath)
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+
+	oldSchema, oldDropped, oldDir := tableInfo.Schema, tableInfo.Dropped, tableInfo.Dir
+	oldPages, oldUsed := tableInfo.NumOfPages, tableInfo.UsedSpace
+	oldPath := filepath.Join(manager.tablesPath(tableName), manager.tableDir(tableName))
+
+	tableInfo.Schema, tableInfo.Dropped, tableInfo.Dir = files.schema, nil, files.dir
+	tableInfo.NumOfPages, tableInfo.UsedSpace = files.info.NumOfPages, files.info.UsedSpace
+	tableInfo.refreshLayout()
+
+	if err := manager.UpdateCatalog(); err != nil {
+		tableInfo.Schema, tableInfo.Dropped, tableInfo.Dir = oldSchema, oldDropped, oldDir
+		tableInfo.NumOfPages, tableInfo.UsedSpace = oldPages, oldUsed
+		tableInfo.refreshLayout()
+		files.obj.Close()
+		os.RemoveAll(files.dirPath)
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	qe.BufferPoolManager.DiscardTablePages(tableName)
+
+	manager.Mu.Lock()
+	manager.TableObjs[tableName] = files.obj
+	tableInfo.toast = files.obj.Toast
+	manager.Mu.Unlock()
+
+	// the table is replaced, what is left of the old files goes on start
+	tableObj.Close()
+	if err := os.RemoveAll(oldPath); err != nil {
+		logger.Log.WithField("table", tableName).Errorf("removing old table files failed: %v", err)
+	} else if err := syncDir(filepath.Dir(oldPath)); err != nil {
+		logger.Log.WithField("table", tableName).Errorf("syncing tables directory failed: %v", err)
+	}
+
+	return nil
+}
+
+// writeRows packs the rows fill adds into the empty files of dir without
+// going through the buffer pool, nothing else can see the files yet. A page
+// is written as soon as it's full.
+func (dm *DiskManagerV2) writeRows(tableName, dir string, schema map[string]ColumnType, fill func(add func(row *RowV2) error) error) (*TableObj, *TableInfo, error) {
+	tableObj, err := dm.openTableFiles(tableName, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tableInfo := &TableInfo{Schema: schema, toast: tableObj.Toast}
+	if current, ok := dm.PageCatalog.Tables[tableName]; ok {
+		tableInfo.Indexes = current.Indexes // built again in dir
+	}
+	tableInfo.refreshLayout()
+
+	flush := func(page *PageV2) error {
+		offset, err := WritePageEOFV2(page, tableObj.DataFile)
+		if err != nil {
+			return fmt.Errorf("WritePageEOFV2 failed: %w", err)
+		}
+
+		freeMem := page.Header.UpperPtr - page.Header.LowerPtr
+		level := getTag(freeMem)
+		if level == 0 {
+			level = AVAIL_DATA
+		}
+
+		pageID := PageID(page.Header.ID)
+		tableObj.DirectoryPage.Value[pageID] = &PageInfo{
+			Offset:       offset,
+			PointerArray: page.PointerArray,
+			Level:        level,
+			ExactFreeMem: freeMem,
+		}
+		tableObj.Memory[level] = append(tableObj.Memory[level], &FreeSpace{PageID: pageID, FreeMemory: freeMem})
+		tableInfo.NumOfPages++
+
+		return nil
+	}
+
+	page := CreatePageV2(tableName)
+	err = fill(func(source *RowV2) error {
+		row := RowV2{ID: source.ID, Values: maps.Clone(source.Values)}
+		if err := EncodeStoredValues(&row, tableInfo); err != nil {
+			return fmt.Errorf("EncodeStoredValues failed: %w", err)
+		}
+
+		encoded, err := EncodeRow(&row, BufferAllocator().(*bytes.Buffer))
+		if err != nil {
+			return fmt.Errorf("EncodeRow failed: %w", err)
+		}
+
+		tuple, err := toastRow(encoded, tableObj.Toast)
+		if err != nil {
+			return fmt.Errorf("toastRow failed: %w", err)
+		}
+
+		if page.Header.UpperPtr-page.Header.LowerPtr <= uint16(len(tuple)) {
+			if err := flush(page); err != nil {
+				return err
+			}
+			page = CreatePageV2(tableName)
+		}
+
+		if err := page.AddTuple(tuple, "writeRows"); err != nil {
+			return fmt.Errorf("AddTuple failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		tableObj.Close()
+		return nil, nil, err
+	}
+
+	if len(page.PointerArray) > 0 {
+		if err := flush(page); err != nil {
+			tableObj.Close()
+			return nil, nil, err
+		}
+	}
+
+	if err := UpdateDirectoryPageDisk(tableObj.DirectoryPage, tableObj.DirFile); err != nil {
+		tableObj.Close()
+		return nil, nil, fmt.Errorf("UpdateDirectoryPageDisk failed: %w", err)
+	}
+
+	if err := saveMemMapping(tableObj, tableInfo); err != nil {
+		tableObj.Close()
+		return nil, nil, fmt.Errorf("saveMemMapping failed: %w", err)
+	}
+
+	if err := dm.openIndexes(tableObj, tableInfo, dir); err != nil {
+		tableObj.Close()
+		return nil, nil, err
+	}
+
+	if err := dm.openBlooms(tableObj, tableInfo, dir); err != nil {
+		tableObj.Close()
+		return nil, nil, err
+	}
+
+	if err := tableObj.fillZones(tableInfo); err != nil {
+		tableObj.Close()
+		return nil, nil, err
+	}
+
+	for _, sync := range []func() error{tableObj.DataFile.Sync, tableObj.DirFile.Sync, tableObj.MemFile.Sync, tableObj.Toast.Sync} {
+		if err := sync(); err != nil {
+			tableObj.Close()
+			return nil, nil, fmt.Errorf("syncing table files failed: %w", err)
+		}
+	}
+
+	return tableObj, tableInfo, nil
+}
+
+// handleAlterTable runs ALTER TABLE name ADD [COLUMN] col type [DEFAULT v] |
+// DROP [COLUMN] [IF EXISTS] col | RENAME [COLUMN] col TO new | RENAME TO new |
+// REWRITE | ADD BLOOM FILTER (cols) | DROP BLOOM FILTER (cols).
+func (qe *QueryEngine) handleAlterTable(plan map[string]interface{}) Result {
+	manager := qe.BufferPoolManager.DiskManager
+
+	tableName := plan["table"].(string)
+	action, _ := plan["action"].(string)
+	column, _ := plan["column"].(string)
+	newName, _ := plan["newName"].(string)
+
+	if _, ok := manager.PageCatalog.Tables[tableName]; !ok {
+		return handleError(fmt.Errorf("table: %s doesn't exist", tableName), "failed")
+	}
+
+	var err error
+	switch action {
+	case "ADD_COLUMN":
+		colType, _ := plan["type"].(string)
+		defaultVal, _ := plan["default"].(string)
+		err = manager.AddColumn(tableName, column, colType, defaultVal)
+	case "DROP_COLUMN":
+		if ifExists, _ := plan["ifExists"].(bool); ifExists {
+			if _, ok := manager.PageCatalog.Tables[tableName].Schema[column]; !ok {
+				return Result{Msg: "Column Doesn't Exist"}
+			}
+		}
+		err = manager.DropColumn(tableName, column)
+	case "RENAME_COLUMN":
+		err = manager.RenameColumn(tableName, column, newName)
+	case "RENAME_TABLE":
+		err = manager.RenameTable(tableName, newName)
+		if err == nil {
+			qe.BufferPoolManager.RenameTablePages(tableName, newName)
+		}
+	case "REWRITE":
+		err = qe.rewriteTable(tableName, false)
+	case "ADD_BLOOM_FILTER", "DROP_BLOOM_FILTER":
+		var columns []string
+		for _, col := range asList(plan["columns"]) {
+			columns = append(columns, strings.ReplaceAll(fmt.Sprint(col), "`", ""))
+		}
+		err = manager.SetBloomFilters(tableName, columns, action == "ADD_BLOOM_FILTER")
+	default:
+		err = fmt.Errorf("unsupported alter action: %s", action)
+	}
+
+	if err != nil {
+		return handleError(fmt.Errorf("ALTER TABLE failed: %w", err), "failed")
+	}
+
+	result := Result{Msg: "Table Altered"}
+
+	switch action {
+	case "ADD_COLUMN", "DROP_COLUMN", "RENAME_COLUMN":
+		if qe.Config != nil && qe.Config.RewriteAfterAlter {
+			result.background = func() error { return qe.rewriteTable(tableName, true) }
+		}
+	}
+
+	return result
+}
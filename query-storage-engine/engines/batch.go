@@ -0,0 +1,329 @@
+package engines
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/scylladb/go-set/strset"
+)
+
+type VectorKind uint8
+
+const (
+	VEC_TEXT VectorKind = iota
+	VEC_INT
+)
+
+// Vector holds one column of a batch. Integer columns are kept as int64 so
+// the kernels compare them without parsing, everything else stays as text.
+type Vector struct {
+	Type  string // declared column type, "" for computed columns
+	Kind  VectorKind
+	Ints  []int64
+	Strs  []string
+	Nulls []bool // the row has no value for the column
+}
+
+func newVector(colType string) *Vector {
+	v := &Vector{Type: colType}
+	if isIntegerType(colType) {
+		v.Kind = VEC_INT
+	}
+
+	return v
+}
+
+func isIntegerType(colType string) bool {
+	switch baseType(colType) {
+	case TYPE_INT, TYPE_INTEGER, TYPE_BIGINT, "SMALLINT", "TINYINT":
+		return true
+	}
+	return false
+}
+
+func (v *Vector) Len() int {
+	return len(v.Nulls)
+}
+
+func (v *Vector) append(text string, present bool) {
+	null := !present || (v.Kind == VEC_INT && text == "")
+
+	if v.Kind == VEC_INT && !null {
+		num, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || strconv.FormatInt(num, 10) != text {
+			v.toText() // legacy value, keep its exact text
+		} else {
+			v.Ints = append(v.Ints, num)
+			v.Nulls = append(v.Nulls, false)
+			return
+		}
+	}
+
+	if v.Kind == VEC_INT {
+		v.Ints = append(v.Ints, 0)
+	} else {
+		v.Strs = append(v.Strs, text)
+	}
+	v.Nulls = append(v.Nulls, null)
+}
+
+func (v *Vector) toText() {
+	v.Strs = make([]string, len(v.Ints))
+	for i, num := range v.Ints {
+		if !v.Nulls[i] {
+			v.Strs[i] = strconv.FormatInt(num, 10)
+		}
+	}
+
+	v.Ints = nil
+	v.Kind = VEC_TEXT
+}
+
+// Text returns the textual value of row i, present is false for nulls.
+func (v *Vector) Text(i int) (string, bool) {
+	if v.Nulls[i] {
+		return "", false
+	}
+
+	if v.Kind == VEC_INT {
+		return strconv.FormatInt(v.Ints[i], 10), true
+	}
+
+	return v.Strs[i], true
+}
+
+// Batch is a set of column vectors of equal length. Sel lists the live
+// rows in output order, a nil Sel means every row from 0 to Len-1.
+type Batch struct {
+	Len     int
+	IDs     []uint64
+	Names   []string
+	Columns []*Vector
+	Sel     []int
+}
+
+func (b *Batch) Column(name string) *Vector {
+	for i, n := range b.Names {
+		if n == name {
+			return b.Columns[i]
+		}
+	}
+
+	return nil
+}
+
+// SetColumn adds the vector or replaces the column with the same name.
+func (b *Batch) SetColumn(name string, v *Vector) {
+	for i, n := range b.Names {
+		if n == name {
+			b.Columns[i] = v
+			return
+		}
+	}
+
+	b.Names = append(b.Names, name)
+	b.Columns = append(b.Columns, v)
+}
+
+// Keep drops every column outside of the set, rows aren't touched.
+func (b *Batch) Keep(set *strset.Set) {
+	names := b.Names[:0]
+	columns := b.Columns[:0]
+
+	for i, name := range b.Names {
+		if set.Has(name) {
+			names = append(names, name)
+			columns = append(columns, b.Columns[i])
+		}
+	}
+
+	b.Names = names
+	b.Columns = columns
+}
+
+func (b *Batch) Live() []int {
+	if b.Sel != nil {
+		return b.Sel
+	}
+
+	sel := make([]int, b.Len)
+	for i := range sel {
+		sel[i] = i
+	}
+
+	return sel
+}
+
+func (b *Batch) NumLive() int {
+	if b.Sel != nil {
+		return len(b.Sel)
+	}
+
+	return b.Len
+}
+
+// Row materializes row i, used by the row adapter and by expressions
+// that have no vectorized kernel.
+func (b *Batch) Row(i int) *RowV2 {
+	row := &RowV2{ID: b.IDs[i], Values: make(map[string]string, len(b.Names))}
+
+	for c, name := range b.Names {
+		if text, ok := b.Columns[c].Text(i); ok {
+			row.Values[name] = text
+		}
+	}
+
+	return row
+}
+
+// ToRows is the row adapter at the tail of the pipeline.
+func (b *Batch) ToRows() []*RowV2 {
+	rows := make([]*RowV2, 0, b.NumLive())
+	for _, i := range b.Live() {
+		rows = append(rows, b.Row(i))
+	}
+
+	return rows
+}
+
+// BatchBuilder turns decoded rows into column vectors.
+type BatchBuilder struct {
+	schema map[string]ColumnType
+	batch  *Batch
+}
+
+func NewBatchBuilder(schema map[string]ColumnType) *BatchBuilder {
+	bb := &BatchBuilder{schema: schema}
+	bb.reset()
+
+	return bb
+}
+
+func (bb *BatchBuilder) reset() {
+	names := make([]string, 0, len(bb.schema))
+	for name := range bb.schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bb.batch = &Batch{Names: names}
+	for _, name := range names {
+		bb.batch.Columns = append(bb.batch.Columns, newVector(bb.schema[name].Type))
+	}
+}
+
+func (bb *BatchBuilder) Len() int {
+	return bb.batch.Len
+}
+
+// Append copies the values of the row, the row can be reused afterwards.
+func (bb *BatchBuilder) Append(row *RowV2) {
+	b := bb.batch
+
+	matched := 0
+	for c, name := range b.Names {
+		text, ok := row.Values[name]
+		if ok {
+			matched++
+		}
+		b.Columns[c].append(text, ok)
+	}
+
+	if matched < len(row.Values) {
+		for name, text := range row.Values {
+			if b.Column(name) != nil {
+				continue
+			}
+
+			v := newVector("")
+			for range b.Len {
+				v.append("", false)
+			}
+			v.append(text, true)
+			b.SetColumn(name, v)
+		}
+	}
+
+	b.IDs = append(b.IDs, row.ID)
+	b.Len++
+}
+
+// Flush returns the built batch and starts a new one.
+func (bb *BatchBuilder) Flush() *Batch {
+	b := bb.batch
+	bb.reset()
+
+	return b
+}
+
+func BatchFromRows(rows []*RowV2, schema map[string]ColumnType) *Batch {
+	bb := NewBatchBuilder(schema)
+	for _, row := range rows {
+		bb.Append(row)
+	}
+
+	return bb.Flush()
+}
+
+// concatBatches compacts the live rows of every batch into a single one.
+func concatBatches(batches []*Batch, schema map[string]ColumnType) *Batch {
+	bb := NewBatchBuilder(schema)
+	out := bb.batch
+
+	for _, b := range batches {
+		live := b.Live()
+		for c, name := range b.Names {
+			if out.Column(name) == nil {
+				v := &Vector{Type: b.Columns[c].Type, Kind: b.Columns[c].Kind}
+				for range out.Len {
+					v.appendNull()
+				}
+				out.SetColumn(name, v)
+			}
+		}
+
+		for c, name := range out.Names {
+			dst := out.Columns[c]
+			src := b.Column(name)
+			for _, i := range live {
+				if src == nil {
+					dst.appendNull()
+					continue
+				}
+				dst.appendFrom(src, i)
+			}
+		}
+
+		for _, i := range live {
+			out.IDs = append(out.IDs, b.IDs[i])
+		}
+		out.Len += len(live)
+	}
+
+	return out
+}
+
+func (v *Vector) appendNull() {
+	if v.Kind == VEC_INT {
+		v.Ints = append(v.Ints, 0)
+	} else {
+		v.Strs = append(v.Strs, "")
+	}
+	v.Nulls = append(v.Nulls, true)
+}
+
+func (v *Vector) appendFrom(src *Vector, i int) {
+	if src.Nulls[i] {
+		v.appendNull()
+		return
+	}
+
+	if v.Kind == VEC_INT && src.Kind == VEC_INT {
+		v.Ints = append(v.Ints, src.Ints[i])
+		v.Nulls = append(v.Nulls, false)
+		return
+	}
+
+	text, _ := src.Text(i)
+	v.append(text, true)
+}
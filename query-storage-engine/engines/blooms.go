@@ -0,0 +1,384 @@
+package engines
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+// Bloom filters of the pages
+//
+// ALTER TABLE name ADD BLOOM FILTER (columns) keeps a bloom filter of the
+// values every page holds in those columns, all of them in
+// Tables/<dir>/skip_page. A scan whose condition holds one of the columns
+// equal to a constant skips the pages whose filter doesn't have it, their
+// rows aren't decoded. findAndUpdate adds the values of the rows it places
+// before writing the page, a filter can't forget a value so cleanOrgnize
+// builds the one of a page again from the rows RearrangePAGE keeps. A filter
+// may hold values its page doesn't, never the other way around, and a page
+// without a filter for the column is always read. The missing ones are built
+// when the table is opened.
+//
+// The filters are kept under the stored name of the column, RENAME COLUMN
+// doesn't touch them.
+
+const (
+	SKIP_PAGE_FILE       = "skip_page"
+	BLOOM_PAGE_ROWS      = 128 // rows a filter is sized for, more only raise the false positives
+	BLOOM_FALSE_POSITIVE = 0.01
+)
+
+type PageBlooms struct {
+	Filters map[PageID]map[Column]*bloom.BloomFilter
+	File    *os.File
+	Mu      sync.RWMutex
+}
+
+func newPageBloom() *bloom.BloomFilter {
+	return bloom.NewWithEstimates(BLOOM_PAGE_ROWS, BLOOM_FALSE_POSITIVE)
+}
+
+// bloomKey is what the filters hold for a value, the values comparing equal
+// under the column type share it.
+func bloomKey(text, colType string) []byte {
+	d := DatumFromText(text, colType)
+	switch d.Kind {
+	case KindNumber:
+		return []byte(strconv.FormatFloat(d.Num, 'g', -1, 64))
+	case KindInterval:
+		return []byte(strconv.FormatInt(intervalMicros(d.Interval), 10))
+	default:
+		return []byte(d.String())
+	}
+}
+
+// bloomColumns maps the columns with a bloom filter to the name their
+// filters are kept under.
+func (ti *TableInfo) bloomColumns() map[string]Column {
+	columns := make(map[string]Column)
+	for column, columnType := range ti.Schema {
+		if columnType.Bloom {
+			columns[column] = Column(columnType.storedName(column))
+		}
+	}
+	return columns
+}
+
+// addBloomValues adds the non null values of the bloom columns to the
+// filters of a page.
+func addBloomValues(filters map[Column]*bloom.BloomFilter, values map[string]string, tableInfo *TableInfo) {
+	for column, key := range tableInfo.bloomColumns() {
+		filter, ok := filters[key]
+		if !ok {
+			continue
+		}
+
+		if value := values[column]; value != "" {
+			filter.Add(bloomKey(value, tableInfo.Schema[column].Type))
+		}
+	}
+}
+
+// openBlooms opens the filters of the table kept in dir when it has bloom
+// columns, the ones of dropped columns are forgotten and the missing ones
+// built from the rows.
+func (dm *DiskManagerV2) openBlooms(tableObj *TableObj, tableInfo *TableInfo, dir string) error {
+	columns := tableInfo.bloomColumns()
+	if len(columns) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(dm.tablesPath(tableObj.TableName), dir, SKIP_PAGE_FILE)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("opening %s failed: %w", path, err)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	filters := make(map[PageID]map[Column]*bloom.BloomFilter)
+	if len(data) > 0 {
+		if filters, err = readSkipPage(bytes.NewReader(data)); err != nil {
+			file.Close()
+			return fmt.Errorf("readSkipPage failed: %w", err)
+		}
+	}
+
+	kept := slices.Collect(maps.Values(columns))
+	for _, pageFilters := range filters {
+		maps.DeleteFunc(pageFilters, func(key Column, _ *bloom.BloomFilter) bool {
+			return !slices.Contains(kept, key)
+		})
+	}
+
+	tableObj.Blooms = &PageBlooms{Filters: filters, File: file}
+	return tableObj.fillBlooms(tableInfo)
+}
+
+// fillBlooms builds the filters the pages of the table are missing.
+func (tableObj *TableObj) fillBlooms(tableInfo *TableInfo) error {
+	columns := tableInfo.bloomColumns()
+
+	tableObj.DirectoryPage.Mu.RLock()
+	pages := maps.Clone(tableObj.DirectoryPage.Value)
+	tableObj.DirectoryPage.Mu.RUnlock()
+
+	blooms := tableObj.Blooms
+	blooms.Mu.Lock()
+	defer blooms.Mu.Unlock()
+
+	for pageID := range blooms.Filters {
+		if _, ok := pages[pageID]; !ok {
+			delete(blooms.Filters, pageID)
+		}
+	}
+
+	for pageID, pageObj := range pages {
+		built := make(map[Column]*bloom.BloomFilter)
+		for _, key := range columns {
+			if _, ok := blooms.Filters[pageID][key]; !ok {
+				built[key] = newPageBloom()
+			}
+		}
+		if len(built) == 0 {
+			continue
+		}
+
+		pageObj.Mu.RLock()
+		offset := pageObj.Offset
+		pageObj.Mu.RUnlock()
+
+		pageBytes, err := ReadPageAtOffset(tableObj.DataFile, offset)
+		if err != nil {
+			return fmt.Errorf("ReadPageAtOffset failed: %w", err)
+		}
+
+		page, err := DecodePageV2(pageBytes)
+		if err != nil {
+			return fmt.Errorf("DecodePageV2 failed: %w", err)
+		}
+
+		err = visitRows(page, tableObj, tableInfo, func(row *RowV2) error {
+			addBloomValues(built, row.Values, tableInfo)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if blooms.Filters[pageID] == nil {
+			blooms.Filters[pageID] = built
+		} else {
+			maps.Copy(blooms.Filters[pageID], built)
+		}
+	}
+
+	return blooms.save()
+}
+
+// save writes every filter to the skip_page file, the caller holds Mu.
+func (blooms *PageBlooms) save() error {
+	var buf bytes.Buffer
+	if err := writeSkipPage(&buf, blooms.Filters); err != nil {
+		return fmt.Errorf("writeSkipPage failed: %w", err)
+	}
+
+	if err := WriteNonPageFile(blooms.File, buf.Bytes()); err != nil {
+		return fmt.Errorf("WriteNonPageFile failed: %w", err)
+	}
+
+	return nil
+}
+
+// addBlooms adds the rows placed from firstSlot on in the page to its
+// filters. A page that had rows before without a filter keeps being read.
+func (tableObj *TableObj) addBlooms(rows []map[string]string, tableInfo *TableInfo, pageID PageID, firstSlot int) error {
+	blooms := tableObj.Blooms
+	if blooms == nil {
+		return nil
+	}
+
+	columns := tableInfo.bloomColumns()
+
+	blooms.Mu.Lock()
+	defer blooms.Mu.Unlock()
+
+	pageFilters := blooms.Filters[pageID]
+	if pageFilters == nil {
+		pageFilters = make(map[Column]*bloom.BloomFilter)
+		blooms.Filters[pageID] = pageFilters
+	}
+
+	if firstSlot == 0 {
+		for _, key := range columns {
+			if _, ok := pageFilters[key]; !ok {
+				pageFilters[key] = newPageBloom()
+			}
+		}
+	}
+
+	for _, values := range rows {
+		addBloomValues(pageFilters, values, tableInfo)
+	}
+
+	return blooms.save()
+}
+
+// rebuildBlooms builds the filters of a page RearrangePAGE compacted from
+// the rows left in it.
+func (tableObj *TableObj) rebuildBlooms(page *PageV2, tableInfo *TableInfo) error {
+	blooms := tableObj.Blooms
+	if blooms == nil {
+		return nil
+	}
+
+	pageFilters := make(map[Column]*bloom.BloomFilter)
+	for _, key := range tableInfo.bloomColumns() {
+		pageFilters[key] = newPageBloom()
+	}
+
+	err := visitRows(page, tableObj, tableInfo, func(row *RowV2) error {
+		addBloomValues(pageFilters, row.Values, tableInfo)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	blooms.Mu.Lock()
+	defer blooms.Mu.Unlock()
+
+	blooms.Filters[PageID(page.Header.ID)] = pageFilters
+	return blooms.save()
+}
+
+// dropBlooms forgets the filters kept under keys.
+func (tableObj *TableObj) dropBlooms(keys ...Column) error {
+	blooms := tableObj.Blooms
+	if blooms == nil || len(keys) == 0 {
+		return nil
+	}
+
+	blooms.Mu.Lock()
+	defer blooms.Mu.Unlock()
+
+	for _, pageFilters := range blooms.Filters {
+		for _, key := range keys {
+			delete(pageFilters, key)
+		}
+	}
+	return blooms.save()
+}
+
+// SetBloomFilters adds (on) or drops the bloom filters of the columns, the
+// added ones are built from the rows right away.
+func (dm *DiskManagerV2) SetBloomFilters(tableName string, columns []string, on bool) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no column given")
+	}
+
+	var keys []Column
+	err := dm.alterSchema(tableName, func(schema map[string]ColumnType, tableInfo *TableInfo) error {
+		for _, column := range columns {
+			columnType, ok := schema[column]
+			switch {
+			case !ok:
+				return fmt.Errorf("column: %s doesn't exist", column)
+			case on && columnType.Bloom:
+				return fmt.Errorf("column: %s already has a bloom filter", column)
+			case !on && !columnType.Bloom:
+				return fmt.Errorf("column: %s has no bloom filter", column)
+			}
+
+			columnType.Bloom = on
+			schema[column] = columnType
+			keys = append(keys, Column(columnType.storedName(column)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tableObj, err := GetTableObj(tableName, dm)
+	if err != nil {
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+	tableInfo := dm.PageCatalog.Tables[tableName]
+
+	if !on {
+		return tableObj.dropBlooms(keys...)
+	}
+
+	if tableObj.Blooms == nil {
+		return dm.openBlooms(tableObj, tableInfo, dm.tableDir(tableName))
+	}
+	return tableObj.fillBlooms(tableInfo)
+}
+
+// bloomProbe is a value a condition holds a bloom column equal to.
+type bloomProbe struct {
+	key   Column
+	value []byte
+}
+
+// bloomProbes are the equalities between a bloom column and a constant
+// among the conjuncts of condition.
+func bloomProbes(condition any, tableInfo *TableInfo, ectx *ExprContext) []bloomProbe {
+	columns := tableInfo.bloomColumns()
+	if len(columns) == 0 {
+		return nil
+	}
+
+	var probes []bloomProbe
+	for _, conjunct := range conjuncts(condition) {
+		node, _ := conjunct.(map[string]any)
+		opMap, _ := node["op"].(map[string]any)
+		operands, _ := node["operands"].([]any)
+		if kind, _ := opMap["kind"].(string); kind != "EQUALS" || len(operands) != 2 {
+			continue
+		}
+
+		for column, key := range columns {
+			colType := tableInfo.Schema[column].Type
+			for i, operand := range operands {
+				if !isColumn(operand, column, ectx) {
+					continue
+				}
+
+				if text, ok := constantKey(operands[1-i], colType, ectx); ok {
+					probes = append(probes, bloomProbe{key: key, value: bloomKey(text, colType)})
+				}
+			}
+		}
+	}
+
+	return probes
+}
+
+// rulesOut reports whether the filters of the page don't have one of the
+// values.
+func (blooms *PageBlooms) rulesOut(pageID PageID, probes []bloomProbe) bool {
+	blooms.Mu.RLock()
+	defer blooms.Mu.RUnlock()
+
+	pageFilters := blooms.Filters[pageID]
+	for _, probe := range probes {
+		if filter, ok := pageFilters[probe.key]; ok && !filter.Test(probe.value) {
+			return true
+		}
+	}
+	return false
+}
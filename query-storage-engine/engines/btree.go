@@ -0,0 +1,632 @@
+package engines
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An Index is a B+tree kept in its own file next to the table, one node per
+// page. Page 0 holds the meta (root and page count) and the leaves are
+// chained left to right for the range scans. An entry is the key plus the
+// location of the row, so equal keys (a primary key moved by an update, a
+// non unique index) are distinct entries ordered by location.
+//
+// Nodes are written through as soon as they change and read through the
+// IndexPool of the buffer pool. Deletes don't rebalance, an emptied leaf
+// stays in the chain until the index is built again.
+
+const (
+	NODE_HEADER     = 11 // [leaf uint8][count uint16][next uint64]
+	INDEX_META      = 16 // [root uint64][pages uint64]
+	MAX_INDEX_KEY   = 1024
+	INDEX_POOL_SIZE = 4 * MaxPoolSize
+)
+
+// RowLocation is the page of the row and its position in the pointer array
+// of the page.
+type RowLocation struct {
+	Page PageID
+	Slot uint16
+}
+
+type IndexEntry struct {
+	Key []string // one value per column, as in RowV2.Values ("" is null)
+	Loc RowLocation
+}
+
+// IndexBound limits a scan on the first len(Key) columns of the index.
+type IndexBound struct {
+	Key       []string
+	Inclusive bool
+}
+
+type indexNode struct {
+	id       uint64
+	leaf     bool
+	next     uint64 // right sibling of a leaf, 0 for the last one
+	entries  []IndexEntry
+	children []uint64 // inner nodes only, len(entries)+1
+}
+
+type Index struct {
+	Name    string
+	Columns []string
+	Types   []string
+	file    *os.File
+	pool    *IndexPool
+	root    uint64
+	pages   uint64
+	mu      sync.RWMutex
+}
+
+// OpenIndex opens the index file at path, a new file starts as an empty
+// leaf and created tells the caller it still has to be filled.
+func OpenIndex(path, name string, columns, types []string, pool *IndexPool) (*Index, bool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, false, fmt.Errorf("opening index file failed: %w", err)
+	}
+
+	idx := &Index{Name: name, Columns: columns, Types: types, file: file, pool: pool}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("index file Stat failed: %w", err)
+	}
+
+	if stat.Size() < PageSizeV2 {
+		if err := idx.init(); err != nil {
+			file.Close()
+			return nil, false, err
+		}
+		return idx, true, nil
+	}
+
+	meta := make([]byte, INDEX_META)
+	if _, err := file.ReadAt(meta, 0); err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("reading index meta failed: %w", err)
+	}
+
+	idx.root = binary.LittleEndian.Uint64(meta[0:8])
+	idx.pages = binary.LittleEndian.Uint64(meta[8:16])
+	return idx, false, nil
+}
+
+// init writes the meta and an empty root leaf over whatever the file held.
+func (idx *Index) init() error {
+	if err := idx.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncating index file failed: %w", err)
+	}
+
+	idx.root, idx.pages = 1, 2
+	if err := idx.writeMeta(); err != nil {
+		return err
+	}
+
+	return idx.writeNode(&indexNode{id: 1, leaf: true})
+}
+
+func (idx *Index) Close() {
+	idx.pool.discard(idx)
+	idx.file.Close()
+}
+
+// Insert adds the entry, an entry already in the index is left alone.
+func (idx *Index) Insert(entry IndexEntry) error {
+	if size := entrySize(entry); size > MAX_INDEX_KEY {
+		return fmt.Errorf("index: %s key of %d bytes exceeds %d bytes", idx.Name, size, MAX_INDEX_KEY)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	path, err := idx.descend(entry)
+	if err != nil {
+		return err
+	}
+
+	leaf := path[len(path)-1]
+	pos, found := idx.search(leaf, entry)
+	if found {
+		return nil
+	}
+
+	leaf.entries = slices.Insert(leaf.entries, pos, entry)
+	return idx.store(path)
+}
+
+// Delete removes the entry, a missing one isn't an error.
+func (idx *Index) Delete(entry IndexEntry) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	path, err := idx.descend(entry)
+	if err != nil {
+		return err
+	}
+
+	leaf := path[len(path)-1]
+	pos, found := idx.search(leaf, entry)
+	if !found {
+		return nil
+	}
+
+	leaf.entries = slices.Delete(leaf.entries, pos, pos+1)
+	return idx.writeNode(leaf)
+}
+
+// Scan calls fn in key order for the entries between lo and hi (nil is
+// unbounded) until fn returns false.
+func (idx *Index) Scan(lo, hi *IndexBound, fn func(entry IndexEntry) bool) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	node, err := idx.readNode(idx.root)
+	if err != nil {
+		return err
+	}
+
+	for !node.leaf {
+		child := 0
+		if lo != nil {
+			child = sort.Search(len(node.entries), func(i int) bool {
+				return idx.compareKeys(node.entries[i].Key, lo.Key) >= 0
+			})
+		}
+
+		if node, err = idx.readNode(node.children[child]); err != nil {
+			return err
+		}
+	}
+
+	for {
+		for _, entry := range node.entries {
+			if lo != nil {
+				cmp := idx.compareKeys(entry.Key, lo.Key)
+				if cmp < 0 || (cmp == 0 && !lo.Inclusive) {
+					continue
+				}
+			}
+
+			if hi != nil {
+				cmp := idx.compareKeys(entry.Key, hi.Key)
+				if cmp > 0 || (cmp == 0 && !hi.Inclusive) {
+					return nil
+				}
+			}
+
+			if !fn(entry) {
+				return nil
+			}
+		}
+
+		if node.next == 0 {
+			return nil
+		}
+
+		if node, err = idx.readNode(node.next); err != nil {
+			return err
+		}
+	}
+}
+
+// descend returns the nodes from the root to the leaf where entry belongs.
+func (idx *Index) descend(entry IndexEntry) ([]*indexNode, error) {
+	node, err := idx.readNode(idx.root)
+	if err != nil {
+		return nil, err
+	}
+
+	path := []*indexNode{node}
+	for !node.leaf {
+		child := sort.Search(len(node.entries), func(i int) bool {
+			return idx.compare(node.entries[i], entry) > 0
+		})
+
+		if node, err = idx.readNode(node.children[child]); err != nil {
+			return nil, err
+		}
+		path = append(path, node)
+	}
+
+	return path, nil
+}
+
+func (idx *Index) search(leaf *indexNode, entry IndexEntry) (int, bool) {
+	pos := sort.Search(len(leaf.entries), func(i int) bool {
+		return idx.compare(leaf.entries[i], entry) >= 0
+	})
+
+	return pos, pos < len(leaf.entries) && idx.compare(leaf.entries[pos], entry) == 0
+}
+
+// store writes the modified leaf at the end of path, splitting the nodes
+// that no longer fit a page from the bottom up.
+func (idx *Index) store(path []*indexNode) error {
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		if node.size() <= PageSizeV2 {
+			return idx.writeNode(node)
+		}
+
+		right, separator := idx.split(node)
+		for _, changed := range []*indexNode{right, node} {
+			if err := idx.writeNode(changed); err != nil {
+				return err
+			}
+		}
+
+		// the page count must be on disk before a parent points to the page
+		if err := idx.writeMeta(); err != nil {
+			return err
+		}
+
+		if i == 0 {
+			root := &indexNode{
+				id:       idx.allocate(),
+				entries:  []IndexEntry{separator},
+				children: []uint64{node.id, right.id},
+			}
+
+			if err := idx.writeNode(root); err != nil {
+				return err
+			}
+
+			idx.root = root.id
+			return idx.writeMeta()
+		}
+
+		parent := path[i-1]
+		at := slices.Index(parent.children, node.id)
+		parent.entries = slices.Insert(parent.entries, at, separator)
+		parent.children = slices.Insert(parent.children, at+1, right.id)
+	}
+
+	return nil
+}
+
+// split moves the upper half (by bytes) of node to a new right sibling and
+// returns the separator the parent gets, the first key of the right side.
+func (idx *Index) split(node *indexNode) (*indexNode, IndexEntry) {
+	half, mid := node.size()/2, 0
+	for used := NODE_HEADER; mid < len(node.entries)-1 && used < half; mid++ {
+		used += entrySize(node.entries[mid])
+	}
+	mid = max(mid, 1)
+
+	right := &indexNode{id: idx.allocate(), leaf: node.leaf}
+	if node.leaf {
+		right.entries = slices.Clone(node.entries[mid:])
+		right.next, node.next = node.next, right.id
+		node.entries = slices.Clone(node.entries[:mid])
+		return right, right.entries[0]
+	}
+
+	// the separator moves up, its right child starts the new node
+	mid = min(mid, len(node.entries)-2)
+	separator := node.entries[mid]
+	right.entries = slices.Clone(node.entries[mid+1:])
+	right.children = slices.Clone(node.children[mid+1:])
+	node.entries = slices.Clone(node.entries[:mid])
+	node.children = slices.Clone(node.children[:mid+1])
+	return right, separator
+}
+
+func (idx *Index) allocate() uint64 {
+	id := idx.pages
+	idx.pages++
+	return id
+}
+
+// compare orders the entries by key and then by location.
+func (idx *Index) compare(a, b IndexEntry) int {
+	if cmp := idx.compareKeys(a.Key, b.Key); cmp != 0 {
+		return cmp
+	}
+
+	if a.Loc.Page != b.Loc.Page {
+		if a.Loc.Page < b.Loc.Page {
+			return -1
+		}
+		return 1
+	}
+
+	return int(a.Loc.Slot) - int(b.Loc.Slot)
+}
+
+// compareKeys compares the columns both keys have, a bound shorter than the
+// index compares equal to every key starting with it.
+func (idx *Index) compareKeys(a, b []string) int {
+	for i := range min(len(a), len(b)) {
+		if cmp := idx.compareColumn(i, a[i], b[i]); cmp != 0 {
+			return cmp
+		}
+	}
+
+	return 0
+}
+
+// compareColumn orders two values of the i-th column by its type.
+func (idx *Index) compareColumn(i int, a, b string) int {
+	colType := ""
+	if i < len(idx.Types) {
+		colType = idx.Types[i]
+	}
+
+	return compareText(a, b, colType)
+}
+
+// compareText orders two values of a column of type colType, values the
+// type can't compare fall back to their text.
+func compareText(a, b, colType string) int {
+	cmp, err := CompareDatums(DatumFromText(a, colType), DatumFromText(b, colType))
+	if err != nil {
+		return strings.Compare(a, b)
+	}
+	return cmp
+}
+
+func entrySize(entry IndexEntry) int {
+	size := 8 + 2 // page and slot
+	for _, value := range entry.Key {
+		size += 2 + len(value)
+	}
+	return size
+}
+
+func (node *indexNode) size() int {
+	size := NODE_HEADER
+	if !node.leaf {
+		size += 8 * len(node.children)
+	}
+
+	for _, entry := range node.entries {
+		size += entrySize(entry)
+	}
+	return size
+}
+
+func (idx *Index) writeMeta() error {
+	meta := make([]byte, PageSizeV2)
+	binary.LittleEndian.PutUint64(meta[0:8], idx.root)
+	binary.LittleEndian.PutUint64(meta[8:16], idx.pages)
+
+	if _, err := idx.file.WriteAt(meta, 0); err != nil {
+		return fmt.Errorf("writing index meta failed: %w", err)
+	}
+	return nil
+}
+
+func (idx *Index) writeNode(node *indexNode) error {
+	if _, err := idx.file.WriteAt(encodeIndexNode(node), int64(node.id)*PageSizeV2); err != nil {
+		// the cached copy may hold changes that never made it to disk
+		idx.pool.discard(idx)
+		return fmt.Errorf("writing index node %d failed: %w", node.id, err)
+	}
+
+	idx.pool.put(idx, node)
+	return nil
+}
+
+func (idx *Index) readNode(id uint64) (*indexNode, error) {
+	if node := idx.pool.get(idx, id); node != nil {
+		return node, nil
+	}
+
+	page := make([]byte, PageSizeV2)
+	if _, err := idx.file.ReadAt(page, int64(id)*PageSizeV2); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading index node %d failed: %w", id, err)
+	}
+
+	node, err := decodeIndexNode(page, id, len(idx.Columns))
+	if err != nil {
+		return nil, fmt.Errorf("index: %s node %d: %w", idx.Name, id, err)
+	}
+
+	idx.pool.put(idx, node)
+	return node, nil
+}
+
+func encodeIndexNode(node *indexNode) []byte {
+	page := make([]byte, PageSizeV2)
+	if node.leaf {
+		page[0] = 1
+	}
+	binary.LittleEndian.PutUint16(page[1:3], uint16(len(node.entries)))
+	binary.LittleEndian.PutUint64(page[3:11], node.next)
+
+	at := NODE_HEADER
+	putUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(page[at:], v)
+		at += 8
+	}
+
+	if !node.leaf {
+		putUint64(node.children[0])
+	}
+
+	for i, entry := range node.entries {
+		for _, value := range entry.Key {
+			binary.LittleEndian.PutUint16(page[at:], uint16(len(value)))
+			at += 2 + copy(page[at+2:], value)
+		}
+
+		putUint64(uint64(entry.Loc.Page))
+		binary.LittleEndian.PutUint16(page[at:], entry.Loc.Slot)
+		at += 2
+
+		if !node.leaf {
+			putUint64(node.children[i+1])
+		}
+	}
+
+	return page
+}
+
+var errCorruptNode = errors.New("corrupt index node")
+
+func decodeIndexNode(page []byte, id uint64, columns int) (*indexNode, error) {
+	node := &indexNode{id: id, leaf: page[0] == 1}
+	count := int(binary.LittleEndian.Uint16(page[1:3]))
+	node.next = binary.LittleEndian.Uint64(page[3:11])
+
+	at := NODE_HEADER
+	uint64At := func() (uint64, error) {
+		if at+8 > len(page) {
+			return 0, errCorruptNode
+		}
+		v := binary.LittleEndian.Uint64(page[at:])
+		at += 8
+		return v, nil
+	}
+
+	if !node.leaf {
+		child, err := uint64At()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, child)
+	}
+
+	node.entries = make([]IndexEntry, 0, count)
+	for range count {
+		entry := IndexEntry{Key: make([]string, columns)}
+		for c := range columns {
+			if at+2 > len(page) {
+				return nil, errCorruptNode
+			}
+			length := int(binary.LittleEndian.Uint16(page[at:]))
+			at += 2
+			if at+length > len(page) {
+				return nil, errCorruptNode
+			}
+			entry.Key[c] = string(page[at : at+length])
+			at += length
+		}
+
+		pageID, err := uint64At()
+		if err != nil || at+2 > len(page) {
+			return nil, errCorruptNode
+		}
+		entry.Loc = RowLocation{Page: PageID(pageID), Slot: binary.LittleEndian.Uint16(page[at:])}
+		at += 2
+		node.entries = append(node.entries, entry)
+
+		if !node.leaf {
+			child, err := uint64At()
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		}
+	}
+
+	return node, nil
+}
+
+type indexFrame struct {
+	index *Index
+	node  uint64
+}
+
+// IndexPool caches the decoded nodes of every index, like the buffer pool
+// does for the table pages. Nodes are written through so an evicted frame
+// is simply dropped.
+type IndexPool struct {
+	Nodes    [INDEX_POOL_SIZE]*indexNode
+	frames   [INDEX_POOL_SIZE]indexFrame
+	table    map[indexFrame]FrameID
+	freeList []FrameID
+	Replacer *LRUKReplacer
+	mu       sync.Mutex
+}
+
+func NewIndexPool(k int) *IndexPool {
+	pool := &IndexPool{table: make(map[indexFrame]FrameID), Replacer: NewLRUKReplacer(k)}
+	for i := range INDEX_POOL_SIZE {
+		pool.freeList = append(pool.freeList, FrameID(i))
+	}
+	return pool
+}
+
+func (pool *IndexPool) get(idx *Index, id uint64) *indexNode {
+	if pool == nil {
+		return nil
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	frameID, ok := pool.table[indexFrame{idx, id}]
+	if !ok {
+		return nil
+	}
+
+	pool.Replacer.RecordAccess(frameID, 0)
+	return pool.Nodes[frameID]
+}
+
+func (pool *IndexPool) put(idx *Index, node *indexNode) {
+	if pool == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	key := indexFrame{idx, node.id}
+	if frameID, ok := pool.table[key]; ok {
+		pool.Nodes[frameID] = node
+		pool.Replacer.RecordAccess(frameID, 0)
+		return
+	}
+
+	if len(pool.freeList) == 0 {
+		frameID, err := pool.Replacer.Evict()
+		if err != nil || frameID < 0 {
+			return // not cached, the next read goes to the file
+		}
+
+		delete(pool.table, pool.frames[frameID])
+		pool.Nodes[frameID] = nil
+		pool.freeList = append(pool.freeList, frameID)
+	}
+
+	frameID := pool.freeList[0]
+	pool.freeList = pool.freeList[1:]
+
+	pool.Nodes[frameID] = node
+	pool.frames[frameID] = key
+	pool.table[key] = frameID
+	pool.Replacer.RecordAccess(frameID, 0)
+}
+
+// discard drops every cached node of the index.
+func (pool *IndexPool) discard(idx *Index) {
+	if pool == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for key, frameID := range pool.table {
+		if key.index != idx {
+			continue
+		}
+
+		delete(pool.table, key)
+		pool.Nodes[frameID] = nil
+		pool.freeList = append(pool.freeList, frameID)
+		pool.Replacer.Remove(frameID)
+	}
+}
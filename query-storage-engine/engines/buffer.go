@@ -22,10 +22,13 @@ type BufferPoolManager struct {
 	Replacer    *LRUKReplacer
 	DiskManager *DiskManagerV2
 	Wal         *WalManager
+	Indexes     *IndexPool // nodes of the table indexes
 	Mu          sync.RWMutex
 }
 
-func (bpm *BufferPoolManager) FullTableScan(ctx context.Context, pageChan chan *PageV2, tableObj *TableObj, staticNumPages uint64) error {
+// FullTableScan sends the pages of the table, skip (nil for none) drops the
+// pages on disk that can't hold a row the scan wants.
+func (bpm *BufferPoolManager) FullTableScan(ctx context.Context, pageChan chan *PageV2, tableObj *TableObj, staticNumPages uint64, skip func(PageID) bool) error {
 	var wg sync.WaitGroup
 
 	errChan := make(chan error, 2)
@@ -44,7 +47,7 @@ func (bpm *BufferPoolManager) FullTableScan(ctx context.Context, pageChan chan *
 
 	go func() {
 		defer wg.Done()
-		if err := GetTablePagesFromDisk(ctx, nil, pageChan, tableObj, bpm.PageTable, staticNumPages); err != nil {
+		if err := GetTablePagesFromDisk(ctx, nil, pageChan, tableObj, bpm.PageTable, staticNumPages, skip); err != nil {
 			errChan <- fmt.Errorf("GetTablePagesFromDisk Failed: %w", err)
 		}
 	}()
@@ -79,6 +82,52 @@ func (bpm *BufferPoolManager) FullBufferScan(pageChan chan *PageV2) error {
 	return nil
 }
 
+// PageScan sends the listed pages of the table, the ones cached in the pool
+// included. Pages emptied since the list was made are skipped.
+func (bpm *BufferPoolManager) PageScan(ctx context.Context, pageChan chan *PageV2, tableObj *TableObj, pages []PageID) error {
+	defer close(pageChan)
+
+	for _, pageID := range pages {
+		if frameID, ok := bpm.PageTable[pageID]; ok {
+			if err := bpm.Pin(pageID); err != nil {
+				return fmt.Errorf("Pin Failed: %w", err)
+			}
+
+			if err := sendPage(ctx, ctx, pageChan, bpm.Pages[frameID]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tableObj.DirectoryPage.Mu.RLock()
+		pageObj, ok := tableObj.DirectoryPage.Value[pageID]
+		tableObj.DirectoryPage.Mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		pageObj.Mu.RLock()
+		offset := pageObj.Offset
+		pageObj.Mu.RUnlock()
+
+		pageBytes, err := ReadPageAtOffset(tableObj.DataFile, offset)
+		if err != nil {
+			return fmt.Errorf("ReadPageAtOffset failed: %w", err)
+		}
+
+		page, err := DecodePageV2(pageBytes)
+		if err != nil {
+			return fmt.Errorf("DecodePageV2 failed: %w", err)
+		}
+
+		if err := sendPage(ctx, ctx, pageChan, page); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // ## when rearranging a new page is being created, so this is necessary
 func (bpm *BufferPoolManager) ReplacePage(page *PageV2) error {
 	if frameID, ok := bpm.PageTable[PageID(page.Header.ID)]; ok {
@@ -164,6 +213,31 @@ func (bpm *BufferPoolManager) DeletePage(pageID PageID) error {
 	return errors.New("page not found")
 }
 
+// DiscardTablePages drops every cached page of the table without writing it
+// back, used when the table's data is thrown away.
+func (bpm *BufferPoolManager) DiscardTablePages(tableName string) {
+	for frameID, page := range bpm.Pages {
+		if page == nil || page.TABLE != tableName {
+			continue
+		}
+
+		delete(bpm.PageTable, PageID(page.Header.ID))
+		bpm.Pages[frameID] = nil
+		*bpm.freeList = append(*bpm.freeList, FrameID(frameID))
+		bpm.Replacer.Remove(FrameID(frameID))
+	}
+}
+
+// RenameTablePages points the cached pages of a renamed table to its new
+// name, eviction finds the table files through it.
+func (bpm *BufferPoolManager) RenameTablePages(tableName, newName string) {
+	for _, page := range bpm.Pages {
+		if page != nil && page.TABLE == tableName {
+			page.TABLE = newName
+		}
+	}
+}
+
 func (bpm *BufferPoolManager) FetchPage(pageID PageID, tableObj *TableObj) (*PageV2, error) {
 	var pagePtr *PageV2
 	if frameID, ok := bpm.PageTable[pageID]; ok {
@@ -239,5 +313,8 @@ func NewBufferPoolManager(k int, fileName string) (*BufferPoolManager, error) {
 		return nil, fmt.Errorf("NewWalManager failed initialization: %w", err)
 	}
 
-	return &BufferPoolManager{pages, &freeList, pageTable, replacer, diskManager, wal, sync.RWMutex{}}, nil
+	indexes := NewIndexPool(k)
+	diskManager.indexPool = indexes
+
+	return &BufferPoolManager{pages, &freeList, pageTable, replacer, diskManager, wal, indexes, sync.RWMutex{}}, nil
 }
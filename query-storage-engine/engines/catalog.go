@@ -1,17 +1,63 @@
 package engines
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type Catalog struct {
-	Tables map[string]*TableInfo
+	Tables    map[string]*TableInfo
+	Sequences map[string]*Sequence
+	Views     map[string]*View
+	// materialized views by name, each also has a table of the same name
+	Materialized map[string]*MaterializedView
+	seqMu        sync.RWMutex // guards the Sequences map
+}
+
+func newCatalog() *Catalog {
+	return &Catalog{
+		Tables:       make(map[string]*TableInfo),
+		Sequences:    make(map[string]*Sequence),
+		Views:        make(map[string]*View),
+		Materialized: make(map[string]*MaterializedView),
+	}
 }
 
 type Column string
 type TableInfo struct {
-	Schema     map[string]ColumnType
-	NumOfPages uint64
-	UsedSpace  uint64 // bytes // entire table
+	Schema      map[string]ColumnType
+	NumOfPages  uint64
+	UsedSpace   uint64       // bytes // entire table
+	Dir         string       // directory under Tables/, "" => the table name
+	Dropped     []string     // stored names of dropped columns, old rows may still hold them
+	Constraints []Constraint // UNIQUE, CHECK and FOREIGN KEY
+	Indexes     []IndexInfo  // secondary indexes, see indexes.go
+	toast       *ToastStore  // set when the table files are opened, not persisted
+	layout      *rowLayout   // rebuilt when the schema changes, see refreshLayout
 }
 
 type ColumnType struct {
 	IsIndex bool
 	Type    string
+	Stored  string // name inside the stored rows when it differs (RENAME COLUMN)
+	Missing string // value of the rows written before ADD COLUMN ... DEFAULT
+	Default string
+	NotNull bool
+	Bloom   bool // pages keep a bloom filter of its values, see blooms.go
+
+	DefaultExpr string // planner expression (json), e.g NOW()
+	defaultExpr any
+}
+
+// Sequence hands out values in steps of Increment. Only the reservation
+// limit is persisted, values are cached in memory in blocks of
+// SEQUENCE_CACHE so a restart resumes after the last reserved block.
+type Sequence struct {
+	Increment int64
+	Table     string // owner of SERIAL / AUTO_INCREMENT sequences
+	Column    string
+	limit     atomic.Int64
+	next      int64
+	cached    int64 // values left before the next reservation
+	mu        sync.Mutex
 }
@@ -0,0 +1,520 @@
+package engines
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Column constraints
+//
+// NOT NULL and DEFAULT live in the ColumnType, UNIQUE and CHECK in
+// TableInfo.Constraints since they can span columns. They're checked on every
+// INSERT and UPDATE before the row is placed (UNIQUE after an update, once
+// every new version is in the table), a violation fails the statement and the
+// transaction is rolled back. FOREIGN KEY is kept with them, see references.go.
+
+const (
+	CONSTRAINT_UNIQUE = "UNIQUE"
+	CONSTRAINT_CHECK  = "CHECK"
+)
+
+var (
+	ErrNotNullViolation = errors.New("not null violation")
+	ErrCheckViolation   = errors.New("check violation")
+
+	// values not fitting the declared type of their column, see CoerceValue
+	ErrStringTooLong     = errors.New("value too long")
+	ErrNumericOutOfRange = errors.New("numeric value out of range")
+	ErrInvalidText       = errors.New("invalid input syntax")
+)
+
+// SQLSTATE codes of the violations, sent along with the message so clients
+// can tell them apart.
+var sqlStates = []struct {
+	err  error
+	code string
+}{
+	{ErrStringTooLong, "22001"},
+	{ErrNumericOutOfRange, "22003"},
+	{ErrInvalidText, "22P02"},
+	{ErrNotNullViolation, "23502"},
+	{ErrForeignKeyViolation, "23503"},
+	{ErrDuplicateKey, "23505"},
+	{ErrCheckViolation, "23514"},
+}
+
+type Constraint struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Columns []string `json:"columns"`
+	Check   string   `json:"check,omitempty"` // planner expression (json)
+
+	// FOREIGN KEY, see references.go
+	RefTable   string   `json:"refTable,omitempty"`
+	RefColumns []string `json:"refColumns,omitempty"`
+	OnDelete   string   `json:"onDelete,omitempty"`
+	OnUpdate   string   `json:"onUpdate,omitempty"`
+
+	check any
+}
+
+// SQLState returns the SQLSTATE code of a constraint violation, "" for any
+// other error.
+func SQLState(err error) string {
+	for _, state := range sqlStates {
+		if errors.Is(err, state.err) {
+			return state.code
+		}
+	}
+	return ""
+}
+
+// FormatQueryError is the message a failed query sends to the client:
+// "ERROR <sqlstate>: <message>" or "ERROR: <message>".
+func FormatQueryError(err error) string {
+	if code := SQLState(err); code != "" {
+		return fmt.Sprintf("ERROR %s: %s", code, err)
+	}
+	return fmt.Sprintf("ERROR: %s", err)
+}
+
+// compileConstraints parses the CHECK and DEFAULT expressions once, called
+// whenever the constraints of the table change.
+func (ti *TableInfo) compileConstraints() error {
+	for i := range ti.Constraints {
+		constraint := &ti.Constraints[i]
+		if constraint.Kind != CONSTRAINT_CHECK {
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(constraint.Check), &constraint.check); err != nil {
+			return fmt.Errorf("constraint: %s has an invalid expression: %w", constraint.Name, err)
+		}
+	}
+
+	for column, columnType := range ti.Schema {
+		if columnType.DefaultExpr == "" {
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(columnType.DefaultExpr), &columnType.defaultExpr); err != nil {
+			return fmt.Errorf("default of column: %s is invalid: %w", column, err)
+		}
+		ti.Schema[column] = columnType
+	}
+
+	return nil
+}
+
+// fillDefaults gives the columns left out of an insert their default, an
+// explicit NULL (nullMarker) is kept as it is.
+func fillDefaults(values map[string]string, tableStats *TableInfo) error {
+	var ectx *ExprContext
+	for column, columnType := range tableStats.Schema {
+		if _, ok := values[column]; ok {
+			continue
+		}
+
+		switch {
+		case columnType.defaultExpr != nil:
+			if ectx == nil {
+				ectx = NewExprContext(nil, tableStats.Schema)
+			}
+
+			d, err := EvalExpr(columnType.defaultExpr, nil, ectx)
+			if err != nil {
+				return fmt.Errorf("evaluating default of %s failed: %w", column, err)
+			}
+
+			if !d.IsNull() {
+				values[column] = d.String()
+			}
+		case columnType.Default != "":
+			values[column] = columnType.Default
+		}
+	}
+
+	return nil
+}
+
+// dropNulls removes the explicit NULLs once the defaults are applied, an
+// absent column is a NULL from there on.
+func dropNulls(values map[string]string) {
+	maps.DeleteFunc(values, func(_, val string) bool { return val == nullMarker })
+}
+
+// coerceRow brings the values of a new row version to their declared types
+// (see CoerceValue). On UPDATE only the columns that changed are checked,
+// rows written before the types were enforced stay as they are. Integer
+// primary keys also take the generated row ids, unsigned 64 bits.
+func coerceRow(values, previous map[string]string, tableName string, tableStats *TableInfo) error {
+	for column, val := range values {
+		columnType, ok := tableStats.Schema[column]
+		if !ok || (previous != nil && previous[column] == val) {
+			continue
+		}
+
+		coerced, err := coerceColumn(val, columnType)
+		if err != nil {
+			return fmt.Errorf("column: %s on table: %s: %w", column, tableName, err)
+		}
+		values[column] = coerced
+	}
+
+	return nil
+}
+
+// coerceColumn is CoerceValue for a value of the column, integer primary
+// keys also take the generated row ids.
+func coerceColumn(val string, columnType ColumnType) (string, error) {
+	coerced, err := CoerceValue(val, columnType.Type)
+	if errors.Is(err, ErrNumericOutOfRange) && columnType.IsIndex && isIntegerText(val) {
+		if _, uintErr := strconv.ParseUint(val, 10, 64); uintErr == nil {
+			return val, nil
+		}
+	}
+	return coerced, err
+}
+
+// checkRow enforces NOT NULL and CHECK on the textual values of a new row
+// version. A CHECK that evaluates to NULL passes, like in the standard.
+func checkRow(values map[string]string, tableName string, tableStats *TableInfo) error {
+	for column, columnType := range tableStats.Schema {
+		if columnType.NotNull && values[column] == "" {
+			return fmt.Errorf("%w: column: %s on table: %s", ErrNotNullViolation, column, tableName)
+		}
+	}
+
+	var ectx *ExprContext
+	for _, constraint := range tableStats.Constraints {
+		if constraint.check == nil {
+			continue
+		}
+
+		if ectx == nil {
+			ectx = NewExprContext(nil, tableStats.Schema)
+		}
+
+		d, err := EvalExpr(constraint.check, &RowV2{Values: values}, ectx)
+		if err != nil {
+			return fmt.Errorf("evaluating constraint: %s failed: %w", constraint.Name, err)
+		}
+
+		if !d.IsNull() && !datumTruth(d) {
+			return fmt.Errorf("%w: constraint: %s on table: %s", ErrCheckViolation, constraint.Name, tableName)
+		}
+	}
+
+	return nil
+}
+
+// columnsKey is the comparable key of the row over the columns, rows with a
+// NULL in any of them never conflict nor reference anything.
+func columnsKey(values map[string]string, columns []string, schema map[string]ColumnType) (string, bool) {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		val := values[column]
+		if val == "" || val == nullMarker {
+			return "", false
+		}
+
+		// '01' and 1 are the same INT
+		if stored, err := EncodeStoredValue(val, schema[column].Type); err == nil {
+			if text, err := DecodeStoredValue(stored, schema[column].Type); err == nil {
+				val = text
+			}
+		}
+		parts[i] = val
+	}
+
+	return strings.Join(parts, ATTR_SEPARATOR), true
+}
+
+// checkUnique rejects rows repeating a UNIQUE key among themselves or with the
+// table. inTable tells whether the rows are already placed (after an update),
+// then each key must be found exactly once. The keys are looked up in an
+// index on their columns when there is one, the table is scanned otherwise.
+func (qe *QueryEngine) checkUnique(rows []map[string]string, inTable bool, tableObj *TableObj, tableStats *TableInfo) error {
+	type uniqueKeys struct {
+		constraint *Constraint
+		seen       map[string]int
+	}
+
+	allowed := 1
+	if inTable {
+		allowed = 2
+	}
+
+	var checks []uniqueKeys
+	for _, constraint := range tableStats.uniqueKeys() {
+		seen := make(map[string]int)
+		for _, values := range rows {
+			key, ok := columnsKey(values, constraint.Columns, tableStats.Schema)
+			if !ok {
+				continue
+			}
+
+			if seen[key] > 0 {
+				return uniqueViolation(constraint, key)
+			}
+			seen[key] = 1
+		}
+
+		idx := tableObj.indexOn(constraint.Columns)
+		if idx == nil {
+			if len(seen) > 0 {
+				checks = append(checks, uniqueKeys{constraint, seen})
+			}
+			continue
+		}
+
+		for key := range seen {
+			values := strings.Split(key, ATTR_SEPARATOR)
+			lookup := make([]string, len(idx.Columns))
+			for i, column := range idx.Columns {
+				lookup[i] = values[slices.Index(constraint.Columns, column)]
+			}
+
+			count, err := idx.countKey(lookup, allowed)
+			if err != nil {
+				return fmt.Errorf("index: %s Scan failed: %w", idx.Name, err)
+			}
+
+			if count+1 > allowed {
+				return uniqueViolation(constraint, key)
+			}
+		}
+	}
+
+	if len(checks) == 0 {
+		return nil
+	}
+
+	return qe.scanRows(tableObj, tableStats, func(row *RowV2) error {
+		for _, check := range checks {
+			key, ok := columnsKey(row.Values, check.constraint.Columns, tableStats.Schema)
+			if !ok {
+				continue
+			}
+
+			if count, found := check.seen[key]; found {
+				if count+1 > allowed {
+					return uniqueViolation(check.constraint, key)
+				}
+				check.seen[key] = count + 1
+			}
+		}
+		return nil
+	})
+}
+
+func uniqueViolation(constraint *Constraint, key string) error {
+	return fmt.Errorf("%w: constraint: %s, (%s) = (%s)", ErrDuplicateKey, constraint.Name,
+		strings.Join(constraint.Columns, ", "), strings.ReplaceAll(key, ATTR_SEPARATOR, ", "))
+}
+
+// parseConstraints reads the "constraints" of a CREATE TABLE plan:
+// {"kind": "NOT_NULL", "column": c}, {"kind": "DEFAULT", "column": c, "value": v | "expr": {...}},
+// {"kind": "UNIQUE", "name": n, "columns": [...]}, {"kind": "CHECK", "name": n, "expr": {...}} and
+// {"kind": "FOREIGN_KEY", "name": n, "columns": [...], "refTable": t, "refColumns": [...], "onDelete": a, "onUpdate": a}.
+func parseConstraints(plan map[string]any, tableName string, tableInfo *TableInfo, catalog *Catalog) error {
+	list, _ := plan["constraints"].([]any)
+	for _, item := range list {
+		node, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid constraint: %v", item)
+		}
+
+		kind, _ := node["kind"].(string)
+		name, _ := node["name"].(string)
+		column := strings.ReplaceAll(fmt.Sprint(node["column"]), "`", "")
+
+		switch kind {
+		case "NOT_NULL", "DEFAULT":
+			columnType, ok := tableInfo.Schema[column]
+			if !ok {
+				return fmt.Errorf("column: %s on table: %s doesn't exist", column, tableName)
+			}
+
+			if kind == "NOT_NULL" {
+				columnType.NotNull = true
+			} else if value, ok := node["value"].(string); ok {
+				coerced, err := CoerceValue(value, columnType.Type)
+				if err != nil {
+					return fmt.Errorf("invalid default for column: %s: %w", column, err)
+				}
+				columnType.Default = coerced
+			} else if expr, ok := node["expr"]; ok {
+				encoded, err := json.Marshal(expr)
+				if err != nil {
+					return fmt.Errorf("encoding default of %s failed: %w", column, err)
+				}
+				columnType.DefaultExpr = string(encoded)
+			}
+
+			tableInfo.Schema[column] = columnType
+		case CONSTRAINT_UNIQUE:
+			columns, err := constraintColumns(node["columns"], tableName, tableInfo)
+			if err != nil {
+				return err
+			}
+
+			if name == "" {
+				name = fmt.Sprintf("%s_%s_key", tableName, strings.Join(columns, "_"))
+			}
+			tableInfo.Constraints = append(tableInfo.Constraints, Constraint{Name: name, Kind: kind, Columns: columns})
+		case CONSTRAINT_CHECK:
+			encoded, err := json.Marshal(node["expr"])
+			if err != nil {
+				return fmt.Errorf("encoding check failed: %w", err)
+			}
+
+			columns := exprColumns(node["expr"], nil)
+			for _, col := range columns {
+				if _, ok := tableInfo.Schema[col]; !ok {
+					return fmt.Errorf("column: %s on table: %s doesn't exist", col, tableName)
+				}
+			}
+
+			if name == "" {
+				name = tableName + "_check"
+				for n := 1; slices.ContainsFunc(tableInfo.Constraints, func(c Constraint) bool { return c.Name == name }); n++ {
+					name = fmt.Sprintf("%s_check%d", tableName, n)
+				}
+			}
+			tableInfo.Constraints = append(tableInfo.Constraints, Constraint{Name: name, Kind: kind, Columns: columns, Check: string(encoded)})
+		case CONSTRAINT_FOREIGN_KEY:
+			columns, err := constraintColumns(node["columns"], tableName, tableInfo)
+			if err != nil {
+				return err
+			}
+
+			fk := Constraint{Name: name, Kind: kind, Columns: columns}
+			fk.RefTable = strings.ReplaceAll(fmt.Sprint(node["refTable"]), "`", "")
+			for _, col := range asList(node["refColumns"]) {
+				fk.RefColumns = append(fk.RefColumns, strings.ReplaceAll(fmt.Sprint(col), "`", ""))
+			}
+			fk.OnDelete, _ = node["onDelete"].(string)
+			fk.OnUpdate, _ = node["onUpdate"].(string)
+
+			if fk.Name == "" {
+				fk.Name = fmt.Sprintf("%s_%s_fkey", tableName, strings.Join(columns, "_"))
+			}
+			tableInfo.Constraints = append(tableInfo.Constraints, fk)
+		default:
+			return fmt.Errorf("unsupported constraint: %s", kind)
+		}
+	}
+
+	// after the loop, a table can reference its own UNIQUE columns
+	for i := range tableInfo.Constraints {
+		if fk := &tableInfo.Constraints[i]; fk.Kind == CONSTRAINT_FOREIGN_KEY {
+			if err := resolveReference(fk, tableName, tableInfo, catalog); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tableInfo.compileConstraints()
+}
+
+// constraintColumns reads the column list of a constraint, every column must
+// be in the table.
+func constraintColumns(list any, tableName string, tableInfo *TableInfo) ([]string, error) {
+	var columns []string
+	for _, col := range asList(list) {
+		col := strings.ReplaceAll(fmt.Sprint(col), "`", "")
+		if _, ok := tableInfo.Schema[col]; !ok {
+			return nil, fmt.Errorf("column: %s on table: %s doesn't exist", col, tableName)
+		}
+		columns = append(columns, col)
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("constraint on table: %s has no columns", tableName)
+	}
+
+	return columns, nil
+}
+
+func asList(v any) []any {
+	list, _ := v.([]any)
+	return list
+}
+
+// exprColumns lists the columns an expression reads.
+func exprColumns(expr any, columns []string) []string {
+	switch node := expr.(type) {
+	case map[string]any:
+		if column, ok := node["column"].(string); ok {
+			column = strings.ReplaceAll(column, "`", "")
+			if !slices.Contains(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+
+		for _, child := range node {
+			columns = exprColumns(child, columns)
+		}
+	case []any:
+		for _, child := range node {
+			columns = exprColumns(child, columns)
+		}
+	}
+
+	return columns
+}
+
+// renameExprColumn points the column references of an expression to the new
+// name, used by RENAME COLUMN.
+func renameExprColumn(expr any, column, newName string) {
+	switch node := expr.(type) {
+	case map[string]any:
+		if name, ok := node["column"].(string); ok && strings.ReplaceAll(name, "`", "") == column {
+			node["column"] = newName
+		}
+
+		for _, child := range node {
+			renameExprColumn(child, column, newName)
+		}
+	case []any:
+		for _, child := range node {
+			renameExprColumn(child, column, newName)
+		}
+	}
+}
+
+// renameConstraintColumn follows RENAME COLUMN in the constraints of the table.
+func renameConstraintColumn(constraints []Constraint, column, newName string) ([]Constraint, error) {
+	renamed := slices.Clone(constraints)
+	for i := range renamed {
+		constraint := &renamed[i]
+		if !slices.Contains(constraint.Columns, column) {
+			continue
+		}
+
+		constraint.Columns = slices.Clone(constraint.Columns)
+		constraint.Columns[slices.Index(constraint.Columns, column)] = newName
+
+		if constraint.Kind == CONSTRAINT_CHECK {
+			var expr any
+			if err := json.Unmarshal([]byte(constraint.Check), &expr); err != nil {
+				return nil, fmt.Errorf("constraint: %s has an invalid expression: %w", constraint.Name, err)
+			}
+			renameExprColumn(expr, column, newName)
+
+			encoded, err := json.Marshal(expr)
+			if err != nil {
+				return nil, fmt.Errorf("encoding constraint: %s failed: %w", constraint.Name, err)
+			}
+			constraint.Check = string(encoded)
+		}
+	}
+
+	return renamed, nil
+}
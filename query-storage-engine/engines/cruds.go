@@ -4,8 +4,10 @@ import (
 	"a2gdb/logger"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 
@@ -18,6 +20,12 @@ type Result struct {
 	Error    error
 	Msg      string
 	Rows     []*RowV2
+	Cursor   *Cursor // streamed SELECT, the caller must close it
+	Database string  // chosen by USE, see Session
+
+	// background runs once the result is sent, the statement still holds
+	// its table but neither InlineMu nor its scheduler slot (see InlineCruds)
+	background func() error
 }
 
 func (qe *QueryEngine) handleUpdate(plan map[string]interface{}, transactionOff bool, induceErr bool) Result {
@@ -25,11 +33,6 @@ func (qe *QueryEngine) handleUpdate(plan map[string]interface{}, transactionOff
 
 	var result Result
 
-	filterColumn := plan["filter_column"].(string)
-
-	modifyColumn := plan["modify_column"].(string)
-	modifyValue := plan["modify_value"].(string)
-
 	tableName := plan["table"].(string)
 	manager := qe.BufferPoolManager.DiskManager
 	walManager := qe.BufferPoolManager.Wal
@@ -42,17 +45,110 @@ func (qe *QueryEngine) handleUpdate(plan map[string]interface{}, transactionOff
 		return result
 	}
 
-	isPrimary, err := isPrimary(filterColumn, tableName, manager.PageCatalog)
+	primary, err := getPrimary(tableName, manager.PageCatalog)
+	if err != nil {
+		result.Error = fmt.Errorf("couldn't get primary: %w", err)
+		result.Msg = "failed"
+		return result
+	}
+
+	assignments, err := parseAssignments(plan, primary, tableStats)
 	if err != nil {
-		result.Error = fmt.Errorf("isPrimary failed: %w", err)
+		result.Error = fmt.Errorf("parseAssignments failed: %w", err)
 		result.Msg = "failed"
 		return result
 	}
 
-	var filterValue string = strings.ReplaceAll(plan["filter_value"].(string), "'", "")
-	if isPrimary {
-		re := regexp.MustCompile(`\d+`)
-		filterValue = re.FindString(filterValue)
+	returning, err := parseReturning(plan, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("parseReturning failed: %w", err), "failed")
+	}
+
+	condition := plan["condition"] // nil updates every row
+	ectx := NewExprContext(nil, tableStats.Schema)
+
+	var updatedRows [][]byte
+	updater := RowUpdater{
+		Match: func(row *RowV2) (bool, error) {
+			return EvalPredicate(condition, row, ectx)
+		},
+		Apply: func(row *RowV2) error {
+			return applyAssignments(row, assignments, ectx)
+		},
+		Condition: condition,
+	}
+
+	if returning != nil {
+		updater.Updated = func(rowBytes []byte) {
+			updatedRows = append(updatedRows, rowBytes)
+		}
+	}
+
+	var txId string
+	if !transactionOff {
+		txId = walManager.BeginTransaction()
+	}
+
+	err = qe.updateRows(tableObj, tableStats, updater, txId, transactionOff)
+	if err != nil || induceErr {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("error occurred during update: %w", err), "failed")
+	}
+
+	if !transactionOff {
+		if err := walManager.CommitTransaction(txId, tableName); err != nil {
+			result.Error = fmt.Errorf("CommitTransaction failed: %w", err)
+			result.Msg = "failed"
+			return result
+		}
+	}
+
+	if returning != nil {
+		return returningResult(updatedRows, tableStats, returning, "success")
+	}
+
+	result.Msg = "success"
+	return result
+}
+
+// updateRows runs the update pipeline: scan -> rewrite the rows picked by
+// the updater -> reorganize the pages -> reinsert the new versions.
+func (qe *QueryEngine) updateRows(tableObj *TableObj, tableStats *TableInfo, updater RowUpdater, txId string, transactionOff bool) error {
+	walManager := qe.BufferPoolManager.Wal
+
+	if err := qe.referenceActions(tableObj, tableStats, updater.Match, updater.Apply, txId, transactionOff); err != nil {
+		return err
+	}
+
+	// every new version must fit the column types and pass NOT NULL and CHECK,
+	// UNIQUE and FOREIGN KEY are checked once they're all in the table
+	apply := updater.Apply
+	updater.Apply = func(row *RowV2) error {
+		previous := maps.Clone(row.Values)
+		if err := apply(row); err != nil {
+			return err
+		}
+
+		if err := coerceRow(row.Values, previous, tableObj.TableName, tableStats); err != nil {
+			return err
+		}
+		return checkRow(row.Values, tableObj.TableName, tableStats)
+	}
+
+	var newVersions []map[string]string
+	if len(tableStats.uniqueKeys()) > 0 || slices.ContainsFunc(tableStats.Constraints, func(c Constraint) bool {
+		return c.Kind == CONSTRAINT_FOREIGN_KEY && !updater.skipReferences
+	}) {
+		updated := updater.Updated
+		updater.Updated = func(rowBytes []byte) {
+			var row RowV2
+			if DecodeRow(&row, bytes.NewReader(rowBytes)) == nil && DecodeStoredValues(&row, tableStats) == nil {
+				newVersions = append(newVersions, row.Values)
+			}
+
+			if updated != nil {
+				updated(rowBytes)
+			}
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -63,11 +159,7 @@ func (qe *QueryEngine) handleUpdate(plan map[string]interface{}, transactionOff
 	pageChan := make(chan *PageV2, 100)
 	updateInfoChan := make(chan *ModifiedInfo, 100)
 	insertChan := make(chan *NonAddedRows, 100)
-
-	var txId string
-	if !transactionOff {
-		txId = walManager.BeginTransaction()
-	}
+	pages := newUpdatePages(tableObj)
 
 	accountingCtx, wasCached := qe.CtxManager.GetOrCreateContext(AccountingLevel, MemoryContextConfig{Name: "Accouting", ContextType: AccountingLevel, AllocationStrat: DefaultAllocation})
 	if !wasCached {
@@ -78,16 +170,16 @@ func (qe *QueryEngine) handleUpdate(plan map[string]interface{}, transactionOff
 
 	tasks := []func() error{
 		func() error {
-			return qe.BufferPoolManager.FullTableScan(ctx, pageChan, tableObj, tableStats.NumOfPages)
+			return qe.scanMatching(ctx, pageChan, tableObj, tableStats, updater.Condition)
 		},
 		func() error {
-			return processPagesForUpdate(ctx, accountingCtx, qe, qe.Lm, pageChan, updateInfoChan, modifyColumn, modifyValue, filterColumn, filterValue, txId, tableObj, walManager, transactionOff)
+			return processPagesForUpdate(ctx, accountingCtx, qe, qe.Lm, pageChan, updateInfoChan, updater, txId, tableObj, tableStats, walManager, transactionOff, pages)
 		},
 		func() error {
-			return cleanOrgnize(ctx, accountingCtx, updateInfoChan, insertChan, tableObj, tableStats)
+			return cleanOrgnize(ctx, accountingCtx, updateInfoChan, insertChan, pages, tableObj, tableStats)
 		},
 		func() error {
-			return handleLikeInsert(ctx, accountingCtx, insertChan, tableObj, tableName, qe.BufferPoolManager, tableStats)
+			return handleLikeInsert(ctx, accountingCtx, insertChan, tableObj, tableObj.TableName, qe.BufferPoolManager, tableStats, pages)
 		},
 	}
 
@@ -107,28 +199,18 @@ func (qe *QueryEngine) handleUpdate(plan map[string]interface{}, transactionOff
 		close(errChan)
 	}()
 
-	firstError := <-errChan
-	if firstError != nil || induceErr {
-		primary, primaryErr := getPrimary(tableName, manager.PageCatalog)
-		if primaryErr != nil {
-			result.Error = fmt.Errorf("couldn't get primary: %w", primaryErr)
-			result.Msg = "failed"
-			return result
-		}
-
-		return rollbackAndReturn(txId, primary, modifyColumn, tableName, walManager, qe, nil, fmt.Errorf("error occurred during update: %w", firstError), "failed")
+	if err := <-errChan; err != nil {
+		return err
 	}
 
-	if !transactionOff {
-		if err := walManager.CommitTransaction(txId, tableName); err != nil {
-			result.Error = fmt.Errorf("CommitTransaction failed: %w", err)
-			result.Msg = "failed"
-			return result
-		}
+	if err := qe.checkUnique(newVersions, true, tableObj, tableStats); err != nil {
+		return err
 	}
 
-	result.Msg = "success"
-	return result
+	if updater.skipReferences {
+		return nil
+	}
+	return qe.checkReferences(newVersions, tableObj.TableName, tableStats)
 }
 
 func (qe *QueryEngine) handleDelete(plan map[string]interface{}, transactionOff, induceErr bool) Result {
@@ -141,8 +223,6 @@ func (qe *QueryEngine) handleDelete(plan map[string]interface{}, transactionOff,
 	tableName := plan["table"].(string)
 	tableStats := manager.PageCatalog.Tables[tableName]
 
-	deleteKey := plan["column"].(string)
-
 	tableObj, err := GetTableObj(tableName, manager)
 	if err != nil {
 		result.Error = fmt.Errorf("GetTableObj failed: %w", err)
@@ -150,17 +230,69 @@ func (qe *QueryEngine) handleDelete(plan map[string]interface{}, transactionOff,
 		return result
 	}
 
-	isPrimary, err := isPrimary(deleteKey, tableName, manager.PageCatalog)
+	primary, err := getPrimary(tableName, manager.PageCatalog)
 	if err != nil {
-		result.Error = fmt.Errorf("isPrimary failed: %w", err)
+		result.Error = fmt.Errorf("couldn't get primary: %w", err)
 		result.Msg = "failed"
 		return result
 	}
 
-	var deleteVal string = strings.ReplaceAll(plan["value"].(string), "'", "")
-	if isPrimary {
-		re := regexp.MustCompile(`\d+`)
-		deleteVal = re.FindString(deleteVal)
+	returning, err := parseReturning(plan, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("parseReturning failed: %w", err), "failed")
+	}
+
+	condition := plan["condition"] // nil deletes every row
+	ectx := NewExprContext(nil, tableStats.Schema)
+	singleRow := primaryEquality(condition, primary)
+
+	var deletedRows [][]byte
+	var deleted func(rowBytes []byte)
+	if returning != nil {
+		deleted = func(rowBytes []byte) {
+			deletedRows = append(deletedRows, rowBytes)
+		}
+	}
+
+	match := func(row *RowV2) (bool, error) {
+		return EvalPredicate(condition, row, ectx)
+	}
+
+	var txId string
+	if !transactionOff {
+		txId = walManager.BeginTransaction()
+	}
+
+	err = qe.deleteRows(tableObj, tableStats, condition, match, singleRow, deleted, txId, transactionOff)
+	if err != nil || induceErr {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, catalog, fmt.Errorf("error occurred during delete: %w", err), "failed")
+	}
+
+	if !transactionOff {
+		if err := walManager.CommitTransaction(txId, tableName); err != nil {
+			result.Error = fmt.Errorf("CommitTransaction failed: %w", err)
+			result.Msg = "failed"
+			return result
+		}
+	}
+
+	if returning != nil {
+		return returningResult(deletedRows, tableStats, returning, "success")
+	}
+
+	result.Msg = "success"
+	return result
+}
+
+// deleteRows runs the delete pipeline: scan -> free the rows picked by match
+// -> reorganize the pages. The foreign keys pointing at the table act first.
+// condition (may be nil) is the one behind match, an index can narrow the
+// scan with it.
+func (qe *QueryEngine) deleteRows(tableObj *TableObj, tableStats *TableInfo, condition any, match func(row *RowV2) (bool, error), singleRow bool, deleted func(rowBytes []byte), txId string, transactionOff bool) error {
+	walManager := qe.BufferPoolManager.Wal
+
+	if err := qe.referenceActions(tableObj, tableStats, match, nil, txId, transactionOff); err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -172,20 +304,22 @@ func (qe *QueryEngine) handleDelete(plan map[string]interface{}, transactionOff,
 	pageChan := make(chan *PageV2, 100)
 	updateInfoChan := make(chan *ModifiedInfo, 100)
 
-	var txId string
-	if !transactionOff {
-		txId = walManager.BeginTransaction()
+	accountingCtx, wasCached := qe.CtxManager.GetOrCreateContext(AccountingLevel, MemoryContextConfig{Name: "Accouting", ContextType: AccountingLevel, AllocationStrat: DefaultAllocation})
+	if !wasCached {
+		CreateAccountingPools(accountingCtx)
 	}
 
+	defer qe.CtxManager.ReturnContext(accountingCtx)
+
 	tasks := []func() error{
 		func() error {
-			return qe.BufferPoolManager.FullTableScan(ctx, pageChan, tableObj, tableStats.NumOfPages)
+			return qe.scanMatching(ctx, pageChan, tableObj, tableStats, condition)
 		},
 		func() error {
-			return processPagesForDeletion(ctx, qe.Lm, pageChan, updateInfoChan, deleteKey, deleteVal, txId, isPrimary, tableObj, walManager, transactionOff)
+			return processPagesForDeletion(ctx, qe.Lm, pageChan, updateInfoChan, match, txId, singleRow, deleted, tableObj, tableStats, walManager, transactionOff)
 		},
 		func() error {
-			return cleanOrgnize(ctx, nil, updateInfoChan, nil, tableObj, tableStats)
+			return cleanOrgnize(ctx, accountingCtx, updateInfoChan, nil, nil, tableObj, tableStats)
 		},
 	}
 
@@ -205,28 +339,78 @@ func (qe *QueryEngine) handleDelete(plan map[string]interface{}, transactionOff,
 		close(errChan)
 	}()
 
-	firstError := <-errChan
-	if firstError != nil || induceErr {
-		primary, primaryErr := getPrimary(tableName, manager.PageCatalog)
-		if primaryErr != nil {
-			result.Error = fmt.Errorf("couldn't get primary: %w", primaryErr)
-			result.Msg = "failed"
-			return result
+	return <-errChan
+}
+
+// handleTruncate swaps the files of the table for empty ones in a single
+// catalog replacement (see replaceTable), a crash leaves the table either
+// whole or empty. The truncate is logged for the materialized views.
+func (qe *QueryEngine) handleTruncate(plan map[string]interface{}, transactionOff bool) Result {
+	tableName := plan["table"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+	walManager := qe.BufferPoolManager.Wal
+
+	if _, ok := manager.PageCatalog.Tables[tableName]; !ok {
+		return handleError(fmt.Errorf("table: %s doesn't exist", tableName), "failed")
+	}
+
+	if err := manager.PageCatalog.checkNotReferenced(tableName); err != nil {
+		return handleError(fmt.Errorf("can't truncate: %w", err), "failed")
+	}
+
+	var txId string
+	if !transactionOff {
+		txId = walManager.BeginTransaction()
+
+		if err := walManager.Log(txId, LogTypeTruncate, tableName, 0, nil, nil); err != nil {
+			return rollbackAndReturn(txId, "", tableName, walManager, qe, nil, fmt.Errorf("wal.log failed: %w", err), "failed")
 		}
+	}
 
-		return rollbackAndReturn(txId, primary, "", tableName, walManager, qe, catalog, fmt.Errorf("error occurred during delete: %w", firstError), "failed")
+	if err := qe.replaceTable(tableName, nil); err != nil {
+		return rollbackAndReturn(txId, "", tableName, walManager, qe, nil, fmt.Errorf("replaceTable failed: %w", err), "failed")
 	}
 
 	if !transactionOff {
 		if err := walManager.CommitTransaction(txId, tableName); err != nil {
-			result.Error = fmt.Errorf("CommitTransaction failed: %w", err)
-			result.Msg = "failed"
-			return result
+			return handleError(fmt.Errorf("CommitTransaction failed: %w", err), "failed")
 		}
 	}
 
-	result.Msg = "success"
-	return result
+	return Result{Msg: "success"}
+}
+
+// handleDropTable runs DROP TABLE [IF EXISTS], the catalog replacement in
+// DropTable is what makes it crash safe.
+func (qe *QueryEngine) handleDropTable(plan map[string]interface{}) Result {
+	tableName := plan["table"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+
+	if _, ok := manager.PageCatalog.Tables[tableName]; !ok {
+		if ifExists, _ := plan["ifExists"].(bool); ifExists {
+			return Result{Msg: "Table Doesn't Exist"}
+		}
+		return handleError(fmt.Errorf("table: %s doesn't exist", tableName), "failed")
+	}
+
+	if _, ok := manager.PageCatalog.Materialized[tableName]; ok {
+		return handleError(fmt.Errorf("%s is a materialized view, use DROP MATERIALIZED VIEW", tableName), "failed")
+	}
+
+	if err := manager.PageCatalog.checkNotReferenced(tableName); err != nil {
+		return handleError(fmt.Errorf("can't drop: %w", err), "failed")
+	}
+
+	if err := manager.PageCatalog.checkNoViews(tableName); err != nil {
+		return handleError(fmt.Errorf("can't drop: %w", err), "failed")
+	}
+
+	qe.BufferPoolManager.DiscardTablePages(tableName)
+	if err := manager.DropTable(tableName); err != nil {
+		return handleError(fmt.Errorf("DropTable failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Table Dropped"}
 }
 
 func (qe *QueryEngine) handleCreate(plan map[string]interface{}) Result {
@@ -246,10 +430,25 @@ func (qe *QueryEngine) handleCreate(plan map[string]interface{}) Result {
 			cleanColName := strings.ReplaceAll(colName, "`", "")
 			colTypeStr := colType.(string)
 
-			tableInfo.Schema[cleanColName] = ColumnType{Type: colTypeStr, IsIndex: colTypeStr == "PRIMARY"}
+			// PRIMARY KEY(col) on a declared column keeps its type (e.g SERIAL)
+			column := tableInfo.Schema[cleanColName]
+			switch {
+			case colTypeStr != "PRIMARY":
+				column.Type = colTypeStr
+			case column.Type == "":
+				column.IsIndex, column.Type = true, colTypeStr
+			default:
+				column.IsIndex = true
+			}
+
+			tableInfo.Schema[cleanColName] = column
 		}
 	}
 
+	if err := parseConstraints(plan, tableName, &tableInfo, qe.BufferPoolManager.DiskManager.PageCatalog); err != nil {
+		return handleError(fmt.Errorf("parseConstraints failed: %w", err), "failed")
+	}
+
 	err := qe.BufferPoolManager.DiskManager.CreateTable(tableName, tableInfo)
 	if err != nil {
 		result.Error = fmt.Errorf("CreateTable failed: %w", err)
@@ -258,6 +457,17 @@ func (qe *QueryEngine) handleCreate(plan map[string]interface{}) Result {
 		return result
 	}
 
+	for column, colType := range tableInfo.Schema {
+		if !isSerialType(colType.Type) {
+			continue
+		}
+
+		err := qe.BufferPoolManager.DiskManager.CreateSequence(ownedSequence(tableName, column), 1, 1, tableName, column)
+		if err != nil {
+			return handleError(fmt.Errorf("CreateSequence failed: %w", err), "failed")
+		}
+	}
+
 	result.Msg = "Table Created"
 
 	return result
@@ -270,6 +480,14 @@ func (qe *QueryEngine) handleInsert(plan map[string]any, transactionOff, induceE
 	walManager := qe.BufferPoolManager.Wal
 	catalog := manager.PageCatalog
 
+	if conflict, ok := plan["onConflict"].(map[string]any); ok {
+		return qe.handleUpsert(plan, conflict, transactionOff, induceErr)
+	}
+
+	if source, ok := plan["source"].(map[string]any); ok {
+		return qe.handleInsertSelect(plan, source, transactionOff, induceErr)
+	}
+
 	selectedCols := plan["selectedCols"].([]any)
 	tableName := plan["table"].(string)
 	tableStats := catalog.Tables[tableName]
@@ -279,45 +497,71 @@ func (qe *QueryEngine) handleInsert(plan map[string]any, transactionOff, induceE
 		return handleError(err, "failed")
 	}
 
+	returning, err := parseReturning(plan, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("parseReturning failed: %w", err), "failed")
+	}
+
 	tableobj, err := GetTableObj(tableName, manager)
 	if err != nil {
 		return handleError(fmt.Errorf("GetTable failed for: %s, error: %s", tableName, err), "failed")
 	}
 
+	rows, err := manager.insertValues(plan, selectedCols)
+	if err != nil {
+		return handleError(fmt.Errorf("insertValues failed: %w", err), "failed")
+	}
+
+	explicitKeys := hasExplicitKeys(rows, primary)
+	for _, values := range rows {
+		if err := manager.fillSerials(values, tableName, tableStats); err != nil {
+			return handleError(fmt.Errorf("fillSerials failed: %w", err), "failed")
+		}
+	}
+
+	if err := qe.checkKeys(rows, explicitKeys, primary, tableobj, tableStats); err != nil {
+		return handleError(err, "failed")
+	}
+
 	var txId string
 	if !transactionOff {
 		txId = walManager.BeginTransaction()
 	}
 
-	bytesNeeded, encodedRows, err := prepareRows(plan, selectedCols, primary, tableName, txId, walManager, transactionOff)
+	encodedRows, err := prepareRows(rows, primary, tableName, tableStats, txId, walManager, transactionOff)
 	if err != nil {
-		return rollbackAndReturn(txId, primary, "", tableName, walManager, qe, nil, fmt.Errorf("preparing rows failed: %w", err), "failed")
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("preparing rows failed: %w", err), "failed")
 	}
 
 	logger.Log.WithFields(logrus.Fields{
 		"table":        tableName,
 		"selectedCols": selectedCols,
 		"primary":      primary,
-		"bytesNeeded":  bytesNeeded,
+		"rows":         len(encodedRows),
 	}).Info("findAndUpdate Inputs Set")
 
-	err = findAndUpdate(qe.BufferPoolManager, tableobj, tableStats, bytesNeeded, tableName, encodedRows)
+	// every row is logged already, a failure half way undoes the placed ones
+	err = findAndUpdate(qe.BufferPoolManager, tableobj, tableStats, tableName, encodedRows, nil)
 	if err != nil {
-		return rollbackAndReturn(txId, primary, "", tableName, walManager, qe, nil, fmt.Errorf("findAndUpdate Failed: %s", err), "failed")
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("findAndUpdate Failed: %s", err), "failed")
 	}
 
 	if induceErr {
-		return rollbackAndReturn(txId, primary, "", tableName, walManager, qe, nil, fmt.Errorf("findAndUpdate Failed: %s", err), "failed")
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("findAndUpdate Failed: %s", err), "failed")
 	}
 
 	if !transactionOff {
 		err = walManager.CommitTransaction(txId, tableName)
 		if err != nil {
-			return rollbackAndReturn(txId, primary, "", tableName, walManager, qe, nil, fmt.Errorf("CommitTransaction Failed: %s", err), "failed")
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("CommitTransaction Failed: %s", err), "failed")
 		}
 	}
 
-	res, err := ReturnPrimaryIds(encodedRows)
+	if returning != nil {
+		return returningResult(encodedRows, tableStats, returning, "successful query")
+	}
+
+	res, err := ReturnPrimaryIds(encodedRows, tableStats)
 	if err != nil {
 		return handleError(fmt.Errorf("ReturnPrimaryIds failed: %w", err), "failed query")
 	}
@@ -325,7 +569,389 @@ func (qe *QueryEngine) handleInsert(plan map[string]any, transactionOff, induceE
 	return *res
 }
 
-func ReturnPrimaryIds(encodedRows [][]byte) (*Result, error) {
+// handleInsertSelect feeds the rows of the source SELECT into the table
+// through the same page filling path as a plain insert, the whole
+// statement is a single transaction.
+func (qe *QueryEngine) handleInsertSelect(plan, source map[string]any, transactionOff, induceErr bool) Result {
+	manager := qe.BufferPoolManager.DiskManager
+	walManager := qe.BufferPoolManager.Wal
+	catalog := manager.PageCatalog
+
+	tableName := plan["table"].(string)
+	tableStats, ok := catalog.Tables[tableName]
+	if !ok {
+		return handleError(fmt.Errorf("table: %s doesn't exist", tableName), "failed")
+	}
+
+	primary, err := getPrimary(tableName, catalog)
+	if err != nil {
+		return handleError(fmt.Errorf("couldn't get primary: %w", err), "failed")
+	}
+
+	targetCols, sourceCols, err := insertSelectColumns(plan["selectedCols"].([]any), source, primary)
+	if err != nil {
+		return handleError(err, "failed")
+	}
+
+	if _, err := checkPresenceGetPrimary(targetCols, tableName, catalog); err != nil {
+		return handleError(err, "failed")
+	}
+
+	returning, err := parseReturning(plan, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("parseReturning failed: %w", err), "failed")
+	}
+
+	tableObj, err := GetTableObj(tableName, manager)
+	if err != nil {
+		return handleError(fmt.Errorf("GetTable failed for: %s, error: %s", tableName, err), "failed")
+	}
+
+	cursor, err := qe.OpenCursor(source)
+	if err != nil {
+		return handleError(fmt.Errorf("OpenCursor failed: %w", err), "failed")
+	}
+	defer cursor.Close()
+
+	// reading the table we're writing to would see our own rows
+	var materialized []*RowV2
+	selfReferencing := scansTable(source, tableName)
+	if selfReferencing {
+		materialized, err = cursor.FetchAll()
+		if err != nil {
+			return handleError(fmt.Errorf("FetchAll failed: %w", err), "failed")
+		}
+	}
+
+	var txId string
+	if !transactionOff {
+		txId = walManager.BeginTransaction()
+	}
+
+	var inserted int
+	var returnedRows [][]byte
+	for done := false; !done; {
+		var rows []*RowV2
+		if selfReferencing {
+			rows, done = materialized, true
+		} else {
+			rows, done, err = cursor.Fetch(DEFAULT_FETCH_SIZE)
+			if err != nil {
+				return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("fetching source rows failed: %w", err), "failed")
+			}
+		}
+
+		batch := make([]map[string]string, 0, len(rows))
+		for _, row := range rows {
+			values := make(map[string]string, len(targetCols))
+			for i, target := range targetCols {
+				// a NULL selected into a column doesn't get its default
+				if val, ok := row.Values[sourceCols[i]]; ok {
+					values[target.(string)] = val
+				} else {
+					values[target.(string)] = nullMarker
+				}
+			}
+
+			batch = append(batch, values)
+		}
+
+		explicitKeys := hasExplicitKeys(batch, primary)
+		for _, values := range batch {
+			if err := manager.fillSerials(values, tableName, tableStats); err != nil {
+				return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("fillSerials failed: %w", err), "failed")
+			}
+		}
+
+		// earlier batches are in the table already, the scan sees them
+		if err := qe.checkKeys(batch, explicitKeys, primary, tableObj, tableStats); err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, err, "failed")
+		}
+
+		nonAddedRows := &NonAddedRows{}
+		for _, values := range batch {
+			encodedRow, err := prepareRow(values, primary, tableName, tableStats, txId, walManager, transactionOff)
+			if err != nil {
+				return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("preparing rows failed: %w", err), "failed")
+			}
+
+			nonAddedRows.Rows = append(nonAddedRows.Rows, encodedRow)
+		}
+
+		err := findAndUpdate(qe.BufferPoolManager, tableObj, tableStats, tableName, nonAddedRows.Rows, nil)
+		if err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("findAndUpdate Failed: %w", err), "failed")
+		}
+
+		if returning != nil {
+			returnedRows = append(returnedRows, nonAddedRows.Rows...)
+		}
+
+		inserted += len(rows)
+	}
+
+	if induceErr {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, errors.New("induced error"), "failed")
+	}
+
+	if !transactionOff {
+		if err := walManager.CommitTransaction(txId, tableName); err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("CommitTransaction Failed: %s", err), "failed")
+		}
+	}
+
+	msg := fmt.Sprintf("%d rows inserted", inserted)
+	if returning != nil {
+		return returningResult(returnedRows, tableStats, returning, msg)
+	}
+
+	return Result{Msg: msg}
+}
+
+// handleUpsert splits the rows on the conflict column: new keys are
+// inserted, existing ones are skipped (DO NOTHING) or rewritten by the update
+// pipeline (DO UPDATE). Both branches log to the same transaction so a
+// rollback undoes the inserts and restores the updated rows.
+func (qe *QueryEngine) handleUpsert(plan, conflict map[string]any, transactionOff, induceErr bool) Result {
+	manager := qe.BufferPoolManager.DiskManager
+	walManager := qe.BufferPoolManager.Wal
+	catalog := manager.PageCatalog
+
+	if _, ok := plan["source"]; ok {
+		return handleError(errors.New("ON CONFLICT isn't supported with INSERT ... SELECT"), "failed")
+	}
+
+	selectedCols := plan["selectedCols"].([]any)
+	tableName := plan["table"].(string)
+	tableStats := catalog.Tables[tableName]
+
+	primary, err := checkPresenceGetPrimary(selectedCols, tableName, catalog)
+	if err != nil {
+		return handleError(err, "failed")
+	}
+
+	column := strings.ReplaceAll(conflict["column"].(string), "`", "")
+	if !slices.Contains(selectedCols, any(column)) {
+		return handleError(fmt.Errorf("conflict column: %s isn't inserted", column), "failed")
+	}
+
+	doUpdate := conflict["action"] == "UPDATE"
+
+	returning, err := parseReturning(plan, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("parseReturning failed: %w", err), "failed")
+	}
+
+	var assignments []Assignment
+	if doUpdate {
+		assignments, err = parseAssignments(conflict, primary, tableStats)
+		if err != nil {
+			return handleError(fmt.Errorf("parseAssignments failed: %w", err), "failed")
+		}
+	}
+
+	tableObj, err := GetTableObj(tableName, manager)
+	if err != nil {
+		return handleError(fmt.Errorf("GetTable failed for: %s, error: %s", tableName, err), "failed")
+	}
+
+	// a null key never conflicts, a key repeated in the statement conflicts
+	// with its first occurrence. The keys are compared once coerced to the
+	// column type, like the stored ones.
+	rows, err := manager.insertValues(plan, selectedCols)
+	if err != nil {
+		return handleError(fmt.Errorf("insertValues failed: %w", err), "failed")
+	}
+
+	columnType := tableStats.Schema[column]
+	storedKey := func(row *RowV2) string {
+		key := row.Values[column]
+		// rows written before the types were enforced may not be canonical
+		if coerced, err := coerceColumn(key, columnType); err == nil {
+			return coerced
+		}
+		return key
+	}
+
+	var candidates []map[string]string
+	proposed := make(map[string]*RowV2)
+	for _, values := range rows {
+		key, err := coerceColumn(values[column], columnType)
+		if err != nil {
+			return handleError(fmt.Errorf("column: %s on table: %s: %w", column, tableName, err), "failed")
+		}
+
+		if key != "" && key != nullMarker {
+			values[column] = key
+			if _, repeated := proposed[key]; repeated {
+				if doUpdate {
+					return handleError(fmt.Errorf("ON CONFLICT DO UPDATE can't affect the row with %s = %s twice", column, key), "failed")
+				}
+				continue
+			}
+			excluded := maps.Clone(values)
+			dropNulls(excluded)
+			proposed[key] = &RowV2{Values: excluded}
+		}
+
+		candidates = append(candidates, values)
+	}
+
+	var txId string
+	if !transactionOff {
+		txId = walManager.BeginTransaction()
+	}
+
+	existing := make(map[string]bool)
+	err = qe.scanRows(tableObj, tableStats, func(row *RowV2) error {
+		if key := storedKey(row); key != "" && proposed[key] != nil {
+			existing[key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("scanRows failed: %w", err), "failed")
+	}
+
+	// RETURNING reports the inserted rows and the new versions of the updated ones
+	var updatedRows [][]byte
+	if doUpdate && len(existing) > 0 {
+		ectx := NewExprContext(nil, tableStats.Schema)
+		updater := RowUpdater{
+			Match: func(row *RowV2) (bool, error) {
+				return existing[storedKey(row)], nil
+			},
+			Apply: func(row *RowV2) error {
+				rowCtx := *ectx
+				rowCtx.Excluded = proposed[storedKey(row)]
+				return applyAssignments(row, assignments, &rowCtx)
+			},
+			Updated: func(rowBytes []byte) {
+				updatedRows = append(updatedRows, rowBytes)
+			},
+		}
+
+		if err := qe.updateRows(tableObj, tableStats, updater, txId, transactionOff); err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("updating conflicting rows failed: %w", err), "failed")
+		}
+	}
+
+	var inserts []map[string]string
+	for _, values := range candidates {
+		if !existing[values[column]] {
+			inserts = append(inserts, values)
+		}
+	}
+
+	explicitKeys := hasExplicitKeys(inserts, primary)
+	for _, values := range inserts {
+		if err := manager.fillSerials(values, tableName, tableStats); err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("fillSerials failed: %w", err), "failed")
+		}
+	}
+
+	if err := qe.checkKeys(inserts, explicitKeys, primary, tableObj, tableStats); err != nil {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, err, "failed")
+	}
+
+	var encodedRows [][]byte
+	for _, values := range inserts {
+		encodedRow, err := prepareRow(values, primary, tableName, tableStats, txId, walManager, transactionOff)
+		if err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("preparing rows failed: %w", err), "failed")
+		}
+		encodedRows = append(encodedRows, encodedRow)
+	}
+
+	err = findAndUpdate(qe.BufferPoolManager, tableObj, tableStats, tableName, encodedRows, nil)
+	if err != nil {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("findAndUpdate Failed: %w", err), "failed")
+	}
+
+	if induceErr {
+		return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, errors.New("induced error"), "failed")
+	}
+
+	if !transactionOff {
+		if err := walManager.CommitTransaction(txId, tableName); err != nil {
+			return rollbackAndReturn(txId, primary, tableName, walManager, qe, nil, fmt.Errorf("CommitTransaction Failed: %s", err), "failed")
+		}
+	}
+
+	if returning != nil {
+		return returningResult(append(encodedRows, updatedRows...), tableStats, returning, "successful query")
+	}
+
+	res, err := ReturnPrimaryIds(encodedRows, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("ReturnPrimaryIds failed: %w", err), "failed query")
+	}
+
+	return *res
+}
+
+// insertSelectColumns pairs every target column with the source column
+// feeding it. Without a column list the source columns are matched by name
+// and the primary is left out so every row gets a fresh one.
+func insertSelectColumns(selectedCols []any, source map[string]any, primary string) ([]any, []string, error) {
+	sourceCols, _ := source["selected_columns"].([]any)
+
+	var targets []any
+	var sources []string
+
+	if len(selectedCols) == 0 {
+		for _, col := range sourceCols {
+			if col.(string) == primary {
+				continue
+			}
+			targets = append(targets, col)
+			sources = append(sources, col.(string))
+		}
+		return targets, sources, nil
+	}
+
+	if len(selectedCols) != len(sourceCols) {
+		return nil, nil, fmt.Errorf("INSERT has %d target columns but the SELECT returns %d", len(selectedCols), len(sourceCols))
+	}
+
+	for i, col := range selectedCols {
+		targets = append(targets, col)
+		sources = append(sources, sourceCols[i].(string))
+	}
+
+	return targets, sources, nil
+}
+
+func scansTable(plan map[string]any, tableName string) bool {
+	rels, _ := plan["rels"].([]any)
+	for _, rel := range rels {
+		relMap, _ := rel.(map[string]any)
+		if relMap["relOp"] != "LogicalTableScan" {
+			continue
+		}
+
+		if scanTableName(relMap) == tableName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scanTableName is the table read by a LogicalTableScan, the planner names
+// the tables of a database by [database, table].
+func scanTableName(rel map[string]any) string {
+	var names []string
+	for _, name := range asList(rel["table"]) {
+		if name, ok := name.(string); ok {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, ".")
+}
+
+func ReturnPrimaryIds(encodedRows [][]byte, tableStats *TableInfo) (*Result, error) {
 	var res Result
 
 	for _, encodedRow := range encodedRows {
@@ -333,6 +959,9 @@ func ReturnPrimaryIds(encodedRows [][]byte) (*Result, error) {
 
 		buff := bytes.NewReader(encodedRow)
 		DecodeRow(&row, buff)
+		if err := DecodeStoredValues(&row, tableStats); err != nil {
+			return nil, fmt.Errorf("DecodeStoredValues failed: %w", err)
+		}
 
 		res.Rows = append(res.Rows, &row)
 	}
@@ -0,0 +1,223 @@
+package engines
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	CURSOR_BUFFER_BATCHES = 4    // batches buffered between the pipeline and the reader
+	DEFAULT_FETCH_SIZE    = 1000 // rows per fetch when the client doesn't ask for a size
+)
+
+// client -> server frames: [op][uint32 n]
+const (
+	CURSOR_FETCH = iota + 1
+	CURSOR_CLOSE
+)
+
+// server -> client frames: [type][uint32 len][payload]
+const (
+	FRAME_ROWS = iota + 1
+	FRAME_ERROR
+)
+
+// Cursor reads the output of a running SELECT pipeline on demand, the
+// pipeline only moves forward as fast as the rows are fetched.
+// A cursor is not safe for concurrent use.
+type Cursor struct {
+	batches   chan []*RowV2
+	pending   []*RowV2
+	cancel    context.CancelFunc
+	errChan   chan error
+	finished  chan struct{}
+	err       error
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func (qe *QueryEngine) OpenCursor(plan map[string]interface{}) (*Cursor, error) {
+	nodes, err := ComputeNodes(plan, qe)
+	if err != nil {
+		return nil, fmt.Errorf("ComputeNodes Failed: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cursor := &Cursor{
+		batches:  nodes[len(nodes)-1].(CollectorNode).Sink,
+		cancel:   cancel,
+		errChan:  make(chan error, len(nodes)),
+		finished: make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node Node) {
+			defer wg.Done()
+			if err := node.initialization(ctx); err != nil {
+				cursor.errChan <- err
+				cancel()
+			}
+		}(node)
+	}
+
+	go func() {
+		wg.Wait()
+		close(cursor.errChan)
+		close(cursor.finished)
+	}()
+
+	return cursor, nil
+}
+
+// rowsCursor serves rows that are already materialized, like the ones of a
+// DML statement with RETURNING, through the same fetch loop as a SELECT.
+func rowsCursor(rows []*RowV2) *Cursor {
+	batches := make(chan []*RowV2)
+	errChan := make(chan error)
+	finished := make(chan struct{})
+	close(batches)
+	close(errChan)
+	close(finished)
+
+	return &Cursor{
+		batches:  batches,
+		pending:  rows,
+		cancel:   func() {},
+		errChan:  errChan,
+		finished: finished,
+	}
+}
+
+// Fetch returns up to n rows, done is true once the pipeline is exhausted.
+func (c *Cursor) Fetch(n int) ([]*RowV2, bool, error) {
+	if c.err != nil {
+		return nil, true, c.err
+	}
+
+	if n <= 0 {
+		n = DEFAULT_FETCH_SIZE
+	}
+
+	for len(c.pending) < n {
+		rows, ok := <-c.batches
+		if !ok {
+			<-c.finished
+			if err := <-c.errChan; err != nil {
+				c.err = fmt.Errorf("pipeline failed: %w", err)
+				return nil, true, c.err
+			}
+
+			rows := c.pending
+			c.pending = nil
+			return rows, true, nil
+		}
+
+		c.pending = append(c.pending, rows...)
+	}
+
+	rows := c.pending[:n:n]
+	c.pending = c.pending[n:]
+
+	return rows, false, nil
+}
+
+func (c *Cursor) FetchAll() ([]*RowV2, error) {
+	var all []*RowV2
+
+	for {
+		rows, done, err := c.Fetch(DEFAULT_FETCH_SIZE)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, rows...)
+		if done {
+			return all, nil
+		}
+	}
+}
+
+// Close stops the pipeline and waits for every node to return,
+// it's safe to call it before the cursor is exhausted.
+func (c *Cursor) Close() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		<-c.finished
+		c.pending = nil
+
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
+
+func (qe *QueryEngine) handleSelectCursor(plan map[string]interface{}) Result {
+	cursor, err := qe.OpenCursor(plan)
+	if err != nil {
+		return handleError(fmt.Errorf("OpenCursor Failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "success", Cursor: cursor}
+}
+
+func EncodeRowsFrame(rows []*RowV2, last bool) ([]byte, error) {
+	var payload bytes.Buffer
+
+	if err := binary.Write(&payload, binary.LittleEndian, last); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(&payload, binary.LittleEndian, uint32(len(rows))); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if err := binary.Write(&payload, binary.LittleEndian, uint32(len(row.Values))); err != nil {
+			return nil, err
+		}
+
+		for key, val := range row.Values {
+			if err := writeString(&payload, key); err != nil {
+				return nil, err
+			}
+
+			if err := writeString(&payload, val); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return encodeFrame(FRAME_ROWS, payload.Bytes())
+}
+
+func EncodeErrorFrame(err error) ([]byte, error) {
+	if err == nil {
+		return nil, errors.New("nil error frame")
+	}
+
+	return encodeFrame(FRAME_ERROR, []byte(FormatQueryError(err)))
+}
+
+func encodeFrame(frameType uint8, payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.LittleEndian, frameType); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(payload))); err != nil {
+		return nil, err
+	}
+
+	if _, err := buf.Write(payload); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,505 @@
+package engines
+
+import (
+	"a2gdb/logger"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Databases
+//
+// CREATE DATABASE gives a namespace its own catalog file and Tables/
+// directory under Databases/<name>. The rest of the engine keeps a single
+// catalog in memory where the objects of a database go by their qualified
+// name, <database>.<table>, which is also how the plans name them.
+// UpdateCatalog splits it back per database so dropping a database is
+// removing its directory. The tables created without a database stay in the
+// catalog and the Tables/ directory at the root of the data dir.
+
+const DATABASES_DIR = "Databases"
+
+var databaseName = regexp.MustCompile(`^\w+$`)
+
+type Database struct {
+	Name        string
+	Dir         string
+	FileCatalog *os.File
+	written     []byte // the catalog last written, an unchanged one isn't rewritten
+}
+
+// SplitTableName splits a qualified name, database is "" for the objects
+// outside of any database.
+func SplitTableName(name string) (database, table string) {
+	if database, table, found := strings.Cut(name, "."); found {
+		return database, table
+	}
+	return "", name
+}
+
+// databaseOf is the database holding name, the root one when name isn't
+// qualified by an existing database.
+func (dm *DiskManagerV2) databaseOf(name string) *Database {
+	database, _ := SplitTableName(name)
+	if database == "" {
+		return dm.root
+	}
+
+	dm.databasesMu.RLock()
+	defer dm.databasesMu.RUnlock()
+
+	if db, ok := dm.Databases[database]; ok {
+		return db
+	}
+	return dm.root
+}
+
+// checkDatabase fails when name is qualified by a database that doesn't
+// exist, used before an object is created.
+func (dm *DiskManagerV2) checkDatabase(name string) error {
+	database, _ := SplitTableName(name)
+	if database == "" {
+		return nil
+	}
+
+	dm.databasesMu.RLock()
+	defer dm.databasesMu.RUnlock()
+
+	if _, ok := dm.Databases[database]; !ok {
+		return fmt.Errorf("database: %s doesn't exist", database)
+	}
+	return nil
+}
+
+func (dm *DiskManagerV2) HasDatabase(name string) bool {
+	dm.databasesMu.RLock()
+	defer dm.databasesMu.RUnlock()
+
+	_, ok := dm.Databases[name]
+	return ok
+}
+
+// DatabaseNames lists the databases, sorted.
+func (dm *DiskManagerV2) DatabaseNames() []string {
+	dm.databasesMu.RLock()
+	defer dm.databasesMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(dm.Databases))
+}
+
+// ListTables gives the tables of database by their name inside of it, ""
+// lists the ones outside of any database.
+func (dm *DiskManagerV2) ListTables(database string) ([]string, error) {
+	if database != "" && !dm.HasDatabase(database) {
+		return nil, fmt.Errorf("database: %s doesn't exist", database)
+	}
+
+	var tables []string
+	for name := range dm.PageCatalog.Tables {
+		if db, table := SplitTableName(name); db == database {
+			tables = append(tables, table)
+		}
+	}
+
+	slices.Sort(tables)
+	return tables, nil
+}
+
+// members lists the objects of the catalog qualified by database.
+func (c *Catalog) members(database string) (tables, views, materialized, sequences []string) {
+	inDatabase := func(name string) bool {
+		db, _ := SplitTableName(name)
+		return db == database
+	}
+
+	for name := range c.Tables {
+		if inDatabase(name) {
+			tables = append(tables, name)
+		}
+	}
+
+	for name := range c.Views {
+		if inDatabase(name) {
+			views = append(views, name)
+		}
+	}
+
+	for name := range c.Materialized {
+		if inDatabase(name) {
+			materialized = append(materialized, name)
+		}
+	}
+
+	c.seqMu.RLock()
+	for name := range c.Sequences {
+		if inDatabase(name) {
+			sequences = append(sequences, name)
+		}
+	}
+	c.seqMu.RUnlock()
+
+	return tables, views, materialized, sequences
+}
+
+// checkDropDatabase fails when an object outside of database depends on one
+// inside of it.
+func (c *Catalog) checkDropDatabase(database string) error {
+	tables, views, _, _ := c.members(database)
+	outside := func(name string) bool {
+		db, _ := SplitTableName(name)
+		return db != database
+	}
+
+	for _, name := range append(tables, views...) {
+		for _, ref := range c.referencesTo(name) {
+			if outside(ref.table) {
+				return fmt.Errorf("constraint: %s on table: %s references table: %s", ref.fk.Name, ref.table, name)
+			}
+		}
+
+		for _, view := range c.viewsOn(name) {
+			if outside(view) {
+				return fmt.Errorf("view: %s depends on: %s", view, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CreateDatabase builds the directory aside and renames it in place, a
+// crash leaves either no database or a complete one.
+func (dm *DiskManagerV2) CreateDatabase(name string) error {
+	if !databaseName.MatchString(name) {
+		return fmt.Errorf("invalid database name: %s", name)
+	}
+
+	dm.catalogMu.Lock()
+	defer dm.catalogMu.Unlock()
+
+	if dm.HasDatabase(name) {
+		return fmt.Errorf("database: %s already exists", name)
+	}
+
+	databasesPath := filepath.Join(dm.DBdirectory, DATABASES_DIR)
+	if err := os.MkdirAll(databasesPath, 0755); err != nil {
+		return fmt.Errorf("creating databases directory failed: %w", err)
+	}
+
+	tmpPath := filepath.Join(databasesPath, "."+name+".tmp")
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return fmt.Errorf("removing previous attempt failed: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmpPath, "Tables"), 0755); err != nil {
+		return fmt.Errorf("creating database directory failed: %w", err)
+	}
+
+	encodedCatalog, err := SerializeCatalog(newCatalog())
+	if err != nil {
+		return fmt.Errorf("SerializeCatalog failed: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpPath, CATALOG_FILE), encodedCatalog, 0666); err != nil {
+		return fmt.Errorf("writing catalog failed: %w", err)
+	}
+
+	dbPath := filepath.Join(databasesPath, name)
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		os.RemoveAll(tmpPath)
+		return fmt.Errorf("renaming database directory failed: %w", err)
+	}
+
+	if err := syncDir(databasesPath); err != nil {
+		return fmt.Errorf("syncDir failed: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dbPath, CATALOG_FILE), os.O_RDWR, 0666)
+	if err != nil {
+		return fmt.Errorf("opening catalog failed: %w", err)
+	}
+
+	dm.databasesMu.Lock()
+	dm.Databases[name] = &Database{Name: name, Dir: dbPath, FileCatalog: file, written: encodedCatalog}
+	dm.databasesMu.Unlock()
+
+	logger.Log.WithField("database", name).Info("database created")
+	return nil
+}
+
+// DropDatabase renames the directory out of the way before forgetting the
+// objects of the database, the renamed directory is removed last or on the
+// next start.
+func (dm *DiskManagerV2) DropDatabase(name string) error {
+	catalog := dm.PageCatalog
+
+	dm.catalogMu.Lock()
+	defer dm.catalogMu.Unlock()
+
+	dm.databasesMu.RLock()
+	database, ok := dm.Databases[name]
+	dm.databasesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("database: %s doesn't exist", name)
+	}
+
+	if err := catalog.checkDropDatabase(name); err != nil {
+		return err
+	}
+
+	databasesPath := filepath.Join(dm.DBdirectory, DATABASES_DIR)
+	droppedPath := filepath.Join(databasesPath, "."+name+".dropped")
+	if err := os.Rename(database.Dir, droppedPath); err != nil {
+		return fmt.Errorf("renaming database directory failed: %w", err)
+	}
+
+	if err := syncDir(databasesPath); err != nil {
+		return fmt.Errorf("syncDir failed: %w", err)
+	}
+
+	tables, views, materialized, sequences := catalog.members(name)
+
+	dm.databasesMu.Lock()
+	delete(dm.Databases, name)
+	dm.databasesMu.Unlock()
+
+	for _, view := range views {
+		delete(catalog.Views, view)
+	}
+
+	for _, view := range materialized {
+		delete(catalog.Materialized, view)
+	}
+
+	catalog.seqMu.Lock()
+	for _, seq := range sequences {
+		delete(catalog.Sequences, seq)
+	}
+	catalog.seqMu.Unlock()
+
+	dm.Mu.Lock()
+	for _, table := range tables {
+		delete(catalog.Tables, table)
+		if tableObj, ok := dm.TableObjs[table]; ok {
+			tableObj.Close()
+			delete(dm.TableObjs, table)
+		}
+	}
+	dm.Mu.Unlock()
+
+	database.FileCatalog.Close()
+	if err := os.RemoveAll(droppedPath); err != nil {
+		return fmt.Errorf("removing database files failed: %w", err)
+	}
+
+	logger.Log.WithField("database", name).Info("database dropped")
+	return syncDir(databasesPath)
+}
+
+// openDatabases loads the catalog of every database into the shared one,
+// directories left by an interrupted CREATE or DROP DATABASE are removed.
+func (dm *DiskManagerV2) openDatabases() error {
+	databasesPath := filepath.Join(dm.DBdirectory, DATABASES_DIR)
+
+	entries, err := os.ReadDir(databasesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading databases directory failed: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") {
+			logger.Log.WithField("database", entry.Name()).Info("removing unfinished database directory")
+			if err := os.RemoveAll(filepath.Join(databasesPath, entry.Name())); err != nil {
+				return fmt.Errorf("removing unfinished database failed: %w", err)
+			}
+			continue
+		}
+
+		dbPath := filepath.Join(databasesPath, entry.Name())
+		file, err := os.OpenFile(filepath.Join(dbPath, CATALOG_FILE), os.O_RDWR, 0666)
+		if err != nil {
+			return fmt.Errorf("opening catalog of database: %s failed: %w", entry.Name(), err)
+		}
+
+		bytes, err := ReadNonPageFile(file)
+		if err != nil {
+			return fmt.Errorf("ReadNonPageFile failed: %w", err)
+		}
+
+		catalog, err := DeserializeCatalog(bytes)
+		if err != nil {
+			return fmt.Errorf("DeserializeCatalog of database: %s failed: %w", entry.Name(), err)
+		}
+
+		maps.Copy(dm.PageCatalog.Tables, catalog.Tables)
+		maps.Copy(dm.PageCatalog.Sequences, catalog.Sequences)
+		maps.Copy(dm.PageCatalog.Views, catalog.Views)
+		maps.Copy(dm.PageCatalog.Materialized, catalog.Materialized)
+
+		dm.Databases[entry.Name()] = &Database{Name: entry.Name(), Dir: dbPath, FileCatalog: file, written: bytes}
+	}
+
+	return nil
+}
+
+// splitCatalog gives every database, the root one included, the part of the
+// shared catalog it persists.
+func (dm *DiskManagerV2) splitCatalog() map[*Database]*Catalog {
+	catalogs := map[*Database]*Catalog{dm.root: newCatalog()}
+
+	dm.databasesMu.RLock()
+	for _, database := range dm.Databases {
+		catalogs[database] = newCatalog()
+	}
+	dm.databasesMu.RUnlock()
+
+	part := func(name string) *Catalog {
+		return catalogs[dm.databaseOf(name)]
+	}
+
+	catalog := dm.PageCatalog
+	for name, tableInfo := range catalog.Tables {
+		part(name).Tables[name] = tableInfo
+	}
+
+	for name, view := range catalog.Views {
+		part(name).Views[name] = view
+	}
+
+	for name, view := range catalog.Materialized {
+		part(name).Materialized[name] = view
+	}
+
+	catalog.seqMu.RLock()
+	for name, seq := range catalog.Sequences {
+		part(name).Sequences[name] = seq
+	}
+	catalog.seqMu.RUnlock()
+
+	return catalogs
+}
+
+// handleCreateDatabase runs CREATE DATABASE [IF NOT EXISTS] name
+func (qe *QueryEngine) handleCreateDatabase(plan map[string]any) Result {
+	name := plan["database"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+
+	if ifNotExists, _ := plan["ifNotExists"].(bool); ifNotExists && manager.HasDatabase(name) {
+		return Result{Msg: "Database Exists"}
+	}
+
+	if err := manager.CreateDatabase(name); err != nil {
+		return handleError(fmt.Errorf("CreateDatabase failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Database Created"}
+}
+
+// handleDropDatabase runs DROP DATABASE [IF EXISTS] name, the tables go with it.
+// It runs holding every table of the database, no statement is on any of them.
+func (qe *QueryEngine) handleDropDatabase(plan map[string]any) Result {
+	name := plan["database"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+
+	if !manager.HasDatabase(name) {
+		if ifExists, _ := plan["ifExists"].(bool); ifExists {
+			return Result{Msg: "Database Doesn't Exist"}
+		}
+		return handleError(fmt.Errorf("database: %s doesn't exist", name), "failed")
+	}
+
+	if err := manager.PageCatalog.checkDropDatabase(name); err != nil {
+		return handleError(fmt.Errorf("can't drop: %w", err), "failed")
+	}
+
+	tables, _, _, _ := manager.PageCatalog.members(name)
+	for _, table := range tables {
+		qe.BufferPoolManager.DiscardTablePages(table)
+	}
+
+	if err := manager.DropDatabase(name); err != nil {
+		return handleError(fmt.Errorf("DropDatabase failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Database Dropped"}
+}
+
+// handleUse runs USE name, the session keeps Result.Database.
+func (qe *QueryEngine) handleUse(plan map[string]any) Result {
+	name := plan["database"].(string)
+
+	if !qe.BufferPoolManager.DiskManager.HasDatabase(name) {
+		return handleError(fmt.Errorf("database: %s doesn't exist", name), "failed")
+	}
+
+	return Result{Msg: "Database Changed", Database: name}
+}
+
+// handleShowTables runs SHOW TABLES [FROM name], one row per table.
+func (qe *QueryEngine) handleShowTables(plan map[string]any) Result {
+	name, _ := plan["database"].(string)
+
+	tables, err := qe.BufferPoolManager.DiskManager.ListTables(name)
+	if err != nil {
+		return handleError(fmt.Errorf("ListTables failed: %w", err), "failed")
+	}
+
+	rows := make([]*RowV2, 0, len(tables))
+	for _, table := range tables {
+		rows = append(rows, &RowV2{Values: map[string]string{"Table": table}})
+	}
+
+	return Result{Msg: "Tables Listed", Rows: rows}
+}
+
+// Session keeps the database chosen by USE, its queries name the tables of
+// that database without qualifying them.
+type Session struct {
+	Database string
+	mu       sync.Mutex
+}
+
+func (s *Session) ExecuteQuery(sql string, queryEngine *QueryEngine) (*Result, error) {
+	return s.execute(sql, queryEngine, false)
+}
+
+func (s *Session) ExecuteQueryStream(sql string, queryEngine *QueryEngine) (*Result, error) {
+	return s.execute(sql, queryEngine, true)
+}
+
+// execute sends the database in front of the query, the planner resolves
+// the bare names with it.
+func (s *Session) execute(sql string, queryEngine *QueryEngine, stream bool) (*Result, error) {
+	s.mu.Lock()
+	database := s.Database
+	s.mu.Unlock()
+
+	if database != "" {
+		sql = fmt.Sprintf("USE `%s`; %s", database, sql)
+	}
+
+	res, err := executeQuery(sql, queryEngine, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Error == nil && res.Database != "" {
+		s.mu.Lock()
+		s.Database = res.Database
+		s.mu.Unlock()
+	}
+
+	return res, nil
+}
@@ -8,12 +8,19 @@ import (
 	"sync"
 )
 
+const CATALOG_FILE = "catalog"
+
 type DiskManagerV2 struct {
 	DBdirectory string
-	PageCatalog *Catalog
-	FileCatalog *os.File
+	PageCatalog *Catalog // every database, see databases.go
+	FileCatalog *os.File // catalog of the tables outside of any database
+	Databases   map[string]*Database
 	TableObjs   map[string]*TableObj
 	Mu          *sync.RWMutex
+	catalogMu   *sync.Mutex // serializes catalog file replacements
+	databasesMu *sync.RWMutex
+	root        *Database // the data dir itself, holds FileCatalog
+	indexPool   *IndexPool
 }
 
 func NewDiskManagerV2(dbDirectory string) (*DiskManagerV2, error) {
@@ -50,13 +57,13 @@ func CreatDefaultManager(dbDirectory string) (DiskManagerV2, error) {
 		return DiskManagerV2{}, fmt.Errorf("CreatDefaultManager (create table dir error): %w", err)
 	}
 
-	catalogFilePtr, err := os.Create(dbDirectory + "/catalog")
+	catalogFilePtr, err := os.Create(filepath.Join(dbDirectory, CATALOG_FILE))
 	if err != nil {
 		return DiskManagerV2{}, fmt.Errorf("CreatDefaultManager (create catalog file error): %w", err)
 	}
 
-	catalog := Catalog{Tables: make(map[string]*TableInfo)}
-	encodedCatalog, err := SerializeCatalog(&catalog)
+	catalog := newCatalog()
+	encodedCatalog, err := SerializeCatalog(catalog)
 	if err != nil {
 		return DiskManagerV2{}, fmt.Errorf("CreatDefaultManager: %w", err)
 	}
@@ -68,17 +75,21 @@ func CreatDefaultManager(dbDirectory string) (DiskManagerV2, error) {
 
 	dm := DiskManagerV2{
 		DBdirectory: dbDirectory,
-		PageCatalog: &catalog,
+		PageCatalog: catalog,
 		FileCatalog: catalogFilePtr,
+		Databases:   make(map[string]*Database),
 		TableObjs:   make(map[string]*TableObj),
 		Mu:          &sync.RWMutex{},
+		catalogMu:   &sync.Mutex{},
+		databasesMu: &sync.RWMutex{},
+		root:        &Database{Dir: dbDirectory, FileCatalog: catalogFilePtr, written: encodedCatalog},
 	}
 
 	return dm, nil
 }
 
 func ReadExistingManager(dbDirectory string) (DiskManagerV2, error) {
-	catalogPath := filepath.Join(dbDirectory, "catalog")
+	catalogPath := filepath.Join(dbDirectory, CATALOG_FILE)
 
 	file, err := os.OpenFile(catalogPath, os.O_RDWR, 0666)
 	if err != nil {
@@ -99,8 +110,20 @@ func ReadExistingManager(dbDirectory string) (DiskManagerV2, error) {
 		DBdirectory: dbDirectory,
 		PageCatalog: catalog,
 		FileCatalog: file,
+		Databases:   make(map[string]*Database),
 		TableObjs:   make(map[string]*TableObj),
 		Mu:          &sync.RWMutex{},
+		catalogMu:   &sync.Mutex{},
+		databasesMu: &sync.RWMutex{},
+		root:        &Database{Dir: dbDirectory, FileCatalog: file, written: bytes},
+	}
+
+	if err := dm.openDatabases(); err != nil {
+		return DiskManagerV2{}, fmt.Errorf("ReadExistingManager: %w", err)
+	}
+
+	if err := dm.removeOrphanTables(); err != nil {
+		return DiskManagerV2{}, fmt.Errorf("ReadExistingManager: %w", err)
 	}
 
 	return dm, nil
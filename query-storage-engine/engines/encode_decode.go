@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"runtime/metrics"
+	"slices"
 	"time"
 
 	"github.com/axiomhq/hyperloglog"
@@ -157,6 +160,15 @@ func DecodePageInfo(data []byte) (*PageInfo, error) {
 		pageInfo.PointerArray[i] = tuple
 	}
 
+	// directories written before the zone maps end here
+	if buf.Len() > 0 {
+		zones, err := readZones(buf)
+		if err != nil {
+			return nil, err
+		}
+		pageInfo.Zones = zones
+	}
+
 	return &pageInfo, nil
 }
 
@@ -198,9 +210,70 @@ func EncodePageInfo(pageInfo *PageInfo) ([]byte, error) {
 		}
 	}
 
+	if err := writeZones(&buf, pageInfo.Zones); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
+func writeZones(buf *bytes.Buffer, zones map[Column]ZoneMap) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(zones))); err != nil {
+		return err
+	}
+
+	for column, zone := range zones {
+		for _, s := range []string{string(column), zone.Min, zone.Max} {
+			if err := writeString(buf, s); err != nil {
+				return err
+			}
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, zone.Values); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, zone.Nulls); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readZones(buf *bytes.Reader) (map[Column]ZoneMap, error) {
+	var numZones uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numZones); err != nil {
+		return nil, err
+	}
+
+	zones := make(map[Column]ZoneMap, numZones)
+	for i := uint32(0); i < numZones; i++ {
+		column, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var zone ZoneMap
+		if zone.Min, err = readString(buf); err != nil {
+			return nil, err
+		}
+		if zone.Max, err = readString(buf); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(buf, binary.LittleEndian, &zone.Values); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &zone.Nulls); err != nil {
+			return nil, err
+		}
+
+		zones[Column(column)] = zone
+	}
+
+	return zones, nil
+}
+
 func DecodeDirectory(data []byte) (*DirectoryPageV2, error) {
 	buf := bytes.NewReader(data)
 
@@ -574,8 +647,9 @@ func readString(buf *bytes.Reader) (string, error) {
 		return "", err
 	}
 
+	// ReadFull, a plain Read of an empty string fails at the end of the buffer
 	strBytes := make([]byte, strLen)
-	if _, err := buf.Read(strBytes); err != nil {
+	if _, err := io.ReadFull(buf, strBytes); err != nil {
 		return "", err
 	}
 	return string(strBytes), nil
@@ -611,7 +685,8 @@ func writeSchema(buf *bytes.Buffer, schema map[string]ColumnType) error {
 		return err
 	}
 
-	for columnName, columnType := range schema {
+	for _, columnName := range slices.Sorted(maps.Keys(schema)) {
+		columnType := schema[columnName]
 		if err := writeString(buf, columnName); err != nil {
 			return err
 		}
@@ -947,7 +1022,8 @@ func SerializeCatalog(catalog *Catalog) ([]byte, error) {
 		return nil, err
 	}
 
-	for tableName, tableInfo := range catalog.Tables {
+	for _, tableName := range slices.Sorted(maps.Keys(catalog.Tables)) {
+		tableInfo := catalog.Tables[tableName]
 		if err := writeString(&buf, tableName); err != nil {
 			return nil, err
 		}
@@ -957,9 +1033,345 @@ func SerializeCatalog(catalog *Catalog) ([]byte, error) {
 		}
 	}
 
+	if err := writeSequences(&buf, catalog); err != nil {
+		return nil, err
+	}
+
+	if err := writeTableAttrs(&buf, catalog); err != nil {
+		return nil, err
+	}
+
+	if err := writeViews(&buf, catalog); err != nil {
+		return nil, err
+	}
+
+	if err := writeMaterializedViews(&buf, catalog); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
+// writeTableAttrs stores the table and column settings added after the
+// catalog layout was fixed as key/value pairs, only the ones that are set.
+// New settings can be added without breaking older catalogs.
+func writeTableAttrs(buf *bytes.Buffer, catalog *Catalog) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(catalog.Tables))); err != nil {
+		return err
+	}
+
+	for _, tableName := range slices.Sorted(maps.Keys(catalog.Tables)) {
+		tableInfo := catalog.Tables[tableName]
+		if err := writeString(buf, tableName); err != nil {
+			return err
+		}
+
+		if err := writeAttrs(buf, tableInfo.attrs()); err != nil {
+			return err
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, uint32(len(tableInfo.Schema))); err != nil {
+			return err
+		}
+
+		for _, column := range slices.Sorted(maps.Keys(tableInfo.Schema)) {
+			if err := writeString(buf, column); err != nil {
+				return err
+			}
+
+			if err := writeAttrs(buf, tableInfo.Schema[column].attrs()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readTableAttrs is the inverse of writeTableAttrs, catalogs written before
+// it existed end right after the sequences.
+func readTableAttrs(buf *bytes.Reader, catalog *Catalog) error {
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	var numTables uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numTables); err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < numTables; i++ {
+		tableName, err := readString(buf)
+		if err != nil {
+			return err
+		}
+
+		attrs, err := readAttrs(buf)
+		if err != nil {
+			return err
+		}
+
+		tableInfo, ok := catalog.Tables[tableName]
+		if !ok {
+			return fmt.Errorf("attributes of unknown table: %s", tableName)
+		}
+		if err := tableInfo.setAttrs(attrs); err != nil {
+			return err
+		}
+
+		var numColumns uint32
+		if err := binary.Read(buf, binary.LittleEndian, &numColumns); err != nil {
+			return err
+		}
+
+		for j := uint32(0); j < numColumns; j++ {
+			column, err := readString(buf)
+			if err != nil {
+				return err
+			}
+
+			attrs, err := readAttrs(buf)
+			if err != nil {
+				return err
+			}
+
+			columnType := tableInfo.Schema[column]
+			columnType.setAttrs(attrs)
+			tableInfo.Schema[column] = columnType
+		}
+
+		tableInfo.refreshLayout()
+		if err := tableInfo.compileConstraints(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeViews(buf *bytes.Buffer, catalog *Catalog) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(catalog.Views))); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(catalog.Views)) {
+		view := catalog.Views[name]
+		if err := writeString(buf, name); err != nil {
+			return err
+		}
+
+		if err := writeAttrs(buf, view.attrs()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readViews is the inverse of writeViews, catalogs written before views
+// existed end right after the table attributes.
+func readViews(buf *bytes.Reader) (map[string]*View, error) {
+	views := make(map[string]*View)
+	if buf.Len() == 0 {
+		return views, nil
+	}
+
+	var numViews uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numViews); err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < numViews; i++ {
+		name, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs, err := readAttrs(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var view View
+		view.setAttrs(attrs)
+		views[name] = &view
+	}
+
+	return views, nil
+}
+
+func writeMaterializedViews(buf *bytes.Buffer, catalog *Catalog) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(catalog.Materialized))); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(catalog.Materialized)) {
+		view := catalog.Materialized[name]
+		if err := writeString(buf, name); err != nil {
+			return err
+		}
+
+		attrs, err := view.attrs()
+		if err != nil {
+			return err
+		}
+
+		if err := writeAttrs(buf, attrs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readMaterializedViews is the inverse of writeMaterializedViews, older
+// catalogs end after the views.
+func readMaterializedViews(buf *bytes.Reader) (map[string]*MaterializedView, error) {
+	views := make(map[string]*MaterializedView)
+	if buf.Len() == 0 {
+		return views, nil
+	}
+
+	var numViews uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numViews); err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < numViews; i++ {
+		name, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs, err := readAttrs(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var view MaterializedView
+		if err := view.setAttrs(attrs); err != nil {
+			return nil, fmt.Errorf("materialized view: %s: %w", name, err)
+		}
+		views[name] = &view
+	}
+
+	return views, nil
+}
+
+func writeAttrs(buf *bytes.Buffer, attrs map[string]string) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(attrs))); err != nil {
+		return err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(attrs)) {
+		if err := writeString(buf, key); err != nil {
+			return err
+		}
+		if err := writeString(buf, attrs[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readAttrs(buf *bytes.Reader) (map[string]string, error) {
+	var numAttrs uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numAttrs); err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string, numAttrs)
+	for i := uint32(0); i < numAttrs; i++ {
+		key, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs[key] = val
+	}
+
+	return attrs, nil
+}
+
+func writeSequences(buf *bytes.Buffer, catalog *Catalog) error {
+	catalog.seqMu.RLock()
+	defer catalog.seqMu.RUnlock()
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(catalog.Sequences))); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(catalog.Sequences)) {
+		seq := catalog.Sequences[name]
+		if err := writeString(buf, name); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, seq.Increment); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, seq.limit.Load()); err != nil {
+			return err
+		}
+		if err := writeString(buf, seq.Table); err != nil {
+			return err
+		}
+		if err := writeString(buf, seq.Column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSequences resumes every sequence at its reservation limit, catalogs
+// written before sequences existed end right after the tables.
+func readSequences(buf *bytes.Reader) (map[string]*Sequence, error) {
+	sequences := make(map[string]*Sequence)
+	if buf.Len() == 0 {
+		return sequences, nil
+	}
+
+	var numSequences uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numSequences); err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < numSequences; i++ {
+		name, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var seq Sequence
+		var limit int64
+		if err := binary.Read(buf, binary.LittleEndian, &seq.Increment); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &limit); err != nil {
+			return nil, err
+		}
+
+		if seq.Table, err = readString(buf); err != nil {
+			return nil, err
+		}
+		if seq.Column, err = readString(buf); err != nil {
+			return nil, err
+		}
+
+		seq.limit.Store(limit)
+		seq.next = limit
+		sequences[name] = &seq
+	}
+
+	return sequences, nil
+}
+
 func DeserializeCatalog(data []byte) (*Catalog, error) {
 	buf := bytes.NewReader(data)
 
@@ -985,5 +1397,27 @@ func DeserializeCatalog(data []byte) (*Catalog, error) {
 		catalog.Tables[tableName] = tableInfo
 	}
 
+	sequences, err := readSequences(buf)
+	if err != nil {
+		return nil, err
+	}
+	catalog.Sequences = sequences
+
+	if err := readTableAttrs(buf, &catalog); err != nil {
+		return nil, err
+	}
+
+	views, err := readViews(buf)
+	if err != nil {
+		return nil, err
+	}
+	catalog.Views = views
+
+	materialized, err := readMaterializedViews(buf)
+	if err != nil {
+		return nil, err
+	}
+	catalog.Materialized = materialized
+
 	return &catalog, nil
 }
@@ -0,0 +1,728 @@
+package engines
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExprContext carries what the evaluator needs to resolve the nodes of a
+// planner expression (Calcite's RexNode json) against a row.
+type ExprContext struct {
+	RefList  map[string]interface{} // "$N" => column name
+	Schema   map[string]ColumnType
+	Now      time.Time
+	Excluded *RowV2 // row proposed by an upsert, read through EXCLUDED.col
+}
+
+func NewExprContext(refList map[string]interface{}, schema map[string]ColumnType) *ExprContext {
+	return &ExprContext{RefList: refList, Schema: schema, Now: time.Now().UTC()}
+}
+
+// EvalPredicate evaluates a boolean expression, nulls are treated as false.
+func EvalPredicate(expr interface{}, row *RowV2, ectx *ExprContext) (bool, error) {
+	if expr == nil {
+		return true, nil
+	}
+
+	d, err := EvalExpr(expr, row, ectx)
+	if err != nil {
+		return false, err
+	}
+
+	return datumTruth(d), nil
+}
+
+func datumTruth(d Datum) bool {
+	switch d.Kind {
+	case KindBool:
+		return d.Bool
+	case KindNumber:
+		return d.Num != 0
+	case KindString:
+		b, err := strconv.ParseBool(strings.ToLower(d.Str))
+		return err == nil && b
+	default:
+		return false
+	}
+}
+
+// EvalExpr evaluates the three node shapes the planner produces:
+// input references {"input": N, "name": "$N"} (or {"column": "Age"}),
+// literals {"literal": X, "type": {...}} and calls {"op": {...}, "operands": [...]}.
+func EvalExpr(expr interface{}, row *RowV2, ectx *ExprContext) (Datum, error) {
+	node, ok := expr.(map[string]interface{})
+	if !ok {
+		return literalDatum(expr, "")
+	}
+
+	if _, isLiteral := node["literal"]; isLiteral {
+		return literalDatum(node["literal"], exprTypeName(node))
+	}
+
+	if _, isCall := node["op"]; isCall {
+		return evalCall(node, row, ectx)
+	}
+
+	colName, err := resolveColumn(node, ectx)
+	if err != nil {
+		return Datum{}, err
+	}
+
+	if qualifier, ok := node["qualifier"].(string); ok && strings.EqualFold(qualifier, "EXCLUDED") {
+		if ectx == nil || ectx.Excluded == nil {
+			return Datum{}, fmt.Errorf("EXCLUDED.%s used outside of ON CONFLICT", colName)
+		}
+		return columnDatum(colName, ectx.Excluded, ectx), nil
+	}
+
+	return columnDatum(colName, row, ectx), nil
+}
+
+func resolveColumn(node map[string]interface{}, ectx *ExprContext) (string, error) {
+	if col, ok := node["column"].(string); ok {
+		return strings.ReplaceAll(col, "`", ""), nil
+	}
+
+	name, ok := node["name"].(string)
+	if !ok {
+		return "", fmt.Errorf("unsupported expression node: %v", node)
+	}
+
+	if ectx != nil && ectx.RefList != nil {
+		if colName, ok := ectx.RefList[name].(string); ok {
+			return colName, nil
+		}
+	}
+
+	return name, nil
+}
+
+func columnDatum(colName string, row *RowV2, ectx *ExprContext) Datum {
+	if row == nil {
+		return NullDatum()
+	}
+
+	val, ok := row.Values[colName]
+	if !ok {
+		return NullDatum()
+	}
+
+	var colType string
+	if ectx != nil && ectx.Schema != nil {
+		colType = ectx.Schema[colName].Type
+	}
+
+	return DatumFromText(val, colType)
+}
+
+func exprTypeName(node map[string]interface{}) string {
+	typeMap, ok := node["type"].(map[string]interface{})
+	if !ok {
+		if typeStr, ok := node["type"].(string); ok {
+			return typeStr
+		}
+		return ""
+	}
+
+	typeName, _ := typeMap["type"].(string)
+	return typeName
+}
+
+func literalDatum(value interface{}, typeName string) (Datum, error) {
+	if value == nil {
+		return NullDatum(), nil
+	}
+
+	t := strings.ToUpper(typeName)
+
+	switch v := value.(type) {
+	case bool:
+		return BoolDatum(v), nil
+	case float64:
+		switch {
+		case t == TYPE_DATE:
+			return DateDatum(time.Unix(int64(v)*86400, 0)), nil
+		case strings.HasPrefix(t, TYPE_TIMESTAMP):
+			return TimestampDatum(time.UnixMilli(int64(v))), nil
+		case strings.HasPrefix(t, TYPE_INTERVAL):
+			return IntervalDatum(intervalFromLiteral(v, t)), nil
+		}
+		return FloatDatum(v), nil
+	case string:
+		switch {
+		case t == "" || t == "CHAR" || t == "VARCHAR" || t == "SYMBOL":
+			return StringDatum(v), nil
+		case IsNumericType(t):
+			return NumberDatum(v)
+		case strings.HasPrefix(t, TYPE_INTERVAL):
+			if num, err := strconv.ParseFloat(v, 64); err == nil {
+				return IntervalDatum(intervalFromLiteral(num, t)), nil
+			}
+		}
+		return CastDatum(StringDatum(v), t)
+	default:
+		return StringDatum(fmt.Sprintf("%v", v)), nil
+	}
+}
+
+// Calcite encodes year-month intervals in months and day-time intervals in milliseconds.
+func intervalFromLiteral(v float64, typeName string) Interval {
+	if strings.Contains(typeName, "YEAR") || strings.Contains(typeName, "MONTH") {
+		return Interval{Months: int32(v)}
+	}
+
+	millis := int64(v)
+	days := millis / (24 * 3600 * 1000)
+	return Interval{Days: int32(days), Micros: (millis - days*24*3600*1000) * 1000}
+}
+
+func evalCall(node map[string]interface{}, row *RowV2, ectx *ExprContext) (Datum, error) {
+	opMap, _ := node["op"].(map[string]interface{})
+	kind, _ := opMap["kind"].(string)
+	name, _ := opMap["name"].(string)
+	operands, _ := node["operands"].([]interface{})
+
+	switch kind {
+	case "AND":
+		for _, operand := range operands {
+			matched, err := EvalPredicate(operand, row, ectx)
+			if err != nil || !matched {
+				return BoolDatum(false), err
+			}
+		}
+		return BoolDatum(true), nil
+	case "OR":
+		for _, operand := range operands {
+			matched, err := EvalPredicate(operand, row, ectx)
+			if err != nil {
+				return Datum{}, err
+			}
+			if matched {
+				return BoolDatum(true), nil
+			}
+		}
+		return BoolDatum(false), nil
+	}
+
+	args := make([]Datum, len(operands))
+	for i, operand := range operands {
+		d, err := EvalExpr(operand, row, ectx)
+		if err != nil {
+			return Datum{}, err
+		}
+		args[i] = d
+	}
+
+	switch kind {
+	case "NOT":
+		return BoolDatum(!datumTruth(args[0])), nil
+	case "IS_NULL":
+		return BoolDatum(args[0].IsNull()), nil
+	case "IS_NOT_NULL":
+		return BoolDatum(!args[0].IsNull()), nil
+	case "IS_TRUE":
+		return BoolDatum(datumTruth(args[0])), nil
+	case "IS_FALSE":
+		return BoolDatum(!args[0].IsNull() && !datumTruth(args[0])), nil
+	case "EQUALS", "NOT_EQUALS", "GREATER_THAN", "GREATER_THAN_OR_EQUAL", "LESS_THAN", "LESS_THAN_OR_EQUAL":
+		return compareCall(kind, args)
+	case "BETWEEN":
+		if len(args) != 3 {
+			return Datum{}, fmt.Errorf("BETWEEN expects 3 operands, got: %d", len(args))
+		}
+		lower, err := compareCall("GREATER_THAN_OR_EQUAL", args[:2])
+		if err != nil || lower.IsNull() || !lower.Bool {
+			return lower, err
+		}
+		return compareCall("LESS_THAN_OR_EQUAL", []Datum{args[0], args[2]})
+	case "IN", "NOT_IN":
+		found := false
+		for _, candidate := range args[1:] {
+			cmp, err := CompareDatums(args[0], candidate)
+			if err == nil && cmp == 0 && !candidate.IsNull() {
+				found = true
+				break
+			}
+		}
+		return BoolDatum(found == (kind == "IN")), nil
+	case "LIKE":
+		return BoolDatum(matchLike(args[0].String(), args[1].String())), nil
+	case "CAST":
+		return CastDatum(args[0], exprTypeName(node))
+	case "MINUS_PREFIX":
+		return negateDatum(args[0])
+	case "PLUS_PREFIX":
+		return args[0], nil
+	case "PLUS", "MINUS", "TIMES", "DIVIDE", "MOD":
+		return arithmetic(kind, args)
+	case "EXTRACT":
+		return extractCall(args)
+	}
+
+	return evalFunction(strings.ToUpper(name), args, node, ectx)
+}
+
+func compareCall(kind string, args []Datum) (Datum, error) {
+	if len(args) != 2 {
+		return Datum{}, fmt.Errorf("%s expects 2 operands, got: %d", kind, len(args))
+	}
+
+	// unknown, a WHERE drops the row but a CHECK lets it through
+	if args[0].IsNull() || args[1].IsNull() {
+		return NullDatum(), nil
+	}
+
+	cmp, err := CompareDatums(args[0], args[1])
+	if err != nil {
+		return Datum{}, fmt.Errorf("compare failed: %w", err)
+	}
+
+	switch kind {
+	case "EQUALS":
+		return BoolDatum(cmp == 0), nil
+	case "NOT_EQUALS":
+		return BoolDatum(cmp != 0), nil
+	case "GREATER_THAN":
+		return BoolDatum(cmp > 0), nil
+	case "GREATER_THAN_OR_EQUAL":
+		return BoolDatum(cmp >= 0), nil
+	case "LESS_THAN":
+		return BoolDatum(cmp < 0), nil
+	default:
+		return BoolDatum(cmp <= 0), nil
+	}
+}
+
+func negateDatum(d Datum) (Datum, error) {
+	switch d.Kind {
+	case KindNull:
+		return d, nil
+	case KindInterval:
+		return IntervalDatum(Interval{Months: -d.Interval.Months, Days: -d.Interval.Days, Micros: -d.Interval.Micros}), nil
+	default:
+		num, err := CastDatum(d, TYPE_DECIMAL)
+		if err != nil {
+			return Datum{}, err
+		}
+		if isIntegerText(num.Str) {
+			if v, err := strconv.ParseInt(num.Str, 10, 64); err == nil {
+				return IntDatum(-v), nil
+			}
+		}
+		return FloatDatum(-num.Num), nil
+	}
+}
+
+func arithmetic(kind string, args []Datum) (Datum, error) {
+	if len(args) != 2 {
+		return Datum{}, fmt.Errorf("%s expects 2 operands, got: %d", kind, len(args))
+	}
+
+	left, right := args[0], args[1]
+	if left.IsNull() || right.IsNull() {
+		return NullDatum(), nil
+	}
+
+	if isTemporal(left) || isTemporal(right) || left.Kind == KindInterval || right.Kind == KindInterval {
+		return dateArithmetic(kind, left, right)
+	}
+
+	left, err := CastDatum(left, TYPE_DECIMAL)
+	if err != nil {
+		return Datum{}, err
+	}
+
+	right, err = CastDatum(right, TYPE_DECIMAL)
+	if err != nil {
+		return Datum{}, err
+	}
+
+	if isIntegerText(left.Str) && isIntegerText(right.Str) && kind != "DIVIDE" {
+		l, errL := strconv.ParseInt(left.Str, 10, 64)
+		r, errR := strconv.ParseInt(right.Str, 10, 64)
+		if errL == nil && errR == nil {
+			switch kind {
+			case "PLUS":
+				return IntDatum(l + r), nil
+			case "MINUS":
+				return IntDatum(l - r), nil
+			case "TIMES":
+				return IntDatum(l * r), nil
+			case "MOD":
+				if r == 0 {
+					return Datum{}, fmt.Errorf("division by zero")
+				}
+				return IntDatum(l % r), nil
+			}
+		}
+	}
+
+	switch kind {
+	case "PLUS":
+		return FloatDatum(left.Num + right.Num), nil
+	case "MINUS":
+		return FloatDatum(left.Num - right.Num), nil
+	case "TIMES":
+		return FloatDatum(left.Num * right.Num), nil
+	case "MOD":
+		if right.Num == 0 {
+			return Datum{}, fmt.Errorf("division by zero")
+		}
+		return FloatDatum(math.Mod(left.Num, right.Num)), nil
+	default:
+		if right.Num == 0 {
+			return Datum{}, fmt.Errorf("division by zero")
+		}
+		return FloatDatum(left.Num / right.Num), nil
+	}
+}
+
+func isTemporal(d Datum) bool {
+	return d.Kind == KindDate || d.Kind == KindTimestamp
+}
+
+func dateArithmetic(kind string, left, right Datum) (Datum, error) {
+	var err error
+
+	// strings coming from VARCHAR columns or untyped literals
+	if left.Kind == KindString {
+		left, err = CastDatum(left, TYPE_TIMESTAMP)
+		if err != nil {
+			return Datum{}, err
+		}
+	}
+
+	if right.Kind == KindString {
+		if isTemporal(left) && kind == "PLUS" {
+			right, err = CastDatum(right, TYPE_INTERVAL)
+		} else if isTemporal(left) {
+			if iv, ivErr := ParseInterval(right.Str); ivErr == nil {
+				right = IntervalDatum(iv)
+			} else {
+				right, err = CastDatum(right, TYPE_TIMESTAMP)
+			}
+		}
+		if err != nil {
+			return Datum{}, err
+		}
+	}
+
+	switch {
+	case isTemporal(left) && right.Kind == KindInterval && (kind == "PLUS" || kind == "MINUS"):
+		iv := right.Interval
+		if kind == "MINUS" {
+			iv = Interval{Months: -iv.Months, Days: -iv.Days, Micros: -iv.Micros}
+		}
+		return addInterval(left, iv), nil
+	case left.Kind == KindInterval && isTemporal(right) && kind == "PLUS":
+		return addInterval(right, left.Interval), nil
+	case isTemporal(left) && isTemporal(right) && kind == "MINUS":
+		diff := left.Time.Sub(right.Time)
+		days := int64(diff / (24 * time.Hour))
+		return IntervalDatum(Interval{Days: int32(days), Micros: int64(diff/time.Microsecond) - days*MICROS_PER_DAY}), nil
+	case left.Kind == KindInterval && right.Kind == KindInterval && (kind == "PLUS" || kind == "MINUS"):
+		sign := int32(1)
+		if kind == "MINUS" {
+			sign = -1
+		}
+		return IntervalDatum(Interval{
+			Months: left.Interval.Months + sign*right.Interval.Months,
+			Days:   left.Interval.Days + sign*right.Interval.Days,
+			Micros: left.Interval.Micros + int64(sign)*right.Interval.Micros,
+		}), nil
+	case left.Kind == KindInterval && (kind == "TIMES" || kind == "DIVIDE"):
+		factor, err := CastDatum(right, TYPE_DECIMAL)
+		if err != nil {
+			return Datum{}, err
+		}
+		f := factor.Num
+		if kind == "DIVIDE" {
+			if f == 0 {
+				return Datum{}, fmt.Errorf("division by zero")
+			}
+			f = 1 / f
+		}
+		return IntervalDatum(Interval{
+			Months: int32(float64(left.Interval.Months) * f),
+			Days:   int32(float64(left.Interval.Days) * f),
+			Micros: int64(float64(left.Interval.Micros) * f),
+		}), nil
+	}
+
+	return Datum{}, fmt.Errorf("unsupported date arithmetic: %s %s %s", kindTypeName(left.Kind), kind, kindTypeName(right.Kind))
+}
+
+func addInterval(d Datum, iv Interval) Datum {
+	t := addMonths(d.Time, int(iv.Months)).AddDate(0, 0, int(iv.Days)).Add(time.Duration(iv.Micros) * time.Microsecond)
+	if d.Kind == KindDate && iv.Micros == 0 {
+		return DateDatum(t)
+	}
+	return TimestampDatum(t)
+}
+
+// addMonths clamps to the end of the month like sql does, 2024-01-31 + 1 month => 2024-02-29.
+func addMonths(t time.Time, months int) time.Time {
+	if months == 0 {
+		return t
+	}
+
+	firstOfMonth := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	target := firstOfMonth.AddDate(0, months, 0)
+	lastDay := target.AddDate(0, 1, -1).Day()
+
+	return target.AddDate(0, 0, min(t.Day(), lastDay)-1)
+}
+
+func extractCall(args []Datum) (Datum, error) {
+	if len(args) != 2 {
+		return Datum{}, fmt.Errorf("EXTRACT expects 2 operands, got: %d", len(args))
+	}
+
+	unit, source := args[0], args[1]
+	if unit.Kind != KindString {
+		unit, source = source, unit
+	}
+
+	return ExtractField(unit.Str, source)
+}
+
+// ExtractField implements EXTRACT(unit FROM x) / DATE_PART(unit, x).
+func ExtractField(unit string, source Datum) (Datum, error) {
+	if source.IsNull() {
+		return source, nil
+	}
+
+	if source.Kind == KindInterval {
+		iv := source.Interval
+		switch normalizeUnit(unit) {
+		case "YEAR":
+			return IntDatum(int64(iv.Months / 12)), nil
+		case "MONTH":
+			return IntDatum(int64(iv.Months % 12)), nil
+		case "DAY":
+			return IntDatum(int64(iv.Days)), nil
+		case "HOUR":
+			return IntDatum(iv.Micros / int64(time.Hour/time.Microsecond)), nil
+		case "MINUTE":
+			return IntDatum(iv.Micros % int64(time.Hour/time.Microsecond) / int64(time.Minute/time.Microsecond)), nil
+		case "SECOND":
+			return FloatDatum(float64(iv.Micros%int64(time.Minute/time.Microsecond)) / 1e6), nil
+		case "EPOCH":
+			return FloatDatum(float64(intervalMicros(iv)) / 1e6), nil
+		}
+		return Datum{}, fmt.Errorf("unsupported interval field: %s", unit)
+	}
+
+	if !isTemporal(source) {
+		converted, err := CastDatum(source, TYPE_TIMESTAMP)
+		if err != nil {
+			return Datum{}, err
+		}
+		source = converted
+	}
+
+	t := source.Time
+	switch normalizeUnit(unit) {
+	case "YEAR":
+		return IntDatum(int64(t.Year())), nil
+	case "QUARTER":
+		return IntDatum(int64((int(t.Month())-1)/3 + 1)), nil
+	case "MONTH":
+		return IntDatum(int64(t.Month())), nil
+	case "WEEK":
+		_, week := t.ISOWeek()
+		return IntDatum(int64(week)), nil
+	case "DAY":
+		return IntDatum(int64(t.Day())), nil
+	case "DOW":
+		return IntDatum(int64(t.Weekday())), nil
+	case "DOY":
+		return IntDatum(int64(t.YearDay())), nil
+	case "HOUR":
+		return IntDatum(int64(t.Hour())), nil
+	case "MINUTE":
+		return IntDatum(int64(t.Minute())), nil
+	case "SECOND":
+		return FloatDatum(float64(t.Second()) + float64(t.Nanosecond())/1e9), nil
+	case "MILLISECOND":
+		return IntDatum(int64(t.Second())*1000 + int64(t.Nanosecond()/1e6)), nil
+	case "MICROSECOND":
+		return IntDatum(int64(t.Second())*1e6 + int64(t.Nanosecond()/1e3)), nil
+	case "EPOCH":
+		return FloatDatum(float64(t.UnixMicro()) / 1e6), nil
+	}
+
+	return Datum{}, fmt.Errorf("unsupported extract field: %s", unit)
+}
+
+func normalizeUnit(unit string) string {
+	u := strings.ToUpper(strings.Trim(strings.TrimSpace(unit), "'"))
+	u = strings.TrimSuffix(u, "S")
+
+	switch u {
+	case "DAYOFWEEK":
+		return "DOW"
+	case "DAYOFYEAR":
+		return "DOY"
+	case "ISOWEEK":
+		return "WEEK"
+	}
+
+	return u
+}
+
+// DateTrunc implements DATE_TRUNC(unit, x), the result keeps the kind of x.
+func DateTrunc(unit string, source Datum) (Datum, error) {
+	if source.IsNull() {
+		return source, nil
+	}
+
+	if !isTemporal(source) {
+		converted, err := CastDatum(source, TYPE_TIMESTAMP)
+		if err != nil {
+			return Datum{}, err
+		}
+		source = converted
+	}
+
+	t := source.Time
+	var truncated time.Time
+
+	switch normalizeUnit(unit) {
+	case "YEAR":
+		truncated = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case "QUARTER":
+		truncated = time.Date(t.Year(), time.Month((int(t.Month())-1)/3*3+1), 1, 0, 0, 0, 0, time.UTC)
+	case "MONTH":
+		truncated = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "WEEK":
+		offset := (int(t.Weekday()) + 6) % 7 // weeks start on monday
+		truncated = time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+	case "DAY":
+		truncated = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case "HOUR":
+		truncated = t.Truncate(time.Hour)
+	case "MINUTE":
+		truncated = t.Truncate(time.Minute)
+	case "SECOND":
+		truncated = t.Truncate(time.Second)
+	default:
+		return Datum{}, fmt.Errorf("unsupported date_trunc unit: %s", unit)
+	}
+
+	if source.Kind == KindDate {
+		return DateDatum(truncated), nil
+	}
+
+	return TimestampDatum(truncated), nil
+}
+
+func evalFunction(name string, args []Datum, node map[string]interface{}, ectx *ExprContext) (Datum, error) {
+	now := time.Now().UTC()
+	if ectx != nil && !ectx.Now.IsZero() {
+		now = ectx.Now
+	}
+
+	switch name {
+	case "NOW", "CURRENT_TIMESTAMP", "LOCALTIMESTAMP":
+		return TimestampDatum(now), nil
+	case "CURRENT_DATE":
+		return DateDatum(now), nil
+	case "DATE_TRUNC", "TIMESTAMP_TRUNC", "DATETIME_TRUNC":
+		if len(args) != 2 {
+			return Datum{}, fmt.Errorf("%s expects 2 operands, got: %d", name, len(args))
+		}
+		// postgres: DATE_TRUNC('day', ts), bigquery: DATE_TRUNC(ts, DAY)
+		if args[0].Kind == KindString && (isTemporal(args[1]) || args[1].Kind == KindString) && !looksTemporal(args[0].Str) {
+			return DateTrunc(args[0].Str, args[1])
+		}
+		return DateTrunc(args[1].String(), args[0])
+	case "DATE_PART":
+		if len(args) != 2 {
+			return Datum{}, fmt.Errorf("%s expects 2 operands, got: %d", name, len(args))
+		}
+		return ExtractField(args[0].String(), args[1])
+	case "DATETIME_PLUS":
+		return dateArithmetic("PLUS", args[0], args[1])
+	case "DATETIME_MINUS", "MINUS_DATE":
+		return dateArithmetic("MINUS", args[0], args[1])
+	case "DATE":
+		if len(args) != 1 {
+			return Datum{}, fmt.Errorf("%s expects 1 operand, got: %d", name, len(args))
+		}
+		return CastDatum(args[0], TYPE_DATE)
+	case "TIMESTAMP":
+		if len(args) != 1 {
+			return Datum{}, fmt.Errorf("%s expects 1 operand, got: %d", name, len(args))
+		}
+		return CastDatum(args[0], TYPE_TIMESTAMP)
+	case "JSON_VALUE", "JSON_QUERY", "JSON_LENGTH", "JSON_ARRAY_LENGTH", "JSON_TYPE", "JSON_TYPEOF":
+		return jsonFunction(name, args)
+	case "->":
+		return jsonFunction("JSON_QUERY", args)
+	case "->>":
+		return jsonFunction("JSON_VALUE", args)
+	case "JSON_VALUE_EXPRESSION", "FORMAT JSON":
+		return args[0], nil
+	case "UPPER":
+		return StringDatum(strings.ToUpper(args[0].String())), nil
+	case "LOWER":
+		return StringDatum(strings.ToLower(args[0].String())), nil
+	case "||", "CONCAT":
+		var sb strings.Builder
+		for _, arg := range args {
+			sb.WriteString(arg.String())
+		}
+		return StringDatum(sb.String()), nil
+	case "COALESCE":
+		for _, arg := range args {
+			if !arg.IsNull() {
+				return arg, nil
+			}
+		}
+		return NullDatum(), nil
+	}
+
+	return Datum{}, fmt.Errorf("unsupported function: %s", name)
+}
+
+func looksTemporal(s string) bool {
+	_, err := ParseTimestamp(s)
+	return err == nil
+}
+
+// matchLike implements SQL LIKE with % and _ wildcards.
+func matchLike(value, pattern string) bool {
+	v, p := []rune(value), []rune(pattern)
+
+	var match func(i, j int) bool
+	match = func(i, j int) bool {
+		for j < len(p) {
+			switch p[j] {
+			case '%':
+				for k := i; k <= len(v); k++ {
+					if match(k, j+1) {
+						return true
+					}
+				}
+				return false
+			case '_':
+				if i >= len(v) {
+					return false
+				}
+			default:
+				if i >= len(v) || v[i] != p[j] {
+					return false
+				}
+			}
+			i++
+			j++
+		}
+		return i == len(v)
+	}
+
+	return match(0, 0)
+}
@@ -8,10 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net"
 	"os"
 	"reflect"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,99 +23,171 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func prepareRows(plan map[string]interface{}, selectedCols []interface{}, primary, tableName, txID string, wal *WalManager, transactionOff bool) (uint16, [][]byte, error) {
-	var bytesNeeded uint16
+func prepareRows(rows []map[string]string, primary, tableName string, tableStats *TableInfo, txID string, wal *WalManager, transactionOff bool) ([][]byte, error) {
 	var encodedRows [][]byte
 
-	interfaceRows := plan["rows"].([]interface{})
-
-	for _, row := range interfaceRows {
-		newRow := RowV2{
-			ID:     GenerateRandomID(),
-			Values: make(map[string]string),
+	for _, values := range rows {
+		encodedRow, err := prepareRow(values, primary, tableName, tableStats, txID, wal, transactionOff)
+		if err != nil {
+			return nil, err
 		}
 
-		//#Add row values
+		encodedRows = append(encodedRows, encodedRow)
+	}
+
+	return encodedRows, nil
+}
+
+// prepareRow encodes the values and logs the insert, rows without a primary
+// key get the random id of the tuple. Tables without one (primary "") keep
+// the values as given.
+func prepareRow(values map[string]string, primary, tableName string, tableStats *TableInfo, txID string, wal *WalManager, transactionOff bool) ([]byte, error) {
+	newRow := RowV2{
+		ID:     GenerateRandomID(),
+		Values: values,
+	}
+
+	if primary != "" && newRow.Values[primary] == "" {
 		newRow.Values[primary] = strconv.FormatUint(newRow.ID, 10)
-		for i, rowVal := range row.([]any) {
-			strRowVal := strings.ReplaceAll(rowVal.(string), "'", "")
-			strRowCol := selectedCols[i].(string)
+	}
 
-			newRow.Values[strRowCol] = strRowVal
-		}
+	if err := EncodeStoredValues(&newRow, tableStats); err != nil {
+		return nil, fmt.Errorf("EncodeStoredValues failed: %w", err)
+	}
 
-		buff := BufferAllocator() // ## TODO - POSSIBLE CHANGE
-		encodedRow, err := EncodeRow(&newRow, buff.(*bytes.Buffer))
+	buff := BufferAllocator() // ## TODO - POSSIBLE CHANGE
+	encodedRow, err := EncodeRow(&newRow, buff.(*bytes.Buffer))
+	if err != nil {
+		return nil, fmt.Errorf("encodeRow failed: %w", err)
+	}
+
+	if !transactionOff {
+		err = wal.Log(txID, LogTypeInsert, tableName, newRow.ID, nil, encodedRow)
 		if err != nil {
-			return 0, nil, fmt.Errorf("encodeRow failed: %w", err)
+			return nil, fmt.Errorf("wal.log failed: %w", err)
+		}
+	}
+
+	return encodedRow, nil
+}
+
+// findAndUpdate spreads the rows over as many pages as needed, pages with
+// free space are filled first and a new page is only created once no
+// existing page can take the next row. With usable set only the pages it
+// accepts are filled.
+func findAndUpdate(bufferM *BufferPoolManager, tableObj *TableObj, tableStats *TableInfo, tableName string, encodedRows [][]byte, usable func(PageID) bool) (err error) {
+	// large values go to the overflow pages as their row is placed, the
+	// callers keep the full images. A row is placed once its page is written,
+	// the chains of the ones that weren't are freed when this fails, the
+	// rollback doesn't see them.
+	images := encodedRows
+	toasted := make([][]byte, 0, len(images))
+	added := 0
+
+	defer func() {
+		if err == nil {
+			return
 		}
 
-		if !transactionOff {
-			err = wal.Log(txID, LogTypeInsert, tableName, newRow.ID, nil, encodedRow)
-			if err != nil {
-				return 0, nil, fmt.Errorf("wal.log failed: %w", err)
+		for _, tuple := range toasted[added:] {
+			if freeErr := freeToasted(tuple, tableObj.Toast); freeErr != nil {
+				logger.Log.WithField("table", tableName).Errorf("freeing the overflow chains failed: %v", freeErr)
 			}
 		}
+	}()
 
-		bytesNeeded += uint16(len(encodedRow))
-		encodedRows = append(encodedRows, encodedRow)
+	toast := func(i int) ([]byte, error) {
+		if i == len(toasted) {
+			tuple, err := toastRow(images[i], tableObj.Toast)
+			if err != nil {
+				return nil, fmt.Errorf("toastRow failed: %w", err)
+			}
+			toasted = append(toasted, tuple)
+		}
+		return toasted[i], nil
 	}
 
-	return bytesNeeded, encodedRows, nil
-}
+	for added < len(images) {
+		first, err := toast(added)
+		if err != nil {
+			return err
+		}
 
-func findAndUpdate(bufferM *BufferPoolManager, tableObj *TableObj, tableStats *TableInfo, bytesNeeded uint16, tableName string, encodedRows [][]byte) error {
-	page, err := getAvailablePage(bufferM, tableObj, bytesNeeded, tableName) // new page could've been created
-	if err != nil {
-		return fmt.Errorf("getAvailablePage failed: %w", err)
-	}
+		rowLen := len(first)
+		if rowLen >= AVAIL_DATA {
+			return fmt.Errorf("row of %d bytes doesn't fit in a page", rowLen)
+		}
 
-	newSpace := FreeSpace{
-		PageID:     PageID(page.Header.ID),
-		FreeMemory: page.Header.UpperPtr - page.Header.LowerPtr, //assuming new page
-	}
+		page, err := getAvailablePage(bufferM, tableObj, uint16(rowLen), tableName, usable) // new page could've been created
+		if err != nil {
+			return fmt.Errorf("getAvailablePage failed: %w", err)
+		}
+		firstSlot := tableObj.slotCount(PageID(page.Header.ID))
 
-	tableObj.DirectoryPage.Mu.RLock()
-	pageInfoObj, ok := tableObj.DirectoryPage.Value[PageID(page.Header.ID)]
-	tableObj.DirectoryPage.Mu.RUnlock()
-	if ok {
-		pageInfoObj.Mu.RLock()
-		newSpace.FreeMemory = pageInfoObj.ExactFreeMem
-		pageInfoObj.Mu.RUnlock()
-	}
+		placed := 0
+		for added+placed < len(images) {
+			tuple, err := toast(added + placed)
+			if err != nil {
+				return err
+			}
+
+			if page.Header.UpperPtr-page.Header.LowerPtr <= uint16(len(tuple)) {
+				break
+			}
+
+			if err := page.AddTuple(tuple, "findAndUpdate"); err != nil {
+				return fmt.Errorf("AddTuple failed: %w", err)
+			}
+			placed++
+		}
+		batch := images[added : added+placed]
+
+		rows := make([]map[string]string, placed)
+		for i, image := range batch {
+			if rows[i], err = decodeRowValues(image, tableStats); err != nil {
+				return err
+			}
+		}
+
+		// the filters and zones first, a page holding a value they miss would be skipped
+		if err := tableObj.addBlooms(rows, tableStats, PageID(page.Header.ID), firstSlot); err != nil {
+			return fmt.Errorf("addBlooms failed: %w", err)
+		}
+		existing := tableObj.widenZones(rows, tableStats, PageID(page.Header.ID), firstSlot)
 
-	for _, encodedRow := range encodedRows {
-		newSpace.FreeMemory -= uint16(len(encodedRow))
-		err := page.AddTuple(encodedRow, "findAndUpdate")
+		logger.Log.WithFields(logrus.Fields{"page": page.Header.ID, "rows": placed}).Info("saving page to disk (created / existing)")
+		err = UpdatePageInfo(page, tableObj, tableStats, bufferM.DiskManager, ADDING) // make sure to save possible new page (this is updating even already existing pages)
 		if err != nil {
-			return fmt.Errorf("AddTuple failed: %w", err)
+			return fmt.Errorf("UpdatePageInfo failed: %w", err)
 		}
-	}
+		added += placed
 
-	logger.Log.Info("saving page to disk (created / existing)")
-	err = UpdatePageInfo(page, tableObj, tableStats, bufferM.DiskManager, ADDING) // make sure to save possible new page (this is updating even already existing pages)
-	if err != nil {
-		return fmt.Errorf("UpdatePageInfo failed: %v", page)
-	}
+		if !existing {
+			// read for every scan until memSeparationSingle writes the directory with them
+			tableObj.widenZones(rows, tableStats, PageID(page.Header.ID), 0)
+		}
 
-	logger.Log.WithFields(logrus.Fields{"newSpace": newSpace}).Info("memSeparationSingle input")
-	err = memSeparationSingle(&newSpace, tableObj, tableStats) // safe to do memory separation
-	if err != nil {
-		return fmt.Errorf("memSeparationSingle failed: %v", page)
-	}
+		if err := tableObj.indexRows(batch, tableStats, PageID(page.Header.ID), firstSlot); err != nil {
+			return fmt.Errorf("indexRows failed: %w", err)
+		}
 
-	return nil
-}
+		// the page left the free space mapping in getAvailablePage
+		newSpace := FreeSpace{
+			PageID:     PageID(page.Header.ID),
+			FreeMemory: page.Header.UpperPtr - page.Header.LowerPtr,
+		}
 
-func isPrimary(key string, tableName string, catalog *Catalog) (bool, error) {
-	tableInfo, ok := catalog.Tables[tableName]
-	if !ok {
-		return false, fmt.Errorf("table: %s doesn't exist", tableName)
-	}
+		err = memSeparationSingle(&newSpace, tableObj, tableStats) // safe to do memory separation
+		if err != nil {
+			return fmt.Errorf("memSeparationSingle failed: %w", err)
+		}
 
-	columnInfo := tableInfo.Schema[key]
+		if placed == 0 {
+			return fmt.Errorf("page: %d has no room for a row of %d bytes", page.Header.ID, rowLen)
+		}
+	}
 
-	return columnInfo.IsIndex, nil
+	return nil
 }
 
 func checkPresenceGetPrimary(selectedCols []interface{}, tableName string, catalog *Catalog) (string, error) {
@@ -148,7 +223,53 @@ func checkPresenceGetPrimary(selectedCols []interface{}, tableName string, catal
 	return primary, nil
 }
 
-func processPagesForDeletion(ctx context.Context, lm *LockManager, pages chan *PageV2, updateInfoChan chan *ModifiedInfo, deleteKey, deleteVal, txID string, isPrimary bool, tableObj *TableObj, wal *WalManager, txOff bool) error {
+// primaryEquality reports whether the condition is `primary = literal`,
+// a delete like that can stop at the first match.
+func primaryEquality(condition interface{}, primary string) bool {
+	node, ok := condition.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	opMap, _ := node["op"].(map[string]interface{})
+	operands, _ := node["operands"].([]interface{})
+	if opMap == nil || opMap["kind"] != "EQUALS" || len(operands) != 2 {
+		return false
+	}
+
+	isPrimaryRef := func(operand interface{}) bool {
+		ref, ok := operand.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		col, ok := ref["column"].(string)
+		return ok && strings.ReplaceAll(col, "`", "") == primary
+	}
+
+	return (isPrimaryRef(operands[0]) && isConstant(operands[1])) || (isPrimaryRef(operands[1]) && isConstant(operands[0]))
+}
+
+// isConstant reports whether the operand is a literal, possibly under casts.
+func isConstant(operand interface{}) bool {
+	for {
+		node, ok := operand.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, isLiteral := node["literal"]; isLiteral {
+			return true
+		}
+
+		opMap, _ := node["op"].(map[string]interface{})
+		args, _ := node["operands"].([]interface{})
+		if opMap == nil || opMap["kind"] != "CAST" || len(args) != 1 {
+			return false
+		}
+		operand = args[0]
+	}
+}
+
+func processPagesForDeletion(ctx context.Context, lm *LockManager, pages chan *PageV2, updateInfoChan chan *ModifiedInfo, match func(row *RowV2) (bool, error), txID string, singleRow bool, deleted func(rowBytes []byte), tableObj *TableObj, tableStats *TableInfo, wal *WalManager, txOff bool) error {
 	defer close(updateInfoChan)
 
 	var foundMatch bool
@@ -157,7 +278,7 @@ func processPagesForDeletion(ctx context.Context, lm *LockManager, pages chan *P
 			return ctx.Err()
 		}
 
-		if isPrimary && foundMatch {
+		if singleRow && foundMatch {
 			break
 		}
 
@@ -183,14 +304,23 @@ func processPagesForDeletion(ctx context.Context, lm *LockManager, pages chan *P
 			var row RowV2
 			buf := bytes.NewReader(rowBytes)
 			DecodeRow(&row, buf)
+			if err := DecodeStoredValues(&row, tableStats); err != nil {
+				pageObj.Mu.Unlock()
+				return fmt.Errorf("DecodeStoredValues failed: %w", err)
+			}
 
 			lm.Lock(row.ID, &row, R)
-			deleteMatchFound := row.Values[deleteKey] == deleteVal
+			deleteMatchFound, matchErr := match(&row)
 			err := lm.Unlock(row.ID, &row, R)
 			if err != nil {
 				return fmt.Errorf("unlock failed: %w", err)
 			}
 
+			if matchErr != nil {
+				pageObj.Mu.Unlock()
+				return fmt.Errorf("match failed: %w", matchErr)
+			}
+
 			if deleteMatchFound {
 				if freeSpacePage == nil {
 					freeSpacePage = &FreeSpace{
@@ -199,17 +329,39 @@ func processPagesForDeletion(ctx context.Context, lm *LockManager, pages chan *P
 						FreeMemory:  pageObj.ExactFreeMem}
 				}
 
+				// rowBytes points into the page, the space is reused after the delete
+				image, err := detoastImage(rowBytes, tableObj.Toast)
+				if err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("detoastImage failed: %w", err)
+				}
+
 				if !txOff {
-					err = wal.Log(txID, LogTypeDelete, tableObj.TableName, row.ID, rowBytes, nil)
+					err = wal.Log(txID, LogTypeDelete, tableObj.TableName, row.ID, image, nil)
 					if err != nil {
 						return fmt.Errorf("wal.log failed: %w", err)
 					}
 				}
 
+				if deleted != nil {
+					deleted(image)
+				}
+
+				if err := tableObj.unindexRow(row.Values, RowLocation{PageID(page.Header.ID), uint16(i)}); err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("unindexRow failed: %w", err)
+				}
+
+				// undo re-inserts the logged image, the chains aren't needed anymore
+				if err := freeToasted(rowBytes, tableObj.Toast); err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("freeToasted failed: %w", err)
+				}
+
 				freeSpacePage.FreeMemory += location.Length
 				location.Free = true
 
-				if isPrimary {
+				if singleRow {
 					foundMatch = true
 					break
 				}
@@ -227,7 +379,7 @@ func processPagesForDeletion(ctx context.Context, lm *LockManager, pages chan *P
 }
 
 type NonAddedRows struct {
-	BytesNeeded uint16
+	BytesNeeded int
 	Rows        [][]byte
 }
 
@@ -236,7 +388,122 @@ type ModifiedInfo struct {
 	NonAddedRow      *NonAddedRows
 }
 
-func processPagesForUpdate(ctx context.Context, accountingCtx *MemoryContext, qe *QueryEngine, lm *LockManager, pageChan chan *PageV2, updateInfoChan chan *ModifiedInfo, updateKey, updateVal, filterKey, filterVal, txID string, tableObj *TableObj, wal *WalManager, txOff bool) error {
+// Assignment is one `column = expression` of the SET list.
+type Assignment struct {
+	Column string
+	Expr   interface{}
+}
+
+func parseAssignments(plan map[string]interface{}, primary string, tableStats *TableInfo) ([]Assignment, error) {
+	rawAssignments, ok := plan["assignments"].([]interface{})
+	if !ok || len(rawAssignments) == 0 {
+		return nil, fmt.Errorf("assignments missing")
+	}
+
+	var assignments []Assignment
+	for _, raw := range rawAssignments {
+		assignment, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid assignment: %v", raw)
+		}
+
+		column := strings.ReplaceAll(assignment["column"].(string), "`", "")
+		if _, ok := tableStats.Schema[column]; !ok {
+			return nil, fmt.Errorf("column: %s doesn't exist", column)
+		}
+
+		if column == primary {
+			return nil, fmt.Errorf("primary column: %s can't be updated", column)
+		}
+
+		assignments = append(assignments, Assignment{Column: column, Expr: assignment["expr"]})
+	}
+
+	return assignments, nil
+}
+
+// applyAssignments evaluates every expression against the old row before
+// writing any of them, so SET a = b, b = a swaps the values.
+func applyAssignments(row *RowV2, assignments []Assignment, ectx *ExprContext) error {
+	newValues := make([]Datum, len(assignments))
+	for i, assignment := range assignments {
+		d, err := EvalExpr(assignment.Expr, row, ectx)
+		if err != nil {
+			return fmt.Errorf("evaluating %s failed: %w", assignment.Column, err)
+		}
+		newValues[i] = d
+	}
+
+	for i, assignment := range assignments {
+		if newValues[i].IsNull() {
+			delete(row.Values, assignment.Column)
+			continue
+		}
+		row.Values[assignment.Column] = newValues[i].String()
+	}
+
+	return nil
+}
+
+// parseReturning reads the RETURNING list of a DML plan, nil means the
+// statement has none and * stands for every column of the table.
+func parseReturning(plan map[string]any, tableStats *TableInfo) ([]string, error) {
+	list, ok := plan["returning"].([]any)
+	if !ok {
+		return nil, nil
+	}
+
+	columns := []string{}
+	for _, col := range list {
+		name := strings.ReplaceAll(col.(string), "`", "")
+		if name == "*" {
+			columns = append(columns, slices.Sorted(maps.Keys(tableStats.Schema))...)
+			continue
+		}
+
+		if _, ok := tableStats.Schema[name]; !ok {
+			return nil, fmt.Errorf("RETURNING column: %s doesn't exist", name)
+		}
+		columns = append(columns, name)
+	}
+
+	return columns, nil
+}
+
+// returningResult decodes the row images written by a statement and keeps
+// the RETURNING columns, nulls stay absent.
+func returningResult(encodedRows [][]byte, tableStats *TableInfo, columns []string, msg string) Result {
+	res, err := ReturnPrimaryIds(encodedRows, tableStats)
+	if err != nil {
+		return handleError(fmt.Errorf("ReturnPrimaryIds failed: %w", err), "failed query")
+	}
+
+	for i, row := range res.Rows {
+		values := make(map[string]string, len(columns))
+		for _, col := range columns {
+			if val, ok := row.Values[col]; ok {
+				values[col] = val
+			}
+		}
+		res.Rows[i] = &RowV2{ID: row.ID, Values: values}
+	}
+
+	res.Msg = msg
+	return *res
+}
+
+// RowUpdater picks the rows of an update and rewrites them in place.
+type RowUpdater struct {
+	Match   func(row *RowV2) (bool, error)
+	Apply   func(row *RowV2) error
+	Updated func(rowBytes []byte) // optional, gets the new image of every rewritten row
+
+	Condition any // optional, the planner condition behind Match, lets an index narrow the scan
+
+	skipReferences bool // the new foreign keys aren't checked, set by the foreign key actions
+}
+
+func processPagesForUpdate(ctx context.Context, accountingCtx *MemoryContext, qe *QueryEngine, lm *LockManager, pageChan chan *PageV2, updateInfoChan chan *ModifiedInfo, updater RowUpdater, txID string, tableObj *TableObj, tableStats *TableInfo, wal *WalManager, txOff bool, pages *updatePages) error {
 	logger.Log.Info("processPagesForUpdate (start)")
 	defer close(updateInfoChan)
 
@@ -253,10 +520,15 @@ func processPagesForUpdate(ctx context.Context, accountingCtx *MemoryContext, qe
 			return ctx.Err()
 		}
 
-		freeSpacePage, updateInfo, nonAddedRows := GetAccountingObjs(accountingCtx)
-
 		pageId := PageID(page.Header.ID)
 
+		// a page created for the new versions, or already rearranged
+		if !pages.pending(pageId) {
+			continue
+		}
+
+		freeSpacePage, updateInfo, nonAddedRows := GetAccountingObjs(accountingCtx)
+
 		directoryPage := tableObj.DirectoryPage
 
 		directoryPage.Mu.RLock()
@@ -276,17 +548,24 @@ func processPagesForUpdate(ctx context.Context, accountingCtx *MemoryContext, qe
 			reader.Reset(*slice)
 
 			DecodeRow(row, reader)
+			if err := DecodeStoredValues(row, tableStats); err != nil {
+				pageObj.Mu.Unlock()
+				return fmt.Errorf("DecodeStoredValues failed: %w", err)
+			}
 
 			readerpObj.cleaner(reader)
 
 			lm.Lock(row.ID, row, R)
-			updateMatch := row.Values[filterKey] == filterVal //x
+			updateMatch, matchErr := updater.Match(row)
 			err := lm.Unlock(row.ID, row, R)
 			if err != nil {
 				return fmt.Errorf("unlock failed: %w", err)
 			}
 
-			fmt.Printf("Row: %+v\n", row)
+			if matchErr != nil {
+				pageObj.Mu.Unlock()
+				return fmt.Errorf("matching row failed: %w", matchErr)
+			}
 
 			if updateMatch {
 				if freeSpacePage.PageID == 0 {
@@ -295,29 +574,62 @@ func processPagesForUpdate(ctx context.Context, accountingCtx *MemoryContext, qe
 					freeSpacePage.FreeMemory = pageObj.ExactFreeMem
 				}
 
+				// the new version is indexed where findAndUpdate places it
+				if err := tableObj.unindexRow(row.Values, RowLocation{pageId, uint16(i)}); err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("unindexRow failed: %w", err)
+				}
+
 				lm.Lock(row.ID, row, W)
-				row.Values[updateKey] = updateVal
+				applyErr := updater.Apply(row)
 				err := lm.Unlock(row.ID, row, W)
 				if err != nil {
 					return fmt.Errorf("unlock failed: %w", err)
 				}
 
-				fmt.Printf("Updated Row: %+v", row)
-				newRowBytes, err := EncodeRow(row, buffer)
+				if applyErr != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("updating row failed: %w", applyErr)
+				}
+
+				if err := EncodeStoredValues(row, tableStats); err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("EncodeStoredValues failed: %w", err)
+				}
+
+				encoded, err := EncodeRow(row, buffer)
 				if err != nil {
 					return fmt.Errorf("EncodeRow failed: %w", err)
 				}
 
+				// the pooled slice and buffer are reused by the next tuple
+				beforeImage, err := detoastImage(*slice, tableObj.Toast)
+				if err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("detoastImage failed: %w", err)
+				}
+				newRowBytes := bytes.Clone(encoded)
+
 				if !txOff {
-					err = wal.Log(txID, LogTypeUpdate, tableObj.TableName, row.ID, *slice, newRowBytes)
+					err = wal.Log(txID, LogTypeUpdate, tableObj.TableName, row.ID, beforeImage, newRowBytes)
 					if err != nil {
 						return fmt.Errorf("wal.log failed: %w", err)
 					}
 				}
 
+				if updater.Updated != nil {
+					updater.Updated(newRowBytes)
+				}
+
+				// the new version gets its own chains in findAndUpdate
+				if err := freeToasted(*slice, tableObj.Toast); err != nil {
+					pageObj.Mu.Unlock()
+					return fmt.Errorf("freeToasted failed: %w", err)
+				}
+
 				location.Free = true
 				freeSpacePage.FreeMemory += location.Length
-				nonAddedRows.BytesNeeded += uint16(len(newRowBytes))
+				nonAddedRows.BytesNeeded += len(newRowBytes)
 				nonAddedRows.Rows = append(nonAddedRows.Rows, newRowBytes)
 
 			}
@@ -347,7 +659,7 @@ func processPagesForUpdate(ctx context.Context, accountingCtx *MemoryContext, qe
 	return nil
 }
 
-func handleLikeInsert(ctx context.Context, accountingCtx *MemoryContext, nonAddedRows chan *NonAddedRows, tableObj *TableObj, tableName string, bpm *BufferPoolManager, tableStats *TableInfo) error {
+func handleLikeInsert(ctx context.Context, accountingCtx *MemoryContext, nonAddedRows chan *NonAddedRows, tableObj *TableObj, tableName string, bpm *BufferPoolManager, tableStats *TableInfo, pages *updatePages) error {
 	logger.Log.Info("handleLikeInsert(update) Started")
 
 	for nonAddedRow := range nonAddedRows {
@@ -355,22 +667,7 @@ func handleLikeInsert(ctx context.Context, accountingCtx *MemoryContext, nonAdde
 			return ctx.Err()
 		}
 
-		if nonAddedRow.BytesNeeded >= AVAIL_DATA {
-			chunkedRows := ChunkRows(nonAddedRow)
-
-			for _, chunkedRow := range chunkedRows {
-				err := findAndUpdate(bpm, tableObj, tableStats, chunkedRow.BytesNeeded, tableName, chunkedRow.Rows)
-				if err != nil {
-					return fmt.Errorf("findAndUpdate failed: %w", err)
-				}
-			}
-
-			var nonAddedRowsType = reflect.TypeOf((*NonAddedRows)(nil))
-			accountingCtx.Release(nonAddedRowsType, nonAddedRow)
-			continue
-		}
-
-		err := findAndUpdate(bpm, tableObj, tableStats, nonAddedRow.BytesNeeded, tableName, nonAddedRow.Rows)
+		err := findAndUpdate(bpm, tableObj, tableStats, tableName, nonAddedRow.Rows, pages.usable)
 		if err != nil {
 			return fmt.Errorf("findAndUpdate failed: %w", err)
 		}
@@ -383,31 +680,6 @@ func handleLikeInsert(ctx context.Context, accountingCtx *MemoryContext, nonAdde
 	return nil
 }
 
-// Potential Improvement
-func ChunkRows(nonAddedRows *NonAddedRows) []*NonAddedRows {
-	const maxBytesPerChunk = 2096
-	var chunkedRows []*NonAddedRows
-
-	currentChunk := &NonAddedRows{}
-	for _, row := range nonAddedRows.Rows {
-		rowSize := uint16(len(row))
-
-		if currentChunk.BytesNeeded+rowSize >= maxBytesPerChunk {
-			chunkedRows = append(chunkedRows, currentChunk)
-			currentChunk = &NonAddedRows{}
-		}
-
-		currentChunk.BytesNeeded += rowSize
-		currentChunk.Rows = append(currentChunk.Rows, row)
-	}
-
-	if len(currentChunk.Rows) > 0 {
-		chunkedRows = append(chunkedRows, currentChunk)
-	}
-
-	return chunkedRows
-}
-
 func getPrimary(tableName string, catalog *Catalog) (string, error) {
 	var primary string
 
@@ -511,8 +783,8 @@ func handleError(err error, msg string) Result {
 	}
 }
 
-func rollbackAndReturn(txId, primary, modifiedColumn, tableName string, walManager *WalManager, engine *QueryEngine, catalog *Catalog, err error, msg string) Result {
-	if rollbackErr := walManager.AbortTransaction(txId, primary, modifiedColumn, tableName, engine, catalog); rollbackErr != nil {
+func rollbackAndReturn(txId, primary, tableName string, walManager *WalManager, engine *QueryEngine, catalog *Catalog, err error, msg string) Result {
+	if rollbackErr := walManager.AbortTransaction(txId, primary, tableName, engine, catalog); rollbackErr != nil {
 		err = fmt.Errorf("AbortTransaction failed: %w", rollbackErr)
 	}
 	return Result{
@@ -544,12 +816,22 @@ func removeTrailing(s string, remove rune) string {
 }
 
 func ExecuteQuery(sql string, queryEngine *QueryEngine) (*Result, error) {
+	return executeQuery(sql, queryEngine, false)
+}
+
+// ExecuteQueryStream returns SELECT results through res.Cursor,
+// the scheduler slot is released once the cursor is closed.
+func ExecuteQueryStream(sql string, queryEngine *QueryEngine) (*Result, error) {
+	return executeQuery(sql, queryEngine, true)
+}
+
+func executeQuery(sql string, queryEngine *QueryEngine, stream bool) (*Result, error) {
 	encodedPlan, err := utils.SendSql(sql)
 	if err != nil {
 		return nil, fmt.Errorf("SendSql Failed: %w", err)
 	}
 
-	queryInfo := QueryInfo{Id: GenerateRandomID(), RawPlan: encodedPlan, TransactionOff: false, InduceErr: false}
+	queryInfo := QueryInfo{Id: GenerateRandomID(), RawPlan: encodedPlan, TransactionOff: false, InduceErr: false, Stream: stream}
 	resChan := queryEngine.ResultManager.CreatePersonalChan()
 	queryEngine.ResultManager.Subscribe(queryInfo.Id, resChan)
 
@@ -628,6 +910,15 @@ func Bookkeeping(email, pass, dbName string, queryEngine *QueryEngine) (*RowV2,
 		return row, nil
 	}
 
+	// every user gets a database of their own
+	if queryEngine.BufferPoolManager.DiskManager.HasDatabase(dbName) {
+		return nil, fmt.Errorf("database: %s belongs to another user", dbName)
+	}
+
+	if err := runStatement(fmt.Sprintf("CREATE DATABASE `%s`\n", dbName), queryEngine); err != nil {
+		return nil, fmt.Errorf("creating database failed: %w", err)
+	}
+
 	sql := fmt.Sprintf("INSERT INTO `User`(Email, Password, DbName) VALUES ('%s', '%s', '%s')\n", email, pass, dbName)
 	encodedPlan, err = utils.SendSql(sql)
 	if err != nil {
@@ -637,17 +928,37 @@ func Bookkeeping(email, pass, dbName string, queryEngine *QueryEngine) (*RowV2,
 	queryInfo = QueryInfo{RawPlan: encodedPlan, TransactionOff: false, InduceErr: false}
 	result = queryEngine.QueryProcessingEntry(&queryInfo)
 	if result.Error != nil {
+		if err := runStatement(fmt.Sprintf("DROP DATABASE `%s`\n", dbName), queryEngine); err != nil {
+			logger.Log.WithField("database", dbName).Errorf("dropping database of unregistered user failed: %v", err)
+		}
 		return nil, fmt.Errorf("QueryProcessingEntry failed: %w", result.Error)
 	}
 
 	return result.Rows[0], nil
 }
 
+func runStatement(sql string, queryEngine *QueryEngine) error {
+	encodedPlan, err := utils.SendSql(sql)
+	if err != nil {
+		return fmt.Errorf("SendSql failed: %w", err)
+	}
+
+	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: false, InduceErr: false}
+	if result := queryEngine.QueryProcessingEntry(&queryInfo); result.Error != nil {
+		return fmt.Errorf("QueryProcessingEntry failed: %w", result.Error)
+	}
+
+	return nil
+}
+
 func undoDelete(log *LogRecord, engine *QueryEngine, catalog *Catalog) error {
 	var oldRow RowV2
 	buf := bytes.NewReader(log.BeforeImage)
 
 	DecodeRow(&oldRow, buf)
+	if err := DecodeStoredValues(&oldRow, catalog.Tables[log.TableID]); err != nil {
+		return fmt.Errorf("DecodeStoredValues failed: %w", err)
+	}
 
 	sql := buildInsertQueryFromMap(log.TableID, oldRow.Values, catalog)
 
@@ -656,7 +967,7 @@ func undoDelete(log *LogRecord, engine *QueryEngine, catalog *Catalog) error {
 		return fmt.Errorf("SendSqls failed: %w", err)
 	}
 
-	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: false, InduceErr: false}
+	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: true, InduceErr: false}
 	result := engine.QueryProcessingEntry(&queryInfo)
 	if result.Error != nil {
 		return fmt.Errorf("QueryProcessingEntry failed: %w", result.Error)
@@ -673,33 +984,83 @@ func buildInsertQueryFromMap(tableID string, oldRow map[string]string, catalog *
 		schema := catalog.Tables[tableID]
 		schemaObj := schema.Schema[col]
 		columns = append(columns, col)
-
-		if schemaObj.Type == "VARCHAR" {
-			values = append(values, fmt.Sprintf("'%v'", val))
-			continue
-		}
-		values = append(values, val)
+		values = append(values, sqlLiteral(val, schemaObj.Type))
 	}
 
 	query := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)\n", tableID, strings.Join(columns, ", "), strings.Join(values, ", "))
 	return query
 }
 
-func undoUpdate(log *LogRecord, engine *QueryEngine, primary, modifiedColumn string) error {
-	var oldRow RowV2
-	buf := bytes.NewReader(log.BeforeImage)
+// sqlLiteral renders a textual value as a literal for the generated undo statements.
+func sqlLiteral(val, colType string) string {
+	if IsNumericType(colType) && isIntegerText(val) {
+		return val
+	}
 
-	DecodeRow(&oldRow, buf)
+	if IsNumericType(colType) {
+		if _, err := strconv.ParseFloat(val, 64); err == nil {
+			return val
+		}
+	}
+
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(val, "'", "''"))
+}
 
-	oldVal := oldRow.Values[modifiedColumn]
-	sql := fmt.Sprintf("UPDATE `%s` SET %s = %s WHERE %s = CAST('%d' AS DECIMAL(20,0))\n", log.TableID, modifiedColumn, oldVal, primary, log.RowID)
+// undoUpdate restores every column that differs between the images,
+// columns added by the update are set back to NULL.
+func undoUpdate(log *LogRecord, engine *QueryEngine, primary string) error {
+	tableInfo := engine.BufferPoolManager.DiskManager.PageCatalog.Tables[log.TableID]
+
+	var oldRow, newRow RowV2
+	DecodeRow(&oldRow, bytes.NewReader(log.BeforeImage))
+	if err := DecodeStoredValues(&oldRow, tableInfo); err != nil {
+		return fmt.Errorf("DecodeStoredValues failed: %w", err)
+	}
+
+	if log.AfterImage != nil {
+		DecodeRow(&newRow, bytes.NewReader(log.AfterImage))
+		if err := DecodeStoredValues(&newRow, tableInfo); err != nil {
+			return fmt.Errorf("DecodeStoredValues failed: %w", err)
+		}
+	}
+
+	var sets []string
+	for col, oldVal := range oldRow.Values {
+		if col == primary {
+			continue
+		}
+
+		if newVal, ok := newRow.Values[col]; ok && newVal == oldVal {
+			continue
+		}
+
+		var colType string
+		if tableInfo != nil {
+			colType = tableInfo.Schema[col].Type
+		}
+		sets = append(sets, fmt.Sprintf("%s = %s", col, sqlLiteral(oldVal, colType)))
+	}
+
+	for col := range newRow.Values {
+		if _, ok := oldRow.Values[col]; !ok && col != primary {
+			sets = append(sets, fmt.Sprintf("%s = NULL", col))
+		}
+	}
+
+	if len(sets) == 0 {
+		return nil
+	}
+
+	sort.Strings(sets)
+	key := keyLiteral(oldRow.Values[primary], log.RowID)
+	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE %s = %s\n", log.TableID, strings.Join(sets, ", "), primary, key)
 
 	encodedPlan, err := utils.SendSql(sql)
 	if err != nil {
 		return fmt.Errorf("SendSql failed: %w", err)
 	}
 
-	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: false, InduceErr: false}
+	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: true, InduceErr: false}
 	result := engine.QueryProcessingEntry(&queryInfo)
 	if result.Error != nil {
 		return fmt.Errorf("QueryProcessingEntry failed: %w", result.Error)
@@ -708,15 +1069,40 @@ func undoUpdate(log *LogRecord, engine *QueryEngine, primary, modifiedColumn str
 	return nil
 }
 
+// keyLiteral renders the primary key of a logged row for the undo
+// statements, big integer keys go through a DECIMAL cast to keep their
+// precision. Records without the key fall back to the tuple id.
+func keyLiteral(key string, rowID uint64) string {
+	if key == "" {
+		key = strconv.FormatUint(rowID, 10)
+	}
+
+	if isIntegerText(key) {
+		return fmt.Sprintf("CAST('%s' AS DECIMAL(20,0))", key)
+	}
+
+	return sqlLiteral(key, "")
+}
+
 func undoInsert(log *LogRecord, engine *QueryEngine, primary string) error {
-	sql := fmt.Sprintf("DELETE FROM `%s` WHERE %s = CAST('%d' AS DECIMAL(20,0))\n", log.TableID, primary, log.RowID)
+	tableInfo := engine.BufferPoolManager.DiskManager.PageCatalog.Tables[log.TableID]
+
+	var newRow RowV2
+	if log.AfterImage != nil {
+		DecodeRow(&newRow, bytes.NewReader(log.AfterImage))
+		if err := DecodeStoredValues(&newRow, tableInfo); err != nil {
+			return fmt.Errorf("DecodeStoredValues failed: %w", err)
+		}
+	}
+
+	sql := fmt.Sprintf("DELETE FROM `%s` WHERE %s = %s\n", log.TableID, primary, keyLiteral(newRow.Values[primary], log.RowID))
 
 	encodedPlan, err := utils.SendSql(sql)
 	if err != nil {
 		return fmt.Errorf("SendSql failed: %w", err)
 	}
 
-	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: false, InduceErr: false}
+	queryInfo := QueryInfo{RawPlan: encodedPlan, TransactionOff: true, InduceErr: false}
 	result := engine.QueryProcessingEntry(&queryInfo)
 	if result.Error != nil {
 		return fmt.Errorf("QueryProcessingEntry failed: %w", result.Error)
@@ -0,0 +1,828 @@
+package engines
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Indexes of a table
+//
+// Every table with a primary key has a B+tree (see btree.go) in
+// Tables/<dir>/pk_index mapping the key to the location of the row, CREATE
+// INDEX adds more in Tables/<dir>/idx_<name> over one or more columns. The
+// catalog keeps the secondary ones in TableInfo.Indexes. The write paths keep
+// all of them in sync: findAndUpdate indexes the rows it places, the delete
+// and update scans drop the entries of the rows they free and cleanOrgnize
+// moves the entries of the rows RearrangePAGE compacts. The WAL undo goes
+// through the same statements. A missing file is built from the rows when
+// the table is opened, a new index is built from them before it's added to
+// the catalog.
+//
+// A condition holding a prefix of the columns of an index equal to
+// constants, and bounding the next one (=, <, <=, >, >=, BETWEEN joined by
+// AND), narrows DELETE, UPDATE and the scans of SELECT to the pages the
+// index points to, the condition is still evaluated on every row of them.
+// The index bounding the most columns is used. A UNIQUE index is also
+// enforced like a UNIQUE constraint, the keys of both are looked up in an
+// index on their columns when there is one.
+
+const (
+	PK_INDEX_FILE = "pk_index"
+	PRIMARY_INDEX = "PRIMARY"
+)
+
+// IndexInfo is a secondary index as the catalog keeps it, the name is
+// qualified by the database of its table.
+type IndexInfo struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+}
+
+// indexFile is the file of the index in the directory of its table.
+func indexFile(name string) string {
+	_, bare := SplitTableName(name)
+	return "idx_" + bare
+}
+
+func primaryColumn(tableInfo *TableInfo) string {
+	for column, columnInfo := range tableInfo.Schema {
+		if columnInfo.IsIndex {
+			return column
+		}
+	}
+	return ""
+}
+
+// openIndexes opens the indexes of the table kept in dir, the primary key
+// one first.
+func (dm *DiskManagerV2) openIndexes(tableObj *TableObj, tableInfo *TableInfo, dir string) error {
+	indexes := slices.Clone(tableInfo.Indexes)
+	if primary := primaryColumn(tableInfo); primary != "" {
+		indexes = slices.Insert(indexes, 0, IndexInfo{Name: PRIMARY_INDEX, Columns: []string{primary}, Unique: true})
+	}
+
+	for _, info := range indexes {
+		file := indexFile(info.Name)
+		if info.Name == PRIMARY_INDEX {
+			file = PK_INDEX_FILE
+		}
+
+		idx, err := dm.openIndex(tableObj, tableInfo, info, filepath.Join(dm.tablesPath(tableObj.TableName), dir, file))
+		if err != nil {
+			return err
+		}
+		tableObj.Indexes = append(tableObj.Indexes, idx)
+	}
+
+	return nil
+}
+
+// openIndex opens the index file at path, a new one is built from the rows.
+func (dm *DiskManagerV2) openIndex(tableObj *TableObj, tableInfo *TableInfo, info IndexInfo, path string) (*Index, error) {
+	types := make([]string, len(info.Columns))
+	for i, column := range info.Columns {
+		types[i] = tableInfo.Schema[column].Type
+	}
+
+	idx, created, err := OpenIndex(path, info.Name, slices.Clone(info.Columns), types, dm.indexPool)
+	if err != nil {
+		return nil, fmt.Errorf("OpenIndex failed: %w", err)
+	}
+
+	if created {
+		if err := buildIndex(idx, tableObj, tableInfo); err != nil {
+			idx.Close()
+			return nil, fmt.Errorf("building index: %s failed: %w", idx.Name, err)
+		}
+	}
+
+	return idx, nil
+}
+
+// buildIndex adds every live row of the table to idx.
+func buildIndex(idx *Index, tableObj *TableObj, tableInfo *TableInfo) error {
+	tableObj.DirectoryPage.Mu.RLock()
+	pages := maps.Clone(tableObj.DirectoryPage.Value)
+	tableObj.DirectoryPage.Mu.RUnlock()
+
+	for pageID, pageObj := range pages {
+		pageObj.Mu.RLock()
+		offset, pointers := pageObj.Offset, slices.Clone(pageObj.PointerArray)
+		pageObj.Mu.RUnlock()
+
+		pageBytes, err := ReadPageAtOffset(tableObj.DataFile, offset)
+		if err != nil {
+			return fmt.Errorf("ReadPageAtOffset failed: %w", err)
+		}
+
+		page, err := DecodePageV2(pageBytes)
+		if err != nil {
+			return fmt.Errorf("DecodePageV2 failed: %w", err)
+		}
+
+		for slot, location := range pointers {
+			if location.Free {
+				continue
+			}
+
+			values, err := decodeRowValues(page.Data[location.Offset:location.Offset+location.Length], tableInfo)
+			if err != nil {
+				return err
+			}
+
+			if err := idx.Insert(idx.entry(values, RowLocation{pageID, uint16(slot)})); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func decodeRowValues(rowBytes []byte, tableInfo *TableInfo) (map[string]string, error) {
+	var row RowV2
+	if err := DecodeRow(&row, bytes.NewReader(rowBytes)); err != nil {
+		return nil, fmt.Errorf("DecodeRow failed: %w", err)
+	}
+
+	if err := DecodeStoredValues(&row, tableInfo); err != nil {
+		return nil, fmt.Errorf("DecodeStoredValues failed: %w", err)
+	}
+
+	return row.Values, nil
+}
+
+func (idx *Index) entry(values map[string]string, loc RowLocation) IndexEntry {
+	key := make([]string, len(idx.Columns))
+	for i, column := range idx.Columns {
+		key[i] = values[column]
+	}
+
+	return IndexEntry{Key: key, Loc: loc}
+}
+
+// indexRow adds the row stored at loc to every index of the table.
+func (tableObj *TableObj) indexRow(values map[string]string, loc RowLocation) error {
+	for _, idx := range tableObj.Indexes {
+		if err := idx.Insert(idx.entry(values, loc)); err != nil {
+			return fmt.Errorf("index: %s: %w", idx.Name, err)
+		}
+	}
+	return nil
+}
+
+// unindexRow removes the row stored at loc from every index of the table.
+func (tableObj *TableObj) unindexRow(values map[string]string, loc RowLocation) error {
+	for _, idx := range tableObj.Indexes {
+		if err := idx.Delete(idx.entry(values, loc)); err != nil {
+			return fmt.Errorf("index: %s: %w", idx.Name, err)
+		}
+	}
+	return nil
+}
+
+// indexRows indexes the encoded rows placed from firstSlot on in the page.
+func (tableObj *TableObj) indexRows(encodedRows [][]byte, tableInfo *TableInfo, pageID PageID, firstSlot int) error {
+	if len(tableObj.Indexes) == 0 {
+		return nil
+	}
+
+	for i, encodedRow := range encodedRows {
+		values, err := decodeRowValues(encodedRow, tableInfo)
+		if err != nil {
+			return err
+		}
+
+		if err := tableObj.indexRow(values, RowLocation{pageID, uint16(firstSlot + i)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// slotCount is the number of slots of the page, the next row added to it
+// takes that slot.
+func (tableObj *TableObj) slotCount(pageID PageID) int {
+	tableObj.DirectoryPage.Mu.RLock()
+	pageObj, ok := tableObj.DirectoryPage.Value[pageID]
+	tableObj.DirectoryPage.Mu.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	pageObj.Mu.RLock()
+	defer pageObj.Mu.RUnlock()
+	return len(pageObj.PointerArray)
+}
+
+// relocateRows moves the index entries of the live rows of page to the
+// slots RearrangePAGE gives them once the freed ones are dropped.
+func (tableObj *TableObj) relocateRows(page *PageV2, tableInfo *TableInfo) error {
+	if len(tableObj.Indexes) == 0 {
+		return nil
+	}
+
+	pageID := PageID(page.Header.ID)
+
+	tableObj.DirectoryPage.Mu.RLock()
+	pageObj, ok := tableObj.DirectoryPage.Value[pageID]
+	tableObj.DirectoryPage.Mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("pageObj not found, pageId: %d", pageID)
+	}
+
+	pageObj.Mu.RLock()
+	pointers := slices.Clone(pageObj.PointerArray)
+	pageObj.Mu.RUnlock()
+
+	next := 0
+	for slot, location := range pointers {
+		if location.Free {
+			continue
+		}
+
+		if slot != next {
+			values, err := decodeRowValues(page.Data[location.Offset:location.Offset+location.Length], tableInfo)
+			if err != nil {
+				return err
+			}
+
+			if err := tableObj.unindexRow(values, RowLocation{pageID, uint16(slot)}); err != nil {
+				return err
+			}
+
+			if err := tableObj.indexRow(values, RowLocation{pageID, uint16(next)}); err != nil {
+				return err
+			}
+		}
+		next++
+	}
+
+	return nil
+}
+
+// renameIndexColumn follows RENAME COLUMN in the indexes of an open table.
+func (dm *DiskManagerV2) renameIndexColumn(tableName, column, newName string) {
+	dm.Mu.RLock()
+	tableObj, ok := dm.TableObjs[tableName]
+	dm.Mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	for _, idx := range tableObj.Indexes {
+		if i := slices.Index(idx.Columns, column); i >= 0 {
+			idx.Columns[i] = newName
+		}
+	}
+}
+
+// renameIndexInfos follows RENAME COLUMN in the catalog entries of the
+// indexes, the old entries are left as they were.
+func renameIndexInfos(indexes []IndexInfo, column, newName string) []IndexInfo {
+	renamed := make([]IndexInfo, len(indexes))
+	for i, info := range indexes {
+		info.Columns = slices.Clone(info.Columns)
+		if j := slices.Index(info.Columns, column); j >= 0 {
+			info.Columns[j] = newName
+		}
+		renamed[i] = info
+	}
+	return renamed
+}
+
+// indexTable is the table of the secondary index name and the position of
+// the index in its TableInfo.Indexes, "" when there is no such index.
+func (c *Catalog) indexTable(name string) (string, int) {
+	for tableName, tableInfo := range c.Tables {
+		if i := slices.IndexFunc(tableInfo.Indexes, func(info IndexInfo) bool { return info.Name == name }); i >= 0 {
+			return tableName, i
+		}
+	}
+	return "", -1
+}
+
+// CreateIndex adds a secondary index on the columns of the table, built from
+// the rows already there before the catalog knows it. A UNIQUE one fails on
+// a key those rows repeat.
+func (dm *DiskManagerV2) CreateIndex(tableName string, info IndexInfo) error {
+	tableInfo, ok := dm.PageCatalog.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+
+	if owner, _ := dm.PageCatalog.indexTable(info.Name); owner != "" || info.Name == PRIMARY_INDEX {
+		return fmt.Errorf("index: %s already exists", info.Name)
+	}
+
+	if len(info.Columns) == 0 {
+		return fmt.Errorf("index: %s has no columns", info.Name)
+	}
+
+	for i, column := range info.Columns {
+		if _, ok := tableInfo.Schema[column]; !ok {
+			return fmt.Errorf("column: %s on table: %s doesn't exist", column, tableName)
+		}
+
+		if slices.Contains(info.Columns[:i], column) {
+			return fmt.Errorf("column: %s is repeated in index: %s", column, info.Name)
+		}
+	}
+
+	tableObj, err := GetTableObj(tableName, dm)
+	if err != nil {
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+
+	// a file left by a CREATE INDEX that didn't finish is built again
+	path := filepath.Join(dm.tablesPath(tableName), dm.tableDir(tableName), indexFile(info.Name))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing old index file failed: %w", err)
+	}
+
+	idx, err := dm.openIndex(tableObj, tableInfo, info, path)
+	if err != nil {
+		return err
+	}
+
+	discard := func() {
+		idx.Close()
+		os.Remove(path)
+	}
+
+	if info.Unique {
+		if key, found, err := idx.repeatedKey(); err != nil || found {
+			discard()
+			if err != nil {
+				return fmt.Errorf("index: %s Scan failed: %w", info.Name, err)
+			}
+			return uniqueViolation(&Constraint{Name: info.Name, Columns: info.Columns}, strings.Join(key, ATTR_SEPARATOR))
+		}
+	}
+
+	old := tableInfo.Indexes
+	tableInfo.Indexes = append(slices.Clip(old), info)
+	if err := dm.UpdateCatalog(); err != nil {
+		tableInfo.Indexes = old
+		discard()
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	tableObj.Mu.Lock()
+	tableObj.Indexes = append(slices.Clip(tableObj.Indexes), idx)
+	tableObj.Mu.Unlock()
+
+	return nil
+}
+
+// DropIndex removes the secondary index from the catalog, then its file.
+func (dm *DiskManagerV2) DropIndex(name string) error {
+	tableName, i := dm.PageCatalog.indexTable(name)
+	if tableName == "" {
+		return fmt.Errorf("index: %s doesn't exist", name)
+	}
+
+	tableInfo := dm.PageCatalog.Tables[tableName]
+	old := tableInfo.Indexes
+	tableInfo.Indexes = slices.Delete(slices.Clone(old), i, i+1)
+	if err := dm.UpdateCatalog(); err != nil {
+		tableInfo.Indexes = old
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	return dm.removeIndexes(tableName, name)
+}
+
+// removeIndexes closes the indexes gone from the catalog of the table and
+// removes their files. A file left behind is harmless, CREATE INDEX
+// overwrites it.
+func (dm *DiskManagerV2) removeIndexes(tableName string, names ...string) error {
+	dm.Mu.RLock()
+	tableObj, ok := dm.TableObjs[tableName]
+	dm.Mu.RUnlock()
+
+	if ok {
+		var removed []*Index
+
+		tableObj.Mu.Lock()
+		tableObj.Indexes = slices.DeleteFunc(slices.Clone(tableObj.Indexes), func(idx *Index) bool {
+			if slices.Contains(names, idx.Name) {
+				removed = append(removed, idx)
+				return true
+			}
+			return false
+		})
+		tableObj.Mu.Unlock()
+
+		for _, idx := range removed {
+			idx.Close()
+		}
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dm.tablesPath(tableName), dm.tableDir(tableName), indexFile(name))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing index file failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// repeatedKey returns a key held by two entries, a key with a NULL never
+// counts.
+func (idx *Index) repeatedKey() ([]string, bool, error) {
+	var previous, repeated []string
+	err := idx.Scan(nil, nil, func(entry IndexEntry) bool {
+		if slices.Contains(entry.Key, "") {
+			return true
+		}
+
+		if previous != nil && idx.compareKeys(previous, entry.Key) == 0 {
+			repeated = entry.Key
+			return false
+		}
+		previous = entry.Key
+		return true
+	})
+
+	return repeated, repeated != nil, err
+}
+
+// countKey counts the entries holding key, up to limit.
+func (idx *Index) countKey(key []string, limit int) (int, error) {
+	count := 0
+	bound := &IndexBound{Key: key, Inclusive: true}
+	err := idx.Scan(bound, bound, func(IndexEntry) bool {
+		count++
+		return count < limit
+	})
+	return count, err
+}
+
+// indexOn is an index over exactly the columns, in any order.
+func (tableObj *TableObj) indexOn(columns []string) *Index {
+	for _, idx := range tableObj.Indexes {
+		if len(idx.Columns) == len(columns) && !slices.ContainsFunc(idx.Columns, func(column string) bool {
+			return !slices.Contains(columns, column)
+		}) {
+			return idx
+		}
+	}
+	return nil
+}
+
+// uniqueKeys are the UNIQUE constraints of the table and its UNIQUE indexes
+// checked like them.
+func (ti *TableInfo) uniqueKeys() []*Constraint {
+	var keys []*Constraint
+	for i := range ti.Constraints {
+		if ti.Constraints[i].Kind == CONSTRAINT_UNIQUE {
+			keys = append(keys, &ti.Constraints[i])
+		}
+	}
+
+	for _, info := range ti.Indexes {
+		if info.Unique {
+			keys = append(keys, &Constraint{Name: info.Name, Kind: CONSTRAINT_UNIQUE, Columns: info.Columns})
+		}
+	}
+
+	return keys
+}
+
+// handleCreateIndex runs CREATE [UNIQUE] INDEX [IF NOT EXISTS] name ON table (columns)
+func (qe *QueryEngine) handleCreateIndex(plan map[string]any) Result {
+	name := plan["name"].(string)
+	tableName, _ := plan["table"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+
+	if owner, _ := manager.PageCatalog.indexTable(name); owner != "" {
+		if ifNotExists, _ := plan["ifNotExists"].(bool); ifNotExists {
+			return Result{Msg: "Index Already Exists"}
+		}
+	}
+
+	info := IndexInfo{Name: name}
+	info.Unique, _ = plan["unique"].(bool)
+	for _, column := range asList(plan["columns"]) {
+		info.Columns = append(info.Columns, strings.ReplaceAll(fmt.Sprint(column), "`", ""))
+	}
+
+	if err := manager.CreateIndex(tableName, info); err != nil {
+		return handleError(fmt.Errorf("CreateIndex failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Index Created"}
+}
+
+// handleDropIndex runs DROP INDEX [IF EXISTS] name
+func (qe *QueryEngine) handleDropIndex(plan map[string]any) Result {
+	name := plan["name"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+
+	if owner, _ := manager.PageCatalog.indexTable(name); owner == "" {
+		if ifExists, _ := plan["ifExists"].(bool); ifExists {
+			return Result{Msg: "Index Doesn't Exist"}
+		}
+		return handleError(fmt.Errorf("index: %s doesn't exist", name), "failed")
+	}
+
+	if err := manager.DropIndex(name); err != nil {
+		return handleError(fmt.Errorf("DropIndex failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Index Dropped"}
+}
+
+// bounds turns the conjuncts of condition into the range of the index to
+// scan: equalities on a prefix of its columns, then at most one range on the
+// next column. score ranks the indexes a condition can use, 2 per column held
+// equal and 1 for the range, 0 when the first column isn't bounded.
+func (idx *Index) bounds(condition any, ectx *ExprContext) (lo, hi *IndexBound, score int) {
+	conditions := conjuncts(condition)
+
+	var prefix []string
+	for i := range idx.Columns {
+		colLo, colHi, ok := idx.columnBounds(conditions, i, ectx)
+		if !ok {
+			break
+		}
+
+		if colLo != nil && colHi != nil && colLo.Inclusive && colHi.Inclusive && idx.compareColumn(i, colLo.Key[0], colHi.Key[0]) == 0 {
+			prefix = append(prefix, colLo.Key[0])
+			score += 2
+			continue
+		}
+
+		return extendBound(prefix, colLo), extendBound(prefix, colHi), score + 1
+	}
+
+	if len(prefix) == 0 {
+		return nil, nil, 0
+	}
+
+	bound := &IndexBound{Key: prefix, Inclusive: true}
+	return bound, bound, score
+}
+
+// columnBounds is the range of the i-th column of the index the conditions
+// comparing it to a constant allow, ok is false when none does.
+func (idx *Index) columnBounds(conditions []any, i int, ectx *ExprContext) (lo, hi *IndexBound, ok bool) {
+	column, colType := idx.Columns[i], idx.Types[i]
+
+	for _, conjunct := range conditions {
+		node, _ := conjunct.(map[string]any)
+		opMap, _ := node["op"].(map[string]any)
+		operands, _ := node["operands"].([]any)
+		kind, _ := opMap["kind"].(string)
+
+		var constants []any
+		switch {
+		case kind == "BETWEEN" && len(operands) == 3 && isColumn(operands[0], column, ectx):
+			constants = operands[1:]
+		case len(operands) != 2:
+			continue
+		case isColumn(operands[0], column, ectx):
+			constants = operands[1:]
+		case isColumn(operands[1], column, ectx):
+			constants = operands[:1]
+			kind = flipComparison(kind)
+		default:
+			continue
+		}
+
+		values := make([]string, len(constants))
+		usable := true
+		for i, operand := range constants {
+			values[i], usable = constantKey(operand, colType, ectx)
+			if !usable {
+				break
+			}
+		}
+		if !usable {
+			continue
+		}
+
+		at := func(value string, inclusive bool) *IndexBound {
+			return &IndexBound{Key: []string{value}, Inclusive: inclusive}
+		}
+
+		switch kind {
+		case "EQUALS":
+			lo, hi = idx.tighten(i, lo, at(values[0], true), 1), idx.tighten(i, hi, at(values[0], true), -1)
+		case "GREATER_THAN":
+			lo = idx.tighten(i, lo, at(values[0], false), 1)
+		case "GREATER_THAN_OR_EQUAL":
+			lo = idx.tighten(i, lo, at(values[0], true), 1)
+		case "LESS_THAN":
+			hi = idx.tighten(i, hi, at(values[0], false), -1)
+		case "LESS_THAN_OR_EQUAL":
+			hi = idx.tighten(i, hi, at(values[0], true), -1)
+		case "BETWEEN":
+			lo, hi = idx.tighten(i, lo, at(values[0], true), 1), idx.tighten(i, hi, at(values[1], true), -1)
+		default:
+			continue
+		}
+		ok = true
+	}
+
+	return lo, hi, ok
+}
+
+// tighten keeps the narrower of two lower (side 1) or upper (side -1) bounds
+// of the i-th column.
+func (idx *Index) tighten(i int, current, bound *IndexBound, side int) *IndexBound {
+	if current == nil {
+		return bound
+	}
+
+	cmp := idx.compareColumn(i, bound.Key[0], current.Key[0]) * side
+	if cmp > 0 || (cmp == 0 && !bound.Inclusive) {
+		return bound
+	}
+	return current
+}
+
+// extendBound puts the bound of a column after the values of the columns
+// held equal before it.
+func extendBound(prefix []string, bound *IndexBound) *IndexBound {
+	if bound == nil {
+		if len(prefix) == 0 {
+			return nil
+		}
+		return &IndexBound{Key: prefix, Inclusive: true}
+	}
+
+	return &IndexBound{Key: append(slices.Clone(prefix), bound.Key...), Inclusive: bound.Inclusive}
+}
+
+func conjuncts(condition any) []any {
+	node, ok := condition.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	opMap, _ := node["op"].(map[string]any)
+	if opMap == nil || opMap["kind"] != "AND" {
+		return []any{condition}
+	}
+
+	var all []any
+	operands, _ := node["operands"].([]any)
+	for _, operand := range operands {
+		all = append(all, conjuncts(operand)...)
+	}
+	return all
+}
+
+func flipComparison(kind string) string {
+	switch kind {
+	case "GREATER_THAN":
+		return "LESS_THAN"
+	case "GREATER_THAN_OR_EQUAL":
+		return "LESS_THAN_OR_EQUAL"
+	case "LESS_THAN":
+		return "GREATER_THAN"
+	case "LESS_THAN_OR_EQUAL":
+		return "GREATER_THAN_OR_EQUAL"
+	default:
+		return kind
+	}
+}
+
+func isColumn(operand any, column string, ectx *ExprContext) bool {
+	node, ok := operand.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	if _, isLiteral := node["literal"]; isLiteral {
+		return false
+	}
+	if _, isCall := node["op"]; isCall {
+		return false
+	}
+
+	name, err := resolveColumn(node, ectx)
+	return err == nil && name == column
+}
+
+// constantKey evaluates a constant operand to the text the index holds for
+// it. Null and values the column type wouldn't read back the same aren't
+// usable, the rows decide for those.
+func constantKey(operand any, colType string, ectx *ExprContext) (string, bool) {
+	if !isConstant(operand) {
+		return "", false
+	}
+
+	d, err := EvalExpr(operand, nil, ectx)
+	if err != nil || d.IsNull() {
+		return "", false
+	}
+
+	text := d.String()
+	cmp, err := CompareDatums(DatumFromText(text, colType), d)
+	if err != nil || cmp != 0 {
+		return "", false
+	}
+
+	return text, true
+}
+
+// candidatePages returns the pages the index bounding condition the most
+// points to for the rows that can satisfy it, ok is false when no index
+// applies. The primary key index wins the ties.
+func (tableObj *TableObj) candidatePages(condition any, ectx *ExprContext) ([]PageID, bool, error) {
+	if condition == nil {
+		return nil, false, nil
+	}
+
+	tableObj.Mu.RLock()
+	indexes := slices.Clone(tableObj.Indexes)
+	tableObj.Mu.RUnlock()
+
+	var idx *Index
+	var lo, hi *IndexBound
+	best := 0
+	for _, candidate := range indexes {
+		if candidateLo, candidateHi, score := candidate.bounds(condition, ectx); score > best {
+			idx, lo, hi, best = candidate, candidateLo, candidateHi, score
+		}
+	}
+
+	if idx == nil {
+		return nil, false, nil
+	}
+
+	var pages []PageID
+	seen := make(map[PageID]bool)
+	err := idx.Scan(lo, hi, func(entry IndexEntry) bool {
+		if !seen[entry.Loc.Page] {
+			seen[entry.Loc.Page] = true
+			pages = append(pages, entry.Loc.Page)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("index: %s Scan failed: %w", idx.Name, err)
+	}
+
+	return pages, true, nil
+}
+
+// skipPages returns whether a page can't hold a row matching condition by
+// its zones or bloom filters, nil when neither lets the scan skip pages.
+func (tableObj *TableObj) skipPages(condition any, tableInfo *TableInfo, ectx *ExprContext) func(pageID PageID) bool {
+	if condition == nil {
+		return nil
+	}
+
+	tests := zoneTests(condition, tableInfo, ectx)
+
+	var probes []bloomProbe
+	blooms := tableObj.Blooms
+	if blooms != nil {
+		probes = bloomProbes(condition, tableInfo, ectx)
+	}
+
+	if len(tests) == 0 && len(probes) == 0 {
+		return nil
+	}
+
+	return func(pageID PageID) bool {
+		if len(tests) > 0 && tableObj.zonesRuleOut(pageID, tests) {
+			return true
+		}
+		return len(probes) > 0 && blooms.rulesOut(pageID, probes)
+	}
+}
+
+// scanMatching sends the pages that can hold rows matching condition, every
+// page of the table unless an index narrows them down or their zones and
+// bloom filters rule them out.
+func (qe *QueryEngine) scanMatching(ctx context.Context, pageChan chan *PageV2, tableObj *TableObj, tableInfo *TableInfo, condition any) error {
+	ectx := NewExprContext(nil, tableInfo.Schema)
+	pages, ok, err := tableObj.candidatePages(condition, ectx)
+	if err != nil {
+		close(pageChan)
+		return err
+	}
+
+	skip := tableObj.skipPages(condition, tableInfo, ectx)
+	if ok {
+		if skip != nil {
+			pages = slices.DeleteFunc(pages, skip)
+		}
+		return qe.BufferPoolManager.PageScan(ctx, pageChan, tableObj, pages)
+	}
+	return qe.BufferPoolManager.FullTableScan(ctx, pageChan, tableObj, tableInfo.NumOfPages, skip)
+}
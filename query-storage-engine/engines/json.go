@@ -0,0 +1,424 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const TYPE_JSON = "JSON"
+
+// Binary JSON
+//
+// Every value starts with a tag, strings/numbers are length prefixed and
+// containers carry their element count, object keys keep their original order.
+const (
+	JSON_NULL byte = iota
+	JSON_FALSE
+	JSON_TRUE
+	JSON_NUMBER
+	JSON_STRING
+	JSON_ARRAY
+	JSON_OBJECT
+)
+
+// EncodeJSON validates the document and returns its binary form.
+func EncodeJSON(text string) ([]byte, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := encodeJSONValue(dec, &buf); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid json: trailing data")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeJSONValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch v := tok.(type) {
+	case nil:
+		buf.WriteByte(JSON_NULL)
+	case bool:
+		if v {
+			buf.WriteByte(JSON_TRUE)
+		} else {
+			buf.WriteByte(JSON_FALSE)
+		}
+	case json.Number:
+		buf.WriteByte(JSON_NUMBER)
+		writeJSONString(buf, v.String())
+	case string:
+		buf.WriteByte(JSON_STRING)
+		writeJSONString(buf, v)
+	case json.Delim:
+		var elems bytes.Buffer
+		var count uint64
+
+		for dec.More() {
+			if v == '{' {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				writeJSONString(&elems, keyTok.(string))
+			}
+
+			if err := encodeJSONValue(dec, &elems); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return err
+		}
+
+		if v == '{' {
+			buf.WriteByte(JSON_OBJECT)
+		} else {
+			buf.WriteByte(JSON_ARRAY)
+		}
+		writeUvarint(buf, count)
+		buf.Write(elems.Bytes())
+	}
+
+	return nil
+}
+
+func writeUvarint(buf *bytes.Buffer, n uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], n)])
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// DecodeJSON turns the binary form back into compact json text.
+func DecodeJSON(b []byte) (string, error) {
+	reader := bytes.NewReader(b)
+
+	var sb strings.Builder
+	if err := decodeJSONValue(reader, &sb); err != nil {
+		return "", fmt.Errorf("corrupted json value: %w", err)
+	}
+
+	return sb.String(), nil
+}
+
+func decodeJSONValue(reader *bytes.Reader, sb *strings.Builder) error {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch tag {
+	case JSON_NULL:
+		sb.WriteString("null")
+	case JSON_FALSE:
+		sb.WriteString("false")
+	case JSON_TRUE:
+		sb.WriteString("true")
+	case JSON_NUMBER:
+		num, err := readJSONString(reader)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(num)
+	case JSON_STRING:
+		str, err := readJSONString(reader)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(jsonText(str))
+	case JSON_ARRAY, JSON_OBJECT:
+		count, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return err
+		}
+
+		open, close := byte('['), byte(']')
+		if tag == JSON_OBJECT {
+			open, close = '{', '}'
+		}
+
+		sb.WriteByte(open)
+		for i := uint64(0); i < count; i++ {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+
+			if tag == JSON_OBJECT {
+				key, err := readJSONString(reader)
+				if err != nil {
+					return err
+				}
+				sb.WriteString(jsonText(key))
+				sb.WriteByte(':')
+			}
+
+			if err := decodeJSONValue(reader, sb); err != nil {
+				return err
+			}
+		}
+		sb.WriteByte(close)
+	default:
+		return fmt.Errorf("unknown tag: %d", tag)
+	}
+
+	return nil
+}
+
+func readJSONString(reader *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if n > uint64(reader.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(reader, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// Path extraction
+//
+// Paths follow the sql/json syntax produced by the planner for the -> and ->>
+// operators: "lax $.a.b[0]", "$.\"some key\"", "$[2]".
+
+type jsonPathStep struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimPrefix(p, "lax ")
+	p = strings.TrimPrefix(p, "strict ")
+	p = strings.TrimSpace(p)
+
+	if !strings.HasPrefix(p, "$") {
+		// a bare key, as in data -> 'name'
+		return []jsonPathStep{{Key: p}}, nil
+	}
+
+	var steps []jsonPathStep
+	for i := 1; i < len(p); {
+		switch p[i] {
+		case '.':
+			i++
+			if i < len(p) && p[i] == '"' {
+				end := strings.IndexByte(p[i+1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid json path: %s", path)
+				}
+				steps = append(steps, jsonPathStep{Key: p[i+1 : i+1+end]})
+				i += end + 2
+				continue
+			}
+
+			start := i
+			for i < len(p) && p[i] != '.' && p[i] != '[' {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("invalid json path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{Key: p[start:i]})
+		case '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid json path: %s", path)
+			}
+			idx, err := strconv.Atoi(strings.TrimSpace(p[i+1 : i+end]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid json path index: %s", path)
+			}
+			steps = append(steps, jsonPathStep{Index: idx, IsIndex: true})
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("invalid json path: %s", path)
+		}
+	}
+
+	return steps, nil
+}
+
+func parseJSONText(doc string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	return value, nil
+}
+
+// JSONExtract walks the path, found is false when a step is missing.
+func JSONExtract(doc string, path string) (interface{}, bool, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, err := parseJSONText(doc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, step := range steps {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if step.IsIndex {
+				return nil, false, nil
+			}
+			next, ok := v[step.Key]
+			if !ok {
+				return nil, false, nil
+			}
+			value = next
+		case []interface{}:
+			idx := step.Index
+			if !step.IsIndex {
+				// data -> '0' on arrays
+				n, err := strconv.Atoi(step.Key)
+				if err != nil {
+					return nil, false, nil
+				}
+				idx = n
+			}
+			if idx < 0 {
+				idx += len(v)
+			}
+			if idx < 0 || idx >= len(v) {
+				return nil, false, nil
+			}
+			value = v[idx]
+		default:
+			return nil, false, nil
+		}
+	}
+
+	return value, true, nil
+}
+
+func jsonText(value interface{}) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// JSONTypeOf names the type of a json value the way json_typeof does.
+func JSONTypeOf(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// jsonFunction implements the json functions of the evaluator.
+// JSON_QUERY (->) returns json text, JSON_VALUE (->>) returns the unquoted text.
+func jsonFunction(name string, args []Datum) (Datum, error) {
+	if len(args) == 0 || args[0].IsNull() {
+		return NullDatum(), nil
+	}
+
+	doc := args[0].String()
+
+	var path string
+	if len(args) > 1 && !args[1].IsNull() {
+		path = args[1].String()
+	}
+
+	var value interface{}
+	var err error
+
+	found := true
+	if path != "" {
+		value, found, err = JSONExtract(doc, path)
+	} else {
+		value, err = parseJSONText(doc)
+	}
+
+	if err != nil {
+		return Datum{}, fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	if !found {
+		return NullDatum(), nil
+	}
+
+	switch name {
+	case "JSON_QUERY":
+		return StringDatum(jsonText(value)), nil
+	case "JSON_VALUE":
+		switch v := value.(type) {
+		case nil:
+			return NullDatum(), nil
+		case string:
+			return StringDatum(v), nil
+		case json.Number:
+			return NumberDatum(v.String())
+		default:
+			return StringDatum(jsonText(v)), nil
+		}
+	case "JSON_LENGTH", "JSON_ARRAY_LENGTH":
+		switch v := value.(type) {
+		case []interface{}:
+			return IntDatum(int64(len(v))), nil
+		case map[string]interface{}:
+			if name == "JSON_ARRAY_LENGTH" {
+				return Datum{}, errors.New("cannot get array length of an object")
+			}
+			return IntDatum(int64(len(v))), nil
+		default:
+			if name == "JSON_ARRAY_LENGTH" {
+				return Datum{}, errors.New("cannot get array length of a scalar")
+			}
+			return IntDatum(1), nil
+		}
+	default: // JSON_TYPE, JSON_TYPEOF
+		return StringDatum(JSONTypeOf(value)), nil
+	}
+}
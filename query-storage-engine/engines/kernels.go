@@ -0,0 +1,511 @@
+package engines
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scylladb/go-set/strset"
+)
+
+// Filter kernel
+//
+// Comparisons between a column and a literal run over the typed vectors,
+// AND/OR narrow the selection vector, anything else is evaluated row by row.
+
+func filterBatch(b *Batch, condition interface{}, ectx *ExprContext) error {
+	sel, err := selectRows(condition, b, b.Live(), ectx)
+	if err != nil {
+		return err
+	}
+
+	b.Sel = sel
+	return nil
+}
+
+func selectRows(expr interface{}, b *Batch, sel []int, ectx *ExprContext) ([]int, error) {
+	if len(sel) == 0 {
+		return sel, nil
+	}
+
+	if node, ok := expr.(map[string]interface{}); ok {
+		opMap, _ := node["op"].(map[string]interface{})
+		kind, _ := opMap["kind"].(string)
+		operands, _ := node["operands"].([]interface{})
+
+		switch kind {
+		case "AND":
+			var err error
+			for _, operand := range operands {
+				sel, err = selectRows(operand, b, sel, ectx)
+				if err != nil || len(sel) == 0 {
+					return sel, err
+				}
+			}
+			return sel, nil
+		case "OR":
+			matched := make([]bool, b.Len)
+			rest := sel
+			for _, operand := range operands {
+				hits, err := selectRows(operand, b, rest, ectx)
+				if err != nil {
+					return nil, err
+				}
+
+				for _, i := range hits {
+					matched[i] = true
+				}
+				rest = unmatched(rest, matched)
+			}
+			return matchedIn(sel, matched), nil
+		case "EQUALS", "NOT_EQUALS", "GREATER_THAN", "GREATER_THAN_OR_EQUAL", "LESS_THAN", "LESS_THAN_OR_EQUAL":
+			if out, ok := compareKernel(kind, operands, b, sel, ectx); ok {
+				return out, nil
+			}
+		}
+	}
+
+	out := make([]int, 0, len(sel))
+	for _, i := range sel {
+		matched, err := EvalPredicate(expr, b.Row(i), ectx)
+		if err != nil {
+			return nil, fmt.Errorf("EvalPredicate failed: %w", err)
+		}
+
+		if matched {
+			out = append(out, i)
+		}
+	}
+
+	return out, nil
+}
+
+func unmatched(sel []int, matched []bool) []int {
+	out := make([]int, 0, len(sel))
+	for _, i := range sel {
+		if !matched[i] {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
+
+func matchedIn(sel []int, matched []bool) []int {
+	out := make([]int, 0, len(sel))
+	for _, i := range sel {
+		if matched[i] {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
+
+var flippedComparison = map[string]string{
+	"EQUALS":                "EQUALS",
+	"NOT_EQUALS":            "NOT_EQUALS",
+	"GREATER_THAN":          "LESS_THAN",
+	"GREATER_THAN_OR_EQUAL": "LESS_THAN_OR_EQUAL",
+	"LESS_THAN":             "GREATER_THAN",
+	"LESS_THAN_OR_EQUAL":    "GREATER_THAN_OR_EQUAL",
+}
+
+func comparisonHolds(kind string, cmp int) bool {
+	switch kind {
+	case "EQUALS":
+		return cmp == 0
+	case "NOT_EQUALS":
+		return cmp != 0
+	case "GREATER_THAN":
+		return cmp > 0
+	case "GREATER_THAN_OR_EQUAL":
+		return cmp >= 0
+	case "LESS_THAN":
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
+
+// compareKernel handles <column> <op> <literal> in either order, ok is false
+// when the operands need the generic evaluator.
+func compareKernel(kind string, operands []interface{}, b *Batch, sel []int, ectx *ExprContext) ([]int, bool) {
+	if len(operands) != 2 {
+		return nil, false
+	}
+
+	vec, castType, isColumn := columnOperand(operands[0], b, ectx)
+	litNode := operands[1]
+	if !isColumn {
+		vec, castType, isColumn = columnOperand(operands[1], b, ectx)
+		litNode = operands[0]
+		kind = flippedComparison[kind]
+	}
+
+	lit, isLiteral := literalOperand(litNode)
+	if !isColumn || !isLiteral {
+		return nil, false
+	}
+
+	out := make([]int, 0, len(sel))
+	if lit.IsNull() {
+		return out, true
+	}
+
+	switch {
+	case vec.Kind == VEC_INT && lit.Kind == KindNumber && (castType == "" || IsNumericType(castType)):
+		if num, err := strconv.ParseInt(lit.Str, 10, 64); err == nil {
+			for _, i := range sel {
+				if !vec.Nulls[i] && comparisonHolds(kind, compareInt64(vec.Ints[i], num)) {
+					out = append(out, i)
+				}
+			}
+			return out, true
+		}
+
+		for _, i := range sel {
+			if vec.Nulls[i] {
+				continue
+			}
+
+			cmp := 0
+			if val := float64(vec.Ints[i]); val < lit.Num {
+				cmp = -1
+			} else if val > lit.Num {
+				cmp = 1
+			}
+
+			if comparisonHolds(kind, cmp) {
+				out = append(out, i)
+			}
+		}
+		return out, true
+	case vec.Kind == VEC_TEXT && lit.Kind == KindString && castType == "" && isTextType(vec.Type):
+		for _, i := range sel {
+			// empty text reads as null, same as DatumFromText
+			if vec.Nulls[i] || vec.Strs[i] == "" {
+				continue
+			}
+
+			if comparisonHolds(kind, strings.Compare(vec.Strs[i], lit.Str)) {
+				out = append(out, i)
+			}
+		}
+		return out, true
+	}
+
+	return nil, false
+}
+
+func isTextType(colType string) bool {
+	t := baseType(colType)
+	return t == "" || t == TYPE_VARCHAR
+}
+
+// columnOperand accepts a column reference, optionally wrapped in a CAST
+// (the planner sees most columns as VARCHAR and casts them for comparisons).
+func columnOperand(expr interface{}, b *Batch, ectx *ExprContext) (*Vector, string, bool) {
+	node, ok := expr.(map[string]interface{})
+	if !ok {
+		return nil, "", false
+	}
+
+	var castType string
+	if opMap, isCall := node["op"].(map[string]interface{}); isCall {
+		operands, _ := node["operands"].([]interface{})
+		if opMap["kind"] != "CAST" || len(operands) != 1 {
+			return nil, "", false
+		}
+
+		castType = exprTypeName(node)
+		node, ok = operands[0].(map[string]interface{})
+		if !ok || node["op"] != nil {
+			return nil, "", false
+		}
+	}
+
+	if _, isLiteral := node["literal"]; isLiteral {
+		return nil, "", false
+	}
+
+	colName, err := resolveColumn(node, ectx)
+	if err != nil {
+		return nil, "", false
+	}
+
+	vec := b.Column(colName)
+	return vec, castType, vec != nil
+}
+
+func literalOperand(expr interface{}) (Datum, bool) {
+	node, ok := expr.(map[string]interface{})
+	if !ok {
+		return Datum{}, false
+	}
+
+	if _, isLiteral := node["literal"]; !isLiteral {
+		return Datum{}, false
+	}
+
+	d, err := literalDatum(node["literal"], exprTypeName(node))
+	if err != nil {
+		return Datum{}, false
+	}
+
+	return d, true
+}
+
+// Projection kernel
+//
+// Computed fields are evaluated for the live rows, dropping the unselected
+// columns is a matter of dropping vectors.
+
+func projectBatch(b *Batch, set *strset.Set, computed map[string]interface{}, ectx *ExprContext) error {
+	if len(computed) > 0 {
+		live := b.Live()
+		rows := make([]*RowV2, len(live))
+		for n, i := range live {
+			rows[n] = b.Row(i)
+		}
+
+		vectors := make(map[string]*Vector, len(computed))
+		for field, expr := range computed {
+			v := &Vector{Kind: VEC_TEXT, Strs: make([]string, b.Len), Nulls: make([]bool, b.Len)}
+			for n, i := range live {
+				d, err := EvalExpr(expr, rows[n], ectx)
+				if err != nil {
+					return fmt.Errorf("EvalExpr failed for %s: %w", field, err)
+				}
+				v.Strs[i] = d.String()
+			}
+			vectors[field] = v
+		}
+
+		for field, v := range vectors {
+			b.SetColumn(field, v)
+		}
+	}
+
+	b.Keep(set)
+	return nil
+}
+
+// Sort kernel
+//
+// Sorting permutes the selection vector, integer columns are compared
+// straight from their vector and everything else through precomputed datums.
+
+func sortBatch(ctx context.Context, b *Batch, innerMap map[string]interface{}, ectx *ExprContext) error {
+	column := innerMap["column"].(string)
+	direction := innerMap["sortDirection"].(string)
+	expr := innerMap["expr"] // ORDER BY <expression>, e.g data->>'age'
+
+	limitPassed := true
+	limit, err := strconv.Atoi(innerMap["limit"].(string))
+	if err != nil {
+		limitPassed = false
+	}
+
+	live := b.Live()
+	vec := b.Column(column)
+
+	var cmp func(x, y int) int
+	if expr == nil && vec != nil && vec.Kind == VEC_INT {
+		cmp = func(x, y int) int {
+			switch {
+			case vec.Nulls[x] || vec.Nulls[y]:
+				return compareInt64(boolInt(!vec.Nulls[x]), boolInt(!vec.Nulls[y]))
+			default:
+				return compareInt64(vec.Ints[x], vec.Ints[y])
+			}
+		}
+	} else {
+		keys := make([]Datum, b.Len)
+		for _, i := range live {
+			if expr != nil {
+				keys[i], err = EvalExpr(expr, b.Row(i), ectx)
+				if err != nil {
+					return fmt.Errorf("EvalExpr failed: %w", err)
+				}
+				continue
+			}
+
+			var text string
+			if vec != nil {
+				text, _ = vec.Text(i)
+			}
+			keys[i] = sortDatum(text, column, ectx.Schema)
+		}
+
+		cmp = func(x, y int) int {
+			c, err := CompareDatums(keys[x], keys[y])
+			if err != nil {
+				c = strings.Compare(keys[x].String(), keys[y].String())
+			}
+			return c
+		}
+	}
+
+	sorted := append([]int(nil), live...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+			c := cmp(sorted[i], sorted[j])
+			if direction == "ASC" {
+				return c < 0
+			} else if direction == "DESC" {
+				return c > 0
+			}
+		}
+		return false
+	})
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if limitPassed && limit < len(sorted) {
+		sorted = sorted[:limit]
+	}
+
+	b.Sel = sorted
+	return nil
+}
+
+// sortDatum types the value using the schema, columns outside of it
+// (aggregates, computed fields) are compared as numbers when possible.
+func sortDatum(val string, column string, schema map[string]ColumnType) Datum {
+	if colInfo, ok := schema[column]; ok {
+		return DatumFromText(val, colInfo.Type)
+	}
+
+	if d, err := NumberDatum(val); err == nil {
+		return d
+	}
+
+	return DatumFromText(val, "")
+}
+
+// Aggregate kernel
+//
+// Groups are folded batch by batch, nothing is buffered besides the
+// running state of every group.
+
+type aggState struct {
+	count int // rows in the group
+	seen  int // non null values
+	sum   int
+	min   int
+	max   int
+}
+
+type aggregator struct {
+	function string
+	groupBy  string
+	arg      string
+	groups   map[string]*aggState
+}
+
+func newAggregator(innerMap map[string]interface{}, colName string, selectedCols []interface{}) (*aggregator, error) {
+	customFieldSlice := innerMap["selected_columns"].([]interface{})
+	aggInfoMap := innerMap["aggregates"].(map[string]interface{})
+	argsSlice := aggInfoMap["args"].([]interface{})
+
+	agg := &aggregator{
+		function: aggInfoMap["function"].(string),
+		groupBy:  customFieldSlice[0].(string),
+		groups:   map[string]*aggState{},
+	}
+
+	switch agg.function {
+	case "COUNT":
+	case "MAX", "MIN":
+		argCode := int(argsSlice[0].(float64))
+		agg.arg = selectedCols[argCode].(string)
+	case "AVG", "SUM":
+		agg.arg = colName
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", agg.function)
+	}
+
+	return agg, nil
+}
+
+func (agg *aggregator) add(b *Batch) error {
+	keys := b.Column(agg.groupBy)
+
+	var vals *Vector
+	if agg.function != "COUNT" {
+		if vals = b.Column(agg.arg); vals == nil {
+			return fmt.Errorf("column %s not found", agg.arg)
+		}
+	}
+
+	for _, i := range b.Live() {
+		var key string
+		if keys != nil {
+			key, _ = keys.Text(i)
+		}
+
+		state, ok := agg.groups[key]
+		if !ok {
+			state = &aggState{}
+			agg.groups[key] = state
+		}
+		state.count++
+
+		if vals == nil || vals.Nulls[i] {
+			continue
+		}
+
+		var num int
+		if vals.Kind == VEC_INT {
+			num = int(vals.Ints[i])
+		} else {
+			var err error
+			num, err = strconv.Atoi(vals.Strs[i])
+			if err != nil {
+				return fmt.Errorf("(%s) - Parsing str => int failed: %w", agg.function, err)
+			}
+		}
+
+		if state.seen == 0 || num < state.min {
+			state.min = num
+		}
+		if state.seen == 0 || num > state.max {
+			state.max = num
+		}
+		state.sum += num
+		state.seen++
+	}
+
+	return nil
+}
+
+func (agg *aggregator) result() map[string]int {
+	resMap := make(map[string]int, len(agg.groups))
+
+	for key, state := range agg.groups {
+		switch agg.function {
+		case "COUNT":
+			resMap[key] = state.count
+		case "SUM":
+			resMap[key] = state.sum
+		case "AVG":
+			if state.seen > 0 {
+				resMap[key] = state.sum / state.seen
+			}
+		case "MIN":
+			resMap[key] = state.min
+		case "MAX":
+			resMap[key] = state.max
+		}
+	}
+
+	return resMap
+}
@@ -0,0 +1,698 @@
+package engines
+
+import (
+	"a2gdb/logger"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Materialized views
+//
+// A materialized view is a SELECT over a single table whose result is kept
+// in a regular table of the same name. Unlike a plain view the engine runs
+// the definition itself: the planner hands over the WHERE condition, the
+// GROUP BY columns and the projected expressions or aggregates.
+//
+// REFRESH MATERIALIZED VIEW rebuilds the table from scratch. Views with
+// aggregates (COUNT, SUM, AVG, MIN, MAX) are also kept current on their own:
+// the records of every committed transaction on the source table are folded
+// into running aggregates and only the rows of the groups that changed are
+// rewritten. A MIN or MAX losing its value recomputes its group from the
+// source, a TRUNCATE rebuilds the view.
+//
+// The running aggregates live in memory, the first commit after a start
+// rebuilds them. The view table is derived data and is written outside of
+// the WAL.
+
+const (
+	AGG_COUNT = "COUNT"
+	AGG_SUM   = "SUM"
+	AGG_AVG   = "AVG"
+	AGG_MIN   = "MIN"
+	AGG_MAX   = "MAX"
+
+	VIEW_FRACTION_DIGITS = 10 // digits kept after the point by AVG and fractional SUMs
+)
+
+type MaterializedView struct {
+	Query     string       `json:"query"`               // the SELECT as written
+	From      string       `json:"from"`                // source table
+	Condition any          `json:"condition,omitempty"` // WHERE, nil => every row
+	GroupBy   []string     `json:"groupBy,omitempty"`
+	Columns   []ViewColumn `json:"columns"`
+
+	state *viewState // running aggregates, nil until the next refresh
+	mu    sync.Mutex // one refresh or maintenance at a time
+}
+
+// ViewColumn is a projected expression, or its aggregate when Function is set.
+type ViewColumn struct {
+	Name     string `json:"name"`
+	Expr     any    `json:"expr,omitempty"` // nil for COUNT(*)
+	Function string `json:"function,omitempty"`
+}
+
+type viewState struct {
+	groups map[string]*viewGroup // by groupKey
+}
+
+type viewGroup struct {
+	keys   map[string]string // GROUP BY column => value, NULLs are absent
+	rows   int64
+	counts []int64    // non NULL arguments, per view column
+	sums   []*big.Rat // SUM and AVG
+	bounds []Datum    // MIN and MAX
+	stale  bool       // a MIN or MAX lost its value
+}
+
+func (v *MaterializedView) attrs() (map[string]string, error) {
+	definition, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"definition": string(definition)}, nil
+}
+
+func (v *MaterializedView) setAttrs(attrs map[string]string) error {
+	return json.Unmarshal([]byte(attrs["definition"]), v)
+}
+
+// aggregated tells the views kept as groups apart from plain projections.
+func (v *MaterializedView) aggregated() bool {
+	return len(v.GroupBy) > 0 || slices.ContainsFunc(v.Columns, func(c ViewColumn) bool { return c.Function != "" })
+}
+
+// viewSourceColumn is the source column of a bare column expression.
+func viewSourceColumn(expr any) (string, bool) {
+	node, ok := expr.(map[string]any)
+	if !ok {
+		return "", false
+	}
+
+	column, ok := node["column"].(string)
+	return strings.ReplaceAll(column, "`", ""), ok
+}
+
+// schema checks the definition against the source and gives the columns of
+// the view table.
+func (v *MaterializedView) schema(source *TableInfo) (map[string]ColumnType, error) {
+	if len(v.Columns) == 0 {
+		return nil, fmt.Errorf("materialized view has no columns")
+	}
+
+	schema := make(map[string]ColumnType)
+	selected := make(map[string]bool) // GROUP BY columns written to the view
+
+	for _, column := range v.Columns {
+		if _, ok := schema[column.Name]; ok || column.Name == "" {
+			return nil, fmt.Errorf("column name: %q is empty or used twice", column.Name)
+		}
+
+		sourceType := TYPE_VARCHAR
+		name, isColumn := viewSourceColumn(column.Expr)
+		if isColumn {
+			colType, ok := source.Schema[name]
+			if !ok {
+				return nil, fmt.Errorf("column: %s on table: %s doesn't exist", name, v.From)
+			}
+
+			sourceType = colType.Type
+			if isSerialType(sourceType) || sourceType == TYPE_PRIMARY {
+				sourceType = TYPE_BIGINT
+			}
+		}
+
+		colType := sourceType
+		switch column.Function {
+		case "":
+			if v.aggregated() {
+				if !isColumn || !slices.Contains(v.GroupBy, name) {
+					return nil, fmt.Errorf("column: %s must be in GROUP BY or an aggregate", column.Name)
+				}
+				selected[name] = true
+			} else if column.Expr == nil {
+				return nil, fmt.Errorf("column: %s has no expression", column.Name)
+			}
+		case AGG_COUNT:
+			colType = TYPE_BIGINT
+		case AGG_SUM:
+			colType = TYPE_DECIMAL
+			if isIntegerType(sourceType) {
+				colType = TYPE_BIGINT
+			}
+		case AGG_AVG:
+			colType = TYPE_DECIMAL
+		case AGG_MIN, AGG_MAX:
+		default:
+			return nil, fmt.Errorf("unsupported aggregate: %s", column.Function)
+		}
+
+		if column.Function != "" && column.Function != AGG_COUNT && column.Expr == nil {
+			return nil, fmt.Errorf("%s needs an argument", column.Function)
+		}
+
+		schema[column.Name] = ColumnType{Type: colType}
+	}
+
+	for _, column := range v.GroupBy {
+		if !selected[column] {
+			return nil, fmt.Errorf("GROUP BY column: %s must be selected", column)
+		}
+	}
+
+	return schema, nil
+}
+
+// groupKey identifies the group of a source row.
+func groupKey(values map[string]string, columns []string) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		if val, ok := values[column]; ok {
+			parts[i] = "=" + val // "" is NULL
+		}
+	}
+
+	return strings.Join(parts, ATTR_SEPARATOR)
+}
+
+// rowKey is the groupKey of a row of the view table.
+func (v *MaterializedView) rowKey(values map[string]string) string {
+	keys := make(map[string]string, len(v.GroupBy))
+	for _, column := range v.Columns {
+		if name, ok := viewSourceColumn(column.Expr); ok && column.Function == "" {
+			if val, ok := values[column.Name]; ok {
+				keys[name] = val
+			}
+		}
+	}
+
+	return groupKey(keys, v.GroupBy)
+}
+
+func (v *MaterializedView) newGroup(values map[string]string) *viewGroup {
+	group := &viewGroup{
+		keys:   make(map[string]string),
+		counts: make([]int64, len(v.Columns)),
+		sums:   make([]*big.Rat, len(v.Columns)),
+		bounds: make([]Datum, len(v.Columns)),
+	}
+
+	for _, column := range v.GroupBy {
+		if val, ok := values[column]; ok {
+			group.keys[column] = val
+		}
+	}
+
+	for i := range group.sums {
+		group.sums[i] = new(big.Rat)
+	}
+
+	return group
+}
+
+// fold adds (sign 1) or takes away (sign -1) a source row. Rows outside of
+// the view and, when only is set, of the listed groups are skipped.
+func (v *MaterializedView) fold(state *viewState, row *RowV2, sign int64, ectx *ExprContext, only map[string]bool) (string, bool, error) {
+	if v.Condition != nil {
+		matched, err := EvalPredicate(v.Condition, row, ectx)
+		if err != nil || !matched {
+			return "", false, err
+		}
+	}
+
+	key := groupKey(row.Values, v.GroupBy)
+	if only != nil && !only[key] {
+		return "", false, nil
+	}
+
+	group, ok := state.groups[key]
+	if !ok {
+		group = v.newGroup(row.Values)
+		state.groups[key] = group
+	}
+
+	group.rows += sign
+	for i, column := range v.Columns {
+		if column.Function == "" {
+			continue
+		}
+
+		if column.Expr == nil { // COUNT(*)
+			group.counts[i] += sign
+			continue
+		}
+
+		d, err := EvalExpr(column.Expr, row, ectx)
+		if err != nil {
+			return "", false, fmt.Errorf("column: %s: %w", column.Name, err)
+		}
+
+		if d.IsNull() {
+			continue
+		}
+		group.counts[i] += sign
+
+		switch column.Function {
+		case AGG_SUM, AGG_AVG:
+			num, ok := new(big.Rat).SetString(d.String())
+			if !ok {
+				return "", false, fmt.Errorf("column: %s: %s isn't a number", column.Name, d.String())
+			}
+
+			if sign < 0 {
+				num.Neg(num)
+			}
+			group.sums[i].Add(group.sums[i], num)
+		case AGG_MIN, AGG_MAX:
+			bound := group.bounds[i]
+			if bound.IsNull() {
+				if sign > 0 {
+					group.bounds[i] = d
+				}
+				continue
+			}
+
+			cmp, err := CompareDatums(d, bound)
+			if err != nil {
+				return "", false, fmt.Errorf("column: %s: %w", column.Name, err)
+			}
+
+			switch {
+			case sign < 0 && cmp == 0:
+				group.stale = true
+			case sign > 0 && (column.Function == AGG_MIN && cmp < 0 || column.Function == AGG_MAX && cmp > 0):
+				group.bounds[i] = d
+			}
+		}
+	}
+
+	return key, true, nil
+}
+
+// groupRow is the row of the view table for a group, NULLs are left out.
+func (v *MaterializedView) groupRow(group *viewGroup) map[string]string {
+	values := make(map[string]string)
+	for i, column := range v.Columns {
+		var val string
+		var ok bool
+
+		switch column.Function {
+		case "":
+			name, _ := viewSourceColumn(column.Expr)
+			val, ok = group.keys[name]
+		case AGG_COUNT:
+			val, ok = strconv.FormatInt(group.counts[i], 10), true
+		case AGG_SUM:
+			val, ok = formatRat(group.sums[i]), group.counts[i] > 0
+		case AGG_AVG:
+			if group.counts[i] > 0 {
+				avg := new(big.Rat).Quo(group.sums[i], new(big.Rat).SetInt64(group.counts[i]))
+				val, ok = formatRat(avg), true
+			}
+		case AGG_MIN, AGG_MAX:
+			val, ok = group.bounds[i].String(), !group.bounds[i].IsNull()
+		}
+
+		if ok {
+			values[column.Name] = val
+		}
+	}
+
+	return values
+}
+
+func formatRat(r *big.Rat) string {
+	if r.IsInt() {
+		return r.RatString()
+	}
+
+	return strings.TrimRight(r.FloatString(VIEW_FRACTION_DIGITS), "0")
+}
+
+// project is the row of a view without aggregates, nil when filtered out.
+func (v *MaterializedView) project(row *RowV2, ectx *ExprContext) (map[string]string, error) {
+	if v.Condition != nil {
+		matched, err := EvalPredicate(v.Condition, row, ectx)
+		if err != nil || !matched {
+			return nil, err
+		}
+	}
+
+	values := make(map[string]string, len(v.Columns))
+	for _, column := range v.Columns {
+		d, err := EvalExpr(column.Expr, row, ectx)
+		if err != nil {
+			return nil, fmt.Errorf("column: %s: %w", column.Name, err)
+		}
+
+		if !d.IsNull() {
+			values[column.Name] = d.String()
+		}
+	}
+
+	return values, nil
+}
+
+// refreshView rebuilds the view table from its source, the caller holds
+// view.mu. The rows go into new files swapped in at once, a failed refresh
+// leaves the view as it was.
+func (qe *QueryEngine) refreshView(name string, view *MaterializedView) error {
+	manager := qe.BufferPoolManager.DiskManager
+	view.state = nil
+
+	source, ok := manager.PageCatalog.Tables[view.From]
+	if !ok {
+		return fmt.Errorf("table: %s read by: %s doesn't exist", view.From, name)
+	}
+
+	sourceObj, err := GetTableObj(view.From, manager)
+	if err != nil {
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+
+	ectx := NewExprContext(nil, source.Schema)
+	state := &viewState{groups: make(map[string]*viewGroup)}
+
+	var rows []map[string]string
+	err = qe.scanRows(sourceObj, source, func(row *RowV2) error {
+		if view.aggregated() {
+			_, _, err := view.fold(state, row, 1, ectx, nil)
+			return err
+		}
+
+		values, err := view.project(row, ectx)
+		if values != nil {
+			rows = append(rows, values)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("scanRows failed: %w", err)
+	}
+
+	if view.aggregated() {
+		// aggregates without GROUP BY have their row on an empty source too
+		if len(view.GroupBy) == 0 && len(state.groups) == 0 {
+			state.groups[""] = view.newGroup(nil)
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(state.groups)) {
+			rows = append(rows, view.groupRow(state.groups[key]))
+		}
+	}
+
+	viewRows := make([]RowV2, len(rows))
+	for i, values := range rows {
+		viewRows[i] = RowV2{ID: GenerateRandomID(), Values: values}
+	}
+
+	if err := qe.replaceTable(name, viewRows); err != nil {
+		return fmt.Errorf("replaceTable failed: %w", err)
+	}
+
+	if view.aggregated() {
+		view.state = state
+	}
+
+	return nil
+}
+
+func (qe *QueryEngine) writeViewRows(name string, tableObj *TableObj, tableInfo *TableInfo, rows []map[string]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	encodedRows, err := prepareRows(rows, "", name, tableInfo, "", qe.BufferPoolManager.Wal, true)
+	if err != nil {
+		return fmt.Errorf("prepareRows failed: %w", err)
+	}
+
+	if err := findAndUpdate(qe.BufferPoolManager, tableObj, tableInfo, name, encodedRows, nil); err != nil {
+		return fmt.Errorf("findAndUpdate failed: %w", err)
+	}
+
+	return nil
+}
+
+// MaintainViews folds the records of a committed transaction into the
+// aggregate views of the tables it changed (see WalManager.OnCommit).
+func (qe *QueryEngine) MaintainViews(records []*LogRecord) {
+	catalog := qe.BufferPoolManager.DiskManager.PageCatalog
+
+	for _, name := range slices.Sorted(maps.Keys(catalog.Materialized)) {
+		view := catalog.Materialized[name]
+		if !view.aggregated() {
+			continue
+		}
+
+		var own []*LogRecord
+		for _, record := range records {
+			if record.TableID == view.From {
+				own = append(own, record)
+			}
+		}
+
+		if len(own) == 0 {
+			continue
+		}
+
+		if err := qe.maintainView(name, view, own); err != nil {
+			logger.Log.Errorf("maintaining materialized view: %s failed, rebuilt on the next commit: %v", name, err)
+		}
+	}
+}
+
+func (qe *QueryEngine) maintainView(name string, view *MaterializedView, records []*LogRecord) (err error) {
+	view.mu.Lock()
+	defer view.mu.Unlock()
+
+	defer func() {
+		if err != nil {
+			view.state = nil
+		}
+	}()
+
+	truncated := slices.ContainsFunc(records, func(r *LogRecord) bool { return r.Type == LogTypeTruncate })
+	if view.state == nil || truncated {
+		return qe.refreshView(name, view)
+	}
+
+	manager := qe.BufferPoolManager.DiskManager
+	source := manager.PageCatalog.Tables[view.From]
+	ectx := NewExprContext(nil, source.Schema)
+
+	changed := make(map[string]bool)
+	fold := func(image []byte, sign int64) error {
+		if image == nil {
+			return nil
+		}
+
+		var row RowV2
+		if err := DecodeRow(&row, bytes.NewReader(image)); err != nil {
+			return fmt.Errorf("DecodeRow failed: %w", err)
+		}
+
+		if err := DecodeStoredValues(&row, source); err != nil {
+			return fmt.Errorf("DecodeStoredValues failed: %w", err)
+		}
+
+		key, folded, err := view.fold(view.state, &row, sign, ectx, nil)
+		if folded {
+			changed[key] = true
+		}
+		return err
+	}
+
+	for _, record := range records {
+		switch record.Type {
+		case LogTypeInsert:
+			err = fold(record.AfterImage, 1)
+		case LogTypeDelete:
+			err = fold(record.BeforeImage, -1)
+		case LogTypeUpdate:
+			if err = fold(record.BeforeImage, -1); err == nil {
+				err = fold(record.AfterImage, 1)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	// groups whose MIN or MAX went away are folded again from the source
+	stale := make(map[string]bool)
+	for key := range changed {
+		if group := view.state.groups[key]; group.stale {
+			stale[key] = true
+			delete(view.state.groups, key)
+		}
+	}
+
+	if len(stale) > 0 {
+		sourceObj, err := GetTableObj(view.From, manager)
+		if err != nil {
+			return fmt.Errorf("GetTableObj failed: %w", err)
+		}
+
+		err = qe.scanRows(sourceObj, source, func(row *RowV2) error {
+			_, _, err := view.fold(view.state, row, 1, ectx, stale)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("scanRows failed: %w", err)
+		}
+	}
+
+	var rows []map[string]string
+	for _, key := range slices.Sorted(maps.Keys(changed)) {
+		group, ok := view.state.groups[key]
+		if !ok {
+			continue // recomputed without rows left
+		}
+
+		if group.rows < 0 {
+			return fmt.Errorf("group: %q is out of sync with: %s", key, view.From)
+		}
+
+		if group.rows == 0 && len(view.GroupBy) > 0 {
+			delete(view.state.groups, key)
+			continue
+		}
+
+		rows = append(rows, view.groupRow(group))
+	}
+
+	tableInfo := manager.PageCatalog.Tables[name]
+	tableObj, err := GetTableObj(name, manager)
+	if err != nil {
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+
+	match := func(row *RowV2) (bool, error) { return changed[view.rowKey(row.Values)], nil }
+	if err := qe.deleteRows(tableObj, tableInfo, nil, match, false, nil, "", true); err != nil {
+		return fmt.Errorf("deleteRows failed: %w", err)
+	}
+
+	return qe.writeViewRows(name, tableObj, tableInfo, rows)
+}
+
+// handleCreateMaterializedView runs CREATE MATERIALIZED VIEW name AS SELECT ...,
+// the view table is created and filled by the same statement.
+func (qe *QueryEngine) handleCreateMaterializedView(plan map[string]any) Result {
+	manager := qe.BufferPoolManager.DiskManager
+	catalog := manager.PageCatalog
+	name := plan["name"].(string)
+
+	encoded, err := json.Marshal(plan)
+	if err != nil {
+		return handleError(fmt.Errorf("encoding the plan failed: %w", err), "failed")
+	}
+
+	view := &MaterializedView{}
+	if err := json.Unmarshal(encoded, view); err != nil {
+		return handleError(fmt.Errorf("decoding the view failed: %w", err), "failed")
+	}
+
+	if _, ok := catalog.Views[name]; ok {
+		return handleError(fmt.Errorf("view: %s already exists", name), "failed")
+	}
+
+	if _, ok := catalog.Materialized[view.From]; ok {
+		return handleError(fmt.Errorf("materialized view: %s can't read another one", name), "failed")
+	}
+
+	source, ok := catalog.Tables[view.From]
+	if !ok {
+		return handleError(fmt.Errorf("table: %s read by: %s doesn't exist", view.From, name), "failed")
+	}
+
+	schema, err := view.schema(source)
+	if err != nil {
+		return handleError(fmt.Errorf("materialized view: %s: %w", name, err), "failed")
+	}
+
+	if _, ok := catalog.Tables[name]; ok {
+		return handleError(fmt.Errorf("table: %s already exists", name), "failed")
+	}
+
+	// set before the table so a single catalog write holds both
+	if catalog.Materialized == nil {
+		catalog.Materialized = make(map[string]*MaterializedView)
+	}
+	catalog.Materialized[name] = view
+
+	if err := manager.CreateTable(name, TableInfo{Schema: schema}); err != nil {
+		delete(catalog.Materialized, name)
+		return handleError(fmt.Errorf("CreateTable failed: %w", err), "failed")
+	}
+
+	view.mu.Lock()
+	err = qe.refreshView(name, view)
+	view.mu.Unlock()
+	if err != nil {
+		return handleError(fmt.Errorf("refreshView failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Materialized View Created"}
+}
+
+// handleRefreshMaterializedView runs REFRESH MATERIALIZED VIEW name
+func (qe *QueryEngine) handleRefreshMaterializedView(plan map[string]any) Result {
+	name := plan["name"].(string)
+
+	view, ok := qe.BufferPoolManager.DiskManager.PageCatalog.Materialized[name]
+	if !ok {
+		return handleError(fmt.Errorf("materialized view: %s doesn't exist", name), "failed")
+	}
+
+	view.mu.Lock()
+	defer view.mu.Unlock()
+
+	if err := qe.refreshView(name, view); err != nil {
+		return handleError(fmt.Errorf("refreshView failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Materialized View Refreshed"}
+}
+
+// handleDropMaterializedView runs DROP MATERIALIZED VIEW [IF EXISTS] name
+func (qe *QueryEngine) handleDropMaterializedView(plan map[string]any) Result {
+	name := plan["name"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+	catalog := manager.PageCatalog
+
+	view, ok := catalog.Materialized[name]
+	if !ok {
+		if ifExists, _ := plan["ifExists"].(bool); ifExists {
+			return Result{Msg: "Materialized View Doesn't Exist"}
+		}
+		return handleError(fmt.Errorf("materialized view: %s doesn't exist", name), "failed")
+	}
+
+	if err := catalog.checkNoViews(name); err != nil {
+		return handleError(fmt.Errorf("can't drop: %w", err), "failed")
+	}
+
+	// removed with the table entry by the single catalog write of DropTable
+	delete(catalog.Materialized, name)
+	qe.BufferPoolManager.DiscardTablePages(name)
+	if err := manager.DropTable(name); err != nil {
+		catalog.Materialized[name] = view
+		return handleError(fmt.Errorf("DropTable failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Materialized View Dropped"}
+}
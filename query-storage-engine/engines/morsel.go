@@ -0,0 +1,291 @@
+package engines
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+)
+
+// Morsel-driven execution
+//
+// A table is split into morsels of MORSEL_PAGES pages. Every morsel is a task
+// for the worker pool shared by all queries: the worker reads the pages,
+// decodes the rows into a batch and runs the fused stages of the query
+// (filters, projections) on it. A query only keeps its share of the workers
+// busy, so a big scan can't starve the others.
+
+const MORSEL_PAGES = 16
+
+type BatchStage func(b *Batch) error
+
+type WorkerPool struct {
+	tasks   chan func()
+	workers int
+	active  atomic.Int32 // queries currently dispatching morsels
+}
+
+// NewWorkerPool starts the workers, workers <= 0 means one per GOMAXPROCS.
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	wp := &WorkerPool{tasks: make(chan func()), workers: workers}
+	for range workers {
+		go func() {
+			for task := range wp.tasks {
+				task()
+			}
+		}()
+	}
+
+	return wp
+}
+
+// Parallelism is the number of morsels a query may have in flight,
+// the workers are split evenly among the running queries.
+func (wp *WorkerPool) Parallelism() int {
+	active := max(int(wp.active.Load()), 1)
+	return max(wp.workers/active, 1)
+}
+
+type morselResult struct {
+	batches []*Batch
+	err     error
+}
+
+// Run executes task for every morsel on the pool. Results come back to the
+// calling goroutine which hands them to emit, a slow consumer blocks the
+// query instead of the shared workers.
+func (wp *WorkerPool) Run(ctx context.Context, morsels int, task func(morsel int) ([]*Batch, error), emit func(*Batch) error) error {
+	wp.active.Add(1)
+	defer wp.active.Add(-1)
+
+	results := make(chan morselResult, wp.workers)
+
+	var firstErr error
+	next, inFlight := 0, 0
+	for next < morsels || inFlight > 0 {
+		canDispatch := firstErr == nil && ctx.Err() == nil && next < morsels
+		if canDispatch && inFlight < wp.Parallelism() {
+			morsel := next
+			wp.tasks <- func() {
+				batches, err := task(morsel)
+				results <- morselResult{batches: batches, err: err}
+			}
+
+			next++
+			inFlight++
+			continue
+		}
+
+		if !canDispatch && inFlight == 0 {
+			break
+		}
+
+		res := <-results
+		inFlight--
+
+		if firstErr != nil {
+			continue
+		}
+
+		if res.err != nil {
+			firstErr = res.err
+			continue
+		}
+
+		for _, batch := range res.batches {
+			if err := emit(batch); err != nil {
+				firstErr = err
+				break
+			}
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+
+	return firstErr
+}
+
+// scanMorsel reads the pages of one morsel and returns its batches after
+// running the fused stages, pages cached in the buffer pool and the ones
+// skip rules out are skipped like in FullTableScan.
+func scanMorsel(tableObj *TableObj, tableInfo *TableInfo, pageTable map[PageID]FrameID, morsel, numPages int, stages []BatchStage, skip func(PageID) bool) ([]*Batch, error) {
+	first := morsel * MORSEL_PAGES
+	count := min(MORSEL_PAGES, numPages-first)
+
+	buffer := make([]byte, count*PageSizeV2)
+	n, err := tableObj.DataFile.ReadAt(buffer, int64(first*PageSizeV2))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading morsel %d failed: %w", morsel, err)
+	}
+
+	var pages []*PageV2
+	for p := range n / PageSizeV2 {
+		page, err := DecodePageV2(buffer[p*PageSizeV2 : (p+1)*PageSizeV2])
+		if err != nil {
+			return nil, fmt.Errorf("DecodePageV2 failed: %w", err)
+		}
+
+		if _, ok := pageTable[PageID(page.Header.ID)]; ok {
+			continue
+		}
+
+		if skip != nil && skip(PageID(page.Header.ID)) {
+			continue
+		}
+		pages = append(pages, page)
+	}
+
+	return batchPages(pages, tableObj, tableInfo, stages)
+}
+
+// scanPageList is scanMorsel for the pages an index picked, they are read
+// one by one at their offset.
+func scanPageList(tableObj *TableObj, tableInfo *TableInfo, pageTable map[PageID]FrameID, pageIDs []PageID, stages []BatchStage) ([]*Batch, error) {
+	var pages []*PageV2
+	for _, pageID := range pageIDs {
+		if _, ok := pageTable[pageID]; ok {
+			continue
+		}
+
+		tableObj.DirectoryPage.Mu.RLock()
+		pageObj, ok := tableObj.DirectoryPage.Value[pageID]
+		tableObj.DirectoryPage.Mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		pageObj.Mu.RLock()
+		offset := pageObj.Offset
+		pageObj.Mu.RUnlock()
+
+		pageBytes, err := ReadPageAtOffset(tableObj.DataFile, offset)
+		if err != nil {
+			return nil, fmt.Errorf("ReadPageAtOffset failed: %w", err)
+		}
+
+		page, err := DecodePageV2(pageBytes)
+		if err != nil {
+			return nil, fmt.Errorf("DecodePageV2 failed: %w", err)
+		}
+		pages = append(pages, page)
+	}
+
+	return batchPages(pages, tableObj, tableInfo, stages)
+}
+
+// batchPages decodes the rows of the pages into batches and runs the stages
+// on them.
+func batchPages(pages []*PageV2, tableObj *TableObj, tableInfo *TableInfo, stages []BatchStage) ([]*Batch, error) {
+	var batches []*Batch
+	flush := func(batch *Batch) error {
+		for _, stage := range stages {
+			if err := stage(batch); err != nil {
+				return err
+			}
+		}
+
+		if batch.NumLive() > 0 {
+			batches = append(batches, batch)
+		}
+		return nil
+	}
+
+	builder := NewBatchBuilder(tableInfo.Schema)
+	for _, page := range pages {
+		if err := collectRows(page, tableObj, tableInfo, builder); err != nil {
+			return nil, err
+		}
+
+		if builder.Len() >= BATCH_THRESHOLD {
+			if err := flush(builder.Flush()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if builder.Len() > 0 {
+		if err := flush(builder.Flush()); err != nil {
+			return nil, err
+		}
+	}
+
+	return batches, nil
+}
+
+func collectRows(page *PageV2, tableObj *TableObj, tableInfo *TableInfo, builder *BatchBuilder) error {
+	return visitRows(page, tableObj, tableInfo, func(row *RowV2) error {
+		builder.Append(row)
+		return nil
+	})
+}
+
+// visitRows decodes every live row of the page and hands it to fn.
+func visitRows(page *PageV2, tableObj *TableObj, tableInfo *TableInfo, fn func(row *RowV2) error) error {
+	tableObj.DirectoryPage.Mu.RLock()
+	pageObj, found := tableObj.DirectoryPage.Value[PageID(page.Header.ID)]
+	tableObj.DirectoryPage.Mu.RUnlock()
+	if !found {
+		return fmt.Errorf("pageObj not found, pageId: %d", page.Header.ID)
+	}
+
+	pageObj.Mu.RLock()
+	defer pageObj.Mu.RUnlock()
+
+	for _, location := range pageObj.PointerArray {
+		if location.Free {
+			continue
+		}
+
+		rowBytes := page.Data[location.Offset : location.Offset+location.Length]
+		var row RowV2
+
+		DecodeRow(&row, bytes.NewReader(rowBytes))
+		if err := DecodeStoredValues(&row, tableInfo); err != nil {
+			return fmt.Errorf("DecodeStoredValues failed: %w", err)
+		}
+
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanRows calls fn for every live row of the table, pages cached in the
+// buffer pool included.
+func (qe *QueryEngine) scanRows(tableObj *TableObj, tableInfo *TableInfo, fn func(row *RowV2) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pageChan := make(chan *PageV2, 100)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- qe.BufferPoolManager.FullTableScan(ctx, pageChan, tableObj, tableInfo.NumOfPages, nil)
+	}()
+
+	var err error
+	for page := range pageChan {
+		if err != nil {
+			continue // draining after a failure
+		}
+
+		if err = visitRows(page, tableObj, tableInfo, fn); err != nil {
+			cancel()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return <-scanErr
+}
@@ -3,46 +3,70 @@ package engines
 import (
 	"context"
 	"fmt"
-	"sync"
-
-	"github.com/scylladb/go-set/strset"
+	"slices"
 )
 
 const (
-	BATCH_THRESHOLD       = 1200
-	PROJECTION_WORKERS    = 5
-	ROW_COLLECTOR_WORKERS = 10
-	FILTER_WORKERS
+	BATCH_THRESHOLD = 1200
 )
 
 type Node interface {
-	GetOutputChan() chan []*RowV2
+	GetOutputChan() chan *Batch
 	initialization(ctx context.Context) error
-	GetRes() []*RowV2
 	GetNodeType() string
 }
 
+// sendBatch hands a batch to the next node, it gives up once the query is
+// cancelled so a consumer that stopped reading never blocks its producers.
+func sendBatch(outerCtx, innerCtx context.Context, outputChan chan *Batch, batch *Batch) error {
+	select {
+	case outputChan <- batch:
+		return nil
+	case <-outerCtx.Done():
+		return outerCtx.Err()
+	case <-innerCtx.Done():
+		return innerCtx.Err()
+	}
+}
+
+func sendPage(outerCtx, innerCtx context.Context, pageChan chan *PageV2, page *PageV2) error {
+	select {
+	case pageChan <- page:
+		return nil
+	case <-outerCtx.Done():
+		return outerCtx.Err()
+	case <-innerCtx.Done():
+		return innerCtx.Err()
+	}
+}
+
+// CollectorNode is the tail of the pipeline, it turns batches back into
+// rows and puts them into a small buffered sink read by the cursor. A slow
+// reader fills the sink and blocks the nodes above instead of piling rows
+// up in memory.
 type CollectorNode struct {
 	Type      string
-	InputChan chan []*RowV2
-	Rows      *[]*RowV2
+	InputChan chan *Batch
+	Sink      chan []*RowV2
 }
 
 func (cn CollectorNode) GetNodeType() string {
 	return cn.Type
 }
 
-func (cn CollectorNode) GetRes() []*RowV2 {
-	return *cn.Rows
-}
-
-func (cn CollectorNode) GetOutputChan() chan []*RowV2 {
+func (cn CollectorNode) GetOutputChan() chan *Batch {
 	return nil
 }
 
 func (cn CollectorNode) initialization(ctx context.Context) error {
-	for rows := range cn.InputChan {
-		*cn.Rows = append(*cn.Rows, rows...)
+	defer close(cn.Sink)
+
+	for batch := range cn.InputChan {
+		select {
+		case cn.Sink <- batch.ToRows():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	return nil
@@ -52,24 +76,23 @@ type TableScanNode struct {
 	Type       string
 	TableName  string
 	Dm         *BufferPoolManager
-	OutputChan chan []*RowV2
+	Pool       *WorkerPool
+	Stages     []BatchStage // filters/projections fused into the scan
+	Condition  any          // filter right on top of the scan, an index or the page filters narrow the pages with it
+	Ectx       *ExprContext
+	OutputChan chan *Batch
 }
 
 func (tsn TableScanNode) GetNodeType() string {
 	return tsn.Type
 }
 
-func (tsn TableScanNode) GetRes() []*RowV2 {
-	return nil
-}
-
-func (tsn TableScanNode) GetOutputChan() chan []*RowV2 {
+func (tsn TableScanNode) GetOutputChan() chan *Batch {
 	return tsn.OutputChan
 }
 
-func (tsn TableScanNode) initialization(outerCtx context.Context) error {
-	pageChan := make(chan *PageV2, 400)
-	errChan := make(chan error, ROW_COLLECTOR_WORKERS+1)
+func (tsn TableScanNode) initialization(ctx context.Context) error {
+	defer close(tsn.OutputChan)
 
 	tableObj, err := GetTableObj(tsn.TableName, tsn.Dm.DiskManager)
 	if err != nil {
@@ -78,211 +101,138 @@ func (tsn TableScanNode) initialization(outerCtx context.Context) error {
 
 	tableStats := tsn.Dm.DiskManager.PageCatalog.Tables[tsn.TableName]
 
-	innerCtx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	var diskWg sync.WaitGroup
-	diskWg.Add(1)
-	go func() {
-		defer diskWg.Done()
-		if err := GetTablePagesFromDisk(outerCtx, innerCtx, pageChan, tableObj, tsn.Dm.PageTable, tableStats.NumOfPages); err != nil {
-			errChan <- fmt.Errorf("FullTableScan Failed: %w", err)
-			cancel()
-		}
-	}()
-
-	var rowWg sync.WaitGroup
-	for range ROW_COLLECTOR_WORKERS {
-		rowWg.Add(1)
-		go func() {
-			defer rowWg.Done()
-			if err := RowCollector(outerCtx, innerCtx, pageChan, tsn.OutputChan, tableObj); err != nil {
-				errChan <- fmt.Errorf("RowCollector Failed: %w", err)
-				cancel()
-			}
-		}()
+	stat, err := tableObj.DataFile.Stat()
+	if err != nil {
+		return fmt.Errorf("DataFile.Stat failed: %w", err)
 	}
 
-	diskWg.Wait()
-	close(pageChan)
-
-	rowWg.Wait()
-	close(errChan)
-	close(tsn.OutputChan)
+	numPages := int(stat.Size() / PageSizeV2)
+	morsels := (numPages + MORSEL_PAGES - 1) / MORSEL_PAGES
+	skip := tableObj.skipPages(tsn.Condition, tableStats, tsn.Ectx)
 
-	select {
-	case err := <-errChan:
-		return err
-	default:
-		return nil
+	scan := func(morsel int) ([]*Batch, error) {
+		return scanMorsel(tableObj, tableStats, tsn.Dm.PageTable, morsel, numPages, tsn.Stages, skip)
 	}
-}
 
-type ProjectionNode struct {
-	Type       string
-	Lm         *LockManager
-	Set        *strset.Set
-	InputChan  chan []*RowV2
-	OutputChan chan []*RowV2
-}
-
-func (pn ProjectionNode) GetNodeType() string {
-	return pn.Type
-}
-
-func (pn ProjectionNode) GetRes() []*RowV2 {
-	return nil
-}
+	pages, indexed, err := tableObj.candidatePages(tsn.Condition, tsn.Ectx)
+	if err != nil {
+		return fmt.Errorf("candidatePages failed: %w", err)
+	}
 
-func (pn ProjectionNode) GetOutputChan() chan []*RowV2 {
-	return pn.OutputChan
-}
+	if indexed {
+		if skip != nil {
+			pages = slices.DeleteFunc(pages, skip)
+		}
 
-func (pn ProjectionNode) initialization(outerCtx context.Context) error {
-	var wg sync.WaitGroup
-	errChan := make(chan error, PROJECTION_WORKERS)
-	innerCtx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	for range PROJECTION_WORKERS {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := Projection(outerCtx, innerCtx, pn.Lm, pn.InputChan, pn.OutputChan, pn.Set); err != nil {
-				errChan <- fmt.Errorf("Projection Failed: %w", err)
-				cancel()
-			}
-		}()
+		morsels = (len(pages) + MORSEL_PAGES - 1) / MORSEL_PAGES
+		scan = func(morsel int) ([]*Batch, error) {
+			first := morsel * MORSEL_PAGES
+			return scanPageList(tableObj, tableStats, tsn.Dm.PageTable, pages[first:min(first+MORSEL_PAGES, len(pages))], tsn.Stages)
+		}
 	}
 
-	wg.Wait()
-	close(pn.OutputChan)
-	close(errChan)
+	emit := func(batch *Batch) error {
+		return sendBatch(ctx, ctx, tsn.OutputChan, batch)
+	}
 
-	select {
-	case err := <-errChan:
-		return err
-	default:
-		return nil
+	if err := tsn.Pool.Run(ctx, morsels, scan, emit); err != nil {
+		return fmt.Errorf("morsel scan failed: %w", err)
 	}
-}
 
-type FilterNode struct {
-	Type       string
-	Lm         *LockManager
-	InnerMap   map[string]interface{}
-	RefList    map[string]interface{}
-	InputChan  chan []*RowV2
-	OutputChan chan []*RowV2
+	return nil
 }
 
-func (fn FilterNode) GetNodeType() string {
-	return fn.Type
+// StageNode runs the stages that can't be fused into a scan,
+// e.g a projection on top of an aggregate.
+type StageNode struct {
+	Type       string
+	Stages     []BatchStage
+	InputChan  chan *Batch
+	OutputChan chan *Batch
 }
 
-func (fn FilterNode) GetRes() []*RowV2 {
-	return nil
+func (sn StageNode) GetNodeType() string {
+	return sn.Type
 }
 
-func (fn FilterNode) GetOutputChan() chan []*RowV2 {
-	return fn.OutputChan
+func (sn StageNode) GetOutputChan() chan *Batch {
+	return sn.OutputChan
 }
 
-func (fn FilterNode) initialization(outerCtx context.Context) error {
-	var wg sync.WaitGroup
-	errChan := make(chan error, FILTER_WORKERS)
-
-	innerCtx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func (sn StageNode) initialization(ctx context.Context) error {
+	defer close(sn.OutputChan)
 
-	for range FILTER_WORKERS {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := Filter(outerCtx, innerCtx, fn.Lm, fn.InnerMap, fn.RefList, fn.InputChan, fn.OutputChan); err != nil {
-				errChan <- fmt.Errorf("Filter Failed: %w", err)
-				cancel()
+	for batch := range sn.InputChan {
+		for _, stage := range sn.Stages {
+			if err := stage(batch); err != nil {
+				return err
 			}
-		}()
-	}
+		}
 
-	wg.Wait()
-	close(fn.OutputChan)
-	close(errChan)
+		if batch.NumLive() == 0 {
+			continue
+		}
 
-	select {
-	case err := <-errChan:
-		return err
-	default:
-		return nil
+		if err := sendBatch(ctx, ctx, sn.OutputChan, batch); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 type SortNode struct {
 	Type       string
-	Lm         *LockManager
 	InnerMap   map[string]interface{}
-	InputChan  chan []*RowV2
-	OutputChan chan []*RowV2
+	Ectx       *ExprContext
+	InputChan  chan *Batch
+	OutputChan chan *Batch
 }
 
 func (sn SortNode) GetNodeType() string {
 	return sn.Type
 }
 
-func (sn SortNode) GetRes() []*RowV2 {
-	return nil
-}
-
-func (sn SortNode) GetOutputChan() chan []*RowV2 {
+func (sn SortNode) GetOutputChan() chan *Batch {
 	return sn.OutputChan
 }
 
 func (sn SortNode) initialization(ctx context.Context) error {
-	var allRows []*RowV2
+	var batches []*Batch
 
 	defer close(sn.OutputChan)
-	for rows := range sn.InputChan {
-		allRows = append(allRows, rows...)
+	for batch := range sn.InputChan {
+		batches = append(batches, batch)
 	}
 
-	Sort(ctx, sn.Lm, sn.InnerMap, &allRows, sn.OutputChan)
+	if err := Sort(ctx, sn.InnerMap, sn.Ectx, batches, sn.OutputChan); err != nil {
+		return fmt.Errorf("Sort failed: %w", err)
+	}
 
 	return nil
 }
 
 type AggregateNode struct {
 	Type         string
-	Lm           *LockManager
 	InnerMap     map[string]interface{}
 	GroupKey     string
 	SelectedCols []interface{}
-	InputChan    chan []*RowV2
-	OutputChan   chan []*RowV2
+	InputChan    chan *Batch
+	OutputChan   chan *Batch
 }
 
 func (cn AggregateNode) GetNodeType() string {
 	return cn.Type
 }
 
-func (cn AggregateNode) GetRes() []*RowV2 {
-	return nil
-}
-
-func (an AggregateNode) GetOutputChan() chan []*RowV2 {
+func (an AggregateNode) GetOutputChan() chan *Batch {
 	return an.OutputChan
 }
 
 func (an AggregateNode) initialization(ctx context.Context) error {
 	defer close(an.OutputChan)
 
-	var allRows []*RowV2
-
-	for rows := range an.InputChan {
-		allRows = append(allRows, rows...)
-	}
-
-	err := Aggregate(ctx, an.Lm, an.InnerMap, an.GroupKey, &allRows, an.SelectedCols, an.OutputChan)
+	err := Aggregate(ctx, an.InnerMap, an.GroupKey, an.SelectedCols, an.InputChan, an.OutputChan)
 	if err != nil {
 		return fmt.Errorf("Aggregate failed: %w", err)
 	}
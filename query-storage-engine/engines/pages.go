@@ -7,6 +7,7 @@ import (
 	"io"
 	"math/big"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
@@ -58,6 +59,7 @@ type PageInfo struct {
 	PointerArray []TupleLocation
 	Level        uint16
 	ExactFreeMem uint16
+	Zones        map[Column]ZoneMap // values of the columns, see zones.go
 	Mu           sync.RWMutex
 }
 
@@ -181,20 +183,81 @@ func ReadPageAtOffset(file *os.File, offset Offset) ([]byte, error) {
 	return pageData, nil
 }
 
+// UpdateCatalog writes the catalog of every database whose part of the
+// shared catalog changed. Each file is replaced atomically, the new version
+// is written and synced aside and renamed over the old one so a crash leaves
+// either of them, never a torn catalog.
 func (dm *DiskManagerV2) UpdateCatalog() error {
-	bytes, err := SerializeCatalog(dm.PageCatalog)
-	if err != nil {
-		return fmt.Errorf("SerializeCatalog failed: %w", err)
+	dm.catalogMu.Lock()
+	defer dm.catalogMu.Unlock()
+
+	for database, catalog := range dm.splitCatalog() {
+		encoded, err := SerializeCatalog(catalog)
+		if err != nil {
+			return fmt.Errorf("SerializeCatalog failed: %w", err)
+		}
+
+		if bytes.Equal(encoded, database.written) {
+			continue
+		}
+
+		if err := database.replaceCatalog(encoded); err != nil {
+			return err
+		}
 	}
 
-	_, err = dm.FileCatalog.WriteAt(bytes, 0)
+	dm.FileCatalog = dm.root.FileCatalog
+	return nil
+}
+
+func (database *Database) replaceCatalog(encoded []byte) error {
+	catalogPath := filepath.Join(database.Dir, CATALOG_FILE)
+	tmpPath := catalogPath + ".tmp"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
+		return fmt.Errorf("creating catalog copy failed: %w", err)
+	}
+
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
 		return fmt.Errorf("writing to file (failed): %w", err)
 	}
 
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing catalog copy failed: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, catalogPath); err != nil {
+		tmp.Close()
+		return fmt.Errorf("replacing catalog failed: %w", err)
+	}
+
+	if err := syncDir(database.Dir); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncDir failed: %w", err)
+	}
+
+	// the old handle points to the replaced file
+	database.FileCatalog.Close()
+	database.FileCatalog = tmp
+	database.written = encoded
+
 	return nil
 }
 
+// syncDir makes renames and removals inside the directory durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
 func GenerateRandomID() uint64 {
 	max := new(big.Int).Lsh(big.NewInt(1), 64)
 	randomNum, _ := rand.Int(rand.Reader, max)
@@ -1,8 +1,10 @@
 package engines
 
 import (
-	"context"
+	"a2gdb/logger"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -18,6 +20,7 @@ const (
 	AUTH = iota + 1
 	CREATE_TABLE
 	QUERY
+	CURSOR
 )
 
 type QueryEngine struct {
@@ -27,6 +30,7 @@ type QueryEngine struct {
 	QueryChan         chan *QueryInfo
 	ResultManager     *ResultManager
 	Scheduler         *QueryScheduler
+	Pool              *WorkerPool
 	InlineMu          sync.Mutex
 	SystemStats       *SystemStats
 	Config            *QueryEngineConfig
@@ -54,6 +58,8 @@ type QueryEngineConfig struct {
 	GarbageCollectionInterval time.Duration
 	AllowedRAMConsuption      uint64
 	MaxConcurrentQueries      int
+	Workers                   int  // shared scan workers, 0 => GOMAXPROCS
+	RewriteAfterAlter         bool // rewrite the table in the background after a column change
 }
 
 func (qe *QueryEngine) SystemInfoCollector() {
@@ -83,8 +89,11 @@ type QueryInfo struct {
 	Type           string
 	RawPlan        interface{}
 	tableName      string
+	database       string // DROP DATABASE holds every table of it
+	index          string // DROP INDEX holds the table of the index
 	TransactionOff bool
 	InduceErr      bool
+	Stream         bool // SELECT results are read through Result.Cursor
 }
 
 func (qe *QueryEngine) QueryManager() {
@@ -96,13 +105,33 @@ func (qe *QueryEngine) QueryManager() {
 		result, plan = unwrapPlannerInfo(queryPlan)
 
 		switch operation := plan["STATEMENT"]; operation {
-		case "CREATE_TABLE", "SELECT":
+		case "CREATE_TABLE", "CREATE_SEQUENCE", "CREATE_VIEW", "DROP_VIEW", "CREATE_MATERIALIZED_VIEW",
+			"CREATE_DATABASE", "USE", "SHOW_TABLES", "SELECT":
 			queryInfo.Type = "NON_CRUD"
 			qe.Scheduler.Queries <- queryInfo
-		case "INSERT", "DELETE", "UPDATE":
+		case "INSERT", "DELETE", "UPDATE", "TRUNCATE", "DROP_TABLE", "ALTER_TABLE":
 			queryInfo.Type = "CRUD"
 			queryInfo.tableName = plan["table"].(string)
 			qe.Scheduler.Queries <- queryInfo
+		case "REFRESH_MATERIALIZED_VIEW", "DROP_MATERIALIZED_VIEW":
+			// the view table is swapped or closed, no read may be on it
+			queryInfo.Type = "CRUD"
+			queryInfo.tableName = plan["name"].(string)
+			qe.Scheduler.Queries <- queryInfo
+		case "CREATE_INDEX":
+			// the index is built from the rows and added to the table's catalog
+			queryInfo.Type = "CRUD"
+			queryInfo.tableName, _ = plan["table"].(string)
+			qe.Scheduler.Queries <- queryInfo
+		case "DROP_INDEX":
+			queryInfo.Type = "CRUD"
+			queryInfo.index = plan["name"].(string)
+			qe.Scheduler.Queries <- queryInfo
+		case "DROP_DATABASE":
+			// the tables are torn down, no statement may be on any of them
+			queryInfo.Type = "CRUD"
+			queryInfo.database = plan["database"].(string)
+			qe.Scheduler.Queries <- queryInfo
 		default:
 			result.Error = fmt.Errorf("unsupported type: %s", operation)
 			result.Msg = "failed"
@@ -125,26 +154,100 @@ func unwrapPlannerInfo(queryPlan interface{}) (*Result, map[string]interface{})
 	return &result, plan
 }
 
+// InlineCruds runs a statement holding its table, the table is freed however
+// the statement ends, committed, aborted or failed before its transaction.
+// The background work the statement leaves runs after InlineMu and the
+// scheduler slot are released, still holding the table.
 func (qe *QueryEngine) InlineCruds(queryInfo *QueryInfo) {
-	qe.InlineMu.Lock()
-	defer qe.InlineMu.Unlock()
+	walManager := qe.BufferPoolManager.Wal
+	tables := qe.holdTables(queryInfo)
+	defer func() {
+		for _, table := range tables {
+			walManager.releaseTable(table)
+		}
+	}()
+
+	result := qe.QueryProcessingEntry(queryInfo)
+	qe.InlineMu.Unlock()
 
-	tablesMap := qe.BufferPoolManager.Wal.activeTxTable
-	tableInfo, ok := tablesMap[queryInfo.tableName]
-	if !ok {
-		tableInfo = &Table{notification: make(chan bool, 1)}
-		tablesMap[queryInfo.tableName] = tableInfo
+	qe.ResultManager.GlobalChannel <- result
+
+	if result.background == nil {
+		return
 	}
 
-	if tableInfo.activeTx {
-		for <-tableInfo.notification {
-			qe.ResultManager.GlobalChannel <- qe.QueryProcessingEntry(queryInfo)
+	if err := result.background(); err != nil {
+		logger.Log.WithField("table", queryInfo.tableName).Errorf("background work failed: %v", err)
+	}
+}
+
+// holdTables acquires the tables of the statement and returns under InlineMu.
+// Tables found through the catalog are listed again once held, a statement
+// that renamed or dropped one meanwhile makes it start over.
+func (qe *QueryEngine) holdTables(queryInfo *QueryInfo) []string {
+	walManager := qe.BufferPoolManager.Wal
+
+	for {
+		qe.InlineMu.Lock()
+		tables := qe.crudTables(queryInfo)
+		qe.InlineMu.Unlock()
+
+		for _, table := range tables {
+			walManager.acquireTable(table)
+		}
+
+		qe.InlineMu.Lock()
+		if slices.Equal(tables, qe.crudTables(queryInfo)) {
+			return tables
+		}
+		qe.InlineMu.Unlock()
+
+		for _, table := range tables {
+			walManager.releaseTable(table)
 		}
-		return
 	}
+}
 
-	tableInfo.activeTx = true
-	qe.ResultManager.GlobalChannel <- qe.QueryProcessingEntry(queryInfo)
+// crudTables lists the tables a CRUD statement holds: the table of the index
+// it creates or drops, or those of a dropped database in order so two
+// statements never wait on each other. No NON_CRUD statement runs, the
+// catalog can't gain a table while they are listed.
+func (qe *QueryEngine) crudTables(queryInfo *QueryInfo) []string {
+	catalog := qe.BufferPoolManager.DiskManager.PageCatalog
+
+	switch {
+	case queryInfo.database != "":
+		tables, _, _, _ := catalog.members(queryInfo.database)
+		sort.Strings(tables)
+		return tables
+	case queryInfo.index != "":
+		if owner, _ := catalog.indexTable(queryInfo.index); owner != "" {
+			return []string{owner}
+		}
+		return nil
+	}
+
+	return []string{queryInfo.tableName}
+}
+
+// asCrud runs fn like a CRUD statement whose tables are already held: once
+// no NON_CRUD statement runs, under InlineMu.
+func (qe *QueryEngine) asCrud(fn func() error) error {
+	qs := qe.Scheduler
+
+	qs.Mu.Lock()
+	for qs.NonCrud > 0 {
+		qs.CondNonCrud.Wait()
+	}
+	qs.Crud++
+	qs.Mu.Unlock()
+
+	defer func() { qe.ResultManager.SchedulerNotification <- &Result{QueryTye: "CRUD"} }()
+
+	qe.InlineMu.Lock()
+	defer qe.InlineMu.Unlock()
+
+	return fn()
 }
 
 func (qe *QueryEngine) QueryProcessingEntry(queryInfo *QueryInfo) *Result {
@@ -162,11 +265,51 @@ func (qe *QueryEngine) QueryProcessingEntry(queryInfo *QueryInfo) *Result {
 	case "CREATE_TABLE":
 		result = qe.handleCreate(plan)
 		result.QueryTye = "NON_CRUD"
+	case "CREATE_SEQUENCE":
+		result = qe.handleCreateSequence(plan)
+		result.QueryTye = "NON_CRUD"
+	case "CREATE_VIEW":
+		result = qe.handleCreateView(plan)
+		result.QueryTye = "NON_CRUD"
+	case "DROP_VIEW":
+		result = qe.handleDropView(plan)
+		result.QueryTye = "NON_CRUD"
+	case "CREATE_MATERIALIZED_VIEW":
+		result = qe.handleCreateMaterializedView(plan)
+		result.QueryTye = "NON_CRUD"
+	case "REFRESH_MATERIALIZED_VIEW":
+		result = qe.handleRefreshMaterializedView(plan)
+		result.QueryTye = "CRUD"
+	case "DROP_MATERIALIZED_VIEW":
+		result = qe.handleDropMaterializedView(plan)
+		result.QueryTye = "CRUD"
+	case "CREATE_INDEX":
+		result = qe.handleCreateIndex(plan)
+		result.QueryTye = "CRUD"
+	case "DROP_INDEX":
+		result = qe.handleDropIndex(plan)
+		result.QueryTye = "CRUD"
+	case "CREATE_DATABASE":
+		result = qe.handleCreateDatabase(plan)
+		result.QueryTye = "NON_CRUD"
+	case "DROP_DATABASE":
+		result = qe.handleDropDatabase(plan)
+		result.QueryTye = "CRUD"
+	case "USE":
+		result = qe.handleUse(plan)
+		result.QueryTye = "NON_CRUD"
+	case "SHOW_TABLES":
+		result = qe.handleShowTables(plan)
+		result.QueryTye = "NON_CRUD"
 	case "INSERT":
 		result = qe.handleInsert(plan, queryInfo.TransactionOff, queryInfo.InduceErr)
 		result.QueryTye = "CRUD"
 	case "SELECT":
-		result = qe.handleSelect(plan)
+		if queryInfo.Stream {
+			result = qe.handleSelectCursor(plan)
+		} else {
+			result = qe.handleSelect(plan)
+		}
 		result.QueryTye = "NON_CRUD"
 	case "DELETE":
 		result = qe.handleDelete(plan, queryInfo.TransactionOff, queryInfo.InduceErr)
@@ -174,6 +317,15 @@ func (qe *QueryEngine) QueryProcessingEntry(queryInfo *QueryInfo) *Result {
 	case "UPDATE":
 		result = qe.handleUpdate(plan, queryInfo.TransactionOff, queryInfo.InduceErr)
 		result.QueryTye = "CRUD"
+	case "TRUNCATE":
+		result = qe.handleTruncate(plan, queryInfo.TransactionOff)
+		result.QueryTye = "CRUD"
+	case "DROP_TABLE":
+		result = qe.handleDropTable(plan)
+		result.QueryTye = "CRUD"
+	case "ALTER_TABLE":
+		result = qe.handleAlterTable(plan)
+		result.QueryTye = "CRUD"
 	default:
 		result.Error = fmt.Errorf("unsupported type: %s", operation)
 		result.Msg = "failed"
@@ -187,36 +339,18 @@ func (qe *QueryEngine) QueryProcessingEntry(queryInfo *QueryInfo) *Result {
 func (qe *QueryEngine) handleSelect(plan map[string]interface{}) Result {
 	var result Result
 
-	nodes, err := ComputeNodes(plan, qe)
+	cursor, err := qe.OpenCursor(plan)
 	if err != nil {
-		return handleError(fmt.Errorf("ComputeNodes Failed: %w", err), "failed")
+		return handleError(fmt.Errorf("OpenCursor Failed: %w", err), "failed")
 	}
+	defer cursor.Close()
 
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(nodes))
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	for _, node := range nodes {
-		wg.Add(1)
-		go func(node Node) {
-			defer wg.Done()
-			if err := node.initialization(ctx); err != nil {
-				errChan <- err
-				cancel()
-			}
-		}(node)
-	}
-
-	wg.Wait()
-	close(errChan)
-
-	firstError := <-errChan
-	if firstError != nil {
-		return handleError(fmt.Errorf("handleSelect Failed: %w", firstError), "failed")
+	rows, err := cursor.FetchAll()
+	if err != nil {
+		return handleError(fmt.Errorf("handleSelect Failed: %w", err), "failed")
 	}
 
-	result.Rows = nodes[len(nodes)-1].GetRes()
+	result.Rows = rows
 	result.Msg = "success"
 
 	return result
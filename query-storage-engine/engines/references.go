@@ -0,0 +1,371 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Foreign keys
+//
+// A FOREIGN KEY lives in the constraints of the referencing (child) table.
+// Every key written to the child must be found in the parent, a key with a
+// NULL in it references nothing. Deleting a parent row or changing its key
+// runs the action of the foreign key on the child rows holding the old key:
+//   - RESTRICT (and NO ACTION) fails the statement
+//   - CASCADE deletes them, or moves them to the new key
+//   - SET_NULL clears their key
+//
+// The actions run before the parent rows change and log in the transaction
+// of the statement, the undo of an abort goes backwards so the parent rows are
+// back before the child rows need them.
+
+const (
+	CONSTRAINT_FOREIGN_KEY = "FOREIGN_KEY"
+
+	FK_RESTRICT  = "RESTRICT"
+	FK_NO_ACTION = "NO_ACTION"
+	FK_CASCADE   = "CASCADE"
+	FK_SET_NULL  = "SET_NULL"
+)
+
+var ErrForeignKeyViolation = errors.New("foreign key violation")
+
+// reference is a foreign key seen from the parent table.
+type reference struct {
+	table     string
+	tableInfo *TableInfo
+	fk        *Constraint
+}
+
+// referencesTo lists the foreign keys pointing at table, ordered by the
+// referencing table so the actions always run in the same order.
+func (c *Catalog) referencesTo(table string) []reference {
+	var refs []reference
+	for name, tableInfo := range c.Tables {
+		for i := range tableInfo.Constraints {
+			if fk := &tableInfo.Constraints[i]; fk.Kind == CONSTRAINT_FOREIGN_KEY && fk.RefTable == table {
+				refs = append(refs, reference{name, tableInfo, fk})
+			}
+		}
+	}
+
+	slices.SortFunc(refs, func(a, b reference) int {
+		return strings.Compare(a.table+"."+a.fk.Name, b.table+"."+b.fk.Name)
+	})
+	return refs
+}
+
+// checkNotReferenced fails when another table references table, or any of
+// the columns when some are given. Used before a table or column goes away.
+func (c *Catalog) checkNotReferenced(table string, columns ...string) error {
+	for _, ref := range c.referencesTo(table) {
+		if len(columns) == 0 && ref.table == table {
+			continue // goes away with the table
+		}
+
+		if len(columns) > 0 && !slices.ContainsFunc(columns, func(column string) bool {
+			return slices.Contains(ref.fk.RefColumns, column) && (ref.table != table || !slices.Contains(ref.fk.Columns, column))
+		}) {
+			continue
+		}
+
+		return fmt.Errorf("constraint: %s on table: %s references table: %s", ref.fk.Name, ref.table, table)
+	}
+
+	return nil
+}
+
+// retargetReferences applies change to every foreign key pointing at table,
+// the returned function puts them back. Used by the renames.
+func (c *Catalog) retargetReferences(table string, change func(fk *Constraint)) (restore func()) {
+	old := make(map[*TableInfo][]Constraint)
+	for _, ref := range c.referencesTo(table) {
+		if _, ok := old[ref.tableInfo]; !ok {
+			old[ref.tableInfo] = ref.tableInfo.Constraints
+			ref.tableInfo.Constraints = slices.Clone(ref.tableInfo.Constraints)
+		}
+	}
+
+	for tableInfo := range old {
+		for i := range tableInfo.Constraints {
+			if fk := &tableInfo.Constraints[i]; fk.Kind == CONSTRAINT_FOREIGN_KEY && fk.RefTable == table {
+				fk.RefColumns = slices.Clone(fk.RefColumns)
+				change(fk)
+			}
+		}
+	}
+
+	return func() {
+		for tableInfo, constraints := range old {
+			tableInfo.Constraints = constraints
+		}
+	}
+}
+
+// resolveReference checks a new foreign key against its parent: the
+// referenced columns default to the primary key and must be the primary key
+// or the columns of a UNIQUE constraint.
+func resolveReference(fk *Constraint, tableName string, tableInfo *TableInfo, catalog *Catalog) error {
+	parent := tableInfo
+	if fk.RefTable != tableName {
+		var ok bool
+		if parent, ok = catalog.Tables[fk.RefTable]; !ok {
+			return fmt.Errorf("table: %s referenced by: %s doesn't exist", fk.RefTable, fk.Name)
+		}
+	}
+
+	var primary string
+	for column, columnType := range parent.Schema {
+		if columnType.IsIndex {
+			primary = column
+		}
+	}
+
+	if len(fk.RefColumns) == 0 {
+		fk.RefColumns = []string{primary}
+	}
+
+	if len(fk.RefColumns) != len(fk.Columns) {
+		return fmt.Errorf("constraint: %s has %d columns referencing %d", fk.Name, len(fk.Columns), len(fk.RefColumns))
+	}
+
+	for _, column := range fk.RefColumns {
+		if _, ok := parent.Schema[column]; !ok {
+			return fmt.Errorf("column: %s on table: %s doesn't exist", column, fk.RefTable)
+		}
+	}
+
+	sameColumns := func(columns []string) bool {
+		return len(columns) == len(fk.RefColumns) && !slices.ContainsFunc(columns, func(column string) bool {
+			return !slices.Contains(fk.RefColumns, column)
+		})
+	}
+
+	unique := sameColumns([]string{primary}) || slices.ContainsFunc(parent.Constraints, func(constraint Constraint) bool {
+		return constraint.Kind == CONSTRAINT_UNIQUE && sameColumns(constraint.Columns)
+	})
+	if !unique {
+		return fmt.Errorf("no unique constraint on table: %s matches the columns referenced by: %s", fk.RefTable, fk.Name)
+	}
+
+	for _, action := range []*string{&fk.OnDelete, &fk.OnUpdate} {
+		switch *action {
+		case "", FK_NO_ACTION:
+			*action = FK_RESTRICT
+		case FK_RESTRICT, FK_CASCADE, FK_SET_NULL:
+		default:
+			return fmt.Errorf("unsupported action: %s on constraint: %s", *action, fk.Name)
+		}
+	}
+
+	return nil
+}
+
+// checkReferences rejects rows whose foreign keys aren't in the parent table,
+// a self referencing table also finds the keys among the rows themselves.
+func (qe *QueryEngine) checkReferences(rows []map[string]string, tableName string, tableStats *TableInfo) error {
+	manager := qe.BufferPoolManager.DiskManager
+
+	for i := range tableStats.Constraints {
+		fk := &tableStats.Constraints[i]
+		if fk.Kind != CONSTRAINT_FOREIGN_KEY {
+			continue
+		}
+
+		missing := make(map[string]bool)
+		for _, values := range rows {
+			if key, ok := columnsKey(values, fk.Columns, tableStats.Schema); ok {
+				missing[key] = true
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		parentInfo, ok := manager.PageCatalog.Tables[fk.RefTable]
+		if !ok {
+			return fmt.Errorf("table: %s referenced by: %s doesn't exist", fk.RefTable, fk.Name)
+		}
+
+		if fk.RefTable == tableName {
+			for _, values := range rows {
+				if key, ok := columnsKey(values, fk.RefColumns, parentInfo.Schema); ok {
+					delete(missing, key)
+				}
+			}
+		}
+
+		parentObj, err := GetTableObj(fk.RefTable, manager)
+		if err != nil {
+			return fmt.Errorf("GetTableObj failed: %w", err)
+		}
+
+		err = qe.scanRows(parentObj, parentInfo, func(row *RowV2) error {
+			if key, ok := columnsKey(row.Values, fk.RefColumns, parentInfo.Schema); ok {
+				delete(missing, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("scanRows failed: %w", err)
+		}
+
+		for key := range missing {
+			return fmt.Errorf("%w: constraint: %s on table: %s, (%s) = (%s) isn't in table: %s", ErrForeignKeyViolation, fk.Name, tableName,
+				strings.Join(fk.Columns, ", "), strings.ReplaceAll(key, ATTR_SEPARATOR, ", "), fk.RefTable)
+		}
+	}
+
+	return nil
+}
+
+// referenceActions runs the actions of the foreign keys pointing at the table
+// for the rows picked by match. apply gives the new version of a row on an
+// update and is nil on a delete, only the rows losing their key are acted on.
+func (qe *QueryEngine) referenceActions(tableObj *TableObj, tableStats *TableInfo, match func(row *RowV2) (bool, error), apply func(row *RowV2) error, txId string, transactionOff bool) error {
+	refs := qe.BufferPoolManager.DiskManager.PageCatalog.referencesTo(tableObj.TableName)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	// old key -> values of the new key, nil when the key goes away
+	changes := make([]map[string][]string, len(refs))
+	for i := range changes {
+		changes[i] = make(map[string][]string)
+	}
+
+	err := qe.scanRows(tableObj, tableStats, func(row *RowV2) error {
+		matched, err := match(row)
+		if err != nil || !matched {
+			return err
+		}
+
+		var newRow *RowV2
+		if apply != nil {
+			newRow = &RowV2{ID: row.ID, Values: maps.Clone(row.Values)}
+			if err := apply(newRow); err != nil {
+				return err
+			}
+		}
+
+		for i, ref := range refs {
+			oldKey, ok := columnsKey(row.Values, ref.fk.RefColumns, tableStats.Schema)
+			if !ok {
+				continue
+			}
+
+			if newRow == nil {
+				changes[i][oldKey] = nil
+				continue
+			}
+
+			newKey, ok := columnsKey(newRow.Values, ref.fk.RefColumns, tableStats.Schema)
+			if ok && newKey == oldKey {
+				continue
+			}
+
+			var newValues []string
+			if ok {
+				for _, column := range ref.fk.RefColumns {
+					newValues = append(newValues, newRow.Values[column])
+				}
+			}
+			changes[i][oldKey] = newValues
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, ref := range refs {
+		if len(changes[i]) == 0 {
+			continue
+		}
+
+		if err := qe.referenceAction(ref, changes[i], tableObj.TableName, match, apply == nil, txId, transactionOff); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// referenceAction acts on the child rows of one foreign key holding the keys
+// being changed.
+func (qe *QueryEngine) referenceAction(ref reference, changes map[string][]string, parentName string, parentMatch func(row *RowV2) (bool, error), isDelete bool, txId string, transactionOff bool) error {
+	fk := ref.fk
+
+	childObj, err := GetTableObj(ref.table, qe.BufferPoolManager.DiskManager)
+	if err != nil {
+		return fmt.Errorf("GetTableObj failed: %w", err)
+	}
+
+	childKey := func(row *RowV2) (string, bool) {
+		key, ok := columnsKey(row.Values, fk.Columns, ref.tableInfo.Schema)
+		if !ok {
+			return "", false
+		}
+
+		_, changed := changes[key]
+		return key, changed
+	}
+
+	childMatch := func(row *RowV2) (bool, error) {
+		if _, ok := childKey(row); !ok {
+			return false, nil
+		}
+
+		// the rows of a self referencing table picked by the statement are
+		// handled by the statement itself
+		if ref.table == parentName {
+			matched, err := parentMatch(row)
+			return !matched, err
+		}
+		return true, nil
+	}
+
+	action := fk.OnUpdate
+	if isDelete {
+		action = fk.OnDelete
+	}
+
+	switch {
+	case action == FK_CASCADE && isDelete:
+		return qe.deleteRows(childObj, ref.tableInfo, nil, childMatch, false, nil, txId, transactionOff)
+	case action == FK_CASCADE || action == FK_SET_NULL:
+		updater := RowUpdater{
+			Match: childMatch,
+			Apply: func(row *RowV2) error {
+				key, _ := childKey(row)
+				newValues := changes[key]
+
+				for j, column := range fk.Columns {
+					if action == FK_SET_NULL || newValues == nil {
+						delete(row.Values, column)
+					} else {
+						row.Values[column] = newValues[j]
+					}
+				}
+				return nil
+			},
+			skipReferences: true, // the new keys are the ones the parent is getting
+		}
+		return qe.updateRows(childObj, ref.tableInfo, updater, txId, transactionOff)
+	}
+
+	return qe.scanRows(childObj, ref.tableInfo, func(row *RowV2) error {
+		matched, err := childMatch(row)
+		if err != nil || !matched {
+			return err
+		}
+
+		key, _ := childKey(row)
+		return fmt.Errorf("%w: constraint: %s on table: %s, (%s) = (%s) is still referenced", ErrForeignKeyViolation, fk.Name, ref.table,
+			strings.Join(fk.RefColumns, ", "), strings.ReplaceAll(key, ATTR_SEPARATOR, ", "))
+	})
+}
@@ -18,7 +18,14 @@ func (rm *ResultManager) ResultCollector() {
 			panic("queryId not subscribed, can't deliver message")
 		}
 
-		rm.SchedulerNotification <- res
+		// a streamed query keeps its scheduler slot until the cursor is closed
+		if res.Cursor != nil {
+			notify := res
+			res.Cursor.onClose = func() { rm.SchedulerNotification <- notify }
+		} else {
+			rm.SchedulerNotification <- res
+		}
+
 		subQueryChan <- res
 	}
 }
@@ -0,0 +1,299 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const SEQUENCE_CACHE = 32 // values reserved per catalog write
+
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// ownedSequence names the sequence behind a SERIAL / AUTO_INCREMENT column.
+func ownedSequence(tableName, column string) string {
+	return fmt.Sprintf("%s_%s_seq", tableName, column)
+}
+
+func isSerialType(colType string) bool {
+	switch baseType(colType) {
+	case "SERIAL", "AUTO_INCREMENT":
+		return true
+	}
+	return false
+}
+
+func (dm *DiskManagerV2) CreateSequence(name string, start, increment int64, table, column string) error {
+	if increment == 0 {
+		return errors.New("sequence increment can't be 0")
+	}
+
+	catalog := dm.PageCatalog
+
+	catalog.seqMu.Lock()
+	if _, ok := catalog.Sequences[name]; ok {
+		catalog.seqMu.Unlock()
+		return fmt.Errorf("sequence: %s already exists", name)
+	}
+
+	seq := &Sequence{Increment: increment, Table: table, Column: column, next: start}
+	seq.limit.Store(start)
+	catalog.Sequences[name] = seq
+	catalog.seqMu.Unlock()
+
+	if err := dm.UpdateCatalog(); err != nil {
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	return nil
+}
+
+func (dm *DiskManagerV2) getSequence(name string) (*Sequence, error) {
+	catalog := dm.PageCatalog
+
+	catalog.seqMu.RLock()
+	defer catalog.seqMu.RUnlock()
+
+	seq, ok := catalog.Sequences[name]
+	if !ok {
+		return nil, fmt.Errorf("sequence: %s doesn't exist", name)
+	}
+
+	return seq, nil
+}
+
+// NextVal returns the next value of the sequence, a new block is reserved
+// and synced to the catalog before any of its values is handed out.
+func (dm *DiskManagerV2) NextVal(name string) (int64, error) {
+	seq, err := dm.getSequence(name)
+	if err != nil {
+		return 0, err
+	}
+
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+
+	if seq.cached == 0 {
+		if err := dm.reserve(seq); err != nil {
+			return 0, err
+		}
+	}
+
+	val := seq.next
+	seq.next += seq.Increment
+	seq.cached--
+
+	return val, nil
+}
+
+// advanceSequence moves an ascending sequence past an explicit value,
+// like AUTO_INCREMENT does, so the next generated key doesn't collide.
+func (dm *DiskManagerV2) advanceSequence(name string, val int64) error {
+	seq, err := dm.getSequence(name)
+	if err != nil {
+		return err
+	}
+
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+
+	if seq.Increment < 0 || val < seq.next {
+		return nil
+	}
+
+	// a restart must resume past the explicit value too
+	seq.next = val + seq.Increment
+	if seq.next >= seq.limit.Load() {
+		return dm.reserve(seq)
+	}
+
+	seq.cached = (seq.limit.Load() - seq.next) / seq.Increment
+	return nil
+}
+
+func (dm *DiskManagerV2) reserve(seq *Sequence) error {
+	seq.limit.Store(seq.next + SEQUENCE_CACHE*seq.Increment)
+
+	if err := dm.UpdateCatalog(); err != nil {
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	if err := dm.FileCatalog.Sync(); err != nil {
+		return fmt.Errorf("syncing catalog failed: %w", err)
+	}
+
+	seq.cached = SEQUENCE_CACHE
+	return nil
+}
+
+// fillSerials gives every SERIAL column left out of the row the next value
+// of its sequence, explicit values push the sequence past them.
+func (dm *DiskManagerV2) fillSerials(values map[string]string, tableName string, tableStats *TableInfo) error {
+	for column, colType := range tableStats.Schema {
+		if !isSerialType(colType.Type) {
+			continue
+		}
+
+		name := ownedSequence(tableName, column)
+
+		if text, ok := values[column]; ok && text != "" && text != "NULL" && text != nullMarker {
+			val, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return fmt.Errorf("column: %s expects an integer, got: %s", column, text)
+			}
+
+			if err := dm.advanceSequence(name, val); err != nil {
+				return fmt.Errorf("advanceSequence failed: %w", err)
+			}
+			continue
+		}
+
+		val, err := dm.NextVal(name)
+		if err != nil {
+			return fmt.Errorf("NextVal failed: %w", err)
+		}
+		values[column] = strconv.FormatInt(val, 10)
+	}
+
+	return nil
+}
+
+// insertValues pairs the VALUES rows with the column list, nextval('seq')
+// comes from the planner as {"nextval": "seq"}.
+func (dm *DiskManagerV2) insertValues(plan map[string]any, selectedCols []any) ([]map[string]string, error) {
+	var rows []map[string]string
+
+	for _, row := range plan["rows"].([]any) {
+		values := make(map[string]string)
+		for i, rowVal := range row.([]any) {
+			column := selectedCols[i].(string)
+
+			switch v := rowVal.(type) {
+			case string:
+				if strings.EqualFold(v, "NULL") {
+					values[column] = nullMarker // unlike a left out column, no default
+					continue
+				}
+				values[column] = strings.ReplaceAll(v, "'", "")
+			case map[string]any:
+				name, _ := v["nextval"].(string)
+				val, err := dm.NextVal(name)
+				if err != nil {
+					return nil, fmt.Errorf("nextval failed: %w", err)
+				}
+				values[column] = strconv.FormatInt(val, 10)
+			default:
+				return nil, fmt.Errorf("unsupported value for column: %s", column)
+			}
+		}
+
+		rows = append(rows, values)
+	}
+
+	return rows, nil
+}
+
+// checkKeys fills the defaults and checks the NOT NULL, CHECK, UNIQUE and
+// FOREIGN KEY constraints of the rows, so a bad row is rejected before
+// anything gets logged. Primary keys are compared once coerced to the column
+// type, "07" and "7" are the same INT key, both within the rows and, when
+// some of them were given explicitly, against the primary key index.
+// Generated keys are unique by construction so they aren't looked up.
+func (qe *QueryEngine) checkKeys(rows []map[string]string, explicit bool, primary string, tableObj *TableObj, tableStats *TableInfo) error {
+	for _, values := range rows {
+		if key := values[primary]; key == nullMarker || strings.EqualFold(key, "NULL") {
+			return fmt.Errorf("%w: primary column: %s can't be NULL", ErrNotNullViolation, primary)
+		}
+
+		if err := fillDefaults(values, tableStats); err != nil {
+			return err
+		}
+		dropNulls(values)
+
+		if err := coerceRow(values, nil, tableObj.TableName, tableStats); err != nil {
+			return err
+		}
+
+		if err := checkRow(values, tableObj.TableName, tableStats); err != nil {
+			return err
+		}
+	}
+
+	keys := make(map[string]bool, len(rows))
+	for _, values := range rows {
+		key := values[primary]
+		if key == "" {
+			continue
+		}
+
+		if keys[key] {
+			return fmt.Errorf("%w: %s = %s", ErrDuplicateKey, primary, key)
+		}
+		keys[key] = true
+	}
+
+	if idx := tableObj.indexOn([]string{primary}); explicit && idx != nil {
+		for key := range keys {
+			count, err := idx.countKey([]string{key}, 1)
+			if err != nil {
+				return fmt.Errorf("index: %s Scan failed: %w", idx.Name, err)
+			}
+
+			if count > 0 {
+				return fmt.Errorf("%w: %s = %s", ErrDuplicateKey, primary, key)
+			}
+		}
+	}
+
+	if err := qe.checkUnique(rows, false, tableObj, tableStats); err != nil {
+		return err
+	}
+
+	return qe.checkReferences(rows, tableObj.TableName, tableStats)
+}
+
+// hasExplicitKeys reports whether any row carries its own primary key.
+func hasExplicitKeys(rows []map[string]string, primary string) bool {
+	for _, values := range rows {
+		if values[primary] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCreateSequence runs CREATE SEQUENCE [IF NOT EXISTS] name
+// [START WITH n] [INCREMENT BY n].
+func (qe *QueryEngine) handleCreateSequence(plan map[string]any) Result {
+	manager := qe.BufferPoolManager.DiskManager
+
+	name := strings.ReplaceAll(plan["name"].(string), "`", "")
+
+	// the bounds come as strings, json numbers can't hold every int64
+	start, increment := int64(1), int64(1)
+	for field, target := range map[string]*int64{"start": &start, "increment": &increment} {
+		text, ok := plan[field].(string)
+		if !ok {
+			continue
+		}
+
+		val, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return handleError(fmt.Errorf("invalid %s: %s", field, text), "failed")
+		}
+		*target = val
+	}
+
+	if ifNotExists, _ := plan["ifNotExists"].(bool); ifNotExists {
+		if _, err := manager.getSequence(name); err == nil {
+			return Result{Msg: "Sequence Exists"}
+		}
+	}
+
+	if err := manager.CreateSequence(name, start, increment, "", ""); err != nil {
+		return handleError(fmt.Errorf("CreateSequence failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "Sequence Created"}
+}
@@ -1,14 +1,24 @@
 package engines
 
 import (
+	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"regexp"
+	"time"
 )
 
+const (
+	CURSOR_IDLE_TIMEOUT  = 60 * time.Second // abandoned cursors release their pipeline
+	CURSOR_WRITE_TIMEOUT = 5 * time.Second
+)
+
+var databaseParam = regexp.MustCompile(`&db=(\w+)&`)
+
 type Server struct {
 	host        string
 	port        string
@@ -60,10 +70,23 @@ func (server *Server) Run() {
 func (client *Client) handleRequest() {
 	defer client.conn.Close()
 
+	reader := bufio.NewReader(client.conn)
+
+	// cursors keep the connection open in both directions,
+	// every other operation is a single request terminated by EOF.
+	operation, err := reader.Peek(1)
+	if err == nil && operation[0] == CURSOR {
+		reader.Discard(1)
+		if err := HandleCursor(reader, client.queryEngine, client.conn); err != nil {
+			fmt.Printf("HandleCursor failed: %s\n", err)
+		}
+		return
+	}
+
 	var rawData []byte
 	for {
 		buffer := make([]byte, 1096)
-		n, err := client.conn.Read(buffer)
+		n, err := reader.Read(buffer)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -73,7 +96,7 @@ func (client *Client) handleRequest() {
 		rawData = append(rawData, buffer[:n]...)
 	}
 
-	err := OperationDecider(rawData, client.queryEngine, client.conn)
+	err = OperationDecider(rawData, client.queryEngine, client.conn)
 	if err != nil {
 		_, err = client.conn.Write([]byte(err.Error()))
 		if err != nil {
@@ -106,18 +129,26 @@ func OperationDecider(req []byte, queryEngine *QueryEngine, conn net.Conn) error
 	return nil
 }
 
+// HandleQueries answers with the result message only, rows (SELECT or
+// RETURNING) are read through HandleCursor. A failed query answers with
+// FormatQueryError.
 func HandleQueries(data []byte, queryEngine *QueryEngine, conn net.Conn) error {
 	re := regexp.MustCompile(`=([^&]*)`)
 	match := re.FindStringSubmatch(string(data))
 
 	if len(match) > 1 {
 		sql := match[1]
-		res, err := ExecuteQuery(sql, queryEngine)
+		res, err := sessionOf(data).ExecuteQuery(sql, queryEngine)
 		if err != nil {
 			return fmt.Errorf("ExecuteQuery Failed: %w", err)
 		}
 
-		err = SendResponse(res.Msg, conn)
+		msg := res.Msg
+		if res.Error != nil {
+			msg = FormatQueryError(res.Error)
+		}
+
+		err = SendResponse(msg, conn)
 		if err != nil {
 			return fmt.Errorf("SendResponse Failed: %w", err)
 		}
@@ -129,18 +160,120 @@ func HandleQueries(data []byte, queryEngine *QueryEngine, conn net.Conn) error {
 	return nil
 }
 
+// HandleCursor runs a query as a cursor, the request is [uint32 len][body],
+// then the client sends CURSOR_FETCH/CURSOR_CLOSE frames and gets
+// FRAME_ROWS/FRAME_ERROR frames back until the last batch is delivered.
+func HandleCursor(reader *bufio.Reader, queryEngine *QueryEngine, conn net.Conn) error {
+	var bodyLen uint32
+	if err := binary.Read(reader, binary.LittleEndian, &bodyLen); err != nil {
+		return fmt.Errorf("reading body length failed: %w", err)
+	}
+
+	data := make([]byte, bodyLen)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return fmt.Errorf("reading body failed: %w", err)
+	}
+
+	re := regexp.MustCompile(`=([^&]*)`)
+	match := re.FindStringSubmatch(string(data))
+	if len(match) < 2 {
+		return sendErrorFrame(errors.New("request body format incorrect"), conn)
+	}
+
+	res, err := sessionOf(data).ExecuteQueryStream(match[1], queryEngine)
+	if err != nil {
+		return sendErrorFrame(fmt.Errorf("ExecuteQuery Failed: %w", err), conn)
+	}
+
+	if res.Error != nil {
+		return sendErrorFrame(res.Error, conn)
+	}
+
+	// non streamed results (INSERT/UPDATE/DELETE ... RETURNING) are
+	// paged like any other cursor
+	if res.Cursor == nil {
+		res.Cursor = rowsCursor(res.Rows)
+	}
+	defer res.Cursor.Close()
+
+	for {
+		err := conn.SetReadDeadline(time.Now().Add(CURSOR_IDLE_TIMEOUT))
+		if err != nil {
+			return fmt.Errorf("SetReadDeadline failed: %w", err)
+		}
+
+		var op uint8
+		var n uint32
+		if err := binary.Read(reader, binary.LittleEndian, &op); err != nil {
+			return fmt.Errorf("reading cursor op failed: %w", err)
+		}
+
+		if err := binary.Read(reader, binary.LittleEndian, &n); err != nil {
+			return fmt.Errorf("reading fetch size failed: %w", err)
+		}
+
+		switch op {
+		case CURSOR_FETCH:
+			rows, done, err := res.Cursor.Fetch(int(n))
+			if err != nil {
+				return sendErrorFrame(err, conn)
+			}
+
+			if err := sendRowsFrame(rows, done, conn); err != nil {
+				return err
+			}
+
+			if done {
+				return nil
+			}
+		case CURSOR_CLOSE:
+			return nil
+		default:
+			return sendErrorFrame(fmt.Errorf("unknown cursor op: %d", op), conn)
+		}
+	}
+}
+
+func sendRowsFrame(rows []*RowV2, last bool, conn net.Conn) error {
+	frame, err := EncodeRowsFrame(rows, last)
+	if err != nil {
+		return fmt.Errorf("EncodeRowsFrame failed: %w", err)
+	}
+
+	return writeFrame(frame, conn)
+}
+
+func sendErrorFrame(queryErr error, conn net.Conn) error {
+	frame, err := EncodeErrorFrame(queryErr)
+	if err != nil {
+		return fmt.Errorf("EncodeErrorFrame failed: %w", err)
+	}
+
+	return writeFrame(frame, conn)
+}
+
+func writeFrame(frame []byte, conn net.Conn) error {
+	err := conn.SetWriteDeadline(time.Now().Add(CURSOR_WRITE_TIMEOUT))
+	if err != nil {
+		return fmt.Errorf("SetWriteDeadline failed: %w", err)
+	}
+
+	if _, err := conn.Write(frame); err != nil {
+		return fmt.Errorf("conn.Write failed: %w", err)
+	}
+
+	return nil
+}
+
 func HandleCreateTable(data []byte, queryEngine *QueryEngine, conn net.Conn) error {
 	tableName, fields := ParsingTableMetadata(string(data))
 	authMap := fields["auth"]
 	schemaMap := fields["schema"]
 
 	dbName := authMap["dbName"]
-	userId := authMap["userId"]
-
-	officialTableName := tableName + "-" + dbName + "-" + userId
 
 	schemaStr := CreateSchemaString(schemaMap)
-	sql := fmt.Sprintf("CREATE TABLE `%s`(%s)\n", officialTableName, schemaStr)
+	sql := fmt.Sprintf("CREATE TABLE `%s`.`%s`(%s)\n", dbName, tableName, schemaStr)
 	res, err := ExecuteQuery(sql, queryEngine)
 	if err != nil {
 		return fmt.Errorf("ExecuteQuery Failed: %w", err)
@@ -154,6 +287,17 @@ func HandleCreateTable(data []byte, queryEngine *QueryEngine, conn net.Conn) err
 	return nil
 }
 
+// sessionOf runs the request in the database named by its db parameter,
+// the tables outside of any database otherwise.
+func sessionOf(data []byte) *Session {
+	session := &Session{}
+	if match := databaseParam.FindSubmatch(data); match != nil {
+		session.Database = string(match[1])
+	}
+
+	return session
+}
+
 func HandleAuth(data []byte, queryEngine *QueryEngine, conn net.Conn) error {
 	fields := ParsingRegistration(string(data))
 
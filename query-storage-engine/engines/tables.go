@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"sync"
@@ -24,6 +25,9 @@ type TableObj struct {
 	DirFile       *os.File
 	DataFile      *os.File
 	MemFile       *os.File
+	Toast         *ToastStore
+	Indexes       []*Index    // the primary key index first, see indexes.go
+	Blooms        *PageBlooms // nil without bloom columns, see blooms.go
 	TableName     string
 	Mu            *sync.RWMutex
 }
@@ -35,36 +39,89 @@ type FreeSpace struct {
 }
 
 func (dm *DiskManagerV2) InMemoryTableSetUp(tableName string) (*TableObj, error) {
-	dirObj, dirFilePtr, err := GetNonpageFile(dm.DBdirectory, tableName, "directory_page")
+	dir := dm.tableDir(tableName)
+	tableObj, err := dm.openTableFiles(tableName, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if tableInfo, ok := dm.PageCatalog.Tables[tableName]; ok {
+		tableInfo.toast = tableObj.Toast // rows decoded through the catalog entry find their values
+
+		if err := dm.openIndexes(tableObj, tableInfo, dir); err != nil {
+			tableObj.Close()
+			return nil, err
+		}
+
+		if err := dm.openBlooms(tableObj, tableInfo, dir); err != nil {
+			tableObj.Close()
+			return nil, err
+		}
+
+		if err := tableObj.fillZones(tableInfo); err != nil {
+			tableObj.Close()
+			return nil, err
+		}
+	}
+
+	dm.Mu.Lock()
+	dm.TableObjs[tableName] = tableObj
+	dm.Mu.Unlock()
+
+	return tableObj, nil
+}
+
+// openTableFiles opens the files of the table kept in Tables/<dir> of its
+// database, the data file is named after the directory.
+func (dm *DiskManagerV2) openTableFiles(tableName, dir string) (*TableObj, error) {
+	dbDirectory := dm.databaseOf(tableName).Dir
+
+	dirObj, dirFilePtr, err := GetNonpageFile(dbDirectory, dir, "directory_page")
 	if err != nil {
 		return nil, fmt.Errorf("GetNonpageFile failed: %w", err)
 	}
 
-	memObj, memFilePtr, err := GetNonpageFile(dm.DBdirectory, tableName, "freeMem")
+	memObj, memFilePtr, err := GetNonpageFile(dbDirectory, dir, "freeMem")
 	if err != nil {
 		return nil, fmt.Errorf("GetNonpageFile failed: %w", err)
 	}
 
-	_, dataFilePtr, err := GetNonpageFile(dm.DBdirectory, tableName, tableName)
+	_, dataFilePtr, err := GetNonpageFile(dbDirectory, dir, dir)
 	if err != nil {
 		return nil, fmt.Errorf("GetNonpageFile failed: %w", err)
 	}
 
-	tableObj := &TableObj{
+	toast, err := OpenToastStore(dbDirectory, dir)
+	if err != nil {
+		return nil, fmt.Errorf("OpenToastStore failed: %w", err)
+	}
+
+	return &TableObj{
 		DirectoryPage: dirObj.(*DirectoryPageV2),
 		Memory:        memObj.(map[uint16][]*FreeSpace),
 		DataFile:      dataFilePtr,
 		DirFile:       dirFilePtr,
 		MemFile:       memFilePtr,
+		Toast:         toast,
 		TableName:     tableName,
 		Mu:            &sync.RWMutex{},
-	}
+	}, nil
+}
 
-	dm.Mu.Lock()
-	dm.TableObjs[tableName] = tableObj
-	dm.Mu.Unlock()
+// tableDir is the directory holding the files of the table under Tables/
+// of its database, it only differs from the name after RENAME TABLE or a
+// rewrite.
+func (dm *DiskManagerV2) tableDir(tableName string) string {
+	if tableInfo, ok := dm.PageCatalog.Tables[tableName]; ok && tableInfo.Dir != "" {
+		return tableInfo.Dir
+	}
+	_, table := SplitTableName(tableName)
+	return table
+}
 
-	return tableObj, nil
+// tablesPath is the Tables/ directory of the database holding tableName.
+func (dm *DiskManagerV2) tablesPath(tableName string) string {
+	return filepath.Join(dm.databaseOf(tableName).Dir, "Tables")
 }
 
 func GetNonpageFile(dbDirectory, tableName, fileName string) (interface{}, *os.File, error) {
@@ -130,29 +187,188 @@ func GetDirInfo(fileptr *os.File) (*DirectoryPageV2, error) {
 	return dirPage, nil
 }
 
+// CreateTable creates the files before the catalog entry, a table without
+// an entry is removed on the next start (see removeOrphanTables).
 func (dm *DiskManagerV2) CreateTable(tableName string, info TableInfo) error {
-	tablePath := filepath.Join(dm.DBdirectory, "Tables", tableName)
+	if err := dm.checkDatabase(tableName); err != nil {
+		return err
+	}
 
-	err := os.Mkdir(tablePath, 0755)
-	if err != nil && os.IsExist(err) {
+	if _, ok := dm.PageCatalog.Tables[tableName]; ok {
 		return fmt.Errorf("[%s] table already exists", tableName)
 	}
 
+	if _, ok := dm.PageCatalog.Views[tableName]; ok {
+		return fmt.Errorf("view: %s already exists", tableName)
+	}
+
+	dir, err := dm.createTableDir(tableName)
+	if err != nil {
+		return err
+	}
+
+	if dir != dm.tableDir(tableName) {
+		info.Dir = dir
+	}
+
+	info.refreshLayout()
 	dm.PageCatalog.Tables[tableName] = &info
 	err = dm.UpdateCatalog()
 	if err != nil {
 		return fmt.Errorf("UpdateCatalog failed: %w", err)
 	}
 
-	os.Create(filepath.Join(tablePath, tableName))
-	os.Create(filepath.Join(tablePath, "directory_page"))
-	os.Create(filepath.Join(tablePath, "freeMem"))
+	return nil
+}
+
+// createTableDir creates an empty set of table files. The directory is named
+// after the table unless a renamed or rewritten table still holds that name.
+func (dm *DiskManagerV2) createTableDir(tableName string) (string, error) {
+	tablesPath := dm.tablesPath(tableName)
+	_, table := SplitTableName(tableName)
+
+	dir := table
+	for n := 1; ; n++ {
+		err := os.Mkdir(filepath.Join(tablesPath, dir), 0755)
+		if err == nil {
+			break
+		}
+
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("creating table directory failed: %w", err)
+		}
+		dir = fmt.Sprintf("%s.%d", table, n)
+	}
+
+	tablePath := filepath.Join(tablesPath, dir)
+	for _, fileName := range []string{dir, "directory_page", "freeMem", TOAST_FILE} {
+		file, err := os.Create(filepath.Join(tablePath, fileName))
+		if err != nil {
+			return "", fmt.Errorf("creating %s failed: %w", fileName, err)
+		}
+		file.Close()
+	}
+
+	return dir, nil
+}
+
+// DropTable removes the catalog entry, and the sequences owned by the table,
+// with a single catalog replacement before deleting any file. A crash in
+// between leaves a directory without entry which is removed on start.
+func (dm *DiskManagerV2) DropTable(tableName string) error {
+	catalog := dm.PageCatalog
+
+	tableInfo, ok := catalog.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table: %s doesn't exist", tableName)
+	}
+	dir := dm.tableDir(tableName)
+
+	owned := make(map[string]*Sequence)
+	catalog.seqMu.Lock()
+	for name, seq := range catalog.Sequences {
+		if seq.Table == tableName {
+			owned[name] = seq
+			delete(catalog.Sequences, name)
+		}
+	}
+	catalog.seqMu.Unlock()
+
+	delete(catalog.Tables, tableName)
+	if err := dm.UpdateCatalog(); err != nil {
+		// nothing was removed on disk, the table is still there
+		catalog.Tables[tableName] = tableInfo
+		catalog.seqMu.Lock()
+		maps.Copy(catalog.Sequences, owned)
+		catalog.seqMu.Unlock()
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	dm.Mu.Lock()
+	tableObj, found := dm.TableObjs[tableName]
+	delete(dm.TableObjs, tableName)
+	dm.Mu.Unlock()
+
+	if found {
+		tableObj.Close()
+	}
+
+	tablesPath := dm.tablesPath(tableName)
+	if err := os.RemoveAll(filepath.Join(tablesPath, dir)); err != nil {
+		return fmt.Errorf("removing table files failed: %w", err)
+	}
+
+	return syncDir(tablesPath)
+}
+
+// removeOrphanTables deletes the table directories the catalog doesn't know,
+// left by a drop or a create interrupted by a crash.
+func (dm *DiskManagerV2) removeOrphanTables() error {
+	inUse := make(map[string]bool, len(dm.PageCatalog.Tables))
+	for tableName := range dm.PageCatalog.Tables {
+		inUse[filepath.Join(dm.tablesPath(tableName), dm.tableDir(tableName))] = true
+	}
+
+	tablesPaths := []string{filepath.Join(dm.DBdirectory, "Tables")}
+	for _, database := range dm.Databases {
+		tablesPaths = append(tablesPaths, filepath.Join(database.Dir, "Tables"))
+	}
+
+	for _, tablesPath := range tablesPaths {
+		if err := removeOrphans(tablesPath, inUse); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeOrphans(tablesPath string, inUse map[string]bool) error {
+	entries, err := os.ReadDir(tablesPath)
+	if err != nil {
+		return fmt.Errorf("reading tables directory failed: %w", err)
+	}
+
+	removed := false
+	for _, entry := range entries {
+		if inUse[filepath.Join(tablesPath, entry.Name())] || !entry.IsDir() {
+			continue
+		}
+
+		logger.Log.WithField("table", entry.Name()).Info("removing orphan table files")
+		if err := os.RemoveAll(filepath.Join(tablesPath, entry.Name())); err != nil {
+			return fmt.Errorf("removing orphan table failed: %w", err)
+		}
+		removed = true
+	}
+
+	if removed {
+		return syncDir(tablesPath)
+	}
 
 	return nil
 }
 
+func (tableObj *TableObj) Close() {
+	for _, file := range []*os.File{tableObj.DataFile, tableObj.DirFile, tableObj.MemFile} {
+		file.Close()
+	}
+
+	if tableObj.Toast != nil {
+		tableObj.Toast.Close()
+	}
+
+	for _, idx := range tableObj.Indexes {
+		idx.Close()
+	}
+
+	if tableObj.Blooms != nil {
+		tableObj.Blooms.File.Close()
+	}
+}
+
 // space for optimization // could decode just the header
-func FullTableScan(outerCtx, innerCtx context.Context, pc chan *PageV2, file *os.File, pageTable map[PageID]FrameID, tp uint64) error {
+func FullTableScan(outerCtx, innerCtx context.Context, pc chan *PageV2, file *os.File, pageTable map[PageID]FrameID, tp uint64, skip func(PageID) bool) error {
 	if outerCtx == nil {
 		outerCtx = context.Background()
 	}
@@ -198,7 +414,16 @@ func FullTableScan(outerCtx, innerCtx context.Context, pc chan *PageV2, file *os
 				continue
 			}
 
-			pc <- page
+			if skip != nil && skip(PageID(page.Header.ID)) {
+				logger.Log.Info("Filtered Page: ", page.Header.ID)
+				offset += PageSizeV2
+				pageCount++
+				continue
+			}
+
+			if err := sendPage(outerCtx, innerCtx, pc, page); err != nil {
+				return err
+			}
 
 			logger.Log.WithField("PageId", page.Header.ID).Info("Page from disk")
 			offset += PageSizeV2
@@ -358,21 +583,23 @@ func DecoderWorker(innerCtx, outerCtx context.Context, byteChan chan []byte, pag
 
 				_, ok := pageTable[PageID(page.Header.ID)]
 				if !ok {
-					pageChan <- page
+					if err := sendPage(outerCtx, innerCtx, pageChan, page); err != nil {
+						return err
+					}
 				}
 			}
 		}
 	}
 }
 
-func GetTablePagesFromDisk(outerCtx, innerCtx context.Context, pc chan *PageV2, tableObj *TableObj, pageMemTable map[PageID]FrameID, totalPages uint64) error {
+func GetTablePagesFromDisk(outerCtx, innerCtx context.Context, pc chan *PageV2, tableObj *TableObj, pageMemTable map[PageID]FrameID, totalPages uint64, skip func(PageID) bool) error {
 	// stat, _ := tableObj.DataFile.Stat()
 	// size := stat.Size()
 
 	// if size >= MAX_FILE_SIZE {
 	// 	return FullTableScanBigFiles(ctx, pc, tableObj.DataFile, pageMemTable, totalPages)
 	// }
-	return FullTableScan(outerCtx, innerCtx, pc, tableObj.DataFile, pageMemTable, totalPages)
+	return FullTableScan(outerCtx, innerCtx, pc, tableObj.DataFile, pageMemTable, totalPages, skip)
 }
 
 func GetTableObj(tableName string, manager *DiskManagerV2) (*TableObj, error) {
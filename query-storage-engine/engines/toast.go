@@ -0,0 +1,376 @@
+package engines
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Overflow (TOAST) storage
+//
+// Rows bigger than TOAST_THRESHOLD get their largest values moved out of line,
+// one at a time, until the row is small enough. A moved value lives in a chain
+// of pages of the "toast" file of the table and the tuple keeps a fixed size
+// pointer in its place. Values are compressed when that makes them smaller.
+//
+// The WAL always logs the expanded rows, undo never depends on toast pages so
+// the chain of a deleted or updated row is freed right away.
+
+const (
+	TOAST_THRESHOLD = PageDataSize / 4
+	TOAST_FILE      = "toast"
+
+	TOAST_PAGE_HEADER = 11 // [used uint8][next uint64][length uint16]
+	TOAST_CHUNK       = PageSizeV2 - TOAST_PAGE_HEADER
+	TOAST_END         = ^uint64(0)
+)
+
+// a pointer is the marker followed by [first uint64][raw uint32][stored uint32][compressed uint8]
+const toastMarker = "\x00\xffTOAST\x00"
+const TOAST_POINTER_SIZE = len(toastMarker) + 17
+
+type ToastStore struct {
+	file  *os.File
+	pages uint64
+	free  []uint64
+	mu    sync.Mutex
+}
+
+type toastPointer struct {
+	first      uint64
+	raw        uint32
+	stored     uint32
+	compressed bool
+}
+
+// OpenToastStore opens the toast file of the table, tables created before
+// overflow storage existed get an empty one.
+func OpenToastStore(dbDirectory, tableName string) (*ToastStore, error) {
+	filePath := filepath.Join(dbDirectory, "Tables", tableName, TOAST_FILE)
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("opening toast file failed: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("toast file stat failed: %w", err)
+	}
+
+	store := &ToastStore{file: file, pages: uint64(stat.Size()) / PageSizeV2}
+
+	// the free pages are only known by their header
+	used := make([]byte, 1)
+	for page := range store.pages {
+		if _, err := file.ReadAt(used, int64(page*PageSizeV2)); err != nil {
+			return nil, fmt.Errorf("reading toast page %d failed: %w", page, err)
+		}
+
+		if used[0] == 0 {
+			store.free = append(store.free, page)
+		}
+	}
+
+	return store, nil
+}
+
+// Write stores the value in a new chain and returns the pointer to it.
+func (ts *ToastStore) Write(value string) (string, error) {
+	ptr := toastPointer{raw: uint32(len(value))}
+
+	data := []byte(value)
+	if compressed, err := compress(data); err == nil && len(compressed) < len(data) {
+		data = compressed
+		ptr.compressed = true
+	}
+	ptr.stored = uint32(len(data))
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	numPages := max((len(data)+TOAST_CHUNK-1)/TOAST_CHUNK, 1)
+	chain := make([]uint64, numPages)
+	for i := range chain {
+		chain[i] = ts.allocate()
+	}
+
+	page := make([]byte, PageSizeV2)
+	for i, pageNum := range chain {
+		next := TOAST_END
+		if i+1 < len(chain) {
+			next = chain[i+1]
+		}
+
+		chunk := data[min(i*TOAST_CHUNK, len(data)):min((i+1)*TOAST_CHUNK, len(data))]
+
+		clear(page)
+		page[0] = 1
+		binary.LittleEndian.PutUint64(page[1:9], next)
+		binary.LittleEndian.PutUint16(page[9:11], uint16(len(chunk)))
+		copy(page[TOAST_PAGE_HEADER:], chunk)
+
+		if _, err := ts.file.WriteAt(page, int64(pageNum*PageSizeV2)); err != nil {
+			return "", fmt.Errorf("writing toast page %d failed: %w", pageNum, err)
+		}
+	}
+
+	ptr.first = chain[0]
+	return ptr.encode(), nil
+}
+
+// Read follows the chain of the pointer and returns the original value.
+func (ts *ToastStore) Read(pointer string) (string, error) {
+	ptr, err := decodeToastPointer(pointer)
+	if err != nil {
+		return "", err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	data := make([]byte, 0, ptr.stored)
+	page := make([]byte, PageSizeV2)
+	for pageNum := ptr.first; pageNum != TOAST_END; {
+		if _, err := ts.file.ReadAt(page, int64(pageNum*PageSizeV2)); err != nil {
+			return "", fmt.Errorf("reading toast page %d failed: %w", pageNum, err)
+		}
+
+		if page[0] == 0 {
+			return "", fmt.Errorf("toast page %d is free", pageNum)
+		}
+
+		length := binary.LittleEndian.Uint16(page[9:11])
+		data = append(data, page[TOAST_PAGE_HEADER:TOAST_PAGE_HEADER+int(length)]...)
+		pageNum = binary.LittleEndian.Uint64(page[1:9])
+	}
+
+	if uint32(len(data)) != ptr.stored {
+		return "", fmt.Errorf("toast chain %d holds %d bytes, expected %d", ptr.first, len(data), ptr.stored)
+	}
+
+	if ptr.compressed {
+		if data, err = decompress(data, ptr.raw); err != nil {
+			return "", fmt.Errorf("decompressing toast chain %d failed: %w", ptr.first, err)
+		}
+	}
+
+	return string(data), nil
+}
+
+// Free marks every page of the chain as free so later values can reuse them.
+func (ts *ToastStore) Free(pointer string) error {
+	ptr, err := decodeToastPointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	header := make([]byte, TOAST_PAGE_HEADER)
+	for pageNum := ptr.first; pageNum != TOAST_END; {
+		if _, err := ts.file.ReadAt(header, int64(pageNum*PageSizeV2)); err != nil {
+			return fmt.Errorf("reading toast page %d failed: %w", pageNum, err)
+		}
+
+		if header[0] == 0 {
+			return nil // freed already
+		}
+
+		if _, err := ts.file.WriteAt([]byte{0}, int64(pageNum*PageSizeV2)); err != nil {
+			return fmt.Errorf("freeing toast page %d failed: %w", pageNum, err)
+		}
+
+		ts.free = append(ts.free, pageNum)
+		pageNum = binary.LittleEndian.Uint64(header[1:9])
+	}
+
+	return nil
+}
+
+func (ts *ToastStore) Sync() error {
+	return ts.file.Sync()
+}
+
+func (ts *ToastStore) Close() error {
+	return ts.file.Close()
+}
+
+// allocate must be called with ts.mu held.
+func (ts *ToastStore) allocate() uint64 {
+	if n := len(ts.free); n > 0 {
+		page := ts.free[n-1]
+		ts.free = ts.free[:n-1]
+		return page
+	}
+
+	page := ts.pages
+	ts.pages++
+	return page
+}
+
+func (ptr toastPointer) encode() string {
+	buf := make([]byte, TOAST_POINTER_SIZE)
+	n := copy(buf, toastMarker)
+	binary.LittleEndian.PutUint64(buf[n:], ptr.first)
+	binary.LittleEndian.PutUint32(buf[n+8:], ptr.raw)
+	binary.LittleEndian.PutUint32(buf[n+12:], ptr.stored)
+	if ptr.compressed {
+		buf[n+16] = 1
+	}
+
+	return string(buf)
+}
+
+func decodeToastPointer(pointer string) (toastPointer, error) {
+	if !isToastPointer(pointer) {
+		return toastPointer{}, errors.New("not a toast pointer")
+	}
+
+	buf := []byte(pointer[len(toastMarker):])
+	return toastPointer{
+		first:      binary.LittleEndian.Uint64(buf),
+		raw:        binary.LittleEndian.Uint32(buf[8:]),
+		stored:     binary.LittleEndian.Uint32(buf[12:]),
+		compressed: buf[16] == 1,
+	}, nil
+}
+
+func isToastPointer(value string) bool {
+	return len(value) == TOAST_POINTER_SIZE && value[:len(toastMarker)] == toastMarker
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte, raw uint32) ([]byte, error) {
+	out := make([]byte, raw)
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(data)), out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// toastRow moves the largest values of an encoded row out of line until the
+// row fits under TOAST_THRESHOLD. Small rows are returned untouched.
+func toastRow(encodedRow []byte, store *ToastStore) ([]byte, error) {
+	if len(encodedRow) <= TOAST_THRESHOLD || store == nil {
+		return encodedRow, nil
+	}
+
+	var row RowV2
+	if err := DecodeRow(&row, bytes.NewReader(encodedRow)); err != nil {
+		return nil, fmt.Errorf("DecodeRow failed: %w", err)
+	}
+
+	columns := make([]string, 0, len(row.Values))
+	for col := range row.Values {
+		columns = append(columns, col)
+	}
+
+	// largest first, ties by name so the layout is deterministic
+	sort.Slice(columns, func(i, j int) bool {
+		li, lj := len(row.Values[columns[i]]), len(row.Values[columns[j]])
+		if li != lj {
+			return li > lj
+		}
+		return columns[i] < columns[j]
+	})
+
+	size := len(encodedRow)
+	for _, col := range columns {
+		val := row.Values[col]
+		if size <= TOAST_THRESHOLD || len(val) <= TOAST_POINTER_SIZE {
+			break
+		}
+
+		if isToastPointer(val) {
+			continue
+		}
+
+		pointer, err := store.Write(val)
+		if err != nil {
+			return nil, fmt.Errorf("toast write failed: %w", err)
+		}
+
+		row.Values[col] = pointer
+		size -= len(val) - TOAST_POINTER_SIZE
+	}
+
+	return EncodeRow(&row, new(bytes.Buffer))
+}
+
+// detoastImage returns a copy of the encoded row with every pointer replaced
+// by its value, this is the image the WAL and RETURNING get.
+func detoastImage(rowBytes []byte, store *ToastStore) ([]byte, error) {
+	if store == nil || !bytes.Contains(rowBytes, []byte(toastMarker)) {
+		return bytes.Clone(rowBytes), nil
+	}
+
+	var row RowV2
+	if err := DecodeRow(&row, bytes.NewReader(rowBytes)); err != nil {
+		return nil, fmt.Errorf("DecodeRow failed: %w", err)
+	}
+
+	for col, val := range row.Values {
+		if !isToastPointer(val) {
+			continue
+		}
+
+		value, err := store.Read(val)
+		if err != nil {
+			return nil, fmt.Errorf("toast read failed: %w", err)
+		}
+		row.Values[col] = value
+	}
+
+	return EncodeRow(&row, new(bytes.Buffer))
+}
+
+// freeToasted releases the chains referenced by an encoded row.
+func freeToasted(rowBytes []byte, store *ToastStore) error {
+	if store == nil || !bytes.Contains(rowBytes, []byte(toastMarker)) {
+		return nil
+	}
+
+	var row RowV2
+	if err := DecodeRow(&row, bytes.NewReader(rowBytes)); err != nil {
+		return fmt.Errorf("DecodeRow failed: %w", err)
+	}
+
+	for _, val := range row.Values {
+		if !isToastPointer(val) {
+			continue
+		}
+
+		if err := store.Free(val); err != nil {
+			return fmt.Errorf("toast free failed: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,918 @@
+package engines
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TYPE_VARCHAR   = "VARCHAR"
+	TYPE_INT       = "INT"
+	TYPE_INTEGER   = "INTEGER"
+	TYPE_BIGINT    = "BIGINT"
+	TYPE_DECIMAL   = "DECIMAL"
+	TYPE_PRIMARY   = "PRIMARY"
+	TYPE_DATE      = "DATE"
+	TYPE_TIMESTAMP = "TIMESTAMP"
+	TYPE_INTERVAL  = "INTERVAL"
+)
+
+const (
+	DATE_LAYOUT      = "2006-01-02"
+	TIMESTAMP_LAYOUT = "2006-01-02 15:04:05.999999"
+
+	DATE_BYTES      = 4
+	TIMESTAMP_BYTES = 8
+	INTERVAL_BYTES  = 16
+
+	MICROS_PER_DAY = int64(24 * time.Hour / time.Microsecond)
+)
+
+var timestampLayouts = []string{
+	TIMESTAMP_LAYOUT,
+	"2006-01-02T15:04:05.999999",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999Z07:00",
+	"2006-01-02 15:04",
+	DATE_LAYOUT,
+}
+
+type DatumKind uint8
+
+const (
+	KindNull DatumKind = iota
+	KindString
+	KindNumber
+	KindBool
+	KindDate
+	KindTimestamp
+	KindInterval
+)
+
+type Interval struct {
+	Months int32
+	Days   int32
+	Micros int64
+}
+
+// Datum is the typed form of a row value while an expression is being
+// evaluated. Rows keep carrying strings; the datum only lives inside the
+// evaluator, comparisons and sorts.
+type Datum struct {
+	Kind     DatumKind
+	Str      string // exact text for strings and numbers
+	Num      float64
+	Bool     bool
+	Time     time.Time
+	Interval Interval
+}
+
+func NullDatum() Datum {
+	return Datum{Kind: KindNull}
+}
+
+func StringDatum(s string) Datum {
+	return Datum{Kind: KindString, Str: s}
+}
+
+func NumberDatum(text string) (Datum, error) {
+	num, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Datum{}, fmt.Errorf("invalid number: %s", text)
+	}
+
+	return Datum{Kind: KindNumber, Str: text, Num: num}, nil
+}
+
+func FloatDatum(num float64) Datum {
+	if num == math.Trunc(num) && math.Abs(num) < 1<<53 {
+		return Datum{Kind: KindNumber, Str: strconv.FormatInt(int64(num), 10), Num: num}
+	}
+
+	return Datum{Kind: KindNumber, Str: strconv.FormatFloat(num, 'f', -1, 64), Num: num}
+}
+
+func IntDatum(num int64) Datum {
+	return Datum{Kind: KindNumber, Str: strconv.FormatInt(num, 10), Num: float64(num)}
+}
+
+func BoolDatum(b bool) Datum {
+	return Datum{Kind: KindBool, Bool: b}
+}
+
+func DateDatum(t time.Time) Datum {
+	y, m, d := t.UTC().Date()
+	return Datum{Kind: KindDate, Time: time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}
+}
+
+func TimestampDatum(t time.Time) Datum {
+	return Datum{Kind: KindTimestamp, Time: t.UTC().Truncate(time.Microsecond)}
+}
+
+func IntervalDatum(iv Interval) Datum {
+	return Datum{Kind: KindInterval, Interval: iv}
+}
+
+func (d Datum) IsNull() bool {
+	return d.Kind == KindNull
+}
+
+func (d Datum) String() string {
+	switch d.Kind {
+	case KindNull:
+		return ""
+	case KindBool:
+		return strconv.FormatBool(d.Bool)
+	case KindDate:
+		return d.Time.Format(DATE_LAYOUT)
+	case KindTimestamp:
+		return d.Time.Format(TIMESTAMP_LAYOUT)
+	case KindInterval:
+		return FormatInterval(d.Interval)
+	default:
+		return d.Str
+	}
+}
+
+func isIntegerText(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	start := 0
+	if s[0] == '-' || s[0] == '+' {
+		start = 1
+	}
+
+	if start == len(s) {
+		return false
+	}
+
+	for i := start; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsNumericType reports whether values of the column are compared and
+// summed as numbers.
+func IsNumericType(colType string) bool {
+	switch baseType(colType) {
+	case TYPE_INT, TYPE_INTEGER, TYPE_BIGINT, TYPE_DECIMAL, TYPE_PRIMARY, "SMALLINT", "TINYINT", "DOUBLE", "FLOAT", "REAL", "NUMERIC", "SERIAL", "AUTO_INCREMENT":
+		return true
+	}
+	return false
+}
+
+// IsTemporalType reports whether the column is stored with the binary
+// date/time encoding.
+func IsTemporalType(colType string) bool {
+	switch baseType(colType) {
+	case TYPE_DATE, TYPE_TIMESTAMP, TYPE_INTERVAL:
+		return true
+	}
+	return false
+}
+
+// baseType strips precision/scale and qualifiers, "DECIMAL(20,0)" => "DECIMAL",
+// "INTERVAL_DAY" => "INTERVAL", "TIMESTAMP(3)" => "TIMESTAMP".
+func baseType(colType string) string {
+	t := strings.ToUpper(strings.TrimSpace(colType))
+	if i := strings.IndexAny(t, "( "); i >= 0 {
+		t = t[:i]
+	}
+
+	if strings.HasPrefix(t, TYPE_INTERVAL) {
+		return TYPE_INTERVAL
+	}
+
+	if strings.HasPrefix(t, TYPE_TIMESTAMP) {
+		return TYPE_TIMESTAMP
+	}
+
+	switch t {
+	case "CHAR", "TEXT", "STRING":
+		return TYPE_VARCHAR
+	}
+
+	return t
+}
+
+// typeArgs reads the arguments of a declared type, "DECIMAL(10,2)" => 10, 2
+// and "VARCHAR(20)" => 20, 0. ok is false when the type has none.
+func typeArgs(colType string) (precision, scale int, ok bool) {
+	open, end := strings.IndexByte(colType, '('), strings.LastIndexByte(colType, ')')
+	if open < 0 || end < open {
+		return 0, 0, false
+	}
+
+	args := strings.Split(colType[open+1:end], ",")
+	precision, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if len(args) > 1 {
+		if scale, err = strconv.Atoi(strings.TrimSpace(args[1])); err != nil {
+			return 0, 0, false
+		}
+	}
+
+	return precision, scale, true
+}
+
+// integerBits is the width of the integer types, SERIAL columns hold BIGINTs.
+var integerBits = map[string]uint{
+	"TINYINT": 8, "SMALLINT": 16, TYPE_INT: 32, TYPE_INTEGER: 32, TYPE_BIGINT: 64, "SERIAL": 64, "AUTO_INCREMENT": 64,
+}
+
+// CoerceValue checks a value written to a column against its declared type
+// and returns its canonical form: integers within the range of the type,
+// DECIMAL(p,s) rounded to s digits with at most p-s before the point and
+// VARCHAR(n) of at most n characters. Temporal and JSON values are checked by
+// EncodeStoredValue, other types are kept as they are.
+func CoerceValue(text, colType string) (string, error) {
+	if text == "" || text == nullMarker {
+		return text, nil
+	}
+
+	precision, scale, hasArgs := typeArgs(colType)
+
+	switch t := baseType(colType); t {
+	case "TINYINT", "SMALLINT", TYPE_INT, TYPE_INTEGER, TYPE_BIGINT, "SERIAL", "AUTO_INCREMENT":
+		num, err := parseInteger(text, colType)
+		if err != nil {
+			return "", err
+		}
+
+		limit := new(big.Int).Lsh(big.NewInt(1), integerBits[t]-1)
+		if num.Cmp(limit) >= 0 || num.Cmp(limit.Neg(limit)) < 0 {
+			return "", fmt.Errorf("%w: %s for type %s", ErrNumericOutOfRange, text, colType)
+		}
+		return num.String(), nil
+	case TYPE_DECIMAL, "NUMERIC":
+		return coerceDecimal(text, colType, precision, scale, hasArgs)
+	case "DOUBLE", "FLOAT", "REAL":
+		if _, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return "", fmt.Errorf("%w: %s for type %s", ErrNumericOutOfRange, text, colType)
+			}
+			return "", fmt.Errorf("%w: %s for type %s", ErrInvalidText, text, colType)
+		}
+		return strings.TrimSpace(text), nil
+	case TYPE_VARCHAR:
+		if hasArgs && utf8.RuneCountInString(text) > precision {
+			return "", fmt.Errorf("%w: %d characters for type %s", ErrStringTooLong, utf8.RuneCountInString(text), colType)
+		}
+	}
+
+	return text, nil
+}
+
+// parseInteger reads an integer, numbers with a zero fraction ("42.0") included.
+func parseInteger(text, colType string) (*big.Int, error) {
+	text = strings.TrimSpace(text)
+	if num, ok := new(big.Int).SetString(strings.TrimPrefix(text, "+"), 10); ok {
+		return num, nil
+	}
+
+	if !strings.Contains(text, "/") {
+		if num, ok := new(big.Rat).SetString(text); ok && num.IsInt() {
+			return num.Num(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s for type %s", ErrInvalidText, text, colType)
+}
+
+func coerceDecimal(text, colType string, precision, scale int, hasArgs bool) (string, error) {
+	text = strings.TrimSpace(text)
+	num, ok := new(big.Rat).SetString(text)
+	if !ok || strings.Contains(text, "/") {
+		return "", fmt.Errorf("%w: %s for type %s", ErrInvalidText, text, colType)
+	}
+
+	if !hasArgs {
+		return text, nil
+	}
+
+	rounded := num.FloatString(scale)
+	if strings.Trim(rounded, "-0.") == "" {
+		rounded = strings.TrimPrefix(rounded, "-") // -0.00
+	}
+
+	whole, _, _ := strings.Cut(strings.TrimPrefix(rounded, "-"), ".")
+	if len(strings.TrimLeft(whole, "0")) > precision-scale {
+		return "", fmt.Errorf("%w: %s for type %s", ErrNumericOutOfRange, text, colType)
+	}
+
+	return rounded, nil
+}
+
+// DatumFromText builds a datum from the textual form of a value of the
+// given column type. Unknown types and unparsable values fall back to strings
+// so that legacy rows keep working.
+func DatumFromText(text string, colType string) Datum {
+	if text == "" {
+		return NullDatum()
+	}
+
+	switch t := baseType(colType); {
+	case IsNumericType(t):
+		if d, err := NumberDatum(text); err == nil {
+			return d
+		}
+	case t == TYPE_DATE:
+		if tm, err := ParseDate(text); err == nil {
+			return DateDatum(tm)
+		}
+	case t == TYPE_TIMESTAMP:
+		if tm, err := ParseTimestamp(text); err == nil {
+			return TimestampDatum(tm)
+		}
+	case t == TYPE_INTERVAL:
+		if iv, err := ParseInterval(text); err == nil {
+			return IntervalDatum(iv)
+		}
+	}
+
+	return StringDatum(text)
+}
+
+// stripLiteralKeyword turns the sql spelling of a typed literal into its
+// value, "DATE '2024-01-01'" => "2024-01-01".
+func stripLiteralKeyword(text string, keyword string) string {
+	t := strings.TrimSpace(text)
+	if len(t) > len(keyword) && strings.EqualFold(t[:len(keyword)], keyword) && t[len(keyword)] == ' ' {
+		t = strings.ReplaceAll(t[len(keyword)+1:], "'", "")
+	}
+
+	return strings.TrimSpace(t)
+}
+
+func ParseDate(text string) (time.Time, error) {
+	text = stripLiteralKeyword(text, TYPE_DATE)
+	tm, err := time.Parse(DATE_LAYOUT, text)
+	if err != nil {
+		ts, tsErr := ParseTimestamp(text)
+		if tsErr != nil {
+			return time.Time{}, fmt.Errorf("invalid date: %s", text)
+		}
+		return DateDatum(ts).Time, nil
+	}
+
+	return tm, nil
+}
+
+func ParseTimestamp(text string) (time.Time, error) {
+	text = stripLiteralKeyword(text, TYPE_TIMESTAMP)
+	for _, layout := range timestampLayouts {
+		if tm, err := time.Parse(layout, text); err == nil {
+			return tm.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid timestamp: %s", text)
+}
+
+// ParseInterval accepts "<n> <unit> [<n> <unit>...]" (e.g. "1 day", "2 hours 30 minutes",
+// "1 year 2 months") and "HH:MM:SS" time parts.
+func ParseInterval(text string) (Interval, error) {
+	var iv Interval
+
+	fields := strings.Fields(strings.ToLower(stripLiteralKeyword(text, TYPE_INTERVAL)))
+	if len(fields) == 0 {
+		return iv, fmt.Errorf("invalid interval: %s", text)
+	}
+
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		if strings.Contains(field, ":") {
+			micros, err := parseClock(field)
+			if err != nil {
+				return iv, fmt.Errorf("invalid interval: %s", text)
+			}
+			iv.Micros += micros
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(field, 64)
+		if err != nil || i+1 >= len(fields) {
+			return iv, fmt.Errorf("invalid interval: %s", text)
+		}
+
+		i++
+		if err := addIntervalUnit(&iv, amount, fields[i]); err != nil {
+			return iv, err
+		}
+	}
+
+	return iv, nil
+}
+
+func parseClock(field string) (int64, error) {
+	sign := int64(1)
+	if strings.HasPrefix(field, "-") {
+		sign = -1
+		field = field[1:]
+	}
+
+	parts := strings.Split(field, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.New("invalid clock")
+	}
+
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	var seconds float64
+	if len(parts) == 3 {
+		seconds, err = strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	micros := (hours*3600+minutes*60)*int64(time.Second/time.Microsecond) + int64(seconds*1e6)
+	return sign * micros, nil
+}
+
+func addIntervalUnit(iv *Interval, amount float64, unit string) error {
+	unit = strings.TrimSuffix(unit, ",")
+	switch unit {
+	case "ms":
+		unit = "millisecond"
+	case "us":
+		unit = "microsecond"
+	default:
+		unit = strings.TrimSuffix(unit, "s")
+	}
+
+	switch unit {
+	case "year", "yr", "y":
+		iv.Months += int32(amount * 12)
+	case "month", "mon":
+		iv.Months += int32(amount)
+	case "week", "w":
+		iv.Days += int32(amount * 7)
+	case "day", "d":
+		iv.Days += int32(amount)
+	case "hour", "hr", "h":
+		iv.Micros += int64(amount * float64(time.Hour/time.Microsecond))
+	case "minute", "min", "m":
+		iv.Micros += int64(amount * float64(time.Minute/time.Microsecond))
+	case "second", "sec":
+		iv.Micros += int64(amount * 1e6)
+	case "millisecond":
+		iv.Micros += int64(amount * 1e3)
+	case "microsecond":
+		iv.Micros += int64(amount)
+	default:
+		return fmt.Errorf("invalid interval unit: %s", unit)
+	}
+
+	return nil
+}
+
+func FormatInterval(iv Interval) string {
+	var parts []string
+
+	years, months := iv.Months/12, iv.Months%12
+	if years != 0 {
+		parts = append(parts, pluralUnit(int64(years), "year"))
+	}
+	if months != 0 {
+		parts = append(parts, pluralUnit(int64(months), "month"))
+	}
+	if iv.Days != 0 {
+		parts = append(parts, pluralUnit(int64(iv.Days), "day"))
+	}
+
+	if iv.Micros != 0 || len(parts) == 0 {
+		micros := iv.Micros
+		sign := ""
+		if micros < 0 {
+			sign = "-"
+			micros = -micros
+		}
+
+		d := time.Duration(micros) * time.Microsecond
+		h := int64(d / time.Hour)
+		m := int64(d % time.Hour / time.Minute)
+		s := float64(d%time.Minute) / float64(time.Second)
+		parts = append(parts, fmt.Sprintf("%s%02d:%02d:%s", sign, h, m, strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%09.6f", s), "0"), ".")))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func pluralUnit(n int64, unit string) string {
+	if n == 1 || n == -1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// Binary encoding
+//
+// DATE and TIMESTAMP are stored as big endian integers with the sign bit flipped,
+// so that the raw bytes sort in the same order as the values.
+
+func EncodeDate(t time.Time) []byte {
+	days := DateDatum(t).Time.Unix() / 86400
+	buf := make([]byte, DATE_BYTES)
+	binary.BigEndian.PutUint32(buf, uint32(int32(days))^(1<<31))
+	return buf
+}
+
+func DecodeDate(b []byte) (time.Time, error) {
+	if len(b) != DATE_BYTES {
+		return time.Time{}, fmt.Errorf("invalid encoded date length: %d", len(b))
+	}
+
+	days := int32(binary.BigEndian.Uint32(b) ^ (1 << 31))
+	return time.Unix(int64(days)*86400, 0).UTC(), nil
+}
+
+func EncodeTimestamp(t time.Time) []byte {
+	buf := make([]byte, TIMESTAMP_BYTES)
+	binary.BigEndian.PutUint64(buf, uint64(t.UTC().UnixMicro())^(1<<63))
+	return buf
+}
+
+func DecodeTimestamp(b []byte) (time.Time, error) {
+	if len(b) != TIMESTAMP_BYTES {
+		return time.Time{}, fmt.Errorf("invalid encoded timestamp length: %d", len(b))
+	}
+
+	micros := int64(binary.BigEndian.Uint64(b) ^ (1 << 63))
+	return time.UnixMicro(micros).UTC(), nil
+}
+
+func EncodeInterval(iv Interval) []byte {
+	buf := make([]byte, INTERVAL_BYTES)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(iv.Months))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(iv.Days))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(iv.Micros))
+	return buf
+}
+
+func DecodeInterval(b []byte) (Interval, error) {
+	if len(b) != INTERVAL_BYTES {
+		return Interval{}, fmt.Errorf("invalid encoded interval length: %d", len(b))
+	}
+
+	return Interval{
+		Months: int32(binary.BigEndian.Uint32(b[0:4])),
+		Days:   int32(binary.BigEndian.Uint32(b[4:8])),
+		Micros: int64(binary.BigEndian.Uint64(b[8:16])),
+	}, nil
+}
+
+// EncodeStoredValue converts the textual value received from the plan into
+// the representation kept inside the tuple.
+func EncodeStoredValue(text string, colType string) (string, error) {
+	if text == "" {
+		return text, nil
+	}
+
+	switch baseType(colType) {
+	case TYPE_DATE:
+		tm, err := ParseDate(text)
+		if err != nil {
+			return "", err
+		}
+		return string(EncodeDate(tm)), nil
+	case TYPE_TIMESTAMP:
+		tm, err := ParseTimestamp(text)
+		if err != nil {
+			return "", err
+		}
+		return string(EncodeTimestamp(tm)), nil
+	case TYPE_INTERVAL:
+		iv, err := ParseInterval(text)
+		if err != nil {
+			return "", err
+		}
+		return string(EncodeInterval(iv)), nil
+	case TYPE_JSON:
+		encoded, err := EncodeJSON(text)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+
+	return text, nil
+}
+
+// DecodeStoredValue is the inverse of EncodeStoredValue.
+func DecodeStoredValue(stored string, colType string) (string, error) {
+	if stored == "" {
+		return stored, nil
+	}
+
+	switch baseType(colType) {
+	case TYPE_DATE:
+		tm, err := DecodeDate([]byte(stored))
+		if err != nil {
+			return "", err
+		}
+		return tm.Format(DATE_LAYOUT), nil
+	case TYPE_TIMESTAMP:
+		tm, err := DecodeTimestamp([]byte(stored))
+		if err != nil {
+			return "", err
+		}
+		return tm.Format(TIMESTAMP_LAYOUT), nil
+	case TYPE_INTERVAL:
+		iv, err := DecodeInterval([]byte(stored))
+		if err != nil {
+			return "", err
+		}
+		return FormatInterval(iv), nil
+	case TYPE_JSON:
+		return DecodeJSON([]byte(stored))
+	}
+
+	return stored, nil
+}
+
+// EncodeStoredValues rewrites every value of the row into its stored form,
+// must be called right before EncodeRow.
+func EncodeStoredValues(row *RowV2, tableInfo *TableInfo) error {
+	if tableInfo == nil {
+		return nil
+	}
+
+	for col, val := range row.Values {
+		colInfo, ok := tableInfo.Schema[col]
+		if !ok {
+			continue
+		}
+
+		stored, err := EncodeStoredValue(val, colInfo.Type)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col, err)
+		}
+
+		row.Values[col] = stored
+	}
+
+	if layout := tableInfo.rowLayout(); !layout.identity {
+		row.Values = layout.toStored(row.Values)
+	}
+
+	return nil
+}
+
+// DecodeStoredValues rewrites every value of the row into its textual form,
+// must be called right after DecodeRow. Values moved to overflow pages are
+// read back here and the row is mapped to the current schema (ALTER TABLE).
+func DecodeStoredValues(row *RowV2, tableInfo *TableInfo) error {
+	if tableInfo == nil {
+		return nil
+	}
+
+	layout := tableInfo.rowLayout()
+	if !layout.identity {
+		row.Values = layout.toSchema(row.Values)
+	}
+
+	for col, val := range row.Values {
+		if isToastPointer(val) {
+			if tableInfo.toast == nil {
+				return fmt.Errorf("column %s: toast storage not opened", col)
+			}
+
+			value, err := tableInfo.toast.Read(val)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col, err)
+			}
+			val = value
+			row.Values[col] = val
+		}
+
+		colInfo, ok := tableInfo.Schema[col]
+		if !ok || val == nullMarker {
+			continue
+		}
+
+		text, err := DecodeStoredValue(val, colInfo.Type)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col, err)
+		}
+
+		row.Values[col] = text
+	}
+
+	if !layout.identity {
+		layout.fillMissing(row.Values)
+	}
+
+	return nil
+}
+
+// CompareDatums returns -1, 0 or 1. Nulls sort first. Mixed kinds are coerced
+// towards the non string side (e.g '2024-01-01' vs a DATE).
+func CompareDatums(a, b Datum) (int, error) {
+	if a.IsNull() || b.IsNull() {
+		switch {
+		case a.IsNull() && b.IsNull():
+			return 0, nil
+		case a.IsNull():
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+
+	if a.Kind != b.Kind {
+		var err error
+		a, b, err = coerceDatums(a, b)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch a.Kind {
+	case KindNumber:
+		return compareNumbers(a, b), nil
+	case KindDate, KindTimestamp:
+		return a.Time.Compare(b.Time), nil
+	case KindInterval:
+		return compareInt64(intervalMicros(a.Interval), intervalMicros(b.Interval)), nil
+	case KindBool:
+		return compareInt64(boolInt(a.Bool), boolInt(b.Bool)), nil
+	default:
+		return strings.Compare(a.Str, b.Str), nil
+	}
+}
+
+func coerceDatums(a, b Datum) (Datum, Datum, error) {
+	if a.Kind == KindString {
+		converted, err := CastDatum(a, kindTypeName(b.Kind))
+		if err != nil {
+			return StringDatum(a.Str), StringDatum(b.String()), nil
+		}
+		return converted, b, nil
+	}
+
+	if b.Kind == KindString {
+		converted, err := CastDatum(b, kindTypeName(a.Kind))
+		if err != nil {
+			return StringDatum(a.String()), StringDatum(b.Str), nil
+		}
+		return a, converted, nil
+	}
+
+	if (a.Kind == KindDate && b.Kind == KindTimestamp) || (a.Kind == KindTimestamp && b.Kind == KindDate) {
+		return TimestampDatum(a.Time), TimestampDatum(b.Time), nil
+	}
+
+	return Datum{}, Datum{}, fmt.Errorf("can't compare %s with %s", kindTypeName(a.Kind), kindTypeName(b.Kind))
+}
+
+func compareNumbers(a, b Datum) int {
+	if isIntegerText(a.Str) && isIntegerText(b.Str) {
+		left, okLeft := new(big.Int).SetString(strings.TrimPrefix(a.Str, "+"), 10)
+		right, okRight := new(big.Int).SetString(strings.TrimPrefix(b.Str, "+"), 10)
+		if okLeft && okRight {
+			return left.Cmp(right)
+		}
+	}
+
+	switch {
+	case a.Num < b.Num:
+		return -1
+	case a.Num > b.Num:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// approximation used only for ordering, a month counts as 30 days.
+func intervalMicros(iv Interval) int64 {
+	return int64(iv.Months)*30*MICROS_PER_DAY + int64(iv.Days)*MICROS_PER_DAY + iv.Micros
+}
+
+func kindTypeName(kind DatumKind) string {
+	switch kind {
+	case KindNumber:
+		return TYPE_DECIMAL
+	case KindBool:
+		return "BOOLEAN"
+	case KindDate:
+		return TYPE_DATE
+	case KindTimestamp:
+		return TYPE_TIMESTAMP
+	case KindInterval:
+		return TYPE_INTERVAL
+	default:
+		return TYPE_VARCHAR
+	}
+}
+
+// CastDatum implements CAST(x AS type) for the types the engine understands.
+func CastDatum(d Datum, typeName string) (Datum, error) {
+	if d.IsNull() {
+		return d, nil
+	}
+
+	switch t := baseType(typeName); {
+	case IsNumericType(t):
+		switch d.Kind {
+		case KindNumber:
+			if t == TYPE_DECIMAL || t == "DOUBLE" || t == "FLOAT" || t == "REAL" || t == "NUMERIC" {
+				return d, nil
+			}
+			if isIntegerText(d.Str) {
+				return d, nil
+			}
+			return FloatDatum(math.Trunc(d.Num)), nil
+		case KindBool:
+			return IntDatum(boolInt(d.Bool)), nil
+		default:
+			return NumberDatum(strings.TrimSpace(d.String()))
+		}
+	case t == TYPE_DATE:
+		switch d.Kind {
+		case KindDate, KindTimestamp:
+			return DateDatum(d.Time), nil
+		default:
+			tm, err := ParseDate(d.String())
+			if err != nil {
+				return Datum{}, err
+			}
+			return DateDatum(tm), nil
+		}
+	case t == TYPE_TIMESTAMP:
+		switch d.Kind {
+		case KindDate, KindTimestamp:
+			return TimestampDatum(d.Time), nil
+		default:
+			tm, err := ParseTimestamp(d.String())
+			if err != nil {
+				return Datum{}, err
+			}
+			return TimestampDatum(tm), nil
+		}
+	case t == TYPE_INTERVAL:
+		if d.Kind == KindInterval {
+			return d, nil
+		}
+		iv, err := ParseInterval(d.String())
+		if err != nil {
+			return Datum{}, err
+		}
+		return IntervalDatum(iv), nil
+	case t == "BOOLEAN":
+		if d.Kind == KindBool {
+			return d, nil
+		}
+		b, err := strconv.ParseBool(strings.ToLower(d.String()))
+		if err != nil {
+			return Datum{}, fmt.Errorf("invalid boolean: %s", d.String())
+		}
+		return BoolDatum(b), nil
+	default:
+		return StringDatum(d.String()), nil
+	}
+}
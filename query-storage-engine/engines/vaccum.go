@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
 
 	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
@@ -25,19 +26,86 @@ const (
 	NEXT_LEVEL = 400
 )
 
-func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoChan chan *ModifiedInfo, insertChan chan *NonAddedRows, tableObj *TableObj, tableStats *TableInfo) error {
+// PENDING_BYTES bounds the new versions an UPDATE holds back while it scans.
+const PENDING_BYTES = 4 * AVAIL_DATA
+
+// updatePages are the pages of the table an UPDATE may still scan or
+// rearrange, a page still to be scanned mustn't get rows the scan didn't
+// read and one waiting for RearrangePAGE mustn't get rows at all. The new
+// versions placed before the scan ends only go to the other pages: the ones
+// already rearranged and the ones created for them, the scan skips both.
+type updatePages struct {
+	mu    sync.RWMutex
+	pages map[PageID]bool
+}
+
+func newUpdatePages(tableObj *TableObj) *updatePages {
+	tableObj.DirectoryPage.Mu.RLock()
+	defer tableObj.DirectoryPage.Mu.RUnlock()
+
+	pages := make(map[PageID]bool, len(tableObj.DirectoryPage.Value))
+	for pageID := range tableObj.DirectoryPage.Value {
+		pages[pageID] = true
+	}
+	return &updatePages{pages: pages}
+}
+
+func (up *updatePages) pending(pageID PageID) bool {
+	up.mu.RLock()
+	defer up.mu.RUnlock()
+	return up.pages[pageID]
+}
+
+func (up *updatePages) usable(pageID PageID) bool {
+	return !up.pending(pageID)
+}
+
+func (up *updatePages) done(pageID PageID) {
+	up.mu.Lock()
+	delete(up.pages, pageID)
+	up.mu.Unlock()
+}
+
+// scanned frees every page once the scan is over.
+func (up *updatePages) scanned() {
+	up.mu.Lock()
+	clear(up.pages)
+	up.mu.Unlock()
+}
+
+func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoChan chan *ModifiedInfo, insertChan chan *NonAddedRows, pages *updatePages, tableObj *TableObj, tableStats *TableInfo) error {
 	logger.Log.Info("cleanOrgnize (start)")
 
 	if insertChan != nil {
 		defer close(insertChan)
 	}
 
+	// the new versions are held back until PENDING_BYTES, then placed in
+	// the pages the update is done with (see updatePages)
+	var pending []*NonAddedRows
+	pendingBytes := 0
+	flush := func() error {
+		for _, nonAddedRow := range pending {
+			select {
+			case insertChan <- nonAddedRow:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		pending, pendingBytes = nil, 0
+		return nil
+	}
+
 	for updateInfo := range updateInfoChan {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
 		space := updateInfo.FreeSpaceMapping
+		if err := tableObj.relocateRows(space.TempPagePtr, tableStats); err != nil {
+			return fmt.Errorf("(cleanOrgnize) => relocateRows failed: %w", err)
+		}
+
 		newPage, err := RearrangePAGE(space.TempPagePtr, tableObj, tableObj.TableName)
 		if err != nil {
 			return fmt.Errorf("(cleanOrgnize) => RearrangePAGE failed: %w", err)
@@ -49,6 +117,12 @@ func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoC
 			memTag = AVAIL_DATA
 		}
 
+		// the rows left narrow the zones again
+		zones, err := pageZones(newPage, newPage.PointerArray, tableStats)
+		if err != nil {
+			return fmt.Errorf("(cleanOrgnize) => pageZones failed: %w", err)
+		}
+
 		dirPage := tableObj.DirectoryPage
 
 		dirPage.Mu.RLock()
@@ -66,6 +140,7 @@ func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoC
 		}
 		pageObj.Level = memTag
 		pageObj.ExactFreeMem = space.FreeMemory
+		pageObj.Zones = zones
 		pageObj.Mu.Unlock()
 
 		// existing page already has something inside of the pointer array.
@@ -74,6 +149,10 @@ func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoC
 			return fmt.Errorf("updatePageInfo failed: %w", err)
 		}
 
+		if err := tableObj.rebuildBlooms(newPage, tableStats); err != nil {
+			return fmt.Errorf("(cleanOrgnize) => rebuildBlooms failed: %w", err)
+		}
+
 		tableObj.Mu.Lock()
 		tableObj.Memory[memTag] = append(tableObj.Memory[memTag], space)
 
@@ -91,8 +170,10 @@ func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoC
 		tableObj.Mu.Unlock()
 
 		if insertChan != nil {
-			insertChan <- updateInfo.NonAddedRow
-		} else {
+			pages.done(space.PageID)
+			pending = append(pending, updateInfo.NonAddedRow)
+			pendingBytes += updateInfo.NonAddedRow.BytesNeeded
+		} else if updateInfo.NonAddedRow != nil {
 			var nonAddedRowsType = reflect.TypeOf((*NonAddedRows)(nil))
 			accountingCtx.Release(nonAddedRowsType, updateInfo.NonAddedRow)
 		}
@@ -103,6 +184,18 @@ func cleanOrgnize(ctx context.Context, accountingCtx *MemoryContext, updateInfoC
 			panic("couldn't free modifiedObj")
 		}
 
+		if pendingBytes >= PENDING_BYTES {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if insertChan != nil {
+		pages.scanned()
+		if err := flush(); err != nil {
+			return err
+		}
 	}
 
 	logger.Log.Info("cleanOrgnize (end)")
@@ -173,7 +266,7 @@ func AccountUsedMemory(memory map[uint16][]*FreeSpace) uint64 {
 	return totalMem
 }
 
-func searchPage(tableObj *TableObj, memoryNedded, level uint16) ([]*FreeSpace, *FreeSpace, uint16, int) {
+func searchPage(tableObj *TableObj, memoryNedded, level uint16, usable func(PageID) bool) ([]*FreeSpace, *FreeSpace, uint16, int) {
 	var memSlice []*FreeSpace
 	var memTag uint16
 	var spaceInfo *FreeSpace
@@ -198,7 +291,7 @@ func searchPage(tableObj *TableObj, memoryNedded, level uint16) ([]*FreeSpace, *
 		}
 
 		for i, mem := range memSlice {
-			if memoryNedded < mem.FreeMemory {
+			if memoryNedded < mem.FreeMemory && (usable == nil || usable(mem.PageID)) {
 				spaceInfo = mem
 				deleteIndex = i
 				break
@@ -217,13 +310,13 @@ func searchPage(tableObj *TableObj, memoryNedded, level uint16) ([]*FreeSpace, *
 	return memSlice, spaceInfo, memTag, deleteIndex
 }
 
-func getAvailablePage(bufferM *BufferPoolManager, tableObj *TableObj, memoryNedded uint16, tableName string) (*PageV2, error) {
+func getAvailablePage(bufferM *BufferPoolManager, tableObj *TableObj, memoryNedded uint16, tableName string, usable func(PageID) bool) (*PageV2, error) {
 	var memSlice []*FreeSpace
 	var memTag uint16
 	var spaceInfo *FreeSpace
 	var deleteIndex int
 
-	memSlice, spaceInfo, memTag, deleteIndex = searchPage(tableObj, memoryNedded, memoryNedded)
+	memSlice, spaceInfo, memTag, deleteIndex = searchPage(tableObj, memoryNedded, memoryNedded, usable)
 	if memTag == 0 && spaceInfo == nil {
 		logger.Log.Info("Created new page")
 
@@ -0,0 +1,173 @@
+package engines
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Views
+//
+// A view is a stored SELECT over a single table or view. The planner keeps
+// the definition too and expands it into every query reading the view, the
+// plans reaching the engine only name tables. The catalog holds the text and
+// what the view reads so the tables can't go away under it.
+
+type View struct {
+	Query   string   // the SELECT as written
+	Columns []string // names of the view columns, in order
+	From    string   // table or view read by the query
+}
+
+func (v *View) attrs() map[string]string {
+	return map[string]string{
+		"query":   v.Query,
+		"columns": strings.Join(v.Columns, ATTR_SEPARATOR),
+		"from":    v.From,
+	}
+}
+
+func (v *View) setAttrs(attrs map[string]string) {
+	v.Query, v.From = attrs["query"], attrs["from"]
+	v.Columns = strings.Split(attrs["columns"], ATTR_SEPARATOR)
+}
+
+// viewsOn lists the views, materialized ones included, reading name.
+func (c *Catalog) viewsOn(name string) []string {
+	var views []string
+	for viewName, view := range c.Views {
+		if view.From == name {
+			views = append(views, viewName)
+		}
+	}
+
+	for viewName, view := range c.Materialized {
+		if view.From == name {
+			views = append(views, viewName)
+		}
+	}
+
+	slices.Sort(views)
+	return views
+}
+
+// checkNoViews fails when a view reads name, used before it's dropped or renamed.
+func (c *Catalog) checkNoViews(name string) error {
+	if views := c.viewsOn(name); len(views) > 0 {
+		return fmt.Errorf("view: %s depends on: %s", strings.Join(views, ", "), name)
+	}
+	return nil
+}
+
+// CreateView stores the view, replace swaps the definition of an existing
+// one as long as it doesn't end up reading itself.
+func (dm *DiskManagerV2) CreateView(name string, view *View, replace bool) error {
+	catalog := dm.PageCatalog
+
+	if err := dm.checkDatabase(name); err != nil {
+		return err
+	}
+
+	if _, ok := catalog.Tables[name]; ok {
+		return fmt.Errorf("table: %s already exists", name)
+	}
+
+	old, exists := catalog.Views[name]
+	if exists && !replace {
+		return fmt.Errorf("view: %s already exists", name)
+	}
+
+	for from := view.From; ; {
+		if from == name {
+			return fmt.Errorf("view: %s can't read itself", name)
+		}
+
+		source, ok := catalog.Views[from]
+		if !ok {
+			if _, ok := catalog.Tables[from]; !ok {
+				return fmt.Errorf("table: %s read by view: %s doesn't exist", from, name)
+			}
+			break
+		}
+		from = source.From
+	}
+
+	if catalog.Views == nil {
+		catalog.Views = make(map[string]*View)
+	}
+
+	catalog.Views[name] = view
+	if err := dm.UpdateCatalog(); err != nil {
+		if exists {
+			catalog.Views[name] = old
+		} else {
+			delete(catalog.Views, name)
+		}
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	return nil
+}
+
+func (dm *DiskManagerV2) DropView(name string) error {
+	catalog := dm.PageCatalog
+
+	view, ok := catalog.Views[name]
+	if !ok {
+		return fmt.Errorf("view: %s doesn't exist", name)
+	}
+
+	if err := catalog.checkNoViews(name); err != nil {
+		return err
+	}
+
+	delete(catalog.Views, name)
+	if err := dm.UpdateCatalog(); err != nil {
+		catalog.Views[name] = view
+		return fmt.Errorf("UpdateCatalog failed: %w", err)
+	}
+
+	return nil
+}
+
+// handleCreateView runs CREATE [OR REPLACE] VIEW name [(columns)] AS SELECT ...
+func (qe *QueryEngine) handleCreateView(plan map[string]any) Result {
+	name := plan["name"].(string)
+
+	view := &View{}
+	view.Query, _ = plan["query"].(string)
+	view.From, _ = plan["from"].(string)
+	for _, column := range asList(plan["columns"]) {
+		view.Columns = append(view.Columns, column.(string))
+	}
+
+	if view.Query == "" || view.From == "" || len(view.Columns) == 0 {
+		return handleError(fmt.Errorf("view: %s has no definition", name), "failed")
+	}
+
+	replace, _ := plan["orReplace"].(bool)
+	if err := qe.BufferPoolManager.DiskManager.CreateView(name, view, replace); err != nil {
+		return handleError(fmt.Errorf("CreateView failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "View Created"}
+}
+
+// handleDropView runs DROP VIEW [IF EXISTS] name
+func (qe *QueryEngine) handleDropView(plan map[string]any) Result {
+	name := plan["name"].(string)
+	manager := qe.BufferPoolManager.DiskManager
+
+	if _, ok := manager.PageCatalog.Views[name]; !ok {
+		if ifExists, _ := plan["ifExists"].(bool); ifExists {
+			return Result{Msg: "View Doesn't Exist"}
+		}
+		return handleError(fmt.Errorf("view: %s doesn't exist", name), "failed")
+	}
+
+	if err := manager.DropView(name); err != nil {
+		return handleError(fmt.Errorf("DropView failed: %w", err), "failed")
+	}
+
+	return Result{Msg: "View Dropped"}
+}
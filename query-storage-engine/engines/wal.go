@@ -18,6 +18,7 @@ const (
 	LogTypeDelete
 	LogTypeCommit
 	LogTypeAbort
+	LogTypeTruncate
 )
 
 type LogRecord struct {
@@ -38,13 +39,55 @@ type WalManager struct {
 	activeTx      map[string][]*LogRecord
 	activeTxTable map[string]*Table
 	mu            sync.Mutex
+
+	// OnCommit gets the records of every committed transaction, called once
+	// the log is released (the materialized views are maintained from it)
+	OnCommit func(records []*LogRecord)
 }
 
+// Table is the slot a statement holds on its table from start to end, see
+// InlineCruds.
 type Table struct {
 	notification chan bool
 	activeTx     bool
 }
 
+// acquireTable waits for the statement holding the table to end and takes it.
+func (wl *WalManager) acquireTable(tableName string) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	tableInfo, ok := wl.activeTxTable[tableName]
+	if !ok {
+		tableInfo = &Table{notification: make(chan bool, 1)}
+		wl.activeTxTable[tableName] = tableInfo
+	}
+
+	for tableInfo.activeTx {
+		wl.mu.Unlock()
+		<-tableInfo.notification
+		wl.mu.Lock()
+	}
+	tableInfo.activeTx = true
+}
+
+// releaseTable frees the table for the next statement.
+func (wl *WalManager) releaseTable(tableName string) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	tableInfo, ok := wl.activeTxTable[tableName]
+	if !ok || !tableInfo.activeTx {
+		return
+	}
+
+	tableInfo.activeTx = false
+	select {
+	case tableInfo.notification <- true:
+	default:
+	}
+}
+
 func NewWalManager(logFile string) (*WalManager, error) {
 	file, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -104,11 +147,25 @@ func (wl *WalManager) Log(txID string, query LogType, tableName string, rowId ui
 }
 
 func (wl *WalManager) CommitTransaction(txID string, tableName string) error {
+	records, err := wl.commit(txID, tableName)
+	if err != nil {
+		return err
+	}
+
+	if wl.OnCommit != nil && len(records) > 0 {
+		wl.OnCommit(records)
+	}
+
+	return nil
+}
+
+func (wl *WalManager) commit(txID string, tableName string) ([]*LogRecord, error) {
 	wl.mu.Lock()
 	defer wl.mu.Unlock()
 
-	if _, exists := wl.activeTx[txID]; !exists {
-		return fmt.Errorf("transaction %s not found", txID)
+	records, exists := wl.activeTx[txID]
+	if !exists {
+		return nil, fmt.Errorf("transaction %s not found", txID)
 	}
 
 	wl.currentLSN++
@@ -121,34 +178,25 @@ func (wl *WalManager) CommitTransaction(txID string, tableName string) error {
 
 	bytes, err := encodeLog(&commitRecord)
 	if err != nil {
-		return fmt.Errorf("encodeLog failed: %w", err)
+		return nil, fmt.Errorf("encodeLog failed: %w", err)
 	}
 
 	_, err = wl.writer.Write(bytes)
 	if err != nil {
-		return fmt.Errorf("commit - writer failed: %w", err)
+		return nil, fmt.Errorf("commit - writer failed: %w", err)
 	}
 
 	err = wl.writer.Flush()
 	if err != nil {
-		return fmt.Errorf("commit - flush failed: %w", err)
+		return nil, fmt.Errorf("commit - flush failed: %w", err)
 	}
 
 	delete(wl.activeTx, txID)
-	tableInfo, ok := wl.activeTxTable[tableName]
-	if ok {
-		tableInfo.activeTx = false
-		select {
-		case tableInfo.notification <- true:
-		default:
-			fmt.Println("No routine waiting")
-		}
-	}
 
-	return nil
+	return records, nil
 }
 
-func (wl *WalManager) AbortTransaction(txID, primary, modifiedColumn, tableName string, engine *QueryEngine, catalog *Catalog) error {
+func (wl *WalManager) AbortTransaction(txID, primary, tableName string, engine *QueryEngine, catalog *Catalog) error {
 	wl.mu.Lock()
 	defer wl.mu.Unlock()
 
@@ -157,7 +205,7 @@ func (wl *WalManager) AbortTransaction(txID, primary, modifiedColumn, tableName
 		return fmt.Errorf("transaction %s not found", txID)
 	}
 
-	if err := Undo(logs, engine, catalog, primary, modifiedColumn); err != nil {
+	if err := Undo(logs, engine, catalog, primary); err != nil {
 		return fmt.Errorf("undo failed: %w", err)
 	}
 
@@ -185,21 +233,27 @@ func (wl *WalManager) AbortTransaction(txID, primary, modifiedColumn, tableName
 	}
 
 	delete(wl.activeTx, txID)
-	tableInfo := wl.activeTxTable[tableName]
-	tableInfo.activeTx = false
-
-	select {
-	case tableInfo.notification <- true:
-	default:
-		fmt.Println("No routine waiting")
-	}
 
 	return nil
 }
 
-func Undo(logs []*LogRecord, engine *QueryEngine, catalog *Catalog, primary, modifiedColumn string) error {
+// Undo reverts the records of a transaction newest first. The records of the
+// foreign key actions belong to other tables, those use their own primary.
+// The undo statements run outside of any transaction, AbortTransaction holds
+// the log while they run and they must not reach OnCommit.
+func Undo(logs []*LogRecord, engine *QueryEngine, catalog *Catalog, primary string) error {
+	if catalog == nil {
+		catalog = engine.BufferPoolManager.DiskManager.PageCatalog
+	}
+
 	for i := len(logs) - 1; i >= 0; i-- {
 		log := logs[i]
+
+		primary := primary
+		if tablePrimary, err := getPrimary(log.TableID, catalog); err == nil {
+			primary = tablePrimary
+		}
+
 		switch log.Type {
 		case LogTypeInsert:
 			err := undoInsert(log, engine, primary)
@@ -207,7 +261,7 @@ func Undo(logs []*LogRecord, engine *QueryEngine, catalog *Catalog, primary, mod
 				return fmt.Errorf("undoInsert failed: %w", err)
 			}
 		case LogTypeUpdate:
-			err := undoUpdate(log, engine, primary, modifiedColumn)
+			err := undoUpdate(log, engine, primary)
 			if err != nil {
 				return fmt.Errorf("undoUpdate failed: %w", err)
 			}
@@ -222,7 +276,7 @@ func Undo(logs []*LogRecord, engine *QueryEngine, catalog *Catalog, primary, mod
 	return nil
 }
 
-func Redo(logs []*LogRecord, engine *QueryEngine, catalog *Catalog, primary, modifiedColumn string) error {
+func Redo(logs []*LogRecord, engine *QueryEngine, catalog *Catalog, primary string) error {
 	for _, log := range logs {
 		switch log.Type {
 		case LogTypeInsert:
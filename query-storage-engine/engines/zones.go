@@ -0,0 +1,279 @@
+package engines
+
+import (
+	"fmt"
+	"maps"
+)
+
+// Zone maps of the pages
+//
+// The PageInfo of every page keeps, next to its slots in the directory, the
+// smallest and largest value of each column and how many rows hold a value
+// or null in it. A scan whose condition bounds a column with constants (the
+// range an index on the column alone would scan) or tests it for null skips
+// the pages whose zone can't match. Rows inserted in order, like timestamps
+// or sequences, fill pages with narrow zones, a window over them only reads
+// the pages it covers.
+//
+// findAndUpdate widens the zones of a page before writing it, cleanOrgnize
+// computes them again from the rows RearrangePAGE keeps. A zone may be wider
+// than its page, never narrower, and a page without the zone of a column
+// (directories written before, ADD COLUMN) is always read for it. The
+// missing zones are computed when the table is opened. Values longer than
+// ZONE_MAX_TEXT aren't kept, the zone only counts the rows then.
+//
+// Zones are kept under the stored name of the column like the bloom filters.
+
+const ZONE_MAX_TEXT = 64
+
+// ZoneMap is what a page holds in a column, Min and Max are "" when no row
+// holds a value or one was too long to keep.
+type ZoneMap struct {
+	Min    string
+	Max    string
+	Values uint32 // rows holding a value
+	Nulls  uint32
+}
+
+// add widens the zone to the value.
+func (zone ZoneMap) add(value, colType string) ZoneMap {
+	if value == "" {
+		zone.Nulls++
+		return zone
+	}
+
+	ranged := zone.Values == 0 || zone.Min != ""
+	zone.Values++
+
+	switch {
+	case !ranged:
+	case len(value) > ZONE_MAX_TEXT:
+		zone.Min, zone.Max = "", ""
+	case zone.Min == "":
+		zone.Min, zone.Max = value, value
+	default:
+		if compareText(value, zone.Min, colType) < 0 {
+			zone.Min = value
+		}
+		if compareText(value, zone.Max, colType) > 0 {
+			zone.Max = value
+		}
+	}
+
+	return zone
+}
+
+// addZones adds the rows to the zones of the columns, only to the ones
+// already there unless the page held no row before.
+func addZones(zones map[Column]ZoneMap, rows []map[string]string, tableInfo *TableInfo, empty bool) {
+	for column, columnType := range tableInfo.Schema {
+		key := Column(columnType.storedName(column))
+
+		zone, ok := zones[key]
+		if !ok && !empty {
+			continue // the rows of the page aren't in it
+		}
+
+		for _, row := range rows {
+			zone = zone.add(row[column], columnType.Type)
+		}
+		zones[key] = zone
+	}
+}
+
+// widenZones adds the rows placed from firstSlot on in the page to its
+// zones, found is false when the page isn't in the directory yet.
+func (tableObj *TableObj) widenZones(rows []map[string]string, tableInfo *TableInfo, pageID PageID, firstSlot int) (found bool) {
+	tableObj.DirectoryPage.Mu.RLock()
+	pageObj, ok := tableObj.DirectoryPage.Value[pageID]
+	tableObj.DirectoryPage.Mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	pageObj.Mu.Lock()
+	defer pageObj.Mu.Unlock()
+
+	// a new map, the directory may be encoded meanwhile
+	zones := maps.Clone(pageObj.Zones)
+	if zones == nil {
+		zones = make(map[Column]ZoneMap)
+	}
+
+	addZones(zones, rows, tableInfo, firstSlot == 0)
+	pageObj.Zones = zones
+	return true
+}
+
+// pageZones are the zones of the rows in the slots of the page.
+func pageZones(page *PageV2, pointers []TupleLocation, tableInfo *TableInfo) (map[Column]ZoneMap, error) {
+	var rows []map[string]string
+	for _, location := range pointers {
+		if location.Free {
+			continue
+		}
+
+		values, err := decodeRowValues(page.Data[location.Offset:location.Offset+location.Length], tableInfo)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, values)
+	}
+
+	zones := make(map[Column]ZoneMap)
+	addZones(zones, rows, tableInfo, true)
+	return zones, nil
+}
+
+// fillZones computes the zones the pages of the table are missing.
+func (tableObj *TableObj) fillZones(tableInfo *TableInfo) error {
+	dirPage := tableObj.DirectoryPage
+	dirPage.Mu.Lock()
+	defer dirPage.Mu.Unlock()
+
+	filled := false
+	for _, pageObj := range dirPage.Value {
+		pageObj.Mu.Lock()
+		complete := true
+		for column, columnType := range tableInfo.Schema {
+			if _, ok := pageObj.Zones[Column(columnType.storedName(column))]; !ok {
+				complete = false
+				break
+			}
+		}
+
+		if complete {
+			pageObj.Mu.Unlock()
+			continue
+		}
+
+		zones, err := tableObj.scanZones(pageObj, tableInfo)
+		if err != nil {
+			pageObj.Mu.Unlock()
+			return err
+		}
+		pageObj.Zones = zones
+		pageObj.Mu.Unlock()
+		filled = true
+	}
+
+	if !filled {
+		return nil
+	}
+
+	if err := UpdateDirectoryPageDisk(dirPage, tableObj.DirFile); err != nil {
+		return fmt.Errorf("UpdateDirectoryPageDisk failed: %w", err)
+	}
+	return nil
+}
+
+// scanZones reads the page back to compute its zones, the caller holds the
+// lock of pageObj.
+func (tableObj *TableObj) scanZones(pageObj *PageInfo, tableInfo *TableInfo) (map[Column]ZoneMap, error) {
+	pageBytes, err := ReadPageAtOffset(tableObj.DataFile, pageObj.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPageAtOffset failed: %w", err)
+	}
+
+	page, err := DecodePageV2(pageBytes)
+	if err != nil {
+		return nil, fmt.Errorf("DecodePageV2 failed: %w", err)
+	}
+
+	return pageZones(page, pageObj.PointerArray, tableInfo)
+}
+
+// zoneTest is what a condition asks of a column: a value within lo and hi
+// (nil for no bound), or null.
+type zoneTest struct {
+	key     Column
+	colType string
+	lo, hi  *IndexBound
+	isNull  bool
+}
+
+// zoneTests are the bounds and null tests on the columns of the table among
+// the conjuncts of condition.
+func zoneTests(condition any, tableInfo *TableInfo, ectx *ExprContext) []zoneTest {
+	conditions := conjuncts(condition)
+
+	var tests []zoneTest
+	for column, columnType := range tableInfo.Schema {
+		key := Column(columnType.storedName(column))
+
+		// the range an index on the column alone would scan
+		single := &Index{Columns: []string{column}, Types: []string{columnType.Type}}
+		if lo, hi, ok := single.columnBounds(conditions, 0, ectx); ok {
+			tests = append(tests, zoneTest{key: key, colType: columnType.Type, lo: lo, hi: hi})
+		}
+
+		for _, conjunct := range conditions {
+			node, _ := conjunct.(map[string]any)
+			opMap, _ := node["op"].(map[string]any)
+			operands, _ := node["operands"].([]any)
+			if len(operands) != 1 || !isColumn(operands[0], column, ectx) {
+				continue
+			}
+
+			switch opMap["kind"] {
+			case "IS_NULL":
+				tests = append(tests, zoneTest{key: key, colType: columnType.Type, isNull: true})
+			case "IS_NOT_NULL":
+				tests = append(tests, zoneTest{key: key, colType: columnType.Type})
+			}
+		}
+	}
+
+	return tests
+}
+
+// rulesOut reports whether no row the zone describes passes the test.
+func (test zoneTest) rulesOut(zone ZoneMap) bool {
+	if test.isNull {
+		return zone.Nulls == 0
+	}
+
+	if zone.Values == 0 {
+		return true
+	}
+	if zone.Min == "" {
+		return false // the range wasn't kept
+	}
+
+	if test.lo != nil {
+		cmp := compareText(zone.Max, test.lo.Key[0], test.colType)
+		if cmp < 0 || (cmp == 0 && !test.lo.Inclusive) {
+			return true
+		}
+	}
+
+	if test.hi != nil {
+		cmp := compareText(zone.Min, test.hi.Key[0], test.colType)
+		if cmp > 0 || (cmp == 0 && !test.hi.Inclusive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zonesRuleOut reports whether the zones of the page fail one of the tests.
+func (tableObj *TableObj) zonesRuleOut(pageID PageID, tests []zoneTest) bool {
+	tableObj.DirectoryPage.Mu.RLock()
+	pageObj, ok := tableObj.DirectoryPage.Value[pageID]
+	tableObj.DirectoryPage.Mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	pageObj.Mu.RLock()
+	zones := pageObj.Zones
+	pageObj.Mu.RUnlock()
+
+	for _, test := range tests {
+		if zone, ok := zones[test.key]; ok && test.rulesOut(zone) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func batchFixture() (*engines.Batch, map[string]engines.ColumnType) {
+	schema := map[string]engines.ColumnType{
+		"Username": {Type: "VARCHAR"},
+		"Age":      {Type: "INT"},
+		"City":     {Type: "VARCHAR"},
+	}
+
+	cities := []string{"Chicago", "Houston", "Los Angeles"}
+
+	var rows []*engines.RowV2
+	for i := range 30 {
+		rows = append(rows, &engines.RowV2{ID: uint64(i + 1), Values: map[string]string{
+			"Username": fmt.Sprintf("user%02d", i),
+			"Age":      fmt.Sprintf("%d", 20+i),
+			"City":     cities[i%3],
+		}})
+	}
+
+	// legacy row without an age
+	rows = append(rows, &engines.RowV2{ID: 100, Values: map[string]string{"Username": "ghost", "City": "Chicago"}})
+
+	return engines.BatchFromRows(rows, schema), schema
+}
+
+func runStage(t *testing.T, input *engines.Batch, stage func(in, out chan *engines.Batch) error) []*engines.RowV2 {
+	in := make(chan *engines.Batch, 1)
+	out := make(chan *engines.Batch, 1)
+
+	in <- input
+	close(in)
+
+	if err := stage(in, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var rows []*engines.RowV2
+	for batch := range out {
+		rows = append(rows, batch.ToRows()...)
+	}
+
+	return rows
+}
+
+func TestBatchFilter(t *testing.T) {
+	batch, schema := batchFixture()
+	ectx := engines.NewExprContext(map[string]interface{}{"$0": "Username", "$1": "Age", "$2": "City"}, schema)
+
+	call := func(kind string, operands ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"op": map[string]interface{}{"kind": kind, "name": kind}, "operands": operands}
+	}
+	ref := func(n string) map[string]interface{} { return map[string]interface{}{"name": n} }
+	lit := func(v interface{}, typ string) map[string]interface{} {
+		return map[string]interface{}{"literal": v, "type": map[string]interface{}{"type": typ}}
+	}
+	cast := func(operand interface{}) map[string]interface{} {
+		node := call("CAST", operand)
+		node["type"] = map[string]interface{}{"type": "INTEGER"}
+		return node
+	}
+
+	upper := map[string]interface{}{
+		"op":       map[string]interface{}{"kind": "OTHER_FUNCTION", "name": "UPPER"},
+		"operands": []interface{}{ref("$0")},
+	}
+
+	// (Age >= 40 AND City = 'Chicago') OR Username = 'user01' OR UPPER(Username) = 'GHOST'
+	condition := call("OR",
+		call("AND",
+			call("GREATER_THAN_OR_EQUAL", cast(ref("$1")), lit(float64(40), "INTEGER")),
+			call("EQUALS", lit("Chicago", "CHAR"), ref("$2")),
+		),
+		call("EQUALS", ref("$0"), lit("user01", "CHAR")),
+		call("EQUALS", upper, lit("GHOST", "CHAR")),
+	)
+
+	filter, err := engines.FilterStage(map[string]interface{}{"condition": condition}, ectx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filter(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := batch.ToRows()
+
+	got := map[string]bool{}
+	for _, row := range rows {
+		got[row.Values["Username"]] = true
+	}
+
+	want := []string{"user01", "user21", "user24", "user27", "ghost"}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d: %v", len(want), len(rows), got)
+	}
+
+	for _, name := range want {
+		if !got[name] {
+			t.Errorf("expected %s to match", name)
+		}
+	}
+
+	for _, row := range rows {
+		if row.Values["Username"] == "ghost" {
+			if _, ok := row.Values["Age"]; ok {
+				t.Error("null age should stay absent after the row adapter")
+			}
+		}
+	}
+}
+
+func TestBatchSortAndAggregate(t *testing.T) {
+	batch, schema := batchFixture()
+	ectx := engines.NewExprContext(map[string]interface{}{}, schema)
+
+	rows := runStage(t, batch, func(in, out chan *engines.Batch) error {
+		innerMap := map[string]interface{}{"column": "Age", "sortDirection": "DESC", "limit": "3"}
+		return engines.Sort(context.Background(), innerMap, ectx, []*engines.Batch{<-in}, out)
+	})
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+
+	for i, want := range []string{"49", "48", "47"} {
+		if rows[i].Values["Age"] != want {
+			t.Errorf("row %d: got age %s, want %s", i, rows[i].Values["Age"], want)
+		}
+	}
+
+	cases := map[string]string{"COUNT": "11", "SUM": "335", "AVG": "33", "MIN": "20", "MAX": "47"}
+	for function, want := range cases {
+		batch, _ := batchFixture()
+		innerMap := map[string]interface{}{
+			"selected_columns": []interface{}{"City", "Age"},
+			"aggregates":       map[string]interface{}{"function": function, "args": []interface{}{float64(1)}},
+		}
+
+		rows := runStage(t, batch, func(in, out chan *engines.Batch) error {
+			return engines.Aggregate(context.Background(), innerMap, "Age", []interface{}{"City", "Age"}, in, out)
+		})
+
+		if got := rows[0].Values["Chicago"]; got != want {
+			t.Errorf("%s: got %s, want %s", function, got, want)
+		}
+	}
+}
+
+func TestWorkerPoolRun(t *testing.T) {
+	pool := engines.NewWorkerPool(4)
+
+	var inFlight, peak atomic.Int32
+	task := func(morsel int) ([]*engines.Batch, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		row := &engines.RowV2{ID: uint64(morsel), Values: map[string]string{"Morsel": fmt.Sprintf("%d", morsel)}}
+		return []*engines.Batch{engines.BatchFromRows([]*engines.RowV2{row}, nil)}, nil
+	}
+
+	seen := map[uint64]bool{}
+	err := pool.Run(context.Background(), 50, task, func(b *engines.Batch) error {
+		for _, row := range b.ToRows() {
+			seen[row.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 50 {
+		t.Fatalf("expected 50 morsels, got %d", len(seen))
+	}
+
+	if peak.Load() > 4 {
+		t.Errorf("expected at most 4 morsels in flight, got %d", peak.Load())
+	}
+
+	failure := errors.New("bad page")
+	err = pool.Run(context.Background(), 50, func(morsel int) ([]*engines.Batch, error) {
+		if morsel == 7 {
+			return nil, failure
+		}
+		return nil, nil
+	}, func(*engines.Batch) error { return nil })
+
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the morsel error, got %v", err)
+	}
+}
@@ -0,0 +1,812 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"a2gdb/logger"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSequenceSurvivesRestart(t *testing.T) {
+	if logger.Log == nil {
+		logger.InitLogger()
+	}
+
+	dir := filepath.Join(t.TempDir(), "db")
+
+	manager, err := engines.NewDiskManagerV2(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.CreateSequence("order_seq", 10, 5, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.CreateSequence("order_seq", 1, 1, "", ""); err == nil {
+		t.Fatal("creating a sequence twice should fail")
+	}
+
+	for _, want := range []int64{10, 15, 20} {
+		got, err := manager.NextVal("order_seq")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+	manager.FileCatalog.Close()
+
+	// the values cached in memory are skipped, nothing handed out is reused
+	reopened, err := engines.NewDiskManagerV2(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.FileCatalog.Close()
+
+	got, err := reopened.NextVal("order_seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := int64(10 + engines.SEQUENCE_CACHE*5); got != want {
+		t.Fatalf("after restart got %d, want %d", got, want)
+	}
+
+	if _, err := reopened.NextVal("missing_seq"); err == nil {
+		t.Fatal("nextval on a missing sequence should fail")
+	}
+}
+
+func TestToastStore(t *testing.T) {
+	if logger.Log == nil {
+		logger.InitLogger()
+	}
+
+	manager, err := engines.NewDiskManagerV2(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.FileCatalog.Close()
+
+	if err := manager.CreateTable("Docs", engines.TableInfo{Schema: map[string]engines.ColumnType{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := engines.OpenToastStore(manager.DBdirectory, "Docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// compressible and incompressible values, both spanning several pages
+	repeated := strings.Repeat("lorem ipsum ", 2000)
+	random := make([]byte, 3*engines.PageSizeV2)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	var pointers []string
+	for _, value := range []string{repeated, string(random)} {
+		pointer, err := store.Write(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pointer) != engines.TOAST_POINTER_SIZE {
+			t.Fatalf("pointer of %d bytes, want %d", len(pointer), engines.TOAST_POINTER_SIZE)
+		}
+
+		got, err := store.Read(pointer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != value {
+			t.Fatalf("value of %d bytes came back as %d bytes", len(value), len(got))
+		}
+
+		pointers = append(pointers, pointer)
+	}
+
+	if err := store.Free(pointers[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Read(pointers[1]); err == nil {
+		t.Fatal("reading a freed chain should fail")
+	}
+	store.Close()
+
+	// the free pages are found again after reopening and get reused
+	reopened, err := engines.OpenToastStore(manager.DBdirectory, "Docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if _, err := reopened.Write(string(random)); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(filepath.Join(manager.DBdirectory, "Tables", "Docs", engines.TOAST_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pages := stat.Size() / engines.PageSizeV2; pages != 5 {
+		t.Fatalf("expected the freed pages to be reused, file has %d pages", pages)
+	}
+
+	if got, err := reopened.Read(pointers[0]); err != nil || got != repeated {
+		t.Fatalf("first value lost after reopening: %v", err)
+	}
+}
+
+func TestToastedInsertFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	engine := openPlanlessEngine(t, dir)
+
+	// a row of many short values can't be moved out of line
+	columns := []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Doc": "VARCHAR"}}
+	selectedCols := []any{"Id", "Doc"}
+	for i := range 200 {
+		column := fmt.Sprintf("C%03d", i)
+		columns = append(columns, map[string]any{column: "VARCHAR"})
+		selectedCols = append(selectedCols, column)
+	}
+
+	res := runPlan(engine, map[string]any{"STATEMENT": "CREATE_TABLE", "table": "Wide", "columns": columns})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	row := func(id int, doc, short string) []any {
+		values := []any{strconv.Itoa(id), "'" + doc + "'"}
+		for range 200 {
+			values = append(values, "'"+short+"'")
+		}
+		return values
+	}
+
+	doc := strings.Repeat("lorem ipsum ", 2000)
+	res = runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Wide", "selectedCols": selectedCols, "rows": []any{
+		row(1, "x", strings.Repeat("s", 20)),
+		row(2, doc, ""),
+	}})
+	if res.Error == nil {
+		t.Fatal("a row bigger than a page was inserted")
+	}
+
+	// the row never placed didn't leave its chain behind
+	stat, err := os.Stat(filepath.Join(dir, "Tables", "Wide", engines.TOAST_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 0 {
+		t.Fatalf("toast file holds %d bytes after the failed insert", stat.Size())
+	}
+}
+
+func TestDropTableFiles(t *testing.T) {
+	if logger.Log == nil {
+		logger.InitLogger()
+	}
+
+	dir := filepath.Join(t.TempDir(), "db")
+
+	manager, err := engines.NewDiskManagerV2(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := map[string]engines.ColumnType{"Id": {IsIndex: true, Type: "SERIAL"}}
+	for _, table := range []string{"Kept", "Gone"} {
+		if err := manager.CreateTable(table, engines.TableInfo{Schema: schema}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := manager.CreateSequence(table+"_Id_seq", 1, 1, table, "Id"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := manager.InMemoryTableSetUp("Gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.DropTable("Gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.DropTable("Gone"); err == nil {
+		t.Fatal("dropping a missing table should fail")
+	}
+
+	tablesPath := filepath.Join(dir, "Tables")
+	if _, err := os.Stat(filepath.Join(tablesPath, "Gone")); !os.IsNotExist(err) {
+		t.Fatalf("table files left behind: %v", err)
+	}
+
+	if _, ok := manager.PageCatalog.Sequences["Gone_Id_seq"]; ok {
+		t.Fatal("owned sequence should go with the table")
+	}
+
+	// a drop interrupted after the catalog replacement leaves the files only
+	if err := os.Mkdir(filepath.Join(tablesPath, "Orphan"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manager.FileCatalog.Close()
+
+	reopened, err := engines.NewDiskManagerV2(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.FileCatalog.Close()
+
+	if _, err := os.Stat(filepath.Join(tablesPath, "Orphan")); !os.IsNotExist(err) {
+		t.Fatalf("orphan table files not removed: %v", err)
+	}
+
+	if _, ok := reopened.PageCatalog.Tables["Gone"]; ok {
+		t.Fatal("dropped table came back after reopening")
+	}
+
+	if _, ok := reopened.PageCatalog.Tables["Kept"]; !ok {
+		t.Fatal("kept table lost after reopening")
+	}
+
+	if _, err := reopened.NextVal("Kept_Id_seq"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTruncateInterrupted leaves the files a crash on either side of the
+// catalog replacement would, the table comes back whole or empty.
+func TestTruncateInterrupted(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	engine := openPlanlessEngine(t, dir)
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := runPlan(engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Logs",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Line": "VARCHAR"}},
+	})
+
+	var rows []any
+	for id := range 50 {
+		rows = append(rows, []any{strconv.Itoa(id), fmt.Sprintf("'line %d'", id)})
+	}
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Logs", "selectedCols": []any{"Id", "Line"}, "rows": rows})
+
+	tablesPath := filepath.Join(dir, "Tables")
+	liveDir := func() string {
+		if dir := engine.BufferPoolManager.DiskManager.PageCatalog.Tables["Logs"].Dir; dir != "" {
+			return dir
+		}
+		return "Logs"
+	}
+	count := func() int {
+		t.Helper()
+		return len(selectIdsWhere(t, engine, "Logs", intIs("Id", "GREATER_THAN_OR_EQUAL", "0")))
+	}
+
+	// before the replacement, the empty files are half written
+	if err := os.Mkdir(filepath.Join(tablesPath, "Logs.9"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tablesPath, "Logs.9", "directory_page"), []byte{1, 2, 3}, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	engine = openPlanlessEngine(t, dir)
+	if n := count(); n != 50 {
+		t.Fatalf("table has %d rows after a crash before the replacement, want 50", n)
+	}
+	if _, err := os.Stat(filepath.Join(tablesPath, "Logs.9")); !os.IsNotExist(err) {
+		t.Fatalf("half written files not removed: %v", err)
+	}
+
+	// after the replacement, the old files are still there
+	oldDir := liveDir()
+	saved := filepath.Join(t.TempDir(), "saved")
+	if err := os.CopyFS(saved, os.DirFS(filepath.Join(tablesPath, oldDir))); err != nil {
+		t.Fatal(err)
+	}
+
+	run(map[string]any{"STATEMENT": "TRUNCATE", "table": "Logs"})
+	if liveDir() == oldDir {
+		t.Fatal("truncate kept the table files")
+	}
+	if err := os.CopyFS(filepath.Join(tablesPath, oldDir), os.DirFS(saved)); err != nil {
+		t.Fatal(err)
+	}
+
+	engine = openPlanlessEngine(t, dir)
+	if n := count(); n != 0 {
+		t.Fatalf("table has %d rows after a crash after the replacement, want 0", n)
+	}
+	if _, err := os.Stat(filepath.Join(tablesPath, oldDir)); !os.IsNotExist(err) {
+		t.Fatalf("old table files not removed: %v", err)
+	}
+
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Logs", "selectedCols": []any{"Id", "Line"}, "rows": rows[:5]})
+	if n := count(); n != 5 {
+		t.Fatalf("table has %d rows after inserting into it again, want 5", n)
+	}
+}
+
+func TestAlterColumns(t *testing.T) {
+	if logger.Log == nil {
+		logger.InitLogger()
+	}
+
+	dir := filepath.Join(t.TempDir(), "db")
+
+	manager, err := engines.NewDiskManagerV2(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := map[string]engines.ColumnType{
+		"Id":   {IsIndex: true, Type: "INT"},
+		"Name": {Type: "VARCHAR"},
+		"Nick": {Type: "VARCHAR"},
+	}
+	if err := manager.CreateTable("People", engines.TableInfo{Schema: schema}); err != nil {
+		t.Fatal(err)
+	}
+
+	// written before any ALTER
+	oldRow := encodeStored(t, manager.PageCatalog.Tables["People"], map[string]string{"Id": "1", "Name": "ann", "Nick": "a"})
+
+	if err := manager.AddColumn("People", "Age", "INT", "18"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.AddColumn("People", "Age", "INT", ""); err == nil {
+		t.Fatal("adding an existing column should fail")
+	}
+
+	if err := manager.RenameColumn("People", "Name", "FullName"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.DropColumn("People", "Id"); err == nil {
+		t.Fatal("dropping the primary column should fail")
+	}
+
+	if err := manager.DropColumn("People", "Nick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new column with the dropped name must not see the old values
+	if err := manager.AddColumn("People", "Nick", "VARCHAR", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.RenameTable("People", "Persons"); err != nil {
+		t.Fatal(err)
+	}
+
+	newRow := encodeStored(t, manager.PageCatalog.Tables["Persons"], map[string]string{"Id": "2", "FullName": "bob", "Nick": "b"})
+	manager.FileCatalog.Close()
+
+	reopened, err := engines.NewDiskManagerV2(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.FileCatalog.Close()
+
+	tableInfo, ok := reopened.PageCatalog.Tables["Persons"]
+	if !ok {
+		t.Fatal("renamed table lost after reopening")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "Tables", tableInfo.Dir)); tableInfo.Dir != "People" || err != nil {
+		t.Fatalf("renamed table should keep its files, dir: %q, %v", tableInfo.Dir, err)
+	}
+
+	for _, tc := range []struct {
+		encoded []byte
+		want    map[string]string
+	}{
+		{oldRow, map[string]string{"Id": "1", "FullName": "ann", "Age": "18"}},
+		{newRow, map[string]string{"Id": "2", "FullName": "bob", "Nick": "b"}}, // explicit NULL age
+	} {
+		got := decodeStored(t, tableInfo, tc.encoded)
+		if len(got) != len(tc.want) {
+			t.Fatalf("got %v, want %v", got, tc.want)
+		}
+
+		for col, val := range tc.want {
+			if got[col] != val {
+				t.Fatalf("column %s: got %q, want %q (%v)", col, got[col], val, got)
+			}
+		}
+	}
+}
+
+func encodeStored(t *testing.T, tableInfo *engines.TableInfo, values map[string]string) []byte {
+	row := engines.RowV2{ID: engines.GenerateRandomID(), Values: values}
+	if err := engines.EncodeStoredValues(&row, tableInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := engines.EncodeRow(&row, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoded
+}
+
+func decodeStored(t *testing.T, tableInfo *engines.TableInfo, encoded []byte) map[string]string {
+	var row engines.RowV2
+	if err := engines.DecodeRow(&row, bytes.NewReader(encoded)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engines.DecodeStoredValues(&row, tableInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	return row.Values
+}
+
+func TestViewsSurviveRestart(t *testing.T) {
+	engine := newPlanlessEngine(t)
+	manager := engine.BufferPoolManager.DiskManager
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Staff",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Dept": "VARCHAR"}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	createView := func(name, query, from string, columns ...any) *engines.Result {
+		return runPlan(engine, map[string]any{"STATEMENT": "CREATE_VIEW", "name": name, "query": query, "from": from, "columns": columns})
+	}
+
+	if res := createView("Eng", "SELECT Id FROM `Staff` WHERE Dept = 'eng'", "Staff", "Id"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if res := createView("EngIds", "SELECT Id AS Num FROM `Eng`", "Eng", "Num"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	for _, tc := range []struct {
+		name string
+		plan map[string]any
+	}{
+		{"view named like a table", map[string]any{"STATEMENT": "CREATE_VIEW", "name": "Staff", "query": "SELECT 1", "from": "Staff", "columns": []any{"Id"}}},
+		{"view over a missing table", map[string]any{"STATEMENT": "CREATE_VIEW", "name": "Lost", "query": "SELECT Id FROM `Gone`", "from": "Gone", "columns": []any{"Id"}}},
+		{"view reading itself", map[string]any{"STATEMENT": "CREATE_VIEW", "name": "Eng", "query": "SELECT Num FROM `EngIds`", "from": "EngIds", "columns": []any{"Num"}, "orReplace": true}},
+		{"drop a table read by a view", map[string]any{"STATEMENT": "DROP_TABLE", "table": "Staff"}},
+		{"drop a view read by a view", map[string]any{"STATEMENT": "DROP_VIEW", "name": "Eng"}},
+		{"rename a table read by a view", map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Staff", "action": "RENAME_TABLE", "newName": "People"}},
+	} {
+		if res := runPlan(engine, tc.plan); res.Error == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	manager.FileCatalog.Close()
+	reopened, err := engines.NewDiskManagerV2(manager.DBdirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.FileCatalog.Close()
+
+	view, ok := reopened.PageCatalog.Views["EngIds"]
+	if !ok || view.Query != "SELECT Id AS Num FROM `Eng`" || view.From != "Eng" || strings.Join(view.Columns, ",") != "Num" {
+		t.Fatalf("view not restored: %+v", view)
+	}
+
+	if err := reopened.DropView("EngIds"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.DropView("EngIds"); err == nil {
+		t.Fatal("dropping a missing view should fail")
+	}
+	if _, ok := reopened.PageCatalog.Views["Eng"]; !ok {
+		t.Fatal("the other view went away")
+	}
+}
+
+func TestMaterializedViewMaintenance(t *testing.T) {
+	engine := newPlanlessEngine(t)
+	engine.BufferPoolManager.Wal.OnCommit = engine.MaintainViews
+	manager := engine.BufferPoolManager.DiskManager
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Sales",
+		"columns": []any{
+			map[string]any{"Id": "SERIAL"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Region": "VARCHAR"}, map[string]any{"Amount": "INT"},
+		},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	insert := func(rows ...[]any) {
+		t.Helper()
+		var planRows []any
+		for _, row := range rows {
+			planRows = append(planRows, row)
+		}
+		if res := runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Sales", "selectedCols": []any{"Region", "Amount"}, "rows": planRows}); res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	is := func(column, literal, typ string) map[string]any {
+		return map[string]any{
+			"op":       map[string]any{"kind": "EQUALS", "name": "="},
+			"operands": []any{map[string]any{"column": column}, map[string]any{"literal": literal, "type": map[string]any{"type": typ}}},
+		}
+	}
+
+	insert([]any{"'north'", "10"}, []any{"'north'", "30"}, []any{"'south'", "5"})
+
+	amount := map[string]any{"column": "Amount"}
+	res = runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_MATERIALIZED_VIEW",
+		"name":      "RegionTotals",
+		"query":     "SELECT Region, COUNT(*) AS N, SUM(Amount) AS Total, MAX(Amount) AS Top, AVG(Amount) AS Mean FROM `Sales` GROUP BY Region",
+		"from":      "Sales",
+		"groupBy":   []any{"Region"},
+		"columns": []any{
+			map[string]any{"name": "Region", "expr": map[string]any{"column": "Region"}},
+			map[string]any{"name": "N", "function": "COUNT"},
+			map[string]any{"name": "Total", "expr": amount, "function": "SUM"},
+			map[string]any{"name": "Top", "expr": amount, "function": "MAX"},
+			map[string]any{"name": "Mean", "expr": amount, "function": "AVG"},
+		},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	res = runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_MATERIALIZED_VIEW",
+		"name":      "BigSales",
+		"query":     "SELECT Region AS Place FROM `Sales` WHERE Amount = 30",
+		"from":      "Sales",
+		"condition": is("Amount", "30", "INTEGER"),
+		"columns":   []any{map[string]any{"name": "Place", "expr": map[string]any{"column": "Region"}}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	groups := func() string {
+		t.Helper()
+		var out []string
+		for _, row := range tableRows(t, engine, "RegionTotals") {
+			out = append(out, strings.Join([]string{row["Region"], row["N"], row["Total"], row["Top"], row["Mean"]}, ":"))
+		}
+		slices.Sort(out)
+		return strings.Join(out, " ")
+	}
+
+	if got := groups(); got != "north:2:40:30:20 south:1:5:5:5" {
+		t.Fatalf("unexpected view after create: %s", got)
+	}
+
+	// incremental: an insert, an update removing the MAX and a delete emptying a group
+	insert([]any{"'south'", "8"})
+	if got := groups(); got != "north:2:40:30:20 south:2:13:8:6.5" {
+		t.Fatalf("unexpected view after insert: %s", got)
+	}
+
+	res = runPlan(engine, map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Sales",
+		"condition":   is("Amount", "30", "INTEGER"),
+		"assignments": []any{map[string]any{"column": "Amount", "expr": map[string]any{"literal": "1", "type": map[string]any{"type": "INTEGER"}}}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := groups(); got != "north:2:11:10:5.5 south:2:13:8:6.5" {
+		t.Fatalf("unexpected view after update: %s", got)
+	}
+
+	if res := runPlan(engine, map[string]any{"STATEMENT": "DELETE", "table": "Sales", "condition": is("Region", "south", "VARCHAR")}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := groups(); got != "north:2:11:10:5.5" {
+		t.Fatalf("unexpected view after delete: %s", got)
+	}
+
+	// views without aggregates only change on REFRESH
+	if rows := tableRows(t, engine, "BigSales"); len(rows) != 1 || rows[0]["Place"] != "north" {
+		t.Fatalf("unexpected BigSales: %v", rows)
+	}
+
+	if res := runPlan(engine, map[string]any{"STATEMENT": "REFRESH_MATERIALIZED_VIEW", "name": "BigSales"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if rows := tableRows(t, engine, "BigSales"); len(rows) != 0 {
+		t.Fatalf("BigSales not refreshed: %v", rows)
+	}
+
+	for _, tc := range []struct {
+		name string
+		plan map[string]any
+	}{
+		{"drop the view table", map[string]any{"STATEMENT": "DROP_TABLE", "table": "BigSales"}},
+		{"drop the source", map[string]any{"STATEMENT": "DROP_TABLE", "table": "Sales"}},
+		{"column outside of GROUP BY", map[string]any{"STATEMENT": "CREATE_MATERIALIZED_VIEW", "name": "Bad", "from": "Sales", "groupBy": []any{"Region"},
+			"columns": []any{map[string]any{"name": "Amount", "expr": amount}}}},
+		{"view over a view", map[string]any{"STATEMENT": "CREATE_MATERIALIZED_VIEW", "name": "Bad", "from": "BigSales",
+			"columns": []any{map[string]any{"name": "Place", "expr": map[string]any{"column": "Place"}}}}},
+	} {
+		if res := runPlan(engine, tc.plan); res.Error == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	if res := runPlan(engine, map[string]any{"STATEMENT": "TRUNCATE", "table": "Sales"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := groups(); got != "" {
+		t.Fatalf("view not emptied by TRUNCATE: %s", got)
+	}
+
+	if res := runPlan(engine, map[string]any{"STATEMENT": "DROP_MATERIALIZED_VIEW", "name": "BigSales"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if _, ok := manager.PageCatalog.Tables["BigSales"]; ok {
+		t.Fatal("the view table is still there")
+	}
+
+	manager.FileCatalog.Close()
+	reopened, err := engines.NewDiskManagerV2(manager.DBdirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.FileCatalog.Close()
+
+	view, ok := reopened.PageCatalog.Materialized["RegionTotals"]
+	if !ok || view.From != "Sales" || len(view.Columns) != 5 || view.Columns[2].Function != "SUM" {
+		t.Fatalf("materialized view not restored: %+v", view)
+	}
+	if _, ok := reopened.PageCatalog.Tables["RegionTotals"]; !ok {
+		t.Fatal("the view table wasn't restored")
+	}
+}
+
+func TestDatabaseCatalogs(t *testing.T) {
+	engine := newPlanlessEngine(t)
+	manager := engine.BufferPoolManager.DiskManager
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := runPlan(engine, plan); res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	create := func(table string, constraints ...any) {
+		t.Helper()
+		columns := []any{map[string]any{"Id": "SERIAL"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Item": "VARCHAR"}, map[string]any{"OrderId": "INT"}}
+		run(map[string]any{"STATEMENT": "CREATE_TABLE", "table": table, "columns": columns, "constraints": constraints})
+	}
+
+	insert := func(table string, items ...any) {
+		t.Helper()
+		var rows []any
+		for _, item := range items {
+			rows = append(rows, []any{item})
+		}
+		run(map[string]any{"STATEMENT": "INSERT", "table": table, "selectedCols": []any{"Item"}, "rows": rows})
+	}
+
+	run(map[string]any{"STATEMENT": "CREATE_DATABASE", "database": "shop"})
+	run(map[string]any{"STATEMENT": "CREATE_DATABASE", "database": "shop", "ifNotExists": true})
+
+	// the same name inside and outside of the database are two tables
+	create("shop.Orders")
+	create("Orders")
+	insert("shop.Orders", "'pen'", "'ink'")
+	insert("Orders", "'cup'")
+
+	for _, tc := range []struct {
+		name string
+		plan map[string]any
+	}{
+		{"database created twice", map[string]any{"STATEMENT": "CREATE_DATABASE", "database": "shop"}},
+		{"invalid database name", map[string]any{"STATEMENT": "CREATE_DATABASE", "database": "a/b"}},
+		{"table of a missing database", map[string]any{"STATEMENT": "CREATE_TABLE", "table": "nope.Orders", "columns": []any{map[string]any{"Id": "INT"}}}},
+		{"table moved to another database", map[string]any{"STATEMENT": "ALTER_TABLE", "table": "shop.Orders", "action": "RENAME_TABLE", "newName": "Sales"}},
+		{"use a missing database", map[string]any{"STATEMENT": "USE", "database": "nope"}},
+		{"drop a missing database", map[string]any{"STATEMENT": "DROP_DATABASE", "database": "nope"}},
+	} {
+		if res := runPlan(engine, tc.plan); res.Error == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	if rows := tableRows(t, engine, "shop.Orders"); len(rows) != 2 {
+		t.Fatalf("got %d rows in shop.Orders, want 2", len(rows))
+	}
+	if rows := tableRows(t, engine, "Orders"); len(rows) != 1 || rows[0]["Item"] != "cup" {
+		t.Fatalf("unexpected rows in Orders: %v", rows)
+	}
+
+	if _, err := os.Stat(filepath.Join(manager.DBdirectory, engines.DATABASES_DIR, "shop", "Tables", "Orders")); err != nil {
+		t.Fatalf("table files not in the database directory: %v", err)
+	}
+
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "shop.Orders", "action": "RENAME_TABLE", "newName": "shop.Sales"})
+
+	if res := runPlan(engine, map[string]any{"STATEMENT": "USE", "database": "shop"}); res.Error != nil || res.Database != "shop" {
+		t.Fatalf("USE shop: %+v", res)
+	}
+
+	res := runPlan(engine, map[string]any{"STATEMENT": "SHOW_TABLES", "database": "shop"})
+	if res.Error != nil || len(res.Rows) != 1 || res.Rows[0].Values["Table"] != "Sales" {
+		t.Fatalf("unexpected tables of shop: %+v", res)
+	}
+
+	if tables, err := manager.ListTables(""); err != nil || !slices.Equal(tables, []string{"Orders"}) {
+		t.Fatalf("unexpected tables outside of databases: %v %v", tables, err)
+	}
+
+	// every database reads back its own catalog
+	reopened, err := engines.NewDiskManagerV2(manager.DBdirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(reopened.DatabaseNames(), []string{"shop"}) {
+		t.Fatalf("databases not restored: %v", reopened.DatabaseNames())
+	}
+	if tables, _ := reopened.ListTables("shop"); !slices.Equal(tables, []string{"Sales"}) {
+		t.Fatalf("tables of shop not restored: %v", tables)
+	}
+	if _, ok := reopened.PageCatalog.Sequences["shop.Sales_Id_seq"]; !ok {
+		t.Fatal("the sequence of shop.Sales wasn't restored")
+	}
+	reopened.FileCatalog.Close()
+
+	// a table outside of the database still references it
+	create("Lines", map[string]any{"kind": "FOREIGN_KEY", "columns": []any{"OrderId"}, "refTable": "shop.Sales", "refColumns": []any{"Id"}})
+	if res := runPlan(engine, map[string]any{"STATEMENT": "DROP_DATABASE", "database": "shop"}); res.Error == nil {
+		t.Fatal("dropping a referenced database should fail")
+	}
+	run(map[string]any{"STATEMENT": "DROP_TABLE", "table": "Lines"})
+
+	run(map[string]any{"STATEMENT": "DROP_DATABASE", "database": "shop"})
+	run(map[string]any{"STATEMENT": "DROP_DATABASE", "database": "shop", "ifExists": true})
+
+	if _, err := os.Stat(filepath.Join(manager.DBdirectory, engines.DATABASES_DIR, "shop")); !os.IsNotExist(err) {
+		t.Fatalf("database directory left behind: %v", err)
+	}
+	if _, ok := manager.PageCatalog.Tables["shop.Sales"]; ok {
+		t.Fatal("the tables of the database are still in the catalog")
+	}
+	if rows := tableRows(t, engine, "Orders"); len(rows) != 1 {
+		t.Fatalf("got %d rows in Orders, want 1", len(rows))
+	}
+}
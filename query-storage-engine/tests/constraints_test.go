@@ -0,0 +1,398 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"a2gdb/logger"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newPlanlessEngine is an engine fed with hand written plans, statements
+// rejected before they log anything don't need the planner.
+func newPlanlessEngine(t *testing.T) *engines.QueryEngine {
+	return openPlanlessEngine(t, filepath.Join(t.TempDir(), "db"))
+}
+
+func openPlanlessEngine(t *testing.T, dir string) *engines.QueryEngine {
+	if logger.Log == nil {
+		logger.InitLogger()
+	}
+
+	bufferPool, err := engines.NewBufferPoolManager(2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &engines.QueryEngine{
+		Config:            &engines.QueryEngineConfig{},
+		BufferPoolManager: bufferPool,
+		Lm:                &engines.LockManager{Mu: sync.RWMutex{}, Rows: map[uint64]*engines.RowInfo{}},
+		CtxManager:        engines.NewContextManager(),
+		Pool:              engines.NewWorkerPool(1),
+	}
+}
+
+func runPlan(engine *engines.QueryEngine, plan map[string]any) *engines.Result {
+	return engine.QueryProcessingEntry(&engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: plan})
+}
+
+func TestConstraintViolations(t *testing.T) {
+	engine := newPlanlessEngine(t)
+
+	// CHECK (Age >= 0)
+	ageCheck := map[string]any{
+		"op": map[string]any{"kind": "GREATER_THAN_OR_EQUAL", "name": ">="},
+		"operands": []any{
+			map[string]any{"column": "Age"},
+			map[string]any{"literal": "0", "type": map[string]any{"type": "INTEGER"}},
+		},
+	}
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Accounts",
+		"columns": []any{
+			map[string]any{"Id": "SERIAL"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Email": "VARCHAR"}, map[string]any{"Age": "INT"}, map[string]any{"Status": "VARCHAR"},
+		},
+		"constraints": []any{
+			map[string]any{"kind": "NOT_NULL", "column": "Email"},
+			map[string]any{"kind": "DEFAULT", "column": "Status", "value": "active"},
+			map[string]any{"kind": "UNIQUE", "columns": []any{"Email"}},
+			map[string]any{"kind": "CHECK", "name": "age_positive", "expr": ageCheck},
+		},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	insert := func(cols []any, rows ...[]any) *engines.Result {
+		var planRows []any
+		for _, row := range rows {
+			planRows = append(planRows, row)
+		}
+		return runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Accounts", "selectedCols": cols, "rows": planRows})
+	}
+
+	if res := insert([]any{"Email", "Age"}, []any{"'ann@x'", "30"}, []any{"'bob@x'", "NULL"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	for _, tc := range []struct {
+		name string
+		res  *engines.Result
+		want error
+		code string
+	}{
+		{"missing not null", insert([]any{"Age"}, []any{"20"}), engines.ErrNotNullViolation, "23502"},
+		{"explicit null", insert([]any{"Email"}, []any{"NULL"}), engines.ErrNotNullViolation, "23502"},
+		{"unique in table", insert([]any{"Email"}, []any{"'ann@x'"}), engines.ErrDuplicateKey, "23505"},
+		{"unique in statement", insert([]any{"Email"}, []any{"'cy@x'"}, []any{"'cy@x'"}), engines.ErrDuplicateKey, "23505"},
+		{"check", insert([]any{"Email", "Age"}, []any{"'dee@x'", "-5"}), engines.ErrCheckViolation, "23514"},
+	} {
+		if !errors.Is(tc.res.Error, tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.name, tc.res.Error, tc.want)
+		}
+
+		if msg := engines.FormatQueryError(tc.res.Error); !strings.HasPrefix(msg, "ERROR "+tc.code+": ") {
+			t.Fatalf("%s: client message %q", tc.name, msg)
+		}
+	}
+
+	// the rejected inserts left nothing behind, the default was applied
+	rows := map[string]map[string]string{}
+	for _, values := range tableRows(t, engine, "Accounts") {
+		rows[values["Email"]] = values
+	}
+
+	if len(rows) != 2 || rows["ann@x"]["Age"] != "30" || rows["bob@x"]["Status"] != "active" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	if _, ok := rows["bob@x"]["Age"]; ok {
+		t.Fatalf("explicit NULL stored as a value: %v", rows["bob@x"])
+	}
+}
+
+func TestDeclaredTypes(t *testing.T) {
+	engine := newPlanlessEngine(t)
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Items",
+		"columns": []any{
+			map[string]any{"Id": "SERIAL"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Code": "VARCHAR(4)"}, map[string]any{"Qty": "INT"}, map[string]any{"Price": "DECIMAL(6,2)"},
+		},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	insert := func(rows ...[]any) *engines.Result {
+		var planRows []any
+		for _, row := range rows {
+			planRows = append(planRows, row)
+		}
+		return runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Items", "selectedCols": []any{"Code", "Qty", "Price"}, "rows": planRows})
+	}
+
+	for _, tc := range []struct {
+		name string
+		res  *engines.Result
+		want error
+	}{
+		{"code too long", insert([]any{"'ab'", "1", "1"}, []any{"'abcde'", "1", "1"}), engines.ErrStringTooLong},
+		{"qty out of range", insert([]any{"'ab'", "2147483648", "1"}), engines.ErrNumericOutOfRange},
+		{"qty not a number", insert([]any{"'ab'", "'many'", "1"}), engines.ErrInvalidText},
+		{"price too big", insert([]any{"'ab'", "1", "10000"}), engines.ErrNumericOutOfRange},
+	} {
+		if !errors.Is(tc.res.Error, tc.want) {
+			t.Fatalf("%s: expected %v, got: %v", tc.name, tc.want, tc.res.Error)
+		}
+	}
+
+	if res := insert([]any{"'ab'", "007", "9.5"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	res = runPlan(engine, map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Items",
+		"condition":   map[string]any{"op": map[string]any{"kind": "EQUALS", "name": "="}, "operands": []any{map[string]any{"column": "Code"}, map[string]any{"literal": "ab", "type": map[string]any{"type": "VARCHAR"}}}},
+		"assignments": []any{map[string]any{"column": "Qty", "expr": map[string]any{"literal": "3000000000", "type": map[string]any{"type": "BIGINT"}}}},
+	})
+	if !errors.Is(res.Error, engines.ErrNumericOutOfRange) {
+		t.Fatalf("expected the update to fail, got: %v", res.Error)
+	}
+
+	// the rejected statements left nothing behind, the stored values are coerced
+	rows := tableRows(t, engine, "Items")
+	if len(rows) != 1 || rows[0]["Qty"] != "7" || rows[0]["Price"] != "9.50" || rows[0]["Code"] != "ab" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+}
+
+func TestCoercedPrimaryKeys(t *testing.T) {
+	engine := newPlanlessEngine(t)
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Codes",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Name": "VARCHAR"}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	insert := func(ids ...string) *engines.Result {
+		var rows []any
+		for _, id := range ids {
+			rows = append(rows, []any{id, "'x'"})
+		}
+		return runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Codes", "selectedCols": []any{"Id", "Name"}, "rows": rows})
+	}
+
+	if res := insert("7"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// the keys are compared as INTs, against the table and within the statement
+	for _, ids := range [][]string{{"07"}, {"+7"}, {"7.0"}, {"8", "08"}} {
+		if res := insert(ids...); !errors.Is(res.Error, engines.ErrDuplicateKey) {
+			t.Fatalf("insert %v: expected %v, got: %v", ids, engines.ErrDuplicateKey, res.Error)
+		}
+	}
+
+	rows := tableRows(t, engine, "Codes")
+	if len(rows) != 1 || rows[0]["Id"] != "7" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+}
+
+func TestUpsertCoercedKeys(t *testing.T) {
+	engine := newPlanlessEngine(t)
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Stock",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Qty": "INT"}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	upsert := func(id, qty string, conflict map[string]any) *engines.Result {
+		plan := map[string]any{"STATEMENT": "INSERT", "table": "Stock", "selectedCols": []any{"Id", "Qty"}, "rows": []any{[]any{id, qty}}}
+		if conflict != nil {
+			plan["onConflict"] = conflict
+		}
+		return runPlan(engine, plan)
+	}
+
+	if res := upsert("7", "1", nil); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// Qty = Qty + EXCLUDED.Qty
+	addQty := map[string]any{"column": "Id", "action": "UPDATE", "assignments": []any{map[string]any{"column": "Qty", "expr": map[string]any{
+		"op":       map[string]any{"kind": "PLUS", "name": "+"},
+		"operands": []any{map[string]any{"column": "Qty"}, map[string]any{"qualifier": "EXCLUDED", "column": "Qty"}},
+	}}}}
+
+	// the keys conflict once coerced to INT
+	if res := upsert("007", "5", addQty); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if res := upsert("7.0", "9", map[string]any{"column": "Id", "action": "NOTHING"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	rows := tableRows(t, engine, "Stock")
+	if len(rows) != 1 || rows[0]["Id"] != "7" || rows[0]["Qty"] != "6" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	engine := newPlanlessEngine(t)
+
+	idIs := func(id string) map[string]any {
+		return map[string]any{
+			"op":       map[string]any{"kind": "EQUALS", "name": "="},
+			"operands": []any{map[string]any{"column": "Id"}, map[string]any{"literal": id, "type": map[string]any{"type": "INTEGER"}}},
+		}
+	}
+
+	create := func(table string, columns []any, constraints ...any) {
+		t.Helper()
+		columns = append([]any{map[string]any{"Id": "SERIAL"}, map[string]any{"Id": "PRIMARY"}}, columns...)
+		if res := runPlan(engine, map[string]any{"STATEMENT": "CREATE_TABLE", "table": table, "columns": columns, "constraints": constraints}); res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	insert := func(table, column string, values ...any) *engines.Result {
+		var rows []any
+		for _, value := range values {
+			rows = append(rows, []any{value})
+		}
+		return runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": table, "selectedCols": []any{column}, "rows": rows})
+	}
+
+	create("Users", []any{map[string]any{"Email": "VARCHAR"}},
+		map[string]any{"kind": "UNIQUE", "columns": []any{"Email"}})
+	create("Orders", []any{map[string]any{"UserId": "INT"}},
+		map[string]any{"kind": "FOREIGN_KEY", "columns": []any{"UserId"}, "refTable": "Users", "onDelete": "CASCADE"})
+	create("Reviews", []any{map[string]any{"UserEmail": "VARCHAR"}},
+		map[string]any{"kind": "FOREIGN_KEY", "columns": []any{"UserEmail"}, "refTable": "Users", "refColumns": []any{"Email"}, "onDelete": "SET_NULL", "onUpdate": "CASCADE"})
+	create("Notes", []any{map[string]any{"UserId": "INT"}},
+		map[string]any{"kind": "FOREIGN_KEY", "columns": []any{"UserId"}, "refTable": "Users"})
+
+	for _, res := range []*engines.Result{
+		insert("Users", "Email", "'a@x'", "'b@x'", "'c@x'"),
+		insert("Orders", "UserId", "1", "1", "2", "NULL"),
+		insert("Reviews", "UserEmail", "'a@x'", "'b@x'"),
+		insert("Notes", "UserId", "3"),
+	} {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	res := insert("Orders", "UserId", "9")
+	if !errors.Is(res.Error, engines.ErrForeignKeyViolation) || engines.SQLState(res.Error) != "23503" {
+		t.Fatalf("expected a foreign key violation, got: %v", res.Error)
+	}
+
+	// a parent referenced by another table can't go
+	if res := runPlan(engine, map[string]any{"STATEMENT": "DROP_TABLE", "table": "Users"}); res.Error == nil {
+		t.Fatal("dropping a referenced table should fail")
+	}
+
+	res = runPlan(engine, map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Users",
+		"condition":   idIs("1"),
+		"assignments": []any{map[string]any{"column": "Email", "expr": map[string]any{"literal": "a2@x", "type": map[string]any{"type": "VARCHAR"}}}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	res = runPlan(engine, map[string]any{"STATEMENT": "DELETE", "table": "Users", "condition": idIs("1")})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	res = runPlan(engine, map[string]any{"STATEMENT": "DELETE", "table": "Users", "condition": idIs("3")})
+	if !errors.Is(res.Error, engines.ErrForeignKeyViolation) {
+		t.Fatalf("expected RESTRICT to fail the delete, got: %v", res.Error)
+	}
+
+	column := func(table, name string) []string {
+		var values []string
+		for _, row := range tableRows(t, engine, table) {
+			values = append(values, row[name])
+		}
+		slices.Sort(values)
+		return values
+	}
+
+	for _, check := range []struct {
+		table, column string
+		want          []string
+	}{
+		{"Users", "Email", []string{"b@x", "c@x"}},
+		{"Orders", "UserId", []string{"", "2"}},       // CASCADE
+		{"Reviews", "UserEmail", []string{"", "b@x"}}, // ON UPDATE CASCADE then SET NULL
+		{"Notes", "UserId", []string{"3"}},
+	} {
+		if got := column(check.table, check.column); !slices.Equal(got, check.want) {
+			t.Fatalf("%s.%s: got %q, want %q", check.table, check.column, got, check.want)
+		}
+	}
+}
+
+// tableRows decodes the rows written to the table file.
+func tableRows(t *testing.T, engine *engines.QueryEngine, tableName string) []map[string]string {
+	t.Helper()
+
+	manager := engine.BufferPoolManager.DiskManager
+	tableObj, err := engines.GetTableObj(tableName, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tablePages, err := engines.GetTablePagesFromDiskTest(tableObj.DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []map[string]string
+	for _, page := range tablePages {
+		pageObj := tableObj.DirectoryPage.Value[engines.PageID(page.Header.ID)]
+		for _, location := range pageObj.PointerArray {
+			if location.Free {
+				continue
+			}
+
+			var row engines.RowV2
+			if err := engines.DecodeRow(&row, bytes.NewReader(page.Data[location.Offset:location.Offset+location.Length])); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := engines.DecodeStoredValues(&row, manager.PageCatalog.Tables[tableName]); err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, row.Values)
+		}
+	}
+
+	return rows
+}
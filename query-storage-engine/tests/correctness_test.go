@@ -4,10 +4,12 @@ import (
 	"a2gdb/cmd"
 	"a2gdb/engines"
 	"a2gdb/utils"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -345,7 +347,7 @@ func UndoInsert(t *testing.T) {
 func UndoUpdate(t *testing.T) {
 	id := getId(t)
 
-	sql := fmt.Sprintf("UPDATE `User` SET Age = 121209  WHERE UserId = CAST('%d' AS DECIMAL(20,0))\n", id)
+	sql := fmt.Sprintf("UPDATE `User` SET Age = 121209, City = 'Nowhere' WHERE UserId = CAST('%d' AS DECIMAL(20,0))\n", id)
 	causeError(t, sql)
 
 	sql = fmt.Sprintf("SELECT * FROM `User` WHERE UserId = CAST('%d' AS DECIMAL(20,0))\n", id)
@@ -359,6 +361,10 @@ func UndoUpdate(t *testing.T) {
 	if age == "121209" {
 		t.Fatalf("Undo update failed, wrong age")
 	}
+
+	if rows[0].Values["City"] == "Nowhere" {
+		t.Fatalf("Undo update failed, wrong city")
+	}
 }
 
 func UndoDelete(t *testing.T) {
@@ -374,3 +380,557 @@ func UndoDelete(t *testing.T) {
 		t.Fatalf("Undo Delete failed, wrong number of tuples")
 	}
 }
+
+func TestDeleteRange(t *testing.T) {
+	execQuery(t, "DELETE FROM `User` WHERE Age >= 500 AND City = 'Los Angeles'\n")
+
+	rows := IsUserPresent(t, "SELECT * FROM `User` WHERE CAST(Age AS INTEGER) >= 500\n")
+	if len(rows) != 0 {
+		t.Fatalf("expected no rows with age >= 500, got %d", len(rows))
+	}
+
+	rows = IsUserPresent(t, "SELECT * FROM `User` WHERE CAST(Age AS INTEGER) < 500\n")
+	if len(rows) == 0 {
+		t.Fatal("rows outside of the range were deleted")
+	}
+}
+
+func TestInsertSelect(t *testing.T) {
+	source := IsUserPresent(t, "SELECT * FROM `User` WHERE CAST(Age AS INTEGER) < 10\n")
+	if len(source) == 0 {
+		t.Fatal("no source rows")
+	}
+
+	execQuery(t, "INSERT INTO `User` (Username, Age, City) SELECT Username, Age, 'Copied' FROM `User` WHERE CAST(Age AS INTEGER) < 10\n")
+
+	copied := IsUserPresent(t, "SELECT * FROM `User` WHERE City = 'Copied'\n")
+	if len(copied) != len(source) {
+		t.Fatalf("expected %d copied rows, got %d", len(source), len(copied))
+	}
+
+	for _, row := range copied {
+		if row.Values["Username"] != checkVal {
+			t.Fatalf("wrong username copied: %+v", row)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	execQuery(t, "INSERT INTO `User` (Username, Age, City) VALUES ('Upserted', 1, 'Boston') ON CONFLICT (Username) DO NOTHING\n")
+	execQuery(t, "INSERT INTO `User` (Username, Age, City) VALUES ('Upserted', 5, 'Denver') ON CONFLICT (Username) DO NOTHING\n")
+
+	rows := IsUserPresent(t, "SELECT * FROM `User` WHERE Username = 'Upserted'\n")
+	if len(rows) != 1 || rows[0].Values["City"] != "Boston" {
+		t.Fatalf("DO NOTHING changed the row: %+v", rows)
+	}
+
+	execQuery(t, "INSERT INTO `User` (Username, Age, City) VALUES ('Upserted', 5, 'Denver') ON CONFLICT (Username) DO UPDATE SET Age = Age + EXCLUDED.Age, City = EXCLUDED.City\n")
+
+	rows = IsUserPresent(t, "SELECT * FROM `User` WHERE Username = 'Upserted'\n")
+	if len(rows) != 1 {
+		t.Fatalf("expected a single row, got %d", len(rows))
+	}
+
+	if rows[0].Values["Age"] != "6" || rows[0].Values["City"] != "Denver" {
+		t.Fatalf("DO UPDATE wasn't applied: %+v", rows[0])
+	}
+
+	causeError(t, "INSERT INTO `User` (Username, Age, City) VALUES ('Upserted', 1, 'Paris'), ('Upserted2', 1, 'Paris') ON CONFLICT (Username) DO UPDATE SET City = EXCLUDED.City\n")
+
+	rows = IsUserPresent(t, "SELECT * FROM `User` WHERE City = 'Paris'\n")
+	if len(rows) != 0 {
+		t.Fatalf("rollback left %d rows behind", len(rows))
+	}
+}
+
+func TestReturning(t *testing.T) {
+	res := execQuery(t, "INSERT INTO `User` (Username, Age, City) VALUES ('Returned', 40, 'Austin'), ('Returned2', 41, 'Austin') RETURNING UserId, Age\n")
+	if len(res.Rows) != 2 {
+		t.Fatalf("expected 2 inserted rows, got %d", len(res.Rows))
+	}
+
+	for _, row := range res.Rows {
+		if row.Values["UserId"] == "" || row.Values["Age"] == "" {
+			t.Fatalf("missing RETURNING columns: %+v", row.Values)
+		}
+
+		if _, ok := row.Values["City"]; ok || len(row.Values) != 2 {
+			t.Fatalf("RETURNING leaked columns: %+v", row.Values)
+		}
+	}
+
+	res = execQuery(t, "UPDATE `User` SET Age = Age + 1 WHERE City = 'Austin' RETURNING *\n")
+	if len(res.Rows) != 2 {
+		t.Fatalf("expected 2 updated rows, got %d", len(res.Rows))
+	}
+
+	for _, row := range res.Rows {
+		if row.Values["Age"] != "41" && row.Values["Age"] != "42" {
+			t.Fatalf("RETURNING should report the new values: %+v", row.Values)
+		}
+
+		if row.Values["City"] != "Austin" {
+			t.Fatalf("RETURNING * should report every column: %+v", row.Values)
+		}
+	}
+
+	res = execQuery(t, "DELETE FROM `User` WHERE City = 'Austin' RETURNING Username\n")
+	if len(res.Rows) != 2 {
+		t.Fatalf("expected 2 deleted rows, got %d", len(res.Rows))
+	}
+
+	names := map[string]bool{}
+	for _, row := range res.Rows {
+		names[row.Values["Username"]] = true
+	}
+
+	if !names["Returned"] || !names["Returned2"] {
+		t.Fatalf("unexpected deleted rows: %v", names)
+	}
+}
+
+func TestExplicitKeys(t *testing.T) {
+	execQuery(t, "INSERT INTO `User` (UserId, Username, Age, City) VALUES ('424242', 'Keyed', 30, 'Reno')\n")
+
+	rows := IsUserPresent(t, "SELECT * FROM `User` WHERE UserId = 424242\n")
+	if len(rows) != 1 || rows[0].Values["Username"] != "Keyed" {
+		t.Fatalf("row with an explicit key not found: %+v", rows)
+	}
+
+	res := sharedDB.QueryProcessingEntry(planFor(t, "INSERT INTO `User` (UserId, Username, Age, City) VALUES ('424242', 'Again', 31, 'Reno')\n"))
+	if !errors.Is(res.Error, engines.ErrDuplicateKey) {
+		t.Fatalf("expected a duplicate key error, got: %v", res.Error)
+	}
+
+	res = sharedDB.QueryProcessingEntry(planFor(t, "INSERT INTO `User` (UserId, Username, Age, City) VALUES ('1', 'One', 1, 'Reno'), ('1', 'Uno', 1, 'Reno')\n"))
+	if !errors.Is(res.Error, engines.ErrDuplicateKey) {
+		t.Fatalf("expected a duplicate key error within the statement, got: %v", res.Error)
+	}
+}
+
+func TestSerialKeys(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Orders`(Id SERIAL, Item VARCHAR, PRIMARY KEY(Id))\n")
+	execQuery(t, "CREATE SEQUENCE invoice_seq START WITH 100 INCREMENT BY 10\n")
+
+	res := execQuery(t, "INSERT INTO `Orders` (Item) VALUES ('pen'), ('ink') RETURNING Id\n")
+	if len(res.Rows) != 2 || res.Rows[0].Values["Id"] != "1" || res.Rows[1].Values["Id"] != "2" {
+		t.Fatalf("expected ids 1 and 2, got: %+v", res.Rows)
+	}
+
+	// explicit values push the sequence past them
+	execQuery(t, "INSERT INTO `Orders` (Id, Item) VALUES (10, 'pad')\n")
+	res = execQuery(t, "INSERT INTO `Orders` (Item) VALUES ('cap') RETURNING Id\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Id"] != "11" {
+		t.Fatalf("expected id 11, got: %+v", res.Rows)
+	}
+
+	res = execQuery(t, "INSERT INTO `Orders` (Id, Item) VALUES (nextval('invoice_seq'), 'nib'), (nextval('invoice_seq'), 'box') RETURNING Id\n")
+	if len(res.Rows) != 2 || res.Rows[0].Values["Id"] != "100" || res.Rows[1].Values["Id"] != "110" {
+		t.Fatalf("expected ids 100 and 110, got: %+v", res.Rows)
+	}
+}
+
+func TestLargeBatch(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Notes`(Id SERIAL, Body VARCHAR, PRIMARY KEY(Id))\n")
+
+	// ~200 bytes a row, far past one page and the old 64KB batch limit
+	body := strings.Repeat("x", 200)
+	values := make([]string, 500)
+	for i := range values {
+		values[i] = fmt.Sprintf("('%s')", body)
+	}
+	sql := "INSERT INTO `Notes` (Body) VALUES " + strings.Join(values, ", ") + "\n"
+
+	causeError(t, sql)
+	rows := IsUserPresent(t, "SELECT * FROM `Notes`\n")
+	if len(rows) != 0 {
+		t.Fatalf("expected the failed batch to be undone, got %d rows", len(rows))
+	}
+
+	execQuery(t, sql)
+	rows = IsUserPresent(t, "SELECT * FROM `Notes`\n")
+	if len(rows) != len(values) {
+		t.Fatalf("expected %d rows, got %d", len(values), len(rows))
+	}
+}
+
+func TestOverflowValues(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Docs`(Id SERIAL, Title VARCHAR, Body VARCHAR, PRIMARY KEY(Id))\n")
+
+	body := strings.Repeat("overflow ", 1500) // ~13KB, bigger than a page
+	execQuery(t, fmt.Sprintf("INSERT INTO `Docs` (Title, Body) VALUES ('a', '%s'), ('b', '%s')\n", body, body))
+
+	rows := IsUserPresent(t, "SELECT * FROM `Docs` WHERE Title = 'a'\n")
+	if len(rows) != 1 || rows[0].Values["Body"] != body {
+		t.Fatalf("expected the large value back, got %d rows", len(rows))
+	}
+
+	updated := strings.Repeat("rewritten ", 1000)
+	execQuery(t, fmt.Sprintf("UPDATE `Docs` SET Body = '%s' WHERE Title = 'a'\n", updated))
+
+	// the failed update is undone from the logged image, not the freed chains
+	causeError(t, "UPDATE `Docs` SET Body = 'short' WHERE Title = 'a'\n")
+	causeError(t, "DELETE FROM `Docs` WHERE Title = 'b'\n")
+
+	rows = IsUserPresent(t, "SELECT * FROM `Docs`\n")
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	for _, row := range rows {
+		want := body
+		if row.Values["Title"] == "a" {
+			want = updated
+		}
+
+		if row.Values["Body"] != want {
+			t.Errorf("row %s: body of %d bytes, want %d", row.Values["Title"], len(row.Values["Body"]), len(want))
+		}
+	}
+
+	execQuery(t, "DELETE FROM `Docs` WHERE Title = 'b'\n")
+	rows = IsUserPresent(t, "SELECT * FROM `Docs`\n")
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+}
+
+func TestDropTable(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Scratch`(Id SERIAL, Note VARCHAR, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Scratch` (Note) VALUES ('a'), ('b')\n")
+
+	execQuery(t, "DROP TABLE `Scratch`\n")
+	if _, ok := sharedDB.BufferPoolManager.DiskManager.PageCatalog.Tables["Scratch"]; ok {
+		t.Fatal("table still in the catalog")
+	}
+
+	if res := sharedDB.QueryProcessingEntry(planFor(t, "DROP TABLE `Scratch`\n")); res.Error == nil {
+		t.Fatal("dropping a missing table should fail")
+	}
+	execQuery(t, "DROP TABLE IF EXISTS `Scratch`\n")
+
+	// the name is free again, with an empty table and a fresh sequence
+	execQuery(t, "CREATE TABLE `Scratch`(Id SERIAL, Note VARCHAR, PRIMARY KEY(Id))\n")
+	res := execQuery(t, "INSERT INTO `Scratch` (Note) VALUES ('c') RETURNING Id\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Id"] != "1" {
+		t.Fatalf("expected id 1, got: %+v", res.Rows)
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Pets`(Id SERIAL, Name VARCHAR, Kind VARCHAR, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Pets` (Name, Kind) VALUES ('rex', 'dog'), ('tom', 'cat')\n")
+
+	execQuery(t, "ALTER TABLE `Pets` ADD COLUMN Age INT DEFAULT 1\n")
+	execQuery(t, "ALTER TABLE `Pets` RENAME COLUMN Name TO Nickname\n")
+	execQuery(t, "ALTER TABLE `Pets` DROP COLUMN Kind\n")
+	execQuery(t, "ALTER TABLE `Pets` DROP COLUMN IF EXISTS Kind\n")
+	execQuery(t, "INSERT INTO `Pets` (Nickname) VALUES ('kit')\n")
+
+	check := func(table string) {
+		t.Helper()
+
+		res := execQuery(t, "SELECT * FROM `"+table+"` ORDER BY Id\n")
+		want := []string{"rex", "tom", "kit"}
+		if len(res.Rows) != len(want) {
+			t.Fatalf("expected %d rows, got: %+v", len(want), res.Rows)
+		}
+
+		for i, row := range res.Rows {
+			if row.Values["Nickname"] != want[i] || row.Values["Age"] != "1" {
+				t.Fatalf("row %d: %+v", i, row.Values)
+			}
+
+			if _, ok := row.Values["Kind"]; ok {
+				t.Fatalf("dropped column still visible: %+v", row.Values)
+			}
+		}
+	}
+	check("Pets")
+
+	execQuery(t, "ALTER TABLE `Pets` RENAME TO `Animals`\n")
+	check("Animals")
+
+	// the rewrite drops the old layout and keeps the rows
+	execQuery(t, "ALTER TABLE `Animals` REWRITE\n")
+	check("Animals")
+
+	tableInfo := sharedDB.BufferPoolManager.DiskManager.PageCatalog.Tables["Animals"]
+	if len(tableInfo.Dropped) != 0 || tableInfo.Schema["Nickname"].Stored != "" {
+		t.Fatalf("rewrite kept the old layout: %+v", tableInfo)
+	}
+
+	if res := sharedDB.QueryProcessingEntry(planFor(t, "ALTER TABLE `Animals` DROP COLUMN Id\n")); res.Error == nil {
+		t.Fatal("dropping the primary column should fail")
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Members`(Id SERIAL, Email VARCHAR NOT NULL, Age INT, Status VARCHAR DEFAULT 'active', PRIMARY KEY(Id), UNIQUE(Email), CHECK (Age >= 0))\n")
+	execQuery(t, "INSERT INTO `Members` (Email, Age) VALUES ('ann@x', 30), ('bob@x', NULL)\n")
+
+	for sql, want := range map[string]error{
+		"INSERT INTO `Members` (Age) VALUES (20)\n":                 engines.ErrNotNullViolation,
+		"INSERT INTO `Members` (Email) VALUES ('ann@x')\n":          engines.ErrDuplicateKey,
+		"INSERT INTO `Members` (Email, Age) VALUES ('cy@x', -1)\n":  engines.ErrCheckViolation,
+		"UPDATE `Members` SET Age = -1\n":                           engines.ErrCheckViolation,
+		"UPDATE `Members` SET Email = 'ann@x'\n":                    engines.ErrDuplicateKey,
+		"UPDATE `Members` SET Email = NULL WHERE Email = 'bob@x'\n": engines.ErrNotNullViolation,
+	} {
+		if res := sharedDB.QueryProcessingEntry(planFor(t, sql)); !errors.Is(res.Error, want) {
+			t.Fatalf("%s: expected %v, got: %v", sql, want, res.Error)
+		}
+	}
+
+	// the failed statements were rolled back
+	res := execQuery(t, "SELECT * FROM `Members` ORDER BY Id\n")
+	if len(res.Rows) != 2 || res.Rows[0].Values["Age"] != "30" || res.Rows[1].Values["Status"] != "active" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+}
+
+func TestForeignKeyActions(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Parents`(Id SERIAL, Name VARCHAR, PRIMARY KEY(Id))\n")
+	execQuery(t, "CREATE TABLE `Kids`(Id SERIAL, ParentId INT, PRIMARY KEY(Id), FOREIGN KEY (ParentId) REFERENCES `Parents`(Id) ON DELETE CASCADE)\n")
+	execQuery(t, "INSERT INTO `Parents` (Name) VALUES ('ann'), ('bob')\n")
+	execQuery(t, "INSERT INTO `Kids` (ParentId) VALUES (1), (1), (2)\n")
+
+	kids := func() int {
+		return len(execQuery(t, "SELECT * FROM `Kids`\n").Rows)
+	}
+
+	// the abort undoes the cascade along with the delete
+	queryInfo := planFor(t, "DELETE FROM `Parents` WHERE Id = 1\n")
+	queryInfo.InduceErr = true
+	if res := sharedDB.QueryProcessingEntry(queryInfo); res.Error == nil {
+		t.Fatal("expected the induced error")
+	}
+
+	if n := kids(); n != 3 {
+		t.Fatalf("expected the cascaded rows back, got %d rows", n)
+	}
+
+	execQuery(t, "DELETE FROM `Parents` WHERE Id = 1\n")
+	if n := kids(); n != 1 {
+		t.Fatalf("expected the cascade to leave 1 row, got %d", n)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO `Kids` (ParentId) VALUES (7)\n",
+		"UPDATE `Kids` SET ParentId = 7\n",
+	} {
+		if res := sharedDB.QueryProcessingEntry(planFor(t, sql)); !errors.Is(res.Error, engines.ErrForeignKeyViolation) {
+			t.Fatalf("%s: expected a foreign key violation, got: %v", sql, res.Error)
+		}
+	}
+
+	res := execQuery(t, "SELECT * FROM `Kids`\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["ParentId"] != "2" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+}
+
+func TestViews(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Staff`(Id SERIAL, Name VARCHAR, Dept VARCHAR, Salary INT, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Staff` (Name, Dept, Salary) VALUES ('ann', 'eng', 100), ('bob', 'eng', 80), ('cy', 'ops', 90)\n")
+	execQuery(t, "CREATE VIEW `Engineers` AS SELECT Name AS Who, Salary FROM `Staff` WHERE Dept = 'eng'\n")
+	execQuery(t, "CREATE VIEW `WellPaid` AS SELECT * FROM `Engineers` WHERE Salary > 90\n")
+
+	res := execQuery(t, "SELECT Who FROM `Engineers` WHERE Salary < 100 ORDER BY Who\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Who"] != "bob" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	res = execQuery(t, "SELECT * FROM `WellPaid`\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Who"] != "ann" || res.Rows[0].Values["Salary"] != "100" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	for _, sql := range []string{
+		"DROP TABLE `Staff`\n",      // read by Engineers
+		"DROP VIEW `Engineers`\n",   // read by WellPaid
+		"DELETE FROM `Engineers`\n", // views are read only
+		"CREATE TABLE `WellPaid`(Id INT, PRIMARY KEY(Id))\n",
+	} {
+		if res := sharedDB.QueryProcessingEntry(planFor(t, sql)); res.Error == nil {
+			t.Fatalf("%s: expected an error", sql)
+		}
+	}
+
+	execQuery(t, "DROP VIEW `WellPaid`\n")
+	execQuery(t, "DROP VIEW `Engineers`\n")
+	execQuery(t, "DROP TABLE `Staff`\n")
+}
+
+func TestMaterializedViews(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Orders`(Id SERIAL, Region VARCHAR, Amount INT, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Orders` (Region, Amount) VALUES ('north', 10), ('north', 30), ('south', 5)\n")
+	execQuery(t, "CREATE MATERIALIZED VIEW `RegionSales` AS SELECT Region, COUNT(*) AS N, SUM(Amount) AS Total FROM `Orders` GROUP BY Region\n")
+
+	totals := func() map[string]string {
+		t.Helper()
+		out := map[string]string{}
+		for _, row := range execQuery(t, "SELECT Region, N, Total FROM `RegionSales`\n").Rows {
+			out[row.Values["Region"]] = row.Values["N"] + ":" + row.Values["Total"]
+		}
+		return out
+	}
+
+	if got := totals(); got["north"] != "2:40" || got["south"] != "1:5" {
+		t.Fatalf("unexpected view: %v", got)
+	}
+
+	// kept current by the commits on Orders
+	execQuery(t, "INSERT INTO `Orders` (Region, Amount) VALUES ('south', 7)\n")
+	execQuery(t, "DELETE FROM `Orders` WHERE Amount = 30\n")
+	if got := totals(); got["north"] != "1:10" || got["south"] != "2:12" {
+		t.Fatalf("unexpected view after changes: %v", got)
+	}
+
+	for _, sql := range []string{
+		"DROP TABLE `Orders`\n",       // read by RegionSales
+		"DELETE FROM `RegionSales`\n", // only changed by REFRESH
+		"DROP TABLE `RegionSales`\n",  // dropped as a view
+		"CREATE MATERIALIZED VIEW `Bad` AS SELECT Region, Amount FROM `Orders` GROUP BY Region\n",
+	} {
+		if res := sharedDB.QueryProcessingEntry(planFor(t, sql)); res.Error == nil {
+			t.Fatalf("%s: expected an error", sql)
+		}
+	}
+
+	execQuery(t, "REFRESH MATERIALIZED VIEW `RegionSales`\n")
+	if got := totals(); got["north"] != "1:10" || got["south"] != "2:12" {
+		t.Fatalf("unexpected view after REFRESH: %v", got)
+	}
+
+	execQuery(t, "DROP MATERIALIZED VIEW `RegionSales`\n")
+	execQuery(t, "DROP TABLE `Orders`\n")
+}
+
+func TestColumnTypeLimits(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Products`(Id SERIAL, Code VARCHAR(4), Qty INT, Price DECIMAL(6,2), PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Products` (Code, Qty, Price) VALUES ('ab', 7, 9.5)\n")
+
+	for _, sql := range []string{
+		"INSERT INTO `Products` (Code, Qty, Price) VALUES ('cd', 1, 1), ('toolong', 1, 1)\n",
+		"INSERT INTO `Products` (Code, Qty, Price) VALUES ('cd', 2147483648, 1)\n",
+		"UPDATE `Products` SET Price = 10000 WHERE Code = 'ab'\n",
+	} {
+		if res := sharedDB.QueryProcessingEntry(planFor(t, sql)); res.Error == nil {
+			t.Fatalf("%s: expected an error", sql)
+		}
+	}
+
+	res := execQuery(t, "SELECT Code, Price FROM `Products`\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Price"] != "9.50" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	execQuery(t, "DROP TABLE `Products`\n")
+}
+
+func TestDatabases(t *testing.T) {
+	execQuery(t, "CREATE DATABASE `tenant`\n")
+	execQuery(t, "CREATE TABLE `tenant`.`Notes`(Id SERIAL, Body VARCHAR, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `tenant`.`Notes` (Body) VALUES ('a'), ('b')\n")
+
+	// after USE the bare name is the table of the database
+	execQuery(t, "USE `tenant`; INSERT INTO `Notes` (Body) VALUES ('c')\n")
+	res := execQuery(t, "USE `tenant`; SELECT Body FROM `Notes`\n")
+	if len(res.Rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(res.Rows))
+	}
+
+	res = execQuery(t, "SELECT Body FROM `tenant`.`Notes` WHERE Body = 'c'\n")
+	if len(res.Rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(res.Rows))
+	}
+
+	res = execQuery(t, "SHOW TABLES FROM `tenant`\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Table"] != "Notes" {
+		t.Fatalf("unexpected tables: %+v", res.Rows)
+	}
+
+	if res := sharedDB.QueryProcessingEntry(planFor(t, "USE `missing`\n")); res.Error == nil {
+		t.Fatal("USE of a missing database should fail")
+	}
+
+	execQuery(t, "DROP DATABASE `tenant`\n")
+	if res := sharedDB.QueryProcessingEntry(planFor(t, "SELECT Body FROM `tenant`.`Notes`\n")); res.Error == nil {
+		t.Fatal("the tables of a dropped database should be gone")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Members`(Id SERIAL, Username VARCHAR, City VARCHAR, Age INT, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Members` (Username, City, Age) VALUES ('ann', 'Oslo', 31), ('bob', 'Oslo', 25), ('cy', 'Lima', 31)\n")
+	execQuery(t, "CREATE INDEX `ByCityAge` ON `Members` (City, Age)\n")
+	execQuery(t, "CREATE UNIQUE INDEX `ByUsername` ON `Members` (Username)\n")
+
+	res := execQuery(t, "SELECT Username FROM `Members` WHERE City = 'Oslo' AND Age > 30\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Username"] != "ann" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO `Members` (Username, City, Age) VALUES ('bob', 'Lima', 40)\n",
+		"CREATE UNIQUE INDEX `ByCity` ON `Members` (City)\n",
+		"CREATE INDEX `ByCityAge` ON `Members` (Age)\n",
+	} {
+		if res := sharedDB.QueryProcessingEntry(planFor(t, sql)); res.Error == nil {
+			t.Fatalf("%s: expected an error", sql)
+		}
+	}
+
+	execQuery(t, "DROP INDEX `ByCityAge`\n")
+	execQuery(t, "DROP INDEX IF EXISTS `ByCityAge`\n")
+	execQuery(t, "DROP TABLE `Members`\n")
+}
+
+func TestBloomFilters(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Shipments`(Id SERIAL, Tracking VARCHAR, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Shipments` (Tracking) VALUES ('tr-1'), ('tr-2'), ('tr-3')\n")
+	execQuery(t, "ALTER TABLE `Shipments` ADD BLOOM FILTER (Tracking)\n")
+
+	res := execQuery(t, "SELECT Id FROM `Shipments` WHERE Tracking = 'tr-2'\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Id"] != "2" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	execQuery(t, "ALTER TABLE `Shipments` DROP BLOOM FILTER (Tracking)\n")
+	execQuery(t, "DROP TABLE `Shipments`\n")
+}
+
+func TestZoneMapRanges(t *testing.T) {
+	execQuery(t, "CREATE TABLE `Ticks`(Id SERIAL, Seq INT, PRIMARY KEY(Id))\n")
+	execQuery(t, "INSERT INTO `Ticks` (Seq) VALUES (10), (20), (30), (NULL)\n")
+
+	res := execQuery(t, "SELECT Id FROM `Ticks` WHERE Seq BETWEEN 15 AND 25\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Id"] != "2" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	res = execQuery(t, "SELECT Id FROM `Ticks` WHERE Seq IS NULL\n")
+	if len(res.Rows) != 1 || res.Rows[0].Values["Id"] != "4" {
+		t.Fatalf("unexpected rows: %+v", res.Rows)
+	}
+
+	execQuery(t, "DROP TABLE `Ticks`\n")
+}
+
+func TestTruncate(t *testing.T) {
+	execQuery(t, "TRUNCATE TABLE `User`\n")
+
+	rows := IsUserPresent(t, "SELECT * FROM `User`\n")
+	if len(rows) != 0 {
+		t.Fatalf("expected an empty table, got %d rows", len(rows))
+	}
+
+	tableInfo := sharedDB.BufferPoolManager.DiskManager.PageCatalog.Tables[tableName]
+	if tableInfo.NumOfPages != 0 || tableInfo.UsedSpace != 0 {
+		t.Fatalf("catalog not reset: %+v", tableInfo)
+	}
+
+	insertMany(t, 10)
+	checkTupleNumber(t, 10)
+}
@@ -400,3 +400,21 @@ func IsUserPresent(t *testing.T, sql string) []*engines.RowV2 {
 
 	return results.Rows
 }
+
+func planFor(t *testing.T, sql string) *engines.QueryInfo {
+	encodedPlan, err := utils.SendSql(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: encodedPlan}
+}
+
+func execQuery(t *testing.T, sql string) *engines.Result {
+	result := sharedDB.QueryProcessingEntry(planFor(t, sql))
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	return result
+}
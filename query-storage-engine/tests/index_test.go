@@ -0,0 +1,437 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func intIs(column, kind string, values ...string) map[string]any {
+	operands := []any{map[string]any{"column": column}}
+	for _, value := range values {
+		operands = append(operands, map[string]any{"literal": value, "type": map[string]any{"type": "INTEGER"}})
+	}
+	return map[string]any{"op": map[string]any{"kind": kind}, "operands": operands}
+}
+
+// checkIndex compares the entries of the index to the rows of the table,
+// every entry must point to a live row holding its key.
+func checkIndex(t *testing.T, engine *engines.QueryEngine, tableName, indexName string, columns ...string) {
+	t.Helper()
+
+	manager := engine.BufferPoolManager.DiskManager
+	tableObj, err := engines.GetTableObj(tableName, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := slices.IndexFunc(tableObj.Indexes, func(idx *engines.Index) bool { return idx.Name == indexName })
+	if i < 0 {
+		t.Fatalf("table: %s has no index: %s", tableName, indexName)
+	}
+	idx := tableObj.Indexes[i]
+
+	keyOf := func(values map[string]string) string {
+		key := make([]string, len(columns))
+		for i, column := range columns {
+			key[i] = values[column]
+		}
+		return strings.Join(key, "|")
+	}
+
+	var indexed []string
+	err = idx.Scan(nil, nil, func(entry engines.IndexEntry) bool {
+		pageObj, ok := tableObj.DirectoryPage.Value[entry.Loc.Page]
+		if !ok || int(entry.Loc.Slot) >= len(pageObj.PointerArray) || pageObj.PointerArray[entry.Loc.Slot].Free {
+			t.Errorf("entry %v points to no row", entry)
+			return true
+		}
+
+		pageBytes, err := engines.ReadPageAtOffset(tableObj.DataFile, pageObj.Offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := engines.DecodePageV2(pageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		location := pageObj.PointerArray[entry.Loc.Slot]
+		var row engines.RowV2
+		if err := engines.DecodeRow(&row, bytes.NewReader(page.Data[location.Offset:location.Offset+location.Length])); err != nil {
+			t.Fatal(err)
+		}
+		if err := engines.DecodeStoredValues(&row, manager.PageCatalog.Tables[tableName]); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := keyOf(row.Values), strings.Join(entry.Key, "|"); got != want {
+			t.Errorf("entry %v points to a row with key %s", entry, got)
+		}
+		indexed = append(indexed, strings.Join(entry.Key, "|"))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored []string
+	for _, row := range tableRows(t, engine, tableName) {
+		stored = append(stored, keyOf(row))
+	}
+
+	slices.Sort(indexed)
+	slices.Sort(stored)
+	if !slices.Equal(indexed, stored) {
+		t.Fatalf("index: %s has %d entries for %d rows", indexName, len(indexed), len(stored))
+	}
+}
+
+func TestPrimaryKeyIndex(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	engine := openPlanlessEngine(t, dir)
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Events",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Payload": "VARCHAR"}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// ~20 rows per page, the table spans a few pages and the index a few leaves
+	var rows []any
+	for id := range 400 {
+		rows = append(rows, []any{strconv.Itoa(id), fmt.Sprintf("'%s'", strings.Repeat("x", 180))})
+	}
+	res = runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Events", "selectedCols": []any{"Id", "Payload"}, "rows": rows})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	checkIndex(t, engine, "Events", engines.PRIMARY_INDEX, "Id")
+
+	res = runPlan(engine, map[string]any{"STATEMENT": "DELETE", "table": "Events", "condition": intIs("Id", "BETWEEN", "50", "99")})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	checkIndex(t, engine, "Events", engines.PRIMARY_INDEX, "Id")
+
+	// the new versions are written elsewhere, the entries follow them
+	res = runPlan(engine, map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Events",
+		"condition":   intIs("Id", "LESS_THAN", "10"),
+		"assignments": []any{map[string]any{"column": "Payload", "expr": map[string]any{"literal": strings.Repeat("y", 300), "type": map[string]any{"type": "VARCHAR"}}}},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	checkIndex(t, engine, "Events", engines.PRIMARY_INDEX, "Id")
+
+	selectIds := func(condition map[string]any) []string {
+		t.Helper()
+
+		res := runPlan(engine, map[string]any{
+			"STATEMENT": "SELECT",
+			"refList":   map[string]any{},
+			"rels": []any{
+				map[string]any{"relOp": "LogicalTableScan", "table": []any{"Events"}},
+				map[string]any{"relOp": "LogicalFilter", "condition": condition},
+			},
+		})
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+
+		var ids []string
+		for _, row := range res.Rows {
+			ids = append(ids, row.Values["Id"])
+		}
+		slices.SortFunc(ids, func(a, b string) int {
+			x, _ := strconv.Atoi(a)
+			y, _ := strconv.Atoi(b)
+			return x - y
+		})
+		return ids
+	}
+
+	if got := selectIds(intIs("Id", "EQUALS", "3")); !slices.Equal(got, []string{"3"}) {
+		t.Fatalf("point lookup returned: %v", got)
+	}
+
+	between := map[string]any{"op": map[string]any{"kind": "AND"}, "operands": []any{
+		intIs("Id", "GREATER_THAN_OR_EQUAL", "45"),
+		intIs("Id", "LESS_THAN", "102"),
+	}}
+	if got := selectIds(between); !slices.Equal(got, []string{"45", "46", "47", "48", "49", "100", "101"}) {
+		t.Fatalf("range lookup returned: %v", got)
+	}
+
+	if got := selectIds(intIs("Id", "EQUALS", "75")); len(got) != 0 {
+		t.Fatalf("deleted key still found: %v", got)
+	}
+
+	// explicit keys are looked up in the index, a deleted one can come back
+	insert := func(id string) *engines.Result {
+		return runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Events", "selectedCols": []any{"Id", "Payload"}, "rows": []any{[]any{id, "'z'"}}})
+	}
+	if res := insert("0200"); !errors.Is(res.Error, engines.ErrDuplicateKey) {
+		t.Fatalf("expected %v, got: %v", engines.ErrDuplicateKey, res.Error)
+	}
+	if res := insert("75"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	checkIndex(t, engine, "Events", engines.PRIMARY_INDEX, "Id")
+
+	// the index is on disk, a missing file is built again from the rows
+	reopened := openPlanlessEngine(t, dir)
+	checkIndex(t, reopened, "Events", engines.PRIMARY_INDEX, "Id")
+
+	tableObj, err := engines.GetTableObj("Events", reopened.BufferPoolManager.DiskManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableObj.Close()
+	delete(reopened.BufferPoolManager.DiskManager.TableObjs, "Events")
+
+	if err := os.Remove(filepath.Join(dir, "Tables", "Events", engines.PK_INDEX_FILE)); err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, reopened, "Events", engines.PRIMARY_INDEX, "Id")
+
+	res = runPlan(reopened, map[string]any{"STATEMENT": "TRUNCATE", "table": "Events"})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	checkIndex(t, reopened, "Events", engines.PRIMARY_INDEX, "Id")
+}
+
+func textIs(column, kind string, values ...string) map[string]any {
+	operands := []any{map[string]any{"column": column}}
+	for _, value := range values {
+		operands = append(operands, map[string]any{"literal": value, "type": map[string]any{"type": "VARCHAR"}})
+	}
+	return map[string]any{"op": map[string]any{"kind": kind}, "operands": operands}
+}
+
+func and(conditions ...any) map[string]any {
+	return map[string]any{"op": map[string]any{"kind": "AND"}, "operands": conditions}
+}
+
+// selectIdsWhere runs SELECT Id FROM table WHERE condition, ids sorted.
+func selectIdsWhere(t *testing.T, engine *engines.QueryEngine, tableName string, condition map[string]any) []int {
+	t.Helper()
+
+	res := runPlan(engine, map[string]any{
+		"STATEMENT": "SELECT",
+		"refList":   map[string]any{},
+		"rels": []any{
+			map[string]any{"relOp": "LogicalTableScan", "table": []any{tableName}},
+			map[string]any{"relOp": "LogicalFilter", "condition": condition},
+		},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	var ids []int
+	for _, row := range res.Rows {
+		id, _ := strconv.Atoi(row.Values["Id"])
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestSecondaryIndexes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	engine := openPlanlessEngine(t, dir)
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := runPlan(engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Users",
+		"columns": []any{
+			map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Username": "VARCHAR"}, map[string]any{"City": "VARCHAR"}, map[string]any{"Age": "INT"},
+			map[string]any{"Bio": "VARCHAR"},
+		},
+	})
+
+	cities := []string{"Oslo", "Lima", "Pune", "Kyiv", "Baku"}
+	var rows []any
+	for id := range 300 {
+		rows = append(rows, []any{strconv.Itoa(id), fmt.Sprintf("'user%d'", id), fmt.Sprintf("'%s'", cities[id%5]), strconv.Itoa(id % 60), fmt.Sprintf("'%s'", strings.Repeat("b", 150))})
+	}
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Users", "selectedCols": []any{"Id", "Username", "City", "Age", "Bio"}, "rows": rows})
+
+	// built from the rows already there
+	run(map[string]any{"STATEMENT": "CREATE_INDEX", "name": "ByCityAge", "table": "Users", "columns": []any{"City", "Age"}})
+	run(map[string]any{"STATEMENT": "CREATE_INDEX", "name": "ByUsername", "table": "Users", "columns": []any{"Username"}, "unique": true})
+	checkIndex(t, engine, "Users", "ByCityAge", "City", "Age")
+	checkIndex(t, engine, "Users", "ByUsername", "Username")
+
+	for _, tc := range []struct {
+		name string
+		plan map[string]any
+	}{
+		{"index name taken", map[string]any{"STATEMENT": "CREATE_INDEX", "name": "ByCityAge", "table": "Users", "columns": []any{"Age"}}},
+		{"missing column", map[string]any{"STATEMENT": "CREATE_INDEX", "name": "ByZip", "table": "Users", "columns": []any{"Zip"}}},
+		{"unique on repeated values", map[string]any{"STATEMENT": "CREATE_INDEX", "name": "ByCity", "table": "Users", "columns": []any{"City"}, "unique": true}},
+		{"drop a missing index", map[string]any{"STATEMENT": "DROP_INDEX", "name": "ByZip"}},
+	} {
+		if res := runPlan(engine, tc.plan); res.Error == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "Tables", "Users", "idx_ByCity")); !os.IsNotExist(err) {
+		t.Fatalf("failed CREATE UNIQUE INDEX left its file: %v", err)
+	}
+
+	// the unique index rejects repeated names
+	res := runPlan(engine, map[string]any{"STATEMENT": "INSERT", "table": "Users", "selectedCols": []any{"Id", "Username"}, "rows": []any{[]any{"1000", "'user7'"}}})
+	if !errors.Is(res.Error, engines.ErrDuplicateKey) {
+		t.Fatalf("insert of a repeated username: %v", res.Error)
+	}
+	checkIndex(t, engine, "Users", "ByUsername", "Username")
+
+	run(map[string]any{"STATEMENT": "DELETE", "table": "Users", "condition": and(textIs("City", "EQUALS", "Lima"), intIs("Age", "GREATER_THAN_OR_EQUAL", "30"))})
+	run(map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Users",
+		"condition":   textIs("City", "EQUALS", "Kyiv"),
+		"assignments": []any{map[string]any{"column": "Age", "expr": map[string]any{"literal": "99", "type": map[string]any{"type": "INTEGER"}}}},
+	})
+	checkIndex(t, engine, "Users", "ByCityAge", "City", "Age")
+	checkIndex(t, engine, "Users", "ByUsername", "Username")
+
+	matching := func(match func(id int) bool) []int {
+		var ids []int
+		for id := range 300 {
+			deleted := id%5 == 1 && id%60 >= 30
+			if !deleted && match(id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	for _, tc := range []struct {
+		name      string
+		condition map[string]any
+		want      []int
+	}{
+		{"equal prefix and range", and(textIs("City", "EQUALS", "Pune"), intIs("Age", "BETWEEN", "10", "20")),
+			matching(func(id int) bool { return id%5 == 2 && id%60 >= 10 && id%60 <= 20 })},
+		{"equal prefix only", textIs("City", "EQUALS", "Lima"),
+			matching(func(id int) bool { return id%5 == 1 })},
+		{"both columns equal", and(intIs("Age", "EQUALS", "99"), textIs("City", "EQUALS", "Kyiv")),
+			matching(func(id int) bool { return id%5 == 3 })},
+		{"moved away", and(textIs("City", "EQUALS", "Kyiv"), intIs("Age", "LESS_THAN", "60")), nil},
+		{"unique key", textIs("Username", "EQUALS", "user42"), []int{42}},
+		{"deleted key", textIs("Username", "EQUALS", "user31"), nil},
+	} {
+		if got := selectIdsWhere(t, engine, "Users", tc.condition); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s returned: %v, want: %v", tc.name, got, tc.want)
+		}
+	}
+
+	// the indexes are in the catalog, a missing file is built again
+	reopened := openPlanlessEngine(t, dir)
+	if err := os.Remove(filepath.Join(dir, "Tables", "Users", "idx_ByCityAge")); err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, reopened, "Users", "ByCityAge", "City", "Age")
+	checkIndex(t, reopened, "Users", "ByUsername", "Username")
+
+	engine = reopened
+	run(map[string]any{"STATEMENT": "DROP_INDEX", "name": "ByCityAge"})
+	if _, err := os.Stat(filepath.Join(dir, "Tables", "Users", "idx_ByCityAge")); !os.IsNotExist(err) {
+		t.Fatalf("DROP INDEX left its file: %v", err)
+	}
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Users", "action": "RENAME_COLUMN", "column": "Username", "newName": "Login"})
+	checkIndex(t, engine, "Users", "ByUsername", "Login")
+
+	// a rewrite builds them again next to the new files
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Users", "action": "REWRITE"})
+	checkIndex(t, engine, "Users", "ByUsername", "Login")
+
+	// the index goes with its column
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Users", "action": "DROP_COLUMN", "column": "Login"})
+	if indexes := engine.BufferPoolManager.DiskManager.PageCatalog.Tables["Users"].Indexes; len(indexes) != 0 {
+		t.Fatalf("indexes left: %v", indexes)
+	}
+
+	tableDir := engine.BufferPoolManager.DiskManager.PageCatalog.Tables["Users"].Dir
+	if _, err := os.Stat(filepath.Join(dir, "Tables", tableDir, "idx_ByUsername")); !os.IsNotExist(err) {
+		t.Fatalf("index file of the dropped column wasn't removed: %v", err)
+	}
+}
+
+func TestUpdateManyPages(t *testing.T) {
+	engine := openPlanlessEngine(t, filepath.Join(t.TempDir(), "db"))
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := runPlan(engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Notes",
+		"columns": []any{
+			map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Edits": "INT"}, map[string]any{"Body": "VARCHAR"},
+		},
+	})
+
+	var rows []any
+	for id := range 400 {
+		rows = append(rows, []any{strconv.Itoa(id), "0", fmt.Sprintf("'%s'", strings.Repeat("a", 180))})
+	}
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Notes", "selectedCols": []any{"Id", "Edits", "Body"}, "rows": rows})
+	run(map[string]any{"STATEMENT": "CREATE_INDEX", "name": "ByEdits", "table": "Notes", "columns": []any{"Edits"}})
+
+	// the new versions don't fit in place and span many pages, the ones
+	// placed while the scan runs must not be updated again
+	for range 2 {
+		run(map[string]any{
+			"STATEMENT": "UPDATE",
+			"table":     "Notes",
+			"condition": intIs("Id", "GREATER_THAN_OR_EQUAL", "0"),
+			"assignments": []any{
+				map[string]any{"column": "Edits", "expr": map[string]any{
+					"op":       map[string]any{"kind": "PLUS", "name": "+"},
+					"operands": []any{map[string]any{"column": "Edits"}, map[string]any{"literal": float64(1), "type": map[string]any{"type": "INTEGER"}}},
+				}},
+				map[string]any{"column": "Body", "expr": map[string]any{"literal": strings.Repeat("b", 300), "type": map[string]any{"type": "VARCHAR"}}},
+			},
+		})
+		checkIndex(t, engine, "Notes", engines.PRIMARY_INDEX, "Id")
+		checkIndex(t, engine, "Notes", "ByEdits", "Edits")
+	}
+
+	if got := selectIdsWhere(t, engine, "Notes", intIs("Edits", "EQUALS", "2")); len(got) != 400 {
+		t.Fatalf("%d rows updated twice, want: 400", len(got))
+	}
+	if got := selectIdsWhere(t, engine, "Notes", intIs("Edits", "GREATER_THAN", "2")); len(got) != 0 {
+		t.Fatalf("rows updated more than once by a statement: %v", got)
+	}
+}
@@ -0,0 +1,382 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openScheduledEngine is openPlanlessEngine with the scheduler running like
+// in InitDatabase, plans sent with submitPlan go through QueryChan.
+func openScheduledEngine(t *testing.T, dir string) *engines.QueryEngine {
+	t.Helper()
+
+	engine := openPlanlessEngine(t, dir)
+
+	notification := make(chan *engines.Result, 1000)
+	global := make(chan *engines.Result, 1000)
+	engine.QueryChan = make(chan *engines.QueryInfo, 1000)
+	engine.ResultManager = &engines.ResultManager{SubscribedQueries: map[uint64]chan *engines.Result{}, GlobalChannel: global, SchedulerNotification: notification}
+	engine.Scheduler = engines.NewQueryScheduler(notification, global, engine)
+	engine.SystemStats = &engines.SystemStats{}
+
+	go engine.QueryManager()
+	go engine.ResultManager.ResultCollector()
+	go engine.Scheduler.Scheduler()
+	go engine.Scheduler.Decreaser()
+
+	t.Cleanup(func() { close(engine.QueryChan) })
+	return engine
+}
+
+// submitPlan sends the plan through the scheduler and waits for its result,
+// a statement left waiting for its table fails the test.
+func submitPlan(t *testing.T, engine *engines.QueryEngine, plan map[string]any) *engines.Result {
+	t.Helper()
+	return submitQuery(t, engine, &engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: plan})
+}
+
+func submitQuery(t *testing.T, engine *engines.QueryEngine, queryInfo *engines.QueryInfo) *engines.Result {
+	t.Helper()
+
+	resChan := engine.ResultManager.CreatePersonalChan()
+	engine.ResultManager.Subscribe(queryInfo.Id, resChan)
+	defer engine.ResultManager.Unsubscribe(queryInfo.Id)
+
+	engine.QueryChan <- queryInfo
+	return waitResult(t, resChan, queryInfo)
+}
+
+func waitResult(t *testing.T, resChan chan *engines.Result, queryInfo *engines.QueryInfo) *engines.Result {
+	t.Helper()
+
+	plan, _ := queryInfo.RawPlan.(map[string]any)
+	select {
+	case res := <-resChan:
+		return res
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%v on %v never ran", plan["STATEMENT"], plan["table"])
+		return nil
+	}
+}
+
+// startBehind sends the plan while a cursor is open and checks it waits for
+// the cursor, its result arrives on the returned channel.
+func startBehind(t *testing.T, engine *engines.QueryEngine, plan map[string]any) (*engines.QueryInfo, chan *engines.Result) {
+	t.Helper()
+
+	queryInfo := &engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: plan}
+	resChan := engine.ResultManager.CreatePersonalChan()
+	engine.ResultManager.Subscribe(queryInfo.Id, resChan)
+	t.Cleanup(func() { engine.ResultManager.Unsubscribe(queryInfo.Id) })
+
+	engine.QueryChan <- queryInfo
+
+	select {
+	case res := <-resChan:
+		t.Fatalf("%v ran under an open cursor: %v", plan["STATEMENT"], res.Error)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	return queryInfo, resChan
+}
+
+func TestScheduledInsertAfterDuplicate(t *testing.T) {
+	engine := openScheduledEngine(t, filepath.Join(t.TempDir(), "db"))
+
+	submit := func(plan map[string]any) *engines.Result {
+		t.Helper()
+		return submitPlan(t, engine, plan)
+	}
+	insert := func(ids ...string) map[string]any {
+		var rows []any
+		for _, id := range ids {
+			rows = append(rows, []any{id, "'x'"})
+		}
+		return map[string]any{"STATEMENT": "INSERT", "table": "Tags", "selectedCols": []any{"Id", "Name"}, "rows": rows}
+	}
+
+	if res := submit(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Tags",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Name": "VARCHAR"}},
+	}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	if res := submit(insert("1")); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// rejected before its transaction starts
+	if res := submit(insert("1")); res.Error == nil {
+		t.Fatal("duplicate key accepted")
+	}
+
+	if res := submit(insert("2")); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	if got := selectIdsWhere(t, engine, "Tags", intIs("Id", "GREATER_THAN", "0")); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("table holds: %v, want: [1 2]", got)
+	}
+}
+
+func TestScheduledAlterTable(t *testing.T) {
+	engine := openScheduledEngine(t, filepath.Join(t.TempDir(), "db"))
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := submitPlan(t, engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+	insert := func(columns []any, rows ...[]any) map[string]any {
+		var values []any
+		for _, row := range rows {
+			values = append(values, row)
+		}
+		return map[string]any{"STATEMENT": "INSERT", "table": "Items", "selectedCols": columns, "rows": values}
+	}
+	alter := func(action string, fields ...string) map[string]any {
+		plan := map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Items", "action": action}
+		for i := 0; i < len(fields); i += 2 {
+			plan[fields[i]] = fields[i+1]
+		}
+		return plan
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Items",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Name": "VARCHAR"}},
+	})
+	run(insert([]any{"Id", "Name"}, []any{"1", "'a'"}, []any{"2", "'b'"}, []any{"3", "'c'"}))
+
+	// an ALTER, applied or not, leaves the table to the next statement
+	run(alter("ADD_COLUMN", "column", "Qty", "type", "INT", "default", "5"))
+	run(insert([]any{"Id", "Name", "Qty"}, []any{"4", "'d'", "1"}))
+
+	if res := submitPlan(t, engine, alter("DROP_COLUMN", "column", "Zip")); res.Error == nil {
+		t.Fatal("dropping a missing column succeeded")
+	}
+	run(map[string]any{"STATEMENT": "DELETE", "table": "Items", "condition": intIs("Id", "EQUALS", "2")})
+
+	// the rewrite holds the table of the ALTER, the INSERT waits for it
+	engine.Config.RewriteAfterAlter = true
+	run(alter("DROP_COLUMN", "column", "Name"))
+	run(insert([]any{"Id", "Qty"}, []any{"5", "5"}))
+
+	// and only the switch to the new files waits for the reads
+	run(alter("ADD_COLUMN", "column", "Note", "type", "VARCHAR"))
+	scan := map[string]any{
+		"STATEMENT": "SELECT",
+		"refList":   map[string]any{},
+		"rels":      []any{map[string]any{"relOp": "LogicalTableScan", "table": []any{"Items"}}},
+	}
+	if res := submitPlan(t, engine, scan); res.Error != nil || len(res.Rows) != 4 {
+		t.Fatalf("SELECT after the ALTER returned %d rows: %v", len(res.Rows), res.Error)
+	}
+	run(insert([]any{"Id", "Qty"}, []any{"6", "6"}))
+
+	tableInfo := engine.BufferPoolManager.DiskManager.PageCatalog.Tables["Items"]
+	if tableInfo.Dir == "" || len(tableInfo.Dropped) > 0 {
+		t.Fatalf("table not rewritten after the ALTER, dir: %q, dropped: %v", tableInfo.Dir, tableInfo.Dropped)
+	}
+
+	if got := selectIdsWhere(t, engine, "Items", intIs("Qty", "EQUALS", "5")); !slices.Equal(got, []int{1, 3, 5}) {
+		t.Fatalf("rows with the default: %v, want: [1 3 5]", got)
+	}
+}
+
+func TestScheduledViewRefresh(t *testing.T) {
+	engine := openScheduledEngine(t, filepath.Join(t.TempDir(), "db"))
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := submitPlan(t, engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Sales",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Amount": "INT"}},
+	})
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Sales", "selectedCols": []any{"Id", "Amount"}, "rows": []any{[]any{"1", "10"}, []any{"2", "20"}}})
+	run(map[string]any{
+		"STATEMENT": "CREATE_MATERIALIZED_VIEW",
+		"name":      "Amounts",
+		"query":     "SELECT Amount FROM `Sales`",
+		"from":      "Sales",
+		"columns":   []any{map[string]any{"name": "Amount", "expr": map[string]any{"column": "Amount"}}},
+	})
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Sales", "selectedCols": []any{"Id", "Amount"}, "rows": []any{[]any{"3", "30"}}})
+
+	scan := map[string]any{
+		"STATEMENT": "SELECT",
+		"refList":   map[string]any{},
+		"rels":      []any{map[string]any{"relOp": "LogicalTableScan", "table": []any{"Amounts"}}},
+	}
+	streamed := submitQuery(t, engine, &engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: scan, Stream: true})
+	if streamed.Error != nil {
+		t.Fatal(streamed.Error)
+	}
+
+	// the refresh swaps the files the cursor reads, it waits for the cursor
+	refresh, refreshed := startBehind(t, engine, map[string]any{"STATEMENT": "REFRESH_MATERIALIZED_VIEW", "name": "Amounts"})
+
+	rows, err := streamed.Cursor.FetchAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed.Cursor.Close()
+	if len(rows) != 2 {
+		t.Fatalf("cursor read %d rows, want: 2", len(rows))
+	}
+
+	if res := waitResult(t, refreshed, refresh); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	if res := submitPlan(t, engine, scan); res.Error != nil || len(res.Rows) != 3 {
+		t.Fatalf("refreshed view holds %d rows: %v", len(res.Rows), res.Error)
+	}
+	run(map[string]any{"STATEMENT": "DROP_MATERIALIZED_VIEW", "name": "Amounts"})
+}
+
+func TestScheduledDropDatabase(t *testing.T) {
+	engine := openScheduledEngine(t, filepath.Join(t.TempDir(), "db"))
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := submitPlan(t, engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{"STATEMENT": "CREATE_DATABASE", "database": "shop"})
+	for _, table := range []string{"shop.Orders", "shop.Lines"} {
+		run(map[string]any{
+			"STATEMENT": "CREATE_TABLE",
+			"table":     table,
+			"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"Item": "VARCHAR"}},
+		})
+		run(map[string]any{"STATEMENT": "INSERT", "table": table, "selectedCols": []any{"Id", "Item"}, "rows": []any{[]any{"1", "'pen'"}, []any{"2", "'ink'"}}})
+	}
+
+	scan := map[string]any{
+		"STATEMENT": "SELECT",
+		"refList":   map[string]any{},
+		"rels":      []any{map[string]any{"relOp": "LogicalTableScan", "table": []any{"shop.Lines"}}},
+	}
+	streamed := submitQuery(t, engine, &engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: scan, Stream: true})
+	if streamed.Error != nil {
+		t.Fatal(streamed.Error)
+	}
+
+	// the drop closes the files the cursor reads, it waits for the cursor
+	drop, dropped := startBehind(t, engine, map[string]any{"STATEMENT": "DROP_DATABASE", "database": "shop"})
+
+	rows, err := streamed.Cursor.FetchAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed.Cursor.Close()
+	if len(rows) != 2 {
+		t.Fatalf("cursor read %d rows, want: 2", len(rows))
+	}
+
+	if res := waitResult(t, dropped, drop); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if res := submitPlan(t, engine, scan); res.Error == nil {
+		t.Fatal("the tables of the dropped database are still readable")
+	}
+
+	run(map[string]any{"STATEMENT": "CREATE_DATABASE", "database": "shop"})
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "shop.Orders",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}},
+	})
+	run(map[string]any{"STATEMENT": "INSERT", "table": "shop.Orders", "selectedCols": []any{"Id"}, "rows": []any{[]any{"1"}}})
+}
+
+func TestScheduledIndexes(t *testing.T) {
+	engine := openScheduledEngine(t, filepath.Join(t.TempDir(), "db"))
+
+	if res := submitPlan(t, engine, map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Users",
+		"columns":   []any{map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"}, map[string]any{"City": "VARCHAR"}, map[string]any{"Name": "VARCHAR"}, map[string]any{"Age": "INT"}},
+	}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// every statement is sent before any result is read, the index changes
+	// and the inserts run in whatever order the scheduler picks
+	var batch []*engines.QueryInfo
+	add := func(plan map[string]any) {
+		batch = append(batch, &engines.QueryInfo{Id: engines.GenerateRandomID(), RawPlan: plan})
+	}
+	insert := func(from, to int) {
+		for id := from; id < to; id++ {
+			add(map[string]any{"STATEMENT": "INSERT", "table": "Users", "selectedCols": []any{"Id", "City", "Name", "Age"}, "rows": []any{[]any{fmt.Sprint(id), "'Rome'", fmt.Sprintf("'user%d'", id), fmt.Sprint(id % 7)}}})
+		}
+	}
+	run := func() {
+		t.Helper()
+
+		results := engine.ResultManager.CreatePersonalChan()
+		for _, queryInfo := range batch {
+			engine.ResultManager.Subscribe(queryInfo.Id, results)
+			defer engine.ResultManager.Unsubscribe(queryInfo.Id)
+		}
+		for _, queryInfo := range batch {
+			engine.QueryChan <- queryInfo
+		}
+
+		for _, queryInfo := range batch {
+			if res := waitResult(t, results, queryInfo); res.Error != nil {
+				t.Fatal(res.Error)
+			}
+		}
+		batch = nil
+	}
+
+	indexes := map[string]string{"ByCity": "City", "ByName": "Name", "ByAge": "Age"}
+	insert(0, 20)
+	for name, column := range indexes {
+		add(map[string]any{"STATEMENT": "CREATE_INDEX", "name": name, "table": "Users", "columns": []any{column}})
+	}
+	insert(20, 40)
+	run()
+
+	manager := engine.BufferPoolManager.DiskManager
+	if got := len(manager.PageCatalog.Tables["Users"].Indexes); got != len(indexes) {
+		t.Fatalf("the catalog holds %d indexes, want: %d", got, len(indexes))
+	}
+	for name, column := range indexes {
+		checkIndex(t, engine, "Users", name, column)
+	}
+
+	add(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Users", "action": "RENAME_TABLE", "newName": "People"})
+	for name := range indexes {
+		add(map[string]any{"STATEMENT": "DROP_INDEX", "name": name})
+	}
+	run()
+
+	tableObj, err := engines.GetTableObj("People", manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := slices.ContainsFunc(tableObj.Indexes, func(idx *engines.Index) bool { return indexes[idx.Name] != "" })
+	if left || len(manager.PageCatalog.Tables["People"].Indexes) != 0 {
+		t.Fatal("the indexes of the renamed table weren't dropped")
+	}
+}
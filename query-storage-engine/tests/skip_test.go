@@ -0,0 +1,440 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkBlooms makes sure the filter of every page holds the values of its
+// rows in the columns, VARCHAR and small INT values are kept as their text.
+func checkBlooms(t *testing.T, engine *engines.QueryEngine, tableName string, columns ...string) {
+	t.Helper()
+
+	manager := engine.BufferPoolManager.DiskManager
+	tableObj, err := engines.GetTableObj(tableName, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableInfo := manager.PageCatalog.Tables[tableName]
+
+	if tableObj.Blooms == nil {
+		t.Fatalf("table: %s has no bloom filters", tableName)
+	}
+
+	for pageID, pageObj := range tableObj.DirectoryPage.Value {
+		pageBytes, err := engines.ReadPageAtOffset(tableObj.DataFile, pageObj.Offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := engines.DecodePageV2(pageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, column := range columns {
+			key := column
+			if stored := tableInfo.Schema[column].Stored; stored != "" {
+				key = stored
+			}
+
+			filter := tableObj.Blooms.Filters[pageID][engines.Column(key)]
+			if filter == nil {
+				t.Fatalf("page: %d has no filter for column: %s", pageID, column)
+			}
+
+			for _, row := range pageRows(t, page, pageObj, tableInfo) {
+				if value := row[column]; value != "" && !filter.Test([]byte(value)) {
+					t.Fatalf("filter of page: %d misses %s = %s", pageID, column, value)
+				}
+			}
+		}
+	}
+}
+
+func pageRows(t *testing.T, page *engines.PageV2, pageObj *engines.PageInfo, tableInfo *engines.TableInfo) []map[string]string {
+	t.Helper()
+
+	var rows []map[string]string
+	for _, location := range pageObj.PointerArray {
+		if location.Free {
+			continue
+		}
+
+		var row engines.RowV2
+		if err := engines.DecodeRow(&row, bytes.NewReader(page.Data[location.Offset:location.Offset+location.Length])); err != nil {
+			t.Fatal(err)
+		}
+		if err := engines.DecodeStoredValues(&row, tableInfo); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row.Values)
+	}
+	return rows
+}
+
+// admittedPages counts the pages whose filter of column may hold value.
+func admittedPages(t *testing.T, engine *engines.QueryEngine, tableName, column, value string) int {
+	t.Helper()
+
+	tableObj, err := engines.GetTableObj(tableName, engine.BufferPoolManager.DiskManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := 0
+	for pageID := range tableObj.DirectoryPage.Value {
+		filter := tableObj.Blooms.Filters[pageID][engines.Column(column)]
+		if filter == nil || filter.Test([]byte(value)) {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+func TestPageBloomFilters(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	engine := openPlanlessEngine(t, dir)
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := runPlan(engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Orders",
+		"columns": []any{
+			map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Code": "VARCHAR"}, map[string]any{"Qty": "INT"}, map[string]any{"Note": "VARCHAR"},
+		},
+	})
+
+	codeColumn := "Code"
+	insert := func(from, to int) {
+		t.Helper()
+		var rows []any
+		for id := from; id < to; id++ {
+			rows = append(rows, []any{strconv.Itoa(id), fmt.Sprintf("'c%d'", id), strconv.Itoa(id / 10), fmt.Sprintf("'%s'", strings.Repeat("n", 150))})
+		}
+		run(map[string]any{"STATEMENT": "INSERT", "table": "Orders", "selectedCols": []any{"Id", codeColumn, "Qty", "Note"}, "rows": rows})
+	}
+
+	// the filters of the pages already there are built right away
+	insert(0, 200)
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "ADD_BLOOM_FILTER", "columns": []any{"Code", "Qty"}})
+	insert(200, 300)
+	checkBlooms(t, engine, "Orders", "Code", "Qty")
+
+	for _, tc := range []struct {
+		name string
+		plan map[string]any
+	}{
+		{"missing column", map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "ADD_BLOOM_FILTER", "columns": []any{"Zip"}}},
+		{"filter already there", map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "ADD_BLOOM_FILTER", "columns": []any{"Code"}}},
+		{"no filter to drop", map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "DROP_BLOOM_FILTER", "columns": []any{"Note"}}},
+	} {
+		if res := runPlan(engine, tc.plan); res.Error == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	numPages := func() int {
+		t.Helper()
+		tableObj, err := engines.GetTableObj("Orders", engine.BufferPoolManager.DiskManager)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(tableObj.DirectoryPage.Value)
+	}
+
+	// a value is in the filter of its page and few others
+	if pages, admitted := numPages(), admittedPages(t, engine, "Orders", "Code", "c123"); pages < 10 || admitted > 3 {
+		t.Fatalf("filters of %d pages admit c123 in %d", pages, admitted)
+	}
+
+	run(map[string]any{"STATEMENT": "DELETE", "table": "Orders", "condition": textIs("Code", "EQUALS", "c7")})
+	run(map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Orders",
+		"condition":   intIs("Qty", "EQUALS", "12"),
+		"assignments": []any{map[string]any{"column": "Code", "expr": map[string]any{"literal": "moved", "type": map[string]any{"type": "VARCHAR"}}}},
+	})
+	checkBlooms(t, engine, "Orders", "Code", "Qty")
+
+	check := func(engine *engines.QueryEngine) {
+		t.Helper()
+		for _, tc := range []struct {
+			name      string
+			condition map[string]any
+			want      []int
+		}{
+			{"code", textIs("Code", "EQUALS", "c250"), []int{250}},
+			{"deleted code", textIs("Code", "EQUALS", "c7"), nil},
+			{"updated code", textIs("Code", "EQUALS", "c121"), nil},
+			{"new code", textIs("Code", "EQUALS", "moved"), []int{120, 121, 122, 123, 124, 125, 126, 127, 128, 129}},
+			{"qty and code", and(intIs("Qty", "EQUALS", "3"), textIs("Code", "EQUALS", "c35")), []int{35}},
+			{"literal first", map[string]any{"op": map[string]any{"kind": "EQUALS"}, "operands": []any{
+				map[string]any{"literal": "c42", "type": map[string]any{"type": "VARCHAR"}}, map[string]any{"column": "Code"},
+			}}, []int{42}},
+			{"code not stored", textIs("Code", "EQUALS", "c9999"), nil},
+		} {
+			if got := selectIdsWhere(t, engine, "Orders", tc.condition); !slices.Equal(got, tc.want) {
+				t.Fatalf("%s returned: %v, want: %v", tc.name, got, tc.want)
+			}
+		}
+	}
+	check(engine)
+
+	// the filters are read back, a missing file is built again
+	engine = openPlanlessEngine(t, dir)
+	check(engine)
+	if err := os.Remove(filepath.Join(dir, "Tables", "Orders", "skip_page")); err != nil {
+		t.Fatal(err)
+	}
+	engine = openPlanlessEngine(t, dir)
+	checkBlooms(t, engine, "Orders", "Code", "Qty")
+	check(engine)
+
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "DROP_BLOOM_FILTER", "columns": []any{"Qty"}})
+	if admittedPages(t, engine, "Orders", "Qty", "3") != numPages() {
+		t.Fatal("dropped filters are still there")
+	}
+
+	// the filters stay under the stored name
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "RENAME_COLUMN", "column": "Code", "newName": "Label"})
+	codeColumn = "Label"
+	checkBlooms(t, engine, "Orders", "Label")
+	if got := selectIdsWhere(t, engine, "Orders", textIs("Label", "EQUALS", "c250")); !slices.Equal(got, []int{250}) {
+		t.Fatalf("renamed column returned: %v", got)
+	}
+
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Orders", "action": "REWRITE"})
+	checkBlooms(t, engine, "Orders", "Label")
+	if got := selectIdsWhere(t, engine, "Orders", textIs("Label", "EQUALS", "c250")); !slices.Equal(got, []int{250}) {
+		t.Fatalf("rewritten table returned: %v", got)
+	}
+
+	run(map[string]any{"STATEMENT": "TRUNCATE", "table": "Orders"})
+	insert(0, 5)
+	checkBlooms(t, engine, "Orders", "Label")
+	if got := selectIdsWhere(t, engine, "Orders", textIs("Label", "EQUALS", "c3")); !slices.Equal(got, []int{3}) {
+		t.Fatalf("truncated table returned: %v", got)
+	}
+}
+
+// checkZones makes sure the zone of every page covers the rows left in it,
+// the columns hold integers.
+func checkZones(t *testing.T, engine *engines.QueryEngine, tableName string, columns ...string) {
+	t.Helper()
+
+	manager := engine.BufferPoolManager.DiskManager
+	tableObj, err := engines.GetTableObj(tableName, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableInfo := manager.PageCatalog.Tables[tableName]
+
+	for pageID, pageObj := range tableObj.DirectoryPage.Value {
+		pageBytes, err := engines.ReadPageAtOffset(tableObj.DataFile, pageObj.Offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := engines.DecodePageV2(pageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, column := range columns {
+			zone, ok := pageObj.Zones[engines.Column(column)]
+			if !ok {
+				t.Fatalf("page: %d has no zone for column: %s", pageID, column)
+			}
+
+			for _, row := range pageRows(t, page, pageObj, tableInfo) {
+				value := row[column]
+				if value == "" {
+					if zone.Nulls == 0 {
+						t.Fatalf("zone of page: %d counts no null in %s", pageID, column)
+					}
+					continue
+				}
+
+				n, _ := strconv.Atoi(value)
+				lo, _ := strconv.Atoi(zone.Min)
+				hi, _ := strconv.Atoi(zone.Max)
+				if zone.Values == 0 || n < lo || n > hi {
+					t.Fatalf("zone [%s, %s] of page: %d misses %s = %s", zone.Min, zone.Max, pageID, column, value)
+				}
+			}
+		}
+	}
+}
+
+// zonedPages counts the pages whose zone of column may hold a value between
+// lo and hi.
+func zonedPages(t *testing.T, engine *engines.QueryEngine, tableName, column string, lo, hi int) int {
+	t.Helper()
+
+	tableObj, err := engines.GetTableObj(tableName, engine.BufferPoolManager.DiskManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := 0
+	for _, pageObj := range tableObj.DirectoryPage.Value {
+		zone, ok := pageObj.Zones[engines.Column(column)]
+		min, _ := strconv.Atoi(zone.Min)
+		max, _ := strconv.Atoi(zone.Max)
+		if !ok || (zone.Values > 0 && min <= hi && max >= lo) {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+func TestZoneMaps(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	engine := openPlanlessEngine(t, dir)
+
+	run := func(plan map[string]any) {
+		t.Helper()
+		if res := runPlan(engine, plan); res.Error != nil {
+			t.Fatalf("%v: %v", plan["STATEMENT"], res.Error)
+		}
+	}
+
+	run(map[string]any{
+		"STATEMENT": "CREATE_TABLE",
+		"table":     "Readings",
+		"columns": []any{
+			map[string]any{"Id": "INT"}, map[string]any{"Id": "PRIMARY"},
+			map[string]any{"Seq": "INT"}, map[string]any{"At": "TIMESTAMP"}, map[string]any{"Tag": "VARCHAR"}, map[string]any{"Note": "VARCHAR"},
+		},
+	})
+
+	// the readings come in order, Tag is null for 100 to 119
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var rows []any
+	for id := range 300 {
+		tag := fmt.Sprintf("'t%d'", id)
+		if id >= 100 && id < 120 {
+			tag = "NULL"
+		}
+		at := start.Add(time.Duration(id) * time.Minute).Format("'2006-01-02 15:04:05'")
+		rows = append(rows, []any{strconv.Itoa(id), strconv.Itoa(id), at, tag, fmt.Sprintf("'%s'", strings.Repeat("n", 150))})
+	}
+	run(map[string]any{"STATEMENT": "INSERT", "table": "Readings", "selectedCols": []any{"Id", "Seq", "At", "Tag", "Note"}, "rows": rows})
+	checkZones(t, engine, "Readings", "Seq")
+
+	tableObj, err := engines.GetTableObj("Readings", engine.BufferPoolManager.DiskManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages, admitted := len(tableObj.DirectoryPage.Value), zonedPages(t, engine, "Readings", "Seq", 140, 150); pages < 10 || admitted > 2 {
+		t.Fatalf("zones of %d pages admit 140 to 150 in %d", pages, admitted)
+	}
+
+	at := func(kind string, minutes ...int) map[string]any {
+		operands := []any{map[string]any{"column": "At"}}
+		for _, m := range minutes {
+			literal := start.Add(time.Duration(m) * time.Minute).Format("2006-01-02 15:04:05")
+			operands = append(operands, map[string]any{"literal": literal, "type": map[string]any{"type": "TIMESTAMP"}})
+		}
+		return map[string]any{"op": map[string]any{"kind": kind}, "operands": operands}
+	}
+	span := func(from, to int) []int {
+		var ids []int
+		for id := from; id <= to; id++ {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	check := func(engine *engines.QueryEngine, deleted bool) {
+		t.Helper()
+
+		window := span(140, 150)
+		below := span(0, 9)
+		above := span(295, 299)
+		if deleted {
+			above = append([]int{7}, above...)
+			window = slices.DeleteFunc(window, func(id int) bool { return id >= 145 })
+			below = slices.DeleteFunc(below, func(id int) bool { return id == 7 })
+		}
+
+		for _, tc := range []struct {
+			name      string
+			condition map[string]any
+			want      []int
+		}{
+			{"between", intIs("Seq", "BETWEEN", "140", "150"), window},
+			{"less than", intIs("Seq", "LESS_THAN", "10"), below},
+			{"at least", intIs("Seq", "GREATER_THAN_OR_EQUAL", "295"), above},
+			{"literal first", map[string]any{"op": map[string]any{"kind": "GREATER_THAN"}, "operands": []any{
+				map[string]any{"literal": "10", "type": map[string]any{"type": "INTEGER"}}, map[string]any{"column": "Seq"},
+			}}, below},
+			{"outside every zone", intIs("Seq", "GREATER_THAN", "5000"), nil},
+			{"time window", and(at("GREATER_THAN_OR_EQUAL", 140), at("LESS_THAN", 151)), window},
+			{"null tag", map[string]any{"op": map[string]any{"kind": "IS_NULL"}, "operands": []any{map[string]any{"column": "Tag"}}}, span(100, 119)},
+			{"tag and seq", and(
+				map[string]any{"op": map[string]any{"kind": "IS_NOT_NULL"}, "operands": []any{map[string]any{"column": "Tag"}}},
+				intIs("Seq", "BETWEEN", "95", "104"),
+			), span(95, 99)},
+		} {
+			if got := selectIdsWhere(t, engine, "Readings", tc.condition); !slices.Equal(got, tc.want) {
+				t.Fatalf("%s returned: %v, want: %v", tc.name, got, tc.want)
+			}
+		}
+	}
+	check(engine, false)
+
+	// a row moved out of its range widens the zone of the page it lands in
+	run(map[string]any{"STATEMENT": "DELETE", "table": "Readings", "condition": intIs("Seq", "BETWEEN", "145", "150")})
+	run(map[string]any{
+		"STATEMENT":   "UPDATE",
+		"table":       "Readings",
+		"condition":   intIs("Id", "EQUALS", "7"),
+		"assignments": []any{map[string]any{"column": "Seq", "expr": map[string]any{"literal": "1000", "type": map[string]any{"type": "INTEGER"}}}},
+	})
+	checkZones(t, engine, "Readings", "Seq")
+	check(engine, true)
+	if got := selectIdsWhere(t, engine, "Readings", intIs("Seq", "GREATER_THAN", "500")); !slices.Equal(got, []int{7}) {
+		t.Fatalf("updated row returned: %v", got)
+	}
+
+	// the zones are read back with the directory
+	engine = openPlanlessEngine(t, dir)
+	checkZones(t, engine, "Readings", "Seq")
+	check(engine, true)
+
+	// pages without zones are read until the table is opened again
+	tableObj, err = engines.GetTableObj("Readings", engine.BufferPoolManager.DiskManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pageObj := range tableObj.DirectoryPage.Value {
+		pageObj.Zones = nil
+	}
+	if err := engines.UpdateDirectoryPageDisk(tableObj.DirectoryPage, tableObj.DirFile); err != nil {
+		t.Fatal(err)
+	}
+	check(engine, true)
+
+	engine = openPlanlessEngine(t, dir)
+	checkZones(t, engine, "Readings", "Seq")
+	check(engine, true)
+
+	run(map[string]any{"STATEMENT": "ALTER_TABLE", "table": "Readings", "action": "REWRITE"})
+	checkZones(t, engine, "Readings", "Seq")
+	check(engine, true)
+}
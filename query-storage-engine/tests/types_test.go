@@ -0,0 +1,307 @@
+package tests
+
+import (
+	"a2gdb/engines"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTemporalEncodingRoundTrip(t *testing.T) {
+	cases := []struct {
+		colType string
+		text    string
+		want    string
+	}{
+		{"DATE", "2024-02-29", "2024-02-29"},
+		{"DATE", "1969-07-20", "1969-07-20"},
+		{"DATE", "DATE '2024-01-01'", "2024-01-01"},
+		{"TIMESTAMP", "2024-03-10 08:15:30.25", "2024-03-10 08:15:30.25"},
+		{"TIMESTAMP", "1950-01-01 00:00:00", "1950-01-01 00:00:00"},
+		{"INTERVAL", "1 year 2 months 3 days 04:05:06", "1 year 2 months 3 days 04:05:06"},
+		{"INTERVAL", "90 minutes", "01:30:00"},
+	}
+
+	for _, c := range cases {
+		stored, err := engines.EncodeStoredValue(c.text, c.colType)
+		if err != nil {
+			t.Fatalf("EncodeStoredValue(%q, %s) failed: %s", c.text, c.colType, err)
+		}
+
+		got, err := engines.DecodeStoredValue(stored, c.colType)
+		if err != nil {
+			t.Fatalf("DecodeStoredValue(%q, %s) failed: %s", c.text, c.colType, err)
+		}
+
+		if got != c.want {
+			t.Errorf("%s round trip: got %q, want %q", c.colType, got, c.want)
+		}
+	}
+
+	if _, err := engines.EncodeStoredValue("not a date", "DATE"); err == nil {
+		t.Error("expected invalid date to fail")
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	cases := []struct {
+		colType string
+		text    string
+		want    string
+		err     error
+	}{
+		{"INT", "+0042", "42", nil},
+		{"INT", "7.0", "7", nil},
+		{"INT", "2147483647", "2147483647", nil},
+		{"INT", "2147483648", "", engines.ErrNumericOutOfRange},
+		{"SMALLINT", "-32769", "", engines.ErrNumericOutOfRange},
+		{"BIGINT", "9223372036854775808", "", engines.ErrNumericOutOfRange},
+		{"INT", "abc", "", engines.ErrInvalidText},
+		{"INT", "1.5", "", engines.ErrInvalidText},
+		{"DECIMAL(5,2)", "123.456", "123.46", nil},
+		{"DECIMAL(5,2)", "-0.001", "0.00", nil},
+		{"DECIMAL(5,2)", "999.995", "", engines.ErrNumericOutOfRange},
+		{"DECIMAL(5,2)", "1/2", "", engines.ErrInvalidText},
+		{"DECIMAL", "12.3400", "12.3400", nil},
+		{"VARCHAR(3)", "héé", "héé", nil},
+		{"VARCHAR(3)", "abcd", "", engines.ErrStringTooLong},
+		{"VARCHAR", "anything", "anything", nil},
+		{"DATE", "2024-01-01", "2024-01-01", nil},
+	}
+
+	for _, c := range cases {
+		got, err := engines.CoerceValue(c.text, c.colType)
+		if !errors.Is(err, c.err) || (c.err != nil) != (err != nil) {
+			t.Errorf("CoerceValue(%q, %s): got error %v, want %v", c.text, c.colType, err, c.err)
+			continue
+		}
+
+		if err == nil && got != c.want {
+			t.Errorf("CoerceValue(%q, %s) = %q, want %q", c.text, c.colType, got, c.want)
+		}
+	}
+}
+
+func TestTemporalEncodingPreservesOrder(t *testing.T) {
+	dates := []string{"1900-01-01", "1969-12-31", "1970-01-01", "2024-06-30"}
+	for i := 1; i < len(dates); i++ {
+		prev, _ := engines.EncodeStoredValue(dates[i-1], "DATE")
+		curr, _ := engines.EncodeStoredValue(dates[i], "DATE")
+		if bytes.Compare([]byte(prev), []byte(curr)) >= 0 {
+			t.Errorf("encoded %s should sort before %s", dates[i-1], dates[i])
+		}
+	}
+}
+
+func TestCompareDatums(t *testing.T) {
+	cases := []struct {
+		a, b engines.Datum
+		want int
+	}{
+		{engines.DatumFromText("2024-01-10", "DATE"), engines.DatumFromText("2024-01-09", "DATE"), 1},
+		{engines.DatumFromText("2024-01-10", "DATE"), engines.StringDatum("2024-01-10"), 0},
+		{engines.DatumFromText("9", "INT"), engines.DatumFromText("10", "INT"), -1},
+		{engines.DatumFromText("18446744073709551615", "PRIMARY"), engines.DatumFromText("18446744073709551614", "PRIMARY"), 1},
+		{engines.DatumFromText("1 day", "INTERVAL"), engines.DatumFromText("23:00:00", "INTERVAL"), 1},
+		{engines.NullDatum(), engines.IntDatum(1), -1},
+	}
+
+	for i, c := range cases {
+		got, err := engines.CompareDatums(c.a, c.b)
+		if err != nil {
+			t.Fatalf("case %d: CompareDatums failed: %s", i, err)
+		}
+
+		if got != c.want {
+			t.Errorf("case %d: got %d, want %d", i, got, c.want)
+		}
+	}
+}
+
+func TestTemporalExpressions(t *testing.T) {
+	schema := map[string]engines.ColumnType{
+		"CreatedAt": {Type: "TIMESTAMP"},
+		"Birthday":  {Type: "DATE"},
+	}
+	refList := map[string]interface{}{"$0": "CreatedAt", "$1": "Birthday"}
+
+	ectx := engines.NewExprContext(refList, schema)
+	ectx.Now = time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+
+	row := &engines.RowV2{Values: map[string]string{
+		"CreatedAt": "2024-05-15 13:45:10",
+		"Birthday":  "1990-08-31",
+	}}
+
+	call := func(kind, name string, operands ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"op":       map[string]interface{}{"kind": kind, "name": name},
+			"operands": operands,
+		}
+	}
+	ref := func(n string) map[string]interface{} { return map[string]interface{}{"name": n} }
+	lit := func(v interface{}, typ string) map[string]interface{} {
+		return map[string]interface{}{"literal": v, "type": map[string]interface{}{"type": typ}}
+	}
+
+	cases := []struct {
+		expr interface{}
+		want string
+	}{
+		{call("OTHER_FUNCTION", "DATE_TRUNC", lit("month", "CHAR"), ref("$0")), "2024-05-01 00:00:00"},
+		{call("OTHER_FUNCTION", "DATE_TRUNC", ref("$1"), lit("YEAR", "SYMBOL")), "1990-01-01"},
+		{call("EXTRACT", "EXTRACT", lit("YEAR", "SYMBOL"), ref("$1")), "1990"},
+		{call("EXTRACT", "EXTRACT", lit("HOUR", "SYMBOL"), ref("$0")), "13"},
+		{call("PLUS", "+", ref("$1"), lit(float64(86400000), "INTERVAL_DAY")), "1990-09-01"},
+		{call("PLUS", "DATETIME_PLUS", ref("$1"), lit(float64(6), "INTERVAL_MONTH")), "1991-02-28"},
+		{call("MINUS", "-", call("OTHER_FUNCTION", "NOW"), ref("$0")), "1 day 22:14:50"},
+		{call("OTHER_FUNCTION", "CURRENT_DATE"), "2024-05-17"},
+	}
+
+	for i, c := range cases {
+		got, err := engines.EvalExpr(c.expr, row, ectx)
+		if err != nil {
+			t.Fatalf("case %d: EvalExpr failed: %s", i, err)
+		}
+
+		if got.String() != c.want {
+			t.Errorf("case %d: got %q, want %q", i, got.String(), c.want)
+		}
+	}
+
+	predicate := call("AND", "AND",
+		call("GREATER_THAN_OR_EQUAL", ">=", ref("$0"), lit(float64(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC).UnixMilli()), "TIMESTAMP")),
+		call("LESS_THAN", "<", ref("$1"), lit("1991-01-01", "DATE")),
+	)
+
+	matched, err := engines.EvalPredicate(predicate, row, ectx)
+	if err != nil {
+		t.Fatalf("EvalPredicate failed: %s", err)
+	}
+
+	if !matched {
+		t.Error("expected predicate to match")
+	}
+}
+
+func TestJSONEncodingRoundTrip(t *testing.T) {
+	docs := []string{
+		`{"name":"ana","tags":["a","b"],"age":31,"score":1.5e3,"active":true,"meta":null}`,
+		`[1,{"z":1,"a":2},"<b>"]`,
+		`"plain"`,
+	}
+
+	for _, doc := range docs {
+		stored, err := engines.EncodeStoredValue(doc, "JSON")
+		if err != nil {
+			t.Fatalf("EncodeStoredValue(%s) failed: %s", doc, err)
+		}
+
+		got, err := engines.DecodeStoredValue(stored, "JSON")
+		if err != nil {
+			t.Fatalf("DecodeStoredValue(%s) failed: %s", doc, err)
+		}
+
+		if got != doc {
+			t.Errorf("round trip: got %s, want %s", got, doc)
+		}
+	}
+
+	for _, invalid := range []string{`{"a":}`, `{"a":1} {}`, `[1,2`} {
+		if _, err := engines.EncodeStoredValue(invalid, "JSON"); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestJSONExpressions(t *testing.T) {
+	schema := map[string]engines.ColumnType{"Meta": {Type: "JSON"}}
+	ectx := engines.NewExprContext(map[string]interface{}{"$0": "Meta"}, schema)
+
+	row := &engines.RowV2{Values: map[string]string{
+		"Meta": `{"name":"ana","address":{"city":"Porto"},"tags":["a","b","c"],"age":31}`,
+	}}
+
+	call := func(name string, operands ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"op":       map[string]interface{}{"kind": "OTHER_FUNCTION", "name": name},
+			"operands": operands,
+		}
+	}
+	path := func(p string) map[string]interface{} {
+		return map[string]interface{}{"literal": p, "type": map[string]interface{}{"type": "CHAR"}}
+	}
+	meta := map[string]interface{}{"column": "Meta"}
+
+	cases := []struct {
+		expr interface{}
+		want string
+	}{
+		{call("JSON_VALUE", meta, path("lax $.name")), "ana"},
+		{call("JSON_QUERY", meta, path("lax $.address")), `{"city":"Porto"}`},
+		{call("JSON_VALUE", meta, path("lax $.address.city")), "Porto"},
+		{call("JSON_VALUE", meta, path("lax $.tags[1]")), "b"},
+		{call("JSON_VALUE", meta, path("lax $.missing")), ""},
+		{call("JSON_LENGTH", call("JSON_QUERY", meta, path("lax $.tags"))), "3"},
+		{call("JSON_TYPE", call("JSON_QUERY", meta, path("lax $.address"))), "object"},
+		{call("JSON_TYPE", call("JSON_QUERY", meta, path("lax $.age"))), "number"},
+	}
+
+	for i, c := range cases {
+		got, err := engines.EvalExpr(c.expr, row, ectx)
+		if err != nil {
+			t.Fatalf("case %d: EvalExpr failed: %s", i, err)
+		}
+
+		if got.String() != c.want {
+			t.Errorf("case %d: got %q, want %q", i, got.String(), c.want)
+		}
+	}
+
+	predicate := map[string]interface{}{
+		"op": map[string]interface{}{"kind": "GREATER_THAN", "name": ">"},
+		"operands": []interface{}{
+			call("JSON_VALUE", meta, path("lax $.age")),
+			map[string]interface{}{"literal": float64(30), "type": map[string]interface{}{"type": "INTEGER"}},
+		},
+	}
+
+	matched, err := engines.EvalPredicate(predicate, row, ectx)
+	if err != nil {
+		t.Fatalf("EvalPredicate failed: %s", err)
+	}
+
+	if !matched {
+		t.Error("expected age > 30 to match")
+	}
+}
+
+func TestExcludedColumns(t *testing.T) {
+	schema := map[string]engines.ColumnType{"Age": {Type: "INT"}}
+	ectx := engines.NewExprContext(nil, schema)
+
+	// Age = Age + EXCLUDED.Age
+	expr := map[string]interface{}{
+		"op": map[string]interface{}{"kind": "PLUS", "name": "+"},
+		"operands": []interface{}{
+			map[string]interface{}{"column": "Age"},
+			map[string]interface{}{"qualifier": "EXCLUDED", "column": "Age"},
+		},
+	}
+
+	existing := &engines.RowV2{Values: map[string]string{"Age": "30"}}
+	if _, err := engines.EvalExpr(expr, existing, ectx); err == nil {
+		t.Fatal("EXCLUDED outside of an upsert should fail")
+	}
+
+	ectx.Excluded = &engines.RowV2{Values: map[string]string{"Age": "12"}}
+	got, err := engines.EvalExpr(expr, existing, ectx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.String() != "42" {
+		t.Errorf("got %s, want 42", got.String())
+	}
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const FETCH_SIZE = 1000 // rows per fetch in Query
+
+// client -> server frames
+const (
+	CURSOR_FETCH = iota + 1
+	CURSOR_CLOSE
+)
+
+// server -> client frames
+const (
+	FRAME_ROWS = iota + 1
+	FRAME_ERROR
+)
+
+// Cursor streams the rows of a query, the server only produces
+// the next batch once the previous one has been fetched.
+type Cursor struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	done   bool
+}
+
+func (cred *UserCred) OpenCursor(sql string) (*Cursor, error) {
+	reqBody := []byte(cred.queryBody(sql))
+
+	var body bytes.Buffer
+	if err := binary.Write(&body, binary.LittleEndian, uint32(len(reqBody))); err != nil {
+		return nil, fmt.Errorf("encoding body length failed: %w", err)
+	}
+	body.Write(reqBody)
+
+	message := CustomTCP{
+		MessageType: CURSOR,
+		MessageBody: body.Bytes(),
+	}
+
+	bytes, err := message.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encoding tcp failed: %w", err)
+	}
+
+	conn, err := StreamBytes(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("StreamBytes Failed: %w", err)
+	}
+
+	return &Cursor{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Query runs the statement through a cursor and returns every row, it's the
+// way to read SELECT results and the rows of INSERT/UPDATE/DELETE ... RETURNING.
+func (cred *UserCred) Query(sql string) ([]map[string]string, error) {
+	cursor, err := cred.OpenCursor(sql)
+	if err != nil {
+		return nil, fmt.Errorf("OpenCursor failed: %w", err)
+	}
+	defer cursor.Close()
+
+	var all []map[string]string
+	for {
+		rows, err := cursor.Fetch(FETCH_SIZE)
+		if err == io.EOF {
+			return all, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Fetch failed: %w", err)
+		}
+
+		all = append(all, rows...)
+	}
+}
+
+// Fetch returns up to n rows, io.EOF is returned once the cursor is exhausted.
+func (c *Cursor) Fetch(n int) ([]map[string]string, error) {
+	if c.done {
+		return nil, io.EOF
+	}
+
+	if err := c.send(CURSOR_FETCH, uint32(n)); err != nil {
+		return nil, fmt.Errorf("sending fetch failed: %w", err)
+	}
+
+	rows, last, err := c.readFrame()
+	if last || err != nil {
+		c.done = true
+		c.conn.Close()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if last && len(rows) == 0 {
+		return nil, io.EOF
+	}
+
+	return rows, nil
+}
+
+// Close releases the cursor on the server, rows not fetched are discarded.
+func (c *Cursor) Close() error {
+	if c.done {
+		return nil
+	}
+
+	c.done = true
+	defer c.conn.Close()
+
+	return c.send(CURSOR_CLOSE, 0)
+}
+
+func (c *Cursor) send(op uint8, n uint32) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, op); err != nil {
+		return err
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, n); err != nil {
+		return err
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT * time.Second))
+	if err != nil {
+		return fmt.Errorf("SetWriteDeadline failed: %w", err)
+	}
+
+	_, err = c.conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *Cursor) readFrame() ([]map[string]string, bool, error) {
+	err := c.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT * time.Second))
+	if err != nil {
+		return nil, false, fmt.Errorf("SetReadDeadline failed: %w", err)
+	}
+
+	var frameType uint8
+	var frameLen uint32
+	if err := binary.Read(c.reader, binary.LittleEndian, &frameType); err != nil {
+		return nil, false, fmt.Errorf("reading frame type failed: %w", err)
+	}
+
+	if err := binary.Read(c.reader, binary.LittleEndian, &frameLen); err != nil {
+		return nil, false, fmt.Errorf("reading frame length failed: %w", err)
+	}
+
+	payload := make([]byte, frameLen)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return nil, false, fmt.Errorf("reading frame failed: %w", err)
+	}
+
+	switch frameType {
+	case FRAME_ERROR:
+		if queryErr := parseQueryError(string(payload)); queryErr != nil {
+			return nil, true, queryErr
+		}
+		return nil, true, errors.New(string(payload))
+	case FRAME_ROWS:
+		return decodeRows(payload)
+	default:
+		return nil, false, fmt.Errorf("unknown frame type: %d", frameType)
+	}
+}
+
+func decodeRows(payload []byte) ([]map[string]string, bool, error) {
+	buf := bytes.NewReader(payload)
+
+	var last bool
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &last); err != nil {
+		return nil, false, err
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return nil, false, err
+	}
+
+	rows := make([]map[string]string, 0, count)
+	for range count {
+		var fields uint32
+		if err := binary.Read(buf, binary.LittleEndian, &fields); err != nil {
+			return nil, false, err
+		}
+
+		row := make(map[string]string, fields)
+		for range fields {
+			key, err := readString(buf)
+			if err != nil {
+				return nil, false, err
+			}
+
+			val, err := readString(buf)
+			if err != nil {
+				return nil, false, err
+			}
+
+			row[key] = val
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, last, nil
+}
+
+func readString(buf *bytes.Reader) (string, error) {
+	var strLen uint32
+	if err := binary.Read(buf, binary.LittleEndian, &strLen); err != nil {
+		return "", err
+	}
+
+	str := make([]byte, strLen)
+	if _, err := io.ReadFull(buf, str); err != nil {
+		return "", err
+	}
+
+	return string(str), nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"regexp"
+)
+
+// QueryError is a query the server rejected, Code is the SQLSTATE of the
+// constraint violations and "" for every other failure.
+type QueryError struct {
+	Code    string
+	Message string
+}
+
+var (
+	ErrNotNullViolation    = &QueryError{Code: "23502", Message: "not null violation"}
+	ErrForeignKeyViolation = &QueryError{Code: "23503", Message: "foreign key violation"}
+	ErrUniqueViolation     = &QueryError{Code: "23505", Message: "unique violation"}
+	ErrCheckViolation      = &QueryError{Code: "23514", Message: "check violation"}
+)
+
+var queryErrorRe = regexp.MustCompile(`(?s)^ERROR(?: (\w{5}))?: (.*)$`)
+
+func (e *QueryError) Error() string {
+	if e.Code != "" {
+		return e.Code + ": " + e.Message
+	}
+	return e.Message
+}
+
+// Is matches on the code, errors.Is(err, client.ErrUniqueViolation).
+func (e *QueryError) Is(target error) bool {
+	t, ok := target.(*QueryError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// parseQueryError reads the "ERROR <sqlstate>: <message>" answers of the
+// server, nil if msg isn't one.
+func parseQueryError(msg string) error {
+	match := queryErrorRe.FindStringSubmatch(msg)
+	if match == nil {
+		return nil
+	}
+
+	return &QueryError{Code: match[1], Message: match[2]}
+}
@@ -3,7 +3,6 @@ package client
 import (
 	"fmt"
 	"io"
-	"regexp"
 	"time"
 )
 
@@ -11,6 +10,7 @@ const (
 	AUTH = iota + 1
 	CREATE_TABLE
 	QUERY
+	CURSOR
 )
 
 type CustomTCP struct {
@@ -101,22 +101,35 @@ func (cred *UserCred) CreateTable(table string, schema map[string]string) error
 	return nil
 }
 
+// GetOfficialTableName is the qualified name of a table of the user's
+// database, the name other databases see it by.
 func (cred *UserCred) GetOfficialTableName(tableName string) string {
-	return tableName + "-" + cred.DbName + "-" + fmt.Sprintf("%d", cred.UserId)
+	return cred.DbName + "." + tableName
 }
 
-func (cred *UserCred) ExecuteQuery(sql string) (string, error) {
-	var tableName string
-	re := regexp.MustCompile("`(.*?)`")
-	match := re.FindStringSubmatch(sql)
-	if len(match) > 1 {
-		tableName = match[1]
+// queryBody sends the statement along with the user's database, the server
+// resolves the bare table names in it.
+func (cred *UserCred) queryBody(sql string) string {
+	return fmt.Sprintf("&sql=%s&db=%s&", sql, cred.DbName)
+}
+
+// ListTables gives the tables of the user's database.
+func (cred *UserCred) ListTables() ([]string, error) {
+	rows, err := cred.Query("SHOW TABLES")
+	if err != nil {
+		return nil, fmt.Errorf("Query failed: %w", err)
+	}
+
+	tables := make([]string, 0, len(rows))
+	for _, row := range rows {
+		tables = append(tables, row["Table"])
 	}
 
-	internalTableName := cred.GetOfficialTableName(tableName)
+	return tables, nil
+}
 
-	updatedQuery := re.ReplaceAllString(sql, "`"+internalTableName+"`")
-	reqBody := fmt.Sprintf("&sql=%s&", updatedQuery)
+func (cred *UserCred) ExecuteQuery(sql string) (string, error) {
+	reqBody := cred.queryBody(sql)
 
 	message := CustomTCP{
 		MessageType: QUERY,
@@ -140,5 +153,9 @@ func (cred *UserCred) ExecuteQuery(sql string) (string, error) {
 		return "", fmt.Errorf("ReadResponse Failed: %w", err)
 	}
 
+	if queryErr := parseQueryError(msg); queryErr != nil {
+		return "", queryErr
+	}
+
 	return msg, nil
 }
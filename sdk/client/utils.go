@@ -23,6 +23,22 @@ const (
 )
 
 func SendBytes(bytes []byte) (net.Conn, error) {
+	conn, err := StreamBytes(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if ok {
+		tcpConn.CloseWrite()
+	}
+
+	return conn, nil
+}
+
+// StreamBytes sends the request but keeps the write side open,
+// used by cursors which keep talking to the server.
+func StreamBytes(bytes []byte) (net.Conn, error) {
 	timeout := DIAL_TIMEOUT * time.Second
 	conn, err := net.DialTimeout("tcp", SERVER, timeout)
 	if err != nil {
@@ -37,14 +53,10 @@ func SendBytes(bytes []byte) (net.Conn, error) {
 
 	_, err = conn.Write(bytes)
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("couldn't write message: %s", err)
 	}
 
-	tcpConn, ok := conn.(*net.TCPConn)
-	if ok {
-		tcpConn.CloseWrite()
-	}
-
 	return conn, nil
 }
 